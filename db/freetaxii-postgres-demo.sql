@@ -0,0 +1,77 @@
+-- FreeTAXII Server demo data for PostgreSQL
+--
+-- Create the schema first with: freetaxii-mgmt --db-init
+-- Then load with: psql -d freetaxii -f db/freetaxii-postgres-demo.sql
+--
+-- The services, collections and indicators are the same as db/freetaxii.db.
+-- --db-init adds services from the configuration file, delete them first if
+-- these are wanted instead.
+
+INSERT INTO Services (id, typeid, available, address) VALUES
+	(1, 1, 1, 'http://test.freetaxii.com:8000/services/discovery'),
+	(2, 2, 1, 'http://test.freetaxii.com:8000/services/collection'),
+	(3, 5, 1, 'http://test.freetaxii.com:8000/services/poll'),
+	(4, 4, 1, 'http://test.freetaxii.com:8000/services/inbox'),
+	(5, 3, 1, 'http://test.freetaxii.com:8000/services/collection-management');
+SELECT setval(pg_get_serial_sequence('services', 'id'), (SELECT MAX(id) FROM Services));
+
+INSERT INTO Collections (id, collection, description, type, location, address, contentbinding, polladdress, inboxaddress, subscriptionaddress) VALUES
+	(1, 'ip-watch-list', 'Interesting IP addresses', 'DATA_SET', NULL, NULL, '', '', '', ''),
+	(2, 'url-watch-list', 'Interesting URLs', 'DATA_SET', NULL, NULL, '', '', '', ''),
+	(3, 'et-compromised-ips', 'List of compromised IPs from Emerging Threats', 'DATA_SET', 'Remote', 'http://rules.emergingthreats.net/blockrules/compromised-ips.txt', '', '', '', '');
+SELECT setval(pg_get_serial_sequence('collections', 'id'), (SELECT MAX(id) FROM Collections));
+
+INSERT INTO Producers (id, name, description, reference) VALUES
+	(1, 'Emerging Threats Compromised IPs', 'Compromised IP block list from Emerging Threats', 'http://rules.emergingthreats.net/blockrules/compromised-ips.txt');
+SELECT setval(pg_get_serial_sequence('producers', 'id'), (SELECT MAX(id) FROM Producers));
+
+INSERT INTO Indicators (id, collectionid, producerid, title, type, indicatortype, created, modified) VALUES
+	(1, 1, NULL, 'Malicious IP Addresses', 'IP Watchlist', 'malicious-activity', '2015-06-15T00:00:00.000000Z', '2015-06-15T00:00:00.000000Z'),
+	(2, 2, NULL, 'Malicious IP Addresses', 'IP Watchlist', 'malicious-activity', '2015-06-15T00:00:00.000000Z', '2015-06-15T00:00:00.000000Z'),
+	(3, 3, 1, 'Compromised IP Addresses', 'IP Watchlist', 'compromised', '2015-06-15T00:00:00.000000Z', '2015-06-15T00:00:00.000000Z');
+SELECT setval(pg_get_serial_sequence('indicators', 'id'), (SELECT MAX(id) FROM Indicators));
+
+INSERT INTO Observables (id, indicatorid, type, value, created) VALUES
+	(1, 1, 'IP Address', '176.119.3.108', '2015-06-15T00:00:00.000000Z'),
+	(2, 1, 'IP Address', '178.207.85.119', '2015-06-15T00:00:00.000000Z'),
+	(3, 1, 'IP Address', '178.63.174.153', '2015-06-15T00:00:00.000000Z'),
+	(4, 1, 'IP Address', '188.241.140.212', '2015-06-15T00:00:00.000000Z'),
+	(5, 1, 'IP Address', '14.138.73.47', '2015-06-15T00:00:00.000000Z'),
+	(6, 1, 'IP Address', '131.72.138.45', '2015-06-15T00:00:00.000000Z'),
+	(7, 1, 'IP Address', '62.84.51.39', '2015-06-15T00:00:00.000000Z'),
+	(8, 1, 'IP Address', '62.109.23.246', '2015-06-15T00:00:00.000000Z'),
+	(9, 1, 'IP Address', '5.101.113.169', '2015-06-15T00:00:00.000000Z'),
+	(10, 1, 'IP Address', '213.231.8.30', '2015-06-15T00:00:00.000000Z'),
+	(11, 1, 'IP Address', '208.43.25.52', '2015-06-15T00:00:00.000000Z'),
+	(12, 1, 'IP Address', '112.208.6.209', '2015-06-15T00:00:00.000000Z'),
+	(13, 1, 'IP Address', '115.239.248.87', '2015-06-15T00:00:00.000000Z'),
+	(14, 1, 'IP Address', '117.216.190.71', '2015-06-15T00:00:00.000000Z'),
+	(15, 1, 'IP Address', '131.72.139.233', '2015-06-15T00:00:00.000000Z'),
+	(16, 1, 'IP Address', '129.194.97.21', '2015-06-15T00:00:00.000000Z'),
+	(17, 1, 'IP Address', '162.244.35.229', '2015-06-15T00:00:00.000000Z'),
+	(18, 1, 'IP Address', '178.219.10.23', '2015-06-15T00:00:00.000000Z'),
+	(19, 1, 'IP Address', '184.154.124.203', '2015-06-15T00:00:00.000000Z'),
+	(20, 1, 'IP Address', '184.154.146.100', '2015-06-15T00:00:00.000000Z'),
+	(21, 1, 'IP Address', '184.154.146.101', '2015-06-15T00:00:00.000000Z'),
+	(22, 2, 'IP Address', '176.119.3.108', '2015-06-15T00:00:00.000000Z'),
+	(23, 2, 'IP Address', '178.207.85.119', '2015-06-15T00:00:00.000000Z'),
+	(24, 2, 'IP Address', '178.63.174.153', '2015-06-15T00:00:00.000000Z'),
+	(25, 2, 'IP Address', '188.241.140.212', '2015-06-15T00:00:00.000000Z'),
+	(26, 2, 'IP Address', '14.138.73.47', '2015-06-15T00:00:00.000000Z'),
+	(27, 2, 'IP Address', '131.72.138.45', '2015-06-15T00:00:00.000000Z'),
+	(28, 2, 'IP Address', '62.84.51.39', '2015-06-15T00:00:00.000000Z'),
+	(29, 2, 'IP Address', '62.109.23.246', '2015-06-15T00:00:00.000000Z'),
+	(30, 2, 'IP Address', '5.101.113.169', '2015-06-15T00:00:00.000000Z'),
+	(31, 2, 'IP Address', '213.231.8.30', '2015-06-15T00:00:00.000000Z'),
+	(32, 2, 'IP Address', '208.43.25.52', '2015-06-15T00:00:00.000000Z'),
+	(33, 2, 'IP Address', '112.208.6.209', '2015-06-15T00:00:00.000000Z'),
+	(34, 2, 'IP Address', '115.239.248.87', '2015-06-15T00:00:00.000000Z'),
+	(35, 2, 'IP Address', '117.216.190.71', '2015-06-15T00:00:00.000000Z'),
+	(36, 2, 'IP Address', '131.72.139.233', '2015-06-15T00:00:00.000000Z'),
+	(37, 2, 'IP Address', '129.194.97.21', '2015-06-15T00:00:00.000000Z'),
+	(38, 2, 'IP Address', '162.244.35.229', '2015-06-15T00:00:00.000000Z'),
+	(39, 2, 'IP Address', '178.219.10.23', '2015-06-15T00:00:00.000000Z'),
+	(40, 2, 'IP Address', '184.154.124.203', '2015-06-15T00:00:00.000000Z'),
+	(41, 2, 'IP Address', '184.154.146.100', '2015-06-15T00:00:00.000000Z'),
+	(42, 2, 'IP Address', '184.154.146.101', '2015-06-15T00:00:00.000000Z');
+SELECT setval(pg_get_serial_sequence('observables', 'id'), (SELECT MAX(id) FROM Observables));
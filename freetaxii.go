@@ -10,10 +10,13 @@ import (
 	"code.google.com/p/getopt"
 	"fmt"
 	"github.com/freetaxii/freetaxii-server/lib/config"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
 	"github.com/freetaxii/freetaxii-server/lib/taxiiserver"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
 const (
@@ -79,14 +82,51 @@ func main() {
 	// Setup Server Object for a listeners
 	// --------------------------------------------------
 
+	store, err := storage.Open(syscfg.System.DbType, syscfg.DbDataSource())
+	if err != nil {
+		log.Fatalf("error opening the store: %v", err)
+	}
+	defer store.Close()
+
+	if syscfg.Logging.LogLevel >= 3 {
+		log.Println("DEBUG-3: Using the storage backend", syscfg.System.DbType)
+	}
+
+	// The server does not change the schema itself, an out of date database
+	// has to be migrated with freetaxii-mgmt first
+	schemaVersion, err := store.GetSchemaVersion()
+	if err != nil {
+		log.Fatalf("error reading the schema version: %v", err)
+	}
+	if schemaVersion > storage.LatestSchemaVersion() {
+		log.Fatalf("The database schema is at version %d which is newer than this server, it only knows up to version %d", schemaVersion, storage.LatestSchemaVersion())
+	}
+	if schemaVersion < storage.LatestSchemaVersion() {
+		log.Fatalf("The database schema is at version %d but this server needs version %d, run freetaxii-mgmt --db-migrate", schemaVersion, storage.LatestSchemaVersion())
+	}
+
 	var taxiiServerObject taxiiserver.ServerType
 	taxiiServerObject.SysConfig = &syscfg
+	taxiiServerObject.Store = store
+	taxiiServerObject.StartTime = time.Now()
 
-	// TODO need to add the ability to signal this process and change the flag
-	// from false to true so that it will be reloaded.
-	taxiiServerObject.ReloadServices = true
-	if syscfg.Logging.LogLevel >= 3 {
-		log.Println("DEBUG-3: Setting reload services to true")
+	// --------------------------------------------------
+	// Setup Client Certificate Checks
+	// --------------------------------------------------
+	// Partners that send a client certificate are mapped to an identity, which
+	// every handler can read from the request
+
+	if syscfg.Tls.Enabled == true && syscfg.Tls.ClientCaFile != "" {
+		verifier, err := taxiiserver.NewClientVerifier(syscfg.Tls.ClientCaFileFullPath, syscfg.Tls.CrlFileFullPath)
+		if err != nil {
+			log.Fatalf("Unable to set up client certificate checks: %v", err)
+		}
+		taxiiServerObject.ClientVerifier = verifier
+		log.Println("Checking client certificates against", syscfg.Tls.ClientCaFileFullPath)
+	}
+
+	if syscfg.Auth.Enabled == true {
+		log.Println("Requiring users to sign in to the TAXII and export services")
 	}
 
 	// --------------------------------------------------
@@ -95,7 +135,7 @@ func main() {
 
 	if syscfg.Services.Discovery != "" {
 		log.Println("Starting TAXII Discovery services at:", syscfg.Services.Discovery)
-		http.HandleFunc(syscfg.Services.Discovery, taxiiServerObject.DiscoveryServerHandler)
+		http.HandleFunc(syscfg.Services.Discovery, taxiiServerObject.WithIdentity(taxiiServerObject.WithAuthentication(taxiiServerObject.DiscoveryServerHandler)))
 		serviceCounter++
 	}
 
@@ -105,7 +145,17 @@ func main() {
 
 	if syscfg.Services.Collection != "" {
 		log.Println("Starting TAXII Collection services at:", syscfg.Services.Collection)
-		http.HandleFunc(syscfg.Services.Collection, taxiiServerObject.CollectionServerHandler)
+		http.HandleFunc(syscfg.Services.Collection, taxiiServerObject.WithIdentity(taxiiServerObject.WithAuthentication(taxiiServerObject.CollectionServerHandler)))
+		serviceCounter++
+	}
+
+	// --------------------------------------------------
+	// Setup Subscription Server
+	// --------------------------------------------------
+
+	if syscfg.Services.Subscription != "" {
+		log.Println("Starting TAXII Subscription services at:", syscfg.Services.Subscription)
+		http.HandleFunc(syscfg.Services.Subscription, taxiiServerObject.WithIdentity(taxiiServerObject.WithAuthentication(taxiiServerObject.SubscriptionServerHandler)))
 		serviceCounter++
 	}
 
@@ -115,18 +165,87 @@ func main() {
 
 	if syscfg.Services.Poll != "" {
 		log.Println("Starting TAXII Poll services at:", syscfg.Services.Poll)
-		http.HandleFunc(syscfg.Services.Poll, taxiiServerObject.PollServerHandler)
+		http.HandleFunc(syscfg.Services.Poll, taxiiServerObject.WithIdentity(taxiiServerObject.WithAuthentication(taxiiServerObject.PollServerHandler)))
+		serviceCounter++
+	}
+
+	// --------------------------------------------------
+	// Setup Inbox Server
+	// --------------------------------------------------
+
+	if syscfg.Services.Inbox != "" {
+		log.Println("Starting TAXII Inbox services at:", syscfg.Services.Inbox)
+		http.HandleFunc(syscfg.Services.Inbox, taxiiServerObject.WithIdentity(taxiiServerObject.WithAuthentication(taxiiServerObject.InboxServerHandler)))
+		serviceCounter++
+	}
+
+	// --------------------------------------------------
+	// Setup TAXII 2.1 Server
+	// --------------------------------------------------
+	// Serves the same collections as the TAXII 1.x services
+
+	if syscfg.Taxii2.Enabled == true {
+		if syscfg.Taxii2.Discovery == "" || syscfg.Taxii2.ApiRoot == "" {
+			log.Fatalln("The TAXII 2.1 discovery and apiroot directives are missing from the configuration file")
+		}
+		log.Println("Starting TAXII 2.1 Discovery services at:", syscfg.Taxii2.Discovery)
+		http.HandleFunc(syscfg.Taxii2.Discovery, taxiiServerObject.WithIdentity(taxiiServerObject.WithAuthentication(taxiiServerObject.Taxii2DiscoveryHandler)))
+		log.Println("Starting TAXII 2.1 API Root services at:", syscfg.Taxii2.ApiRoot)
+		http.HandleFunc(syscfg.Taxii2.ApiRoot, taxiiServerObject.WithIdentity(taxiiServerObject.WithAuthentication(taxiiServerObject.Taxii2ApiRootHandler)))
 		serviceCounter++
 	}
 
+	// --------------------------------------------------
+	// Setup Export Server
+	// --------------------------------------------------
+	// Not a TAXII service, so it is not counted either
+
+	if syscfg.Services.Export != "" {
+		log.Println("Starting Export services at:", syscfg.Services.Export)
+		http.HandleFunc(syscfg.Services.Export, taxiiServerObject.WithIdentity(taxiiServerObject.WithAuthentication(taxiiServerObject.ExportServerHandler)))
+	}
+
 	// --------------------------------------------------
 	// Setup Admin Server
 	// --------------------------------------------------
+	// The admin API has its own listener so that it can be kept off the
+	// addresses the TAXII clients use. It is not counted as a TAXII service.
+	// Passwords and API tokens are sent to it, so with TLS turned on it uses
+	// the same certificate as the HTTPS listener, and without TLS it has to
+	// stay on a loopback address.
 
 	if syscfg.Services.Admin != "" {
-		log.Println("Starting TAXII Admin services at:", syscfg.Services.Admin)
-		http.HandleFunc(syscfg.Services.Admin, taxiiServerObject.AdminServerHandler)
-		//serviceCounter++  Do not count this service in the list
+		if syscfg.System.AdminListen == "" {
+			log.Println("The admin API is not started as the adminlisten directive is missing from the configuration file")
+		} else {
+			log.Println("Starting Admin API at:", syscfg.Services.Admin, "on", syscfg.System.AdminListen)
+			// Everything under the admin path is part of the API
+			adminPath := syscfg.Services.Admin
+			if !strings.HasSuffix(adminPath, "/") {
+				adminPath += "/"
+			}
+			adminMux := http.NewServeMux()
+			adminMux.HandleFunc(adminPath, taxiiServerObject.AdminServerHandler)
+			adminServer := &http.Server{Addr: syscfg.System.AdminListen, Handler: adminMux}
+
+			if syscfg.Tls.Enabled == true {
+				tlsConfig, err := syscfg.AdminTlsConfig()
+				if err != nil {
+					log.Fatalf("Unable to set up TLS for the admin API: %v", err)
+				}
+				adminServer.TLSConfig = tlsConfig
+				go func() {
+					log.Fatalln(adminServer.ListenAndServeTLS("", ""))
+				}()
+			} else {
+				if !config.IsLoopbackAddress(syscfg.System.AdminListen) {
+					log.Fatalln("The admin API can only listen on a loopback address when TLS is not enabled, adminlisten is", syscfg.System.AdminListen)
+				}
+				go func() {
+					log.Fatalln(adminServer.ListenAndServe())
+				}()
+			}
+		}
 	}
 
 	// --------------------------------------------------
@@ -137,18 +256,69 @@ func main() {
 		log.Fatalln("No TAXII services defined")
 	}
 
+	// --------------------------------------------------
+	// Start Delivery Engine
+	// --------------------------------------------------
+	// Pushes new content to subscribers that registered an inbox address
+
+	if syscfg.Delivery.Enabled == true {
+		log.Println("Starting TAXII subscription delivery engine")
+		deliveryEngine := taxiiserver.DeliveryEngineType{Server: &taxiiServerObject}
+		deliveryEngine.Start()
+	}
+
+	// --------------------------------------------------
+	// Start Feed Fetcher
+	// --------------------------------------------------
+	// Reads the remote sources of collections in to the store
+
+	if syscfg.Fetcher.Enabled == true {
+		log.Println("Starting feed fetcher")
+		fetcher := taxiiserver.FetcherType{Server: &taxiiServerObject}
+		fetcher.Start()
+	}
+
 	// --------------------------------------------------
 	// Listen for Incoming Connections
 	// --------------------------------------------------
 
+	// The plain HTTP listener and the HTTPS listener can run at the same time.
+	// With the TLS redirect turned on the plain listener only sends clients
+	// on to the HTTPS listener.
+
 	// TODO - Need to verify the list address is a valid IPv4 address and port
 	// combination.
-	if syscfg.System.Listen != "" {
-		http.ListenAndServe(syscfg.System.Listen, nil)
-	} else {
+	if syscfg.System.Listen == "" && (syscfg.Tls.Enabled == false || syscfg.Tls.Listen == "") {
 		log.Fatalln("The listen directive is missing from the configuration file")
 	}
 
+	if syscfg.Tls.Enabled == true {
+		if syscfg.Tls.Listen == "" {
+			log.Fatalln("The tls listen directive is missing from the configuration file")
+		}
+		tlsConfig, err := syscfg.TlsConfig()
+		if err != nil {
+			log.Fatalf("Unable to set up TLS: %v", err)
+		}
+
+		if syscfg.System.Listen != "" {
+			var handler http.Handler
+			if syscfg.Tls.Redirect == true {
+				log.Println("Redirecting HTTP requests at", syscfg.System.Listen, "to HTTPS")
+				handler = http.HandlerFunc(taxiiServerObject.HttpsRedirectHandler)
+			}
+			go func() {
+				log.Fatalln(http.ListenAndServe(syscfg.System.Listen, handler))
+			}()
+		}
+
+		log.Println("Listening for HTTPS at", syscfg.Tls.Listen)
+		tlsServer := &http.Server{Addr: syscfg.Tls.Listen, TLSConfig: tlsConfig}
+		log.Fatalln(tlsServer.ListenAndServeTLS("", ""))
+	}
+
+	log.Fatalln(http.ListenAndServe(syscfg.System.Listen, nil))
+
 }
 
 // --------------------------------------------------
@@ -0,0 +1,99 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package auth hashes and checks the passwords and API tokens of users. The
+// server uses it to sign users in and freetaxii-mgmt uses it to set them up.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"sync"
+)
+
+// Every API token starts with this, so a leaked token is easy to search for
+const (
+	API_TOKEN_PREFIX = "ftx_"
+)
+
+// Passwords shorter than this are not accepted
+const (
+	MIN_PASSWORD_LENGTH = 8
+)
+
+// A hash to compare against when the user does not exist, so that a sign in
+// takes as long for a user that does not exist as for one that does
+var dummyHash []byte
+var dummyHashOnce sync.Once
+
+// --------------------------------------------------
+// Hash a Password
+// --------------------------------------------------
+
+func HashPassword(password string) (string, error) {
+	if len(password) < MIN_PASSWORD_LENGTH {
+		return "", errors.New("passwords need to be at least 8 characters long")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// --------------------------------------------------
+// Check a Password
+// --------------------------------------------------
+// A blank hash never matches, it is used for users that can only use tokens.
+
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		CheckNoUser(password)
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Takes as long as checking a password, for when there is no user to check it
+// against
+func CheckNoUser(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("no user has this password"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+// --------------------------------------------------
+// Create an API Token
+// --------------------------------------------------
+// Returns the token, which is only ever shown once, and the hash that is
+// stored.
+
+func NewApiToken() (string, string, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", "", err
+	}
+	token := API_TOKEN_PREFIX + base64.RawURLEncoding.EncodeToString(data)
+	return token, HashApiToken(token), nil
+}
+
+// --------------------------------------------------
+// Hash an API Token
+// --------------------------------------------------
+// Tokens are long and random, so a plain SHA-256 hash is enough and lets the
+// token be looked up by its hash.
+
+func HashApiToken(token string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return hex.EncodeToString(sum[:])
+}
@@ -7,11 +7,10 @@
 package config
 
 import (
-	"database/sql"
 	"encoding/json"
-	_ "github.com/mattn/go-sqlite3"
 	"log"
 	"os"
+	"path/filepath"
 )
 
 // Log Level 1 = basic system logging information, sent to STDOUT unless Enabled = true then it is logged to a file
@@ -23,10 +22,34 @@ import (
 type ServerConfigType struct {
 	System struct {
 		Listen         string
+		AdminListen    string // Address of the admin API listener, keep it on localhost
 		Prefix         string
-		DbFile         string
+		DbType         string // sqlite3, postgres or memory
+		DbFile         string // Used by sqlite3
+		DbConnection   string // Used by postgres
 		DbFileFullPath string
 	}
+	Tls struct {
+		Enabled              bool
+		Listen               string   // Address of the HTTPS listener, system listen stays plain HTTP
+		CertFile             string   // PEM certificate chain, relative to the prefix
+		KeyFile              string   // PEM private key, relative to the prefix
+		MinVersion           string   // 1.0, 1.1, 1.2 or 1.3, blank for 1.2
+		CipherSuites         []string // Names from crypto/tls, blank for the Go defaults
+		Redirect             bool     // Send plain HTTP requests to the HTTPS listener
+		ClientCaFile         string   // PEM bundle of the CAs that sign client certificates, blank for none
+		CrlFile              string   // PEM or DER revocation lists of those CAs, read again when it changes
+		RequireClientCert    bool     // Turn away clients that do not send a certificate
+		CertFileFullPath     string
+		KeyFileFullPath      string
+		ClientCaFileFullPath string
+		CrlFileFullPath      string
+	}
+	Auth struct {
+		Enabled bool   // Every TAXII and export request needs a user or a client certificate identity
+		Realm   string // Realm sent with HTTP Basic challenges, blank for FreeTAXII
+		Acl     bool   // Collections are only offered to the users, identities and groups they are granted to
+	}
 	Logging struct {
 		Enabled         bool
 		LogLevel        int
@@ -34,13 +57,50 @@ type ServerConfigType struct {
 		LogFileFullPath string
 	}
 	Services struct {
-		Discovery  string
-		Collection string
-		Poll       string
-		Admin      string
+		Discovery    string
+		Collection   string
+		Subscription string
+		Poll         string
+		Inbox        string
+		Admin        string
+		Export       string // Plain text, CSV, rule and firewall exports of the collections
+
+		MaxRequestSize int // Largest request body in bytes that the TAXII 1.x services read
 	}
 	Poll struct {
-		FormatOutput bool
+		FormatOutput     bool
+		MaxContentBlocks int // Maximum content blocks in a single Poll Response
+		ResultSetTimeout int // Seconds a paged result set is kept for Poll Fulfillment
+		AsyncWorkers     int // Number of workers that build asynchronous poll results
+		EstimatedWait    int // Seconds a client is told to wait for an asynchronous poll result
+	}
+	Delivery struct {
+		Enabled       bool
+		Interval      int // Seconds between delivery runs
+		MaxBlocks     int // Maximum content blocks in a single pushed Inbox Message
+		RetryDelay    int // Seconds to wait after the first failed delivery, doubled on each failure
+		MaxRetryDelay int // Upper limit in seconds for the retry delay
+		Workers       int // Number of subscriptions delivered to at the same time
+
+		// Push addresses on loopback, link local and private networks are
+		// refused unless this is set
+		AllowPrivateAddresses bool
+	}
+	Fetcher struct {
+		Enabled  bool
+		Interval int // Seconds between checks for feeds that are due
+		Timeout  int // Seconds to wait for a remote source
+		MaxSize  int // Largest content in bytes that is read from a source
+	}
+	Taxii2 struct {
+		Enabled          bool
+		Discovery        string // URL path of the TAXII 2.1 discovery endpoint
+		ApiRoot          string // URL path of the TAXII 2.1 API root
+		Title            string
+		Description      string
+		Contact          string
+		MaxPageSize      int // Maximum objects returned in a single envelope or manifest
+		MaxContentLength int // Maximum size in bytes of a request body
 	}
 }
 
@@ -67,9 +127,18 @@ func (this *ServerConfigType) LoadConfig(filename string) {
 		log.Fatalf("error parsing configuration file %v", err)
 	}
 
+	// The database has always been SQLite
+	if this.System.DbType == "" {
+		this.System.DbType = "sqlite3"
+	}
+
 	// Lets assign the full paths to a few variables so we can use them later
 	this.System.DbFileFullPath = this.System.Prefix + "/" + this.System.DbFile
 	this.Logging.LogFileFullPath = this.System.Prefix + "/" + this.Logging.LogFile
+	this.Tls.CertFileFullPath = this.fullPath(this.Tls.CertFile)
+	this.Tls.KeyFileFullPath = this.fullPath(this.Tls.KeyFile)
+	this.Tls.ClientCaFileFullPath = this.fullPath(this.Tls.ClientCaFile)
+	this.Tls.CrlFileFullPath = this.fullPath(this.Tls.CrlFile)
 
 	if this.Logging.LogLevel >= 5 {
 		log.Printf("DEBUG-5: System Configuration Dump %+v\n", this)
@@ -77,44 +146,23 @@ func (this *ServerConfigType) LoadConfig(filename string) {
 }
 
 // --------------------------------------------------
-// Get list of valid collections
+// Get the Data Source of the Store
 // --------------------------------------------------
+// SQLite uses the database file, PostgreSQL uses the connection string and
+// the memory store does not need either.
 
-func (this *ServerConfigType) GetValidCollections() map[string]string {
-
-	// TODO Read in from a database the collections we offer for this authenticated
-	// user and put them in a map
-	// TODO switch from a map to a struct so we can track more than just name and description
-
-	// Open connection to database
-	filename := this.System.DbFileFullPath
-	db, err := sql.Open("sqlite3", filename)
-	if err != nil {
-		log.Fatalf("Unable to open file %s due to error %v", filename, err)
+func (this *ServerConfigType) DbDataSource() string {
+	if this.System.DbType == "postgres" {
+		return this.System.DbConnection
 	}
-	defer db.Close()
-
-	rows, err := db.Query("SELECT collection, description FROM Collections")
-	if err != nil {
-		log.Printf("error running query, %v", err)
-	}
-	defer rows.Close()
-
-	c := make(map[string]string)
-
-	for rows.Next() {
-		var collection string
-		var description string
-		err = rows.Scan(&collection, &description)
-
-		if err != nil {
-			log.Printf("error reading from database, %v", err)
-		}
+	return this.System.DbFileFullPath
+}
 
-		c[collection] = description
+// Certificate and key files can be given with an absolute path, anything else
+// is under the prefix
+func (this *ServerConfigType) fullPath(filename string) string {
+	if filename == "" || filepath.IsAbs(filename) {
+		return filename
 	}
-
-	// c["ip-watch-list"] = "List of interesting IP addresses"
-	// c["url-watch-list"] = "List of interesting URL addresses"
-	return c
+	return this.System.Prefix + "/" + filename
 }
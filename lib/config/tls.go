@@ -0,0 +1,120 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+)
+
+const (
+	DEFAULT_TLS_MIN_VERSION = "1.2"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// --------------------------------------------------
+// Build the TLS Configuration of the HTTPS Listener
+// --------------------------------------------------
+// The certificate and key are loaded here so a bad file stops the server
+// before it starts listening. Cipher suites only apply up to TLS 1.2, the
+// TLS 1.3 suites can not be changed.
+//
+// Client certificates are asked for when there is a client CA file, but they
+// are not checked during the handshake. A handshake that fails can not carry a
+// TAXII status message, so the certificate is checked against the CAs and the
+// revocation list when the request is handled.
+
+func (this *ServerConfigType) TlsConfig() (*tls.Config, error) {
+	if this.Tls.CertFile == "" || this.Tls.KeyFile == "" {
+		return nil, errors.New("the tls section needs both a certfile and a keyfile")
+	}
+
+	cert, err := tls.LoadX509KeyPair(this.Tls.CertFileFullPath, this.Tls.KeyFileFullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	minVersion := this.Tls.MinVersion
+	if minVersion == "" {
+		minVersion = DEFAULT_TLS_MIN_VERSION
+	}
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, errors.New("the tls minversion " + minVersion + " is not one of 1.0, 1.1, 1.2 or 1.3")
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   version,
+	}
+
+	if this.Tls.ClientCaFile != "" {
+		tlsConfig.ClientAuth = tls.RequestClientCert
+		if this.Tls.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAnyClientCert
+		}
+	} else if this.Tls.RequireClientCert {
+		return nil, errors.New("the tls section needs a clientcafile to require client certificates")
+	}
+
+	if len(this.Tls.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range this.Tls.CipherSuites {
+			id, ok := suites[strings.TrimSpace(name)]
+			if !ok {
+				return nil, errors.New("the tls cipher suite " + name + " is not known")
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// --------------------------------------------------
+// Build the TLS Configuration of the Admin Listener
+// --------------------------------------------------
+// The admin listener uses the certificate of the HTTPS listener. Admins sign
+// in with a password or an API token, so client certificates are never asked
+// for.
+
+func (this *ServerConfigType) AdminTlsConfig() (*tls.Config, error) {
+	tlsConfig, err := this.TlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ClientAuth = tls.NoClientCert
+	return tlsConfig, nil
+}
+
+// --------------------------------------------------
+// Check if a Listen Address is Loopback Only
+// --------------------------------------------------
+// The host has to be localhost or a loopback IP address. A blank host listens
+// on every address, so it is not.
+
+func IsLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
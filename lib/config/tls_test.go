@@ -0,0 +1,35 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package config
+
+import (
+	"testing"
+)
+
+func TestIsLoopbackAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{"127.0.0.1:8001", true},
+		{"127.8.8.8:8001", true},
+		{"localhost:8001", true},
+		{"[::1]:8001", true},
+		{":8001", false},
+		{"0.0.0.0:8001", false},
+		{"[::]:8001", false},
+		{"192.0.2.1:8001", false},
+		{"admin.example.com:8001", false},
+		{"127.0.0.1", false},
+	}
+
+	for _, test := range tests {
+		if got := IsLoopbackAddress(test.address); got != test.want {
+			t.Errorf("IsLoopbackAddress(%q) = %t, want %t", test.address, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package export writes the indicators of a collection in formats that
+// firewalls, IDS sensors and DNS servers can load directly.
+package export
+
+import (
+	"errors"
+	"github.com/freetaxii/freetaxii-server/lib/parser"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"io"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------------
+// Define Export Format Type
+// ----------------------------------------------------------------------
+// Each format writes the values that it can use and skips the rest, so an
+// iptables script only has the addresses and networks in a collection. The
+// values given to a format have been through parser.Normalize.
+
+type FormatType struct {
+	Name        string
+	ContentType string
+	Write       func(w io.Writer, info InfoType, observables []storage.ObservableType) error
+}
+
+// This type holds what a format needs to know about the export beyond the
+// values themselves
+type InfoType struct {
+	CollectionName string
+	Generated      time.Time
+}
+
+var registry = struct {
+	sync.RWMutex
+	formats map[string]FormatType
+}{formats: make(map[string]FormatType)}
+
+// --------------------------------------------------
+// Register a Format
+// --------------------------------------------------
+
+func Register(format FormatType) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.formats[format.Name] = format
+}
+
+// --------------------------------------------------
+// Get a Format by Name
+// --------------------------------------------------
+
+func Get(name string) (FormatType, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	format, ok := registry.formats[name]
+	if !ok {
+		return FormatType{}, errors.New("unknown export format " + name)
+	}
+	return format, nil
+}
+
+// --------------------------------------------------
+// Get the Names of every Format
+// --------------------------------------------------
+
+func Names() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	var names []string
+	for name := range registry.formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// --------------------------------------------------
+// Helpers
+// --------------------------------------------------
+
+func isAddress(observableType string) bool {
+	switch observableType {
+	case parser.OBSERVABLE_TYPE_IPV4_ADDRESS, parser.OBSERVABLE_TYPE_IPV4_NETWORK,
+		parser.OBSERVABLE_TYPE_IPV6_ADDRESS, parser.OBSERVABLE_TYPE_IPV6_NETWORK:
+		return true
+	}
+	return false
+}
+
+func isIpv6(observableType string) bool {
+	return observableType == parser.OBSERVABLE_TYPE_IPV6_ADDRESS || observableType == parser.OBSERVABLE_TYPE_IPV6_NETWORK
+}
+
+// Names used for firewall chains and sets can only have a few characters in
+// them, anything else becomes a -
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+func safeName(s string, max int) string {
+	s = unsafeName.ReplaceAllString(s, "-")
+	if len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
@@ -0,0 +1,116 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package export
+
+import (
+	"fmt"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"io"
+	"time"
+)
+
+// The default size of an ipset hash, larger sets need to say so
+const IPSET_DEFAULT_MAXELEM = 65536
+
+// ----------------------------------------------------------------------
+// iptables and ipset
+// ----------------------------------------------------------------------
+// Only addresses and networks are written. The iptables script creates or
+// empties a chain for the collection and drops traffic to and from each value
+// in it, it is left to the firewall policy to send traffic to the chain. The
+// ipset file is read with ipset restore and fills one set for IPv4 and one for
+// IPv6.
+
+func init() {
+	Register(FormatType{Name: "iptables", ContentType: "text/x-shellscript; charset=utf-8", Write: writeIptables})
+	Register(FormatType{Name: "ipset", ContentType: "text/plain; charset=utf-8", Write: writeIpset})
+}
+
+func writeIptables(w io.Writer, info InfoType, observables []storage.ObservableType) error {
+	chain := "FT-" + safeName(info.CollectionName, 25)
+
+	fmt.Fprintln(w, "#!/bin/sh")
+	fmt.Fprintf(w, "# FreeTAXII collection %s\n", info.CollectionName)
+	fmt.Fprintf(w, "# Generated %s\n", info.Generated.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "# Send traffic to the chain with: iptables -I INPUT -j %s\n", chain)
+	fmt.Fprintln(w, "set -e")
+
+	// A command is only needed for an address family that has values
+	commands := make(map[string]bool)
+	for _, value := range observables {
+		if isAddress(value.Type) {
+			commands[iptablesCommand(value.Type)] = true
+		}
+	}
+	for _, command := range []string{"iptables", "ip6tables"} {
+		if commands[command] {
+			fmt.Fprintf(w, "%s -N %s 2>/dev/null || %s -F %s\n", command, chain, command, chain)
+		}
+	}
+
+	for _, value := range observables {
+		if !isAddress(value.Type) {
+			continue
+		}
+		command := iptablesCommand(value.Type)
+		fmt.Fprintf(w, "%s -A %s -s %s -j DROP\n", command, chain, value.Value)
+		_, err := fmt.Fprintf(w, "%s -A %s -d %s -j DROP\n", command, chain, value.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeIpset(w io.Writer, info InfoType, observables []storage.ObservableType) error {
+	name := "ft-" + safeName(info.CollectionName, 25)
+
+	fmt.Fprintf(w, "# FreeTAXII collection %s\n", info.CollectionName)
+	fmt.Fprintf(w, "# Generated %s\n", info.Generated.UTC().Format(time.RFC3339))
+
+	sets := []struct {
+		name   string
+		family string
+		values []string
+	}{
+		{name: name + "-v4", family: "inet"},
+		{name: name + "-v6", family: "inet6"},
+	}
+	for _, value := range observables {
+		if !isAddress(value.Type) {
+			continue
+		}
+		if isIpv6(value.Type) {
+			sets[1].values = append(sets[1].values, value.Value)
+		} else {
+			sets[0].values = append(sets[0].values, value.Value)
+		}
+	}
+
+	for _, set := range sets {
+		maxelem := IPSET_DEFAULT_MAXELEM
+		if len(set.values) > maxelem {
+			maxelem = len(set.values)
+		}
+		fmt.Fprintf(w, "create %s hash:net family %s maxelem %d -exist\n", set.name, set.family, maxelem)
+		fmt.Fprintf(w, "flush %s\n", set.name)
+		for _, value := range set.values {
+			_, err := fmt.Fprintf(w, "add %s %s -exist\n", set.name, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func iptablesCommand(observableType string) string {
+	if isIpv6(observableType) {
+		return "ip6tables"
+	}
+	return "iptables"
+}
@@ -0,0 +1,120 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package export
+
+import (
+	"fmt"
+	"github.com/freetaxii/freetaxii-server/lib/parser"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------------
+// DNS Response Policy Zone
+// ----------------------------------------------------------------------
+// Domain names block the name and every name under it. Addresses and networks
+// are written as rpz-ip triggers, which block answers that hold them. Every
+// rule answers NXDOMAIN. The serial is the time of the export, so a secondary
+// picks up each new copy.
+
+func init() {
+	Register(FormatType{Name: "rpz", ContentType: "text/dns; charset=utf-8", Write: writeRpz})
+}
+
+func writeRpz(w io.Writer, info InfoType, observables []storage.ObservableType) error {
+	fmt.Fprintf(w, "; FreeTAXII collection %s\n", info.CollectionName)
+	fmt.Fprintf(w, "; Generated %s\n", info.Generated.UTC().Format(time.RFC3339))
+	fmt.Fprintln(w, "$TTL 300")
+	fmt.Fprintf(w, "@ IN SOA localhost. hostmaster.localhost. ( %d 3600 600 86400 300 )\n", info.Generated.Unix())
+	fmt.Fprintln(w, "@ IN NS localhost.")
+
+	for _, value := range observables {
+		var err error
+		switch {
+		case value.Type == parser.OBSERVABLE_TYPE_DOMAIN_NAME:
+			fmt.Fprintf(w, "%s CNAME .\n", value.Value)
+			_, err = fmt.Fprintf(w, "*.%s CNAME .\n", value.Value)
+		case isAddress(value.Type):
+			trigger := rpzIpTrigger(value.Value)
+			if trigger != "" {
+				_, err = fmt.Fprintf(w, "%s CNAME .\n", trigger)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --------------------------------------------------
+// Helpers
+// --------------------------------------------------
+
+// An address or network as an rpz-ip owner name: the prefix length and then
+// the address in reverse order. IPv4 uses the octets, IPv6 uses the 16 bit
+// words in hex with zz in place of the longest run of zero words.
+func rpzIpTrigger(value string) string {
+	if !strings.Contains(value, "/") {
+		if strings.Contains(value, ":") {
+			value = value + "/128"
+		} else {
+			value = value + "/32"
+		}
+	}
+	ip, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return ""
+	}
+	ones, _ := network.Mask.Size()
+	ip = ip.Mask(network.Mask)
+
+	labels := []string{strconv.Itoa(ones)}
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := 3; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(ip4[i])))
+		}
+		return strings.Join(labels, ".") + ".rpz-ip"
+	}
+
+	var words [8]int
+	for i := range words {
+		words[i] = int(ip[2*i])<<8 | int(ip[2*i+1])
+	}
+
+	// The longest run of zero words, runs of one are left alone
+	start, length := -1, 1
+	for i := 0; i < 8; {
+		if words[i] != 0 {
+			i++
+			continue
+		}
+		j := i
+		for j < 8 && words[j] == 0 {
+			j++
+		}
+		if j-i > length {
+			start, length = i, j-i
+		}
+		i = j
+	}
+
+	for i := 7; i >= 0; i-- {
+		if start >= 0 && i >= start && i < start+length {
+			if i == start {
+				labels = append(labels, "zz")
+			}
+			continue
+		}
+		labels = append(labels, strconv.FormatInt(int64(words[i]), 16))
+	}
+	return strings.Join(labels, ".") + ".rpz-ip"
+}
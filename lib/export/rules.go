@@ -0,0 +1,151 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/freetaxii/freetaxii-server/lib/parser"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The first rule gets this SID plus one. SIDs from one million up are for
+// local rules, and rules are numbered again on every export.
+const RULE_SID_BASE = 9000000
+
+// ----------------------------------------------------------------------
+// Snort and Suricata Rules
+// ----------------------------------------------------------------------
+// Addresses and networks alert on traffic to or from them. Domain names alert
+// on DNS queries for the name or any name under it. URLs with the http scheme
+// alert on requests for the host and path, other URLs are skipped since the
+// sensor can not see them. Email addresses and hashes are skipped.
+
+func init() {
+	Register(FormatType{Name: "snort", ContentType: "text/plain; charset=utf-8", Write: writeSnort})
+	Register(FormatType{Name: "suricata", ContentType: "text/plain; charset=utf-8", Write: writeSuricata})
+}
+
+func writeSnort(w io.Writer, info InfoType, observables []storage.ObservableType) error {
+	return writeRules(w, info, observables, snortRuleOptions)
+}
+
+func writeSuricata(w io.Writer, info InfoType, observables []storage.ObservableType) error {
+	return writeRules(w, info, observables, suricataRuleOptions)
+}
+
+func writeRules(w io.Writer, info InfoType, observables []storage.ObservableType, ruleOptions func(observable storage.ObservableType) (string, string)) error {
+	fmt.Fprintf(w, "# FreeTAXII collection %s\n", info.CollectionName)
+	fmt.Fprintf(w, "# Generated %s\n", info.Generated.UTC().Format(time.RFC3339))
+
+	sid := RULE_SID_BASE
+	for _, value := range observables {
+		header, options := ruleOptions(value)
+		if header == "" {
+			continue
+		}
+		sid++
+		msg := ruleMsg("FreeTAXII " + info.CollectionName + " " + value.Value)
+		_, err := fmt.Fprintf(w, "alert %s (msg:\"%s\"; %ssid:%d; rev:1;)\n", header, msg, options, sid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the rule header and the options that come before the SID, or a
+// blank header if there is no rule for the value
+func snortRuleOptions(observable storage.ObservableType) (string, string) {
+	switch {
+	case isAddress(observable.Type):
+		return "ip $HOME_NET any <> " + observable.Value + " any", "classtype:trojan-activity; "
+
+	case observable.Type == parser.OBSERVABLE_TYPE_DOMAIN_NAME:
+		return "udp $HOME_NET any -> any 53", "content:\"" + dnsLabels(observable.Value) + "\"; nocase; classtype:trojan-activity; "
+
+	case observable.Type == parser.OBSERVABLE_TYPE_URL:
+		u, ok := httpUrl(observable.Value)
+		if !ok {
+			return "", ""
+		}
+		options := "flow:established,to_server; content:\"Host|3A 20|" + ruleContent(u.Host) + "|0D 0A|\"; http_header; nocase; "
+		options += "content:\"" + ruleContent(u.RequestURI()) + "\"; http_uri; classtype:trojan-activity; "
+		return "tcp $HOME_NET any -> $EXTERNAL_NET $HTTP_PORTS", options
+	}
+	return "", ""
+}
+
+func suricataRuleOptions(observable storage.ObservableType) (string, string) {
+	switch {
+	case isAddress(observable.Type):
+		return "ip $HOME_NET any <> " + observable.Value + " any", "classtype:trojan-activity; "
+
+	case observable.Type == parser.OBSERVABLE_TYPE_DOMAIN_NAME:
+		return "dns $HOME_NET any -> any any", "dns.query; dotprefix; content:\"." + ruleContent(observable.Value) + "\"; nocase; endswith; classtype:trojan-activity; "
+
+	case observable.Type == parser.OBSERVABLE_TYPE_URL:
+		// The host buffer does not have the port in it
+		u, ok := httpUrl(observable.Value)
+		if !ok {
+			return "", ""
+		}
+		options := "flow:established,to_server; http.host; content:\"" + ruleContent(u.Hostname()) + "\"; startswith; endswith; "
+		options += "http.uri; content:\"" + ruleContent(u.RequestURI()) + "\"; startswith; classtype:trojan-activity; "
+		return "http $HOME_NET any -> $EXTERNAL_NET any", options
+	}
+	return "", ""
+}
+
+// --------------------------------------------------
+// Helpers
+// --------------------------------------------------
+
+// Only http URLs can be seen by a sensor
+func httpUrl(value string) (*url.URL, bool) {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme != "http" {
+		return nil, false
+	}
+	return u, true
+}
+
+// A domain name as it is sent in a DNS query, with the length of each label
+// in front of it. Names under the domain end the same way so they also match.
+func dnsLabels(domain string) string {
+	var b bytes.Buffer
+	for _, label := range strings.Split(domain, ".") {
+		fmt.Fprintf(&b, "|%02X|%s", len(label), ruleContent(label))
+	}
+	b.WriteString("|00|")
+	return b.String()
+}
+
+// Characters that end or change the meaning of a content match are written
+// as hex
+func ruleContent(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || strings.IndexByte("\"';\\|:", c) >= 0 {
+			fmt.Fprintf(&b, "|%02X|", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+var msgEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `;`, `\;`)
+
+func ruleMsg(s string) string {
+	return msgEscaper.Replace(s)
+}
@@ -0,0 +1,49 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"io"
+	"time"
+)
+
+// ----------------------------------------------------------------------
+// Plain Text and CSV
+// ----------------------------------------------------------------------
+// The plain text list has one value per line under a few comment lines, which
+// is what most firewalls read as an external block list. The CSV list has a
+// header row and the type and first seen time of each value.
+
+func init() {
+	Register(FormatType{Name: "txt", ContentType: "text/plain; charset=utf-8", Write: writeText})
+	Register(FormatType{Name: "csv", ContentType: "text/csv; charset=utf-8", Write: writeCsv})
+}
+
+func writeText(w io.Writer, info InfoType, observables []storage.ObservableType) error {
+	fmt.Fprintf(w, "# FreeTAXII collection %s\n", info.CollectionName)
+	fmt.Fprintf(w, "# Generated %s\n", info.Generated.UTC().Format(time.RFC3339))
+	for _, value := range observables {
+		_, err := fmt.Fprintln(w, value.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCsv(w io.Writer, info InfoType, observables []storage.ObservableType) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"type", "value", "created"})
+	for _, value := range observables {
+		c.Write([]string{value.Type, value.Value, value.Created})
+	}
+	c.Flush()
+	return c.Error()
+}
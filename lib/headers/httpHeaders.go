@@ -8,12 +8,20 @@ package headers
 
 import (
 	"fmt"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
 	"github.com/freetaxii/libtaxii/defs"
 	"net/http"
 )
 
+// The TAXII message bindings of the request and of the response, and the
+// protocol binding the request came in on, are set by
+// VerifyHttpTaxiiHeaderValues. The response binding defaults to JSON so that
+// a status message can still be sent when the headers are not right.
 type HttpHeaderType struct {
-	DebugLevel int
+	DebugLevel      int
+	RequestBinding  string
+	ResponseBinding string
+	Protocol        string
 }
 
 // --------------------------------------------------
@@ -21,6 +29,12 @@ type HttpHeaderType struct {
 // --------------------------------------------------
 
 func (this *HttpHeaderType) VerifyHttpTaxiiHeaderValues(r *http.Request) error {
+	this.RequestBinding = common.TAXII_MESSAGE_JSON
+	this.ResponseBinding = common.TAXII_MESSAGE_JSON
+	this.Protocol = common.TAXII_PROTOCOL_HTTP
+	if r.TLS != nil {
+		this.Protocol = common.TAXII_PROTOCOL_HTTPS
+	}
 
 	// --------------------------------------------------
 	// Version of the TAXII specification they are using
@@ -40,9 +54,10 @@ func (this *HttpHeaderType) VerifyHttpTaxiiHeaderValues(r *http.Request) error {
 		return fmt.Errorf("%s, Requested Encoding Not Defined in HTTP Header X-Taxii-Accept", r.RemoteAddr)
 	}
 
-	if r.Header["X-Taxii-Accept"][0] != defs.TAXII_MESSAGE_JSON {
+	if !common.IsSupportedMessageBinding(r.Header["X-Taxii-Accept"][0]) {
 		return fmt.Errorf("%s, Client Requested Response Encoding in X-Taxii-Accept is Unsupported, %s", r.RemoteAddr, r.Header["X-Taxii-Accept"][0])
 	}
+	this.ResponseBinding = r.Header["X-Taxii-Accept"][0]
 
 	// --------------------------------------------------
 	// TAXII message format the client used on this message, JSON, XML, etc.
@@ -51,13 +66,36 @@ func (this *HttpHeaderType) VerifyHttpTaxiiHeaderValues(r *http.Request) error {
 		return fmt.Errorf("%s, Supplied Content Encoding Not Defined in HTTP Header X-Taxii-Content-Type", r.RemoteAddr)
 	}
 
-	if r.Header["X-Taxii-Content-Type"][0] != defs.TAXII_MESSAGE_JSON {
+	if !common.IsSupportedMessageBinding(r.Header["X-Taxii-Content-Type"][0]) {
 		return fmt.Errorf("%s, Supplied Message Encoding in X-Taxii-Content-Type Is Unsupported, %s", r.RemoteAddr, r.Header["X-Taxii-Content-Type"][0])
 	}
+	this.RequestBinding = r.Header["X-Taxii-Content-Type"][0]
 
 	return nil
 }
 
+// --------------------------------------------------
+// Set HTTP Headers on a TAXII Response
+// --------------------------------------------------
+
+func (this *HttpHeaderType) SetHttpTaxiiResponseHeaders(w http.ResponseWriter) {
+	binding := this.ResponseBinding
+	if binding == "" {
+		binding = common.TAXII_MESSAGE_JSON
+	}
+
+	if binding == common.TAXII_MESSAGE_XML {
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	w.Header().Set("X-Taxii-Content-Type", binding)
+	w.Header().Set("X-Taxii-Services", defs.TAXII_VERSION)
+	if this.Protocol != "" {
+		w.Header().Set("X-Taxii-Protocol", this.Protocol)
+	}
+}
+
 // --------------------------------------------------
 // Debug HTTP Headers
 // --------------------------------------------------
@@ -84,7 +122,7 @@ func (this *HttpHeaderType) DebugHttpRequest(r *http.Request) {
 	fmt.Println("DEBUG: RequestURI", r.RequestURI)
 	fmt.Println("DEBUG: TLS", r.TLS)
 	fmt.Println("DEBUG: --------------- END HTTP DUMP ---------------")
-	fmt.Println("\n")
+	fmt.Print("\n\n")
 	fmt.Println("DEBUG: --------------- BEGIN HEADER DUMP ---------------")
 	for k, v := range r.Header {
 		fmt.Println("DEBUG:", k, v)
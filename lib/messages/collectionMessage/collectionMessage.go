@@ -0,0 +1,173 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package collectionMessage carries the Collection Information Response
+// message. It follows the libtaxii API, but each service of a collection can
+// advertise more than one message binding and the response can be sent in
+// either the JSON or the XML binding. The Collection Information Request has
+// not changed, so DecodeRequest reads either binding in to the libtaxii
+// request type.
+package collectionMessage
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	libtaxiiCollectionMessage "github.com/freetaxii/libtaxii/messages/collectionMessage"
+	"io"
+)
+
+// TAXII collection types
+const (
+	COLLECTION_TYPE_DATA_FEED = "DATA_FEED"
+	COLLECTION_TYPE_DATA_SET  = "DATA_SET"
+)
+
+// ----------------------------------------------------------------------
+// Define Message Types
+// ----------------------------------------------------------------------
+
+type CollectionResponseMessageType struct {
+	MessageType  string           `json:"message_type,omitempty"`
+	Id           string           `json:"id,omitempty"`
+	InResponseTo string           `json:"in_response_to,omitempty"`
+	Message      string           `json:"message,omitempty"`
+	Collections  []CollectionType `json:"collections,omitempty"`
+}
+
+type CollectionType struct {
+	CollectionName       string                `json:"collection_name,omitempty"`
+	CollectionType       string                `json:"collection_type,omitempty"`
+	Available            bool                  `json:"available"`
+	Description          string                `json:"description,omitempty"`
+	ContentBindings      []string              `json:"content_bindings,omitempty"`
+	ContentVolume        int                   `json:"content_volume,omitempty"`
+	PushMethods          []PushMethodType      `json:"push_methods,omitempty"`
+	PollingServices      []ServiceInstanceType `json:"polling_services,omitempty"`
+	SubscriptionServices []ServiceInstanceType `json:"subscription_services,omitempty"`
+	InboxServices        []ServiceInstanceType `json:"receiving_inbox_services,omitempty"`
+}
+
+type PushMethodType struct {
+	ProtocolBinding string   `json:"protocol_binding,omitempty"`
+	MessageBindings []string `json:"message_bindings,omitempty"`
+}
+
+type ServiceInstanceType struct {
+	ProtocolBinding string   `json:"protocol_binding,omitempty"`
+	Address         string   `json:"address,omitempty"`
+	MessageBindings []string `json:"message_bindings,omitempty"`
+}
+
+// ----------------------------------------------------------------------
+// Public Create Functions
+// ----------------------------------------------------------------------
+
+func NewResponse() CollectionResponseMessageType {
+	var obj CollectionResponseMessageType
+	obj.MessageType = "Collection_Information_Response"
+	obj.Id = common.CreateMessageId()
+	return obj
+}
+
+// ----------------------------------------------------------------------
+// Public Decode Functions
+// ----------------------------------------------------------------------
+
+func DecodeRequest(binding string, r io.Reader) (libtaxiiCollectionMessage.CollectionRequestMessageType, error) {
+	var obj libtaxiiCollectionMessage.CollectionRequestMessageType
+
+	if binding == common.TAXII_MESSAGE_XML {
+		var x collectionRequestXmlType
+		err := xml.NewDecoder(r).Decode(&x)
+		obj.Id = x.MessageId
+		return obj, err
+	}
+
+	err := json.NewDecoder(r).Decode(&obj)
+	return obj, err
+}
+
+// ----------------------------------------------------------------------
+// Collection Response Methods
+// ----------------------------------------------------------------------
+
+func (this *CollectionResponseMessageType) AddInResponseTo(s string) {
+	this.InResponseTo = s
+}
+
+// NewCollection adds an empty collection to the message and returns a
+// pointer to it so that the caller can populate it
+func (this *CollectionResponseMessageType) NewCollection() *CollectionType {
+	var obj CollectionType
+	obj.CollectionType = COLLECTION_TYPE_DATA_FEED
+	positionThatAppendWillUse := len(this.Collections)
+	this.Collections = append(this.Collections, obj)
+	return &this.Collections[positionThatAppendWillUse]
+}
+
+// Encode returns the message in the requested TAXII message binding
+func (this *CollectionResponseMessageType) Encode(binding string) ([]byte, error) {
+	if binding == common.TAXII_MESSAGE_XML {
+		return common.EncodeXml(this.toXml())
+	}
+	return json.Marshal(this)
+}
+
+// ----------------------------------------------------------------------
+// Collection Methods
+// ----------------------------------------------------------------------
+
+func (this *CollectionType) AddName(s string) {
+	this.CollectionName = s
+}
+
+func (this *CollectionType) SetTypeDataFeed() {
+	this.CollectionType = COLLECTION_TYPE_DATA_FEED
+}
+
+func (this *CollectionType) SetTypeDataSet() {
+	this.CollectionType = COLLECTION_TYPE_DATA_SET
+}
+
+func (this *CollectionType) SetAvailable() {
+	this.Available = true
+}
+
+func (this *CollectionType) SetUnavailable() {
+	this.Available = false
+}
+
+func (this *CollectionType) AddDescription(s string) {
+	this.Description = s
+}
+
+func (this *CollectionType) AddContentBinding(s string) {
+	this.ContentBindings = append(this.ContentBindings, s)
+}
+
+func (this *CollectionType) AddVolume(i int) {
+	this.ContentVolume = i
+}
+
+func (this *CollectionType) SetPushMethodToHttpJson() {
+	this.PushMethods = append(this.PushMethods, PushMethodType{
+		ProtocolBinding: common.TAXII_PROTOCOL_HTTP,
+		MessageBindings: []string{common.TAXII_MESSAGE_JSON},
+	})
+}
+
+func (this *CollectionType) AddPollService(protocol, address string, messageBindings ...string) {
+	this.PollingServices = append(this.PollingServices, ServiceInstanceType{protocol, address, messageBindings})
+}
+
+func (this *CollectionType) AddSubscriptionService(protocol, address string, messageBindings ...string) {
+	this.SubscriptionServices = append(this.SubscriptionServices, ServiceInstanceType{protocol, address, messageBindings})
+}
+
+func (this *CollectionType) AddInboxService(protocol, address string, messageBindings ...string) {
+	this.InboxServices = append(this.InboxServices, ServiceInstanceType{protocol, address, messageBindings})
+}
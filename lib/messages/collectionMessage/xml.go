@@ -0,0 +1,96 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package collectionMessage
+
+import (
+	"encoding/xml"
+)
+
+// ----------------------------------------------------------------------
+// Define TAXII XML Binding Types
+// ----------------------------------------------------------------------
+
+type collectionRequestXmlType struct {
+	XMLName   xml.Name `xml:"http://taxii.mitre.org/messages/taxii_xml_binding-1.1 Collection_Information_Request"`
+	MessageId string   `xml:"message_id,attr"`
+}
+
+type collectionResponseXmlType struct {
+	XMLName      xml.Name            `xml:"http://taxii.mitre.org/messages/taxii_xml_binding-1.1 Collection_Information_Response"`
+	MessageId    string              `xml:"message_id,attr"`
+	InResponseTo string              `xml:"in_response_to,attr"`
+	Collections  []collectionXmlType `xml:"Collection"`
+	Message      string              `xml:"Message,omitempty"`
+}
+
+type collectionXmlType struct {
+	CollectionName       string                   `xml:"collection_name,attr"`
+	CollectionType       string                   `xml:"collection_type,attr,omitempty"`
+	Available            bool                     `xml:"available,attr"`
+	Description          string                   `xml:"Description"`
+	ContentBindings      []contentBindingXmlType  `xml:"Content_Binding"`
+	ContentVolume        int                      `xml:"Content_Volume,omitempty"`
+	PushMethods          []pushMethodXmlType      `xml:"Push_Method"`
+	PollingServices      []serviceInstanceXmlType `xml:"Polling_Service"`
+	SubscriptionServices []serviceInstanceXmlType `xml:"Subscription_Service"`
+	InboxServices        []serviceInstanceXmlType `xml:"Receiving_Inbox_Service"`
+}
+
+type contentBindingXmlType struct {
+	BindingId string `xml:"binding_id,attr"`
+}
+
+type pushMethodXmlType struct {
+	ProtocolBinding string   `xml:"Protocol_Binding"`
+	MessageBindings []string `xml:"Message_Binding"`
+}
+
+type serviceInstanceXmlType struct {
+	ProtocolBinding string   `xml:"Protocol_Binding"`
+	Address         string   `xml:"Address"`
+	MessageBindings []string `xml:"Message_Binding"`
+}
+
+// ----------------------------------------------------------------------
+// Convert to the TAXII XML Binding
+// ----------------------------------------------------------------------
+
+func (this *CollectionResponseMessageType) toXml() collectionResponseXmlType {
+	var x collectionResponseXmlType
+	x.MessageId = this.Id
+	x.InResponseTo = this.InResponseTo
+	x.Message = this.Message
+
+	for _, value := range this.Collections {
+		c := collectionXmlType{
+			CollectionName: value.CollectionName,
+			CollectionType: value.CollectionType,
+			Available:      value.Available,
+			Description:    value.Description,
+			ContentVolume:  value.ContentVolume,
+		}
+		for _, binding := range value.ContentBindings {
+			c.ContentBindings = append(c.ContentBindings, contentBindingXmlType{binding})
+		}
+		for _, push := range value.PushMethods {
+			c.PushMethods = append(c.PushMethods, pushMethodXmlType{push.ProtocolBinding, push.MessageBindings})
+		}
+		c.PollingServices = serviceInstancesToXml(value.PollingServices)
+		c.SubscriptionServices = serviceInstancesToXml(value.SubscriptionServices)
+		c.InboxServices = serviceInstancesToXml(value.InboxServices)
+		x.Collections = append(x.Collections, c)
+	}
+	return x
+}
+
+func serviceInstancesToXml(services []ServiceInstanceType) []serviceInstanceXmlType {
+	var x []serviceInstanceXmlType
+	for _, value := range services {
+		x = append(x, serviceInstanceXmlType{value.ProtocolBinding, value.Address, value.MessageBindings})
+	}
+	return x
+}
@@ -0,0 +1,109 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package common
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/freetaxii/libtaxii/defs"
+	"io"
+	"strings"
+)
+
+// TAXII message and protocol bindings
+const (
+	TAXII_MESSAGE_JSON   = defs.TAXII_MESSAGE_JSON
+	TAXII_MESSAGE_XML    = "urn:taxii.mitre.org:message:xml:1.1"
+	TAXII_PROTOCOL_HTTP  = "urn:taxii.mitre.org:protocol:http:1.0"
+	TAXII_PROTOCOL_HTTPS = "urn:taxii.mitre.org:protocol:https:1.0"
+)
+
+// Content bindings of the STIX content the server publishes
+const (
+	CONTENT_BINDING_STIX1_JSON = "urn:stix.mitre.org:json:1.1.1"
+	CONTENT_BINDING_STIX2_JSON = "application/stix+json;version=2.1"
+)
+
+// Every element of a TAXII 1.1 XML message is in this namespace
+const (
+	TAXII_XML_NAMESPACE = "http://taxii.mitre.org/messages/taxii_xml_binding-1.1"
+)
+
+// --------------------------------------------------
+// Check a TAXII Message Binding
+// --------------------------------------------------
+
+func IsSupportedMessageBinding(s string) bool {
+	return s == TAXII_MESSAGE_JSON || s == TAXII_MESSAGE_XML
+}
+
+// --------------------------------------------------
+// Encode a TAXII XML Message
+// --------------------------------------------------
+
+func EncodeXml(v interface{}) ([]byte, error) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// --------------------------------------------------
+// Get the Root Element of a TAXII XML Message
+// --------------------------------------------------
+// The root element of a TAXII XML message is named after the message type, so
+// this is used to find out what kind of message was sent before decoding it.
+
+func XmlRootElement(data []byte) string {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
+
+// --------------------------------------------------
+// Prepare Content for an XML Content Block
+// --------------------------------------------------
+// Content that is already well formed XML, like a STIX 1.x document, is
+// embedded as is without its XML declaration. Anything else, like JSON, is
+// escaped as text.
+
+func XmlContent(s string) string {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "<?xml") {
+		if i := strings.Index(trimmed, "?>"); i != -1 {
+			trimmed = strings.TrimSpace(trimmed[i+2:])
+		}
+	}
+	if strings.HasPrefix(trimmed, "<") && isWellFormedXml(trimmed) {
+		return trimmed
+	}
+
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func isWellFormedXml(s string) bool {
+	decoder := xml.NewDecoder(strings.NewReader(s))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package common
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// --------------------------------------------------
+// Create a TAXII Message ID
+// --------------------------------------------------
+// Message IDs are random version 4 UUIDs
+
+func CreateMessageId() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return ""
+	}
+
+	// Set the version (4) and variant (RFC 4122) bits
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// --------------------------------------------------
+// Create a Name Based ID
+// --------------------------------------------------
+// Name based IDs are version 5 UUIDs, the same namespace and name always give
+// the same ID. The namespace must be a UUID.
+
+func CreateNameBasedId(namespace, name string) string {
+	ns, err := hex.DecodeString(strings.Replace(namespace, "-", "", -1))
+	if err != nil || len(ns) != 16 {
+		return ""
+	}
+
+	h := sha1.New()
+	h.Write(ns)
+	h.Write([]byte(name))
+	b := h.Sum(nil)[:16]
+
+	// Set the version (5) and variant (RFC 4122) bits
+	b[6] = (b[6] & 0x0f) | 0x50
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
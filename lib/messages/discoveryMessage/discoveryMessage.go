@@ -0,0 +1,146 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package discoveryMessage carries the Discovery Response message. It follows
+// the libtaxii API, but each service can advertise more than one message
+// binding and the response can be sent in either the JSON or the XML binding.
+// The Discovery Request has not changed, so DecodeRequest reads either
+// binding in to the libtaxii request type.
+package discoveryMessage
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"github.com/freetaxii/libtaxii/defs"
+	libtaxiiDiscoveryMessage "github.com/freetaxii/libtaxii/messages/discoveryMessage"
+	"io"
+)
+
+// TAXII service types
+const (
+	SERVICE_TYPE_DISCOVERY             = "DISCOVERY"
+	SERVICE_TYPE_COLLECTION_MANAGEMENT = "COLLECTION_MANAGEMENT"
+	SERVICE_TYPE_POLL                  = "POLL"
+	SERVICE_TYPE_INBOX                 = "INBOX"
+)
+
+// ----------------------------------------------------------------------
+// Define Message Types
+// ----------------------------------------------------------------------
+
+type DiscoveryResponseMessageType struct {
+	MessageType  string        `json:"message_type,omitempty"`
+	Id           string        `json:"id,omitempty"`
+	InResponseTo string        `json:"in_response_to,omitempty"`
+	Message      string        `json:"message,omitempty"`
+	Services     []ServiceType `json:"service_instances,omitempty"`
+}
+
+type ServiceType struct {
+	ServiceType     string   `json:"service_type,omitempty"`
+	ServiceVersion  string   `json:"service_version,omitempty"`
+	Available       bool     `json:"available"`
+	ProtocolBinding string   `json:"protocol_binding,omitempty"`
+	Address         string   `json:"address,omitempty"`
+	MessageBindings []string `json:"message_bindings,omitempty"`
+	Message         string   `json:"message,omitempty"`
+}
+
+// ----------------------------------------------------------------------
+// Public Create Functions
+// ----------------------------------------------------------------------
+
+func NewResponse() DiscoveryResponseMessageType {
+	var obj DiscoveryResponseMessageType
+	obj.MessageType = "Discovery_Response"
+	obj.Id = common.CreateMessageId()
+	return obj
+}
+
+// ----------------------------------------------------------------------
+// Public Decode Functions
+// ----------------------------------------------------------------------
+
+func DecodeRequest(binding string, r io.Reader) (libtaxiiDiscoveryMessage.DiscoveryRequestMessageType, error) {
+	var obj libtaxiiDiscoveryMessage.DiscoveryRequestMessageType
+
+	if binding == common.TAXII_MESSAGE_XML {
+		var x discoveryRequestXmlType
+		err := xml.NewDecoder(r).Decode(&x)
+		obj.Id = x.MessageId
+		return obj, err
+	}
+
+	err := json.NewDecoder(r).Decode(&obj)
+	return obj, err
+}
+
+// ----------------------------------------------------------------------
+// Discovery Response Methods
+// ----------------------------------------------------------------------
+
+func (this *DiscoveryResponseMessageType) AddInResponseTo(s string) {
+	this.InResponseTo = s
+}
+
+// NewService adds an empty service to the message and returns a pointer to
+// it so that the caller can populate it
+func (this *DiscoveryResponseMessageType) NewService() *ServiceType {
+	var obj ServiceType
+	obj.ServiceVersion = defs.TAXII_VERSION
+	positionThatAppendWillUse := len(this.Services)
+	this.Services = append(this.Services, obj)
+	return &this.Services[positionThatAppendWillUse]
+}
+
+// Encode returns the message in the requested TAXII message binding
+func (this *DiscoveryResponseMessageType) Encode(binding string) ([]byte, error) {
+	if binding == common.TAXII_MESSAGE_XML {
+		return common.EncodeXml(this.toXml())
+	}
+	return json.Marshal(this)
+}
+
+// ----------------------------------------------------------------------
+// Service Methods
+// ----------------------------------------------------------------------
+
+func (this *ServiceType) SetTypeDiscovery() {
+	this.ServiceType = SERVICE_TYPE_DISCOVERY
+}
+
+func (this *ServiceType) SetTypeCollection() {
+	this.ServiceType = SERVICE_TYPE_COLLECTION_MANAGEMENT
+}
+
+func (this *ServiceType) SetTypePoll() {
+	this.ServiceType = SERVICE_TYPE_POLL
+}
+
+func (this *ServiceType) SetTypeInbox() {
+	this.ServiceType = SERVICE_TYPE_INBOX
+}
+
+func (this *ServiceType) SetAvailable() {
+	this.Available = true
+}
+
+func (this *ServiceType) SetUnavailable() {
+	this.Available = false
+}
+
+func (this *ServiceType) AddProtocolBinding(s string) {
+	this.ProtocolBinding = s
+}
+
+func (this *ServiceType) AddMessageBinding(s string) {
+	this.MessageBindings = append(this.MessageBindings, s)
+}
+
+func (this *ServiceType) AddAddress(s string) {
+	this.Address = s
+}
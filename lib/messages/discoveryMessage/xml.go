@@ -0,0 +1,62 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package discoveryMessage
+
+import (
+	"encoding/xml"
+)
+
+// ----------------------------------------------------------------------
+// Define TAXII XML Binding Types
+// ----------------------------------------------------------------------
+
+type discoveryRequestXmlType struct {
+	XMLName   xml.Name `xml:"http://taxii.mitre.org/messages/taxii_xml_binding-1.1 Discovery_Request"`
+	MessageId string   `xml:"message_id,attr"`
+}
+
+type discoveryResponseXmlType struct {
+	XMLName          xml.Name                 `xml:"http://taxii.mitre.org/messages/taxii_xml_binding-1.1 Discovery_Response"`
+	MessageId        string                   `xml:"message_id,attr"`
+	InResponseTo     string                   `xml:"in_response_to,attr"`
+	ServiceInstances []serviceInstanceXmlType `xml:"Service_Instance"`
+	Message          string                   `xml:"Message,omitempty"`
+}
+
+type serviceInstanceXmlType struct {
+	ServiceType     string   `xml:"service_type,attr"`
+	ServiceVersion  string   `xml:"service_version,attr"`
+	Available       bool     `xml:"available,attr"`
+	ProtocolBinding string   `xml:"Protocol_Binding"`
+	Address         string   `xml:"Address"`
+	MessageBindings []string `xml:"Message_Binding"`
+	Message         string   `xml:"Message,omitempty"`
+}
+
+// ----------------------------------------------------------------------
+// Convert to the TAXII XML Binding
+// ----------------------------------------------------------------------
+
+func (this *DiscoveryResponseMessageType) toXml() discoveryResponseXmlType {
+	var x discoveryResponseXmlType
+	x.MessageId = this.Id
+	x.InResponseTo = this.InResponseTo
+	x.Message = this.Message
+
+	for _, value := range this.Services {
+		x.ServiceInstances = append(x.ServiceInstances, serviceInstanceXmlType{
+			ServiceType:     value.ServiceType,
+			ServiceVersion:  value.ServiceVersion,
+			Available:       value.Available,
+			ProtocolBinding: value.ProtocolBinding,
+			Address:         value.Address,
+			MessageBindings: value.MessageBindings,
+			Message:         value.Message,
+		})
+	}
+	return x
+}
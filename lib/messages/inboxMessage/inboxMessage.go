@@ -0,0 +1,121 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package inboxMessage
+
+import (
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+)
+
+// ----------------------------------------------------------------------
+// Define Message Type
+// ----------------------------------------------------------------------
+
+type InboxMessageType struct {
+	MessageType                string                  `json:"message_type,omitempty"`
+	Id                         string                  `json:"id,omitempty"`
+	ResultId                   string                  `json:"result_id,omitempty"`
+	DestinationCollectionNames []string                `json:"destination_collection_names,omitempty"`
+	Message                    string                  `json:"message,omitempty"`
+	SourceSubscription         *SourceSubscriptionType `json:"source_subscription,omitempty"`
+	RecordCount                int                     `json:"record_count,omitempty"`
+	ContentBlocks              []ContentBlockType      `json:"content_blocks,omitempty"`
+}
+
+// SourceSubscriptionType is set when the server pushes content to a
+// subscriber and tells them which subscription, and which window of
+// timestamp labels, the content is for
+type SourceSubscriptionType struct {
+	CollectionName               string `json:"collection_name,omitempty"`
+	SubscriptionId               string `json:"subscription_id,omitempty"`
+	ExclusiveBeginTimestampLabel string `json:"exclusive_begin_timestamp_label,omitempty"`
+	InclusiveEndTimestampLabel   string `json:"inclusive_end_timestamp_label,omitempty"`
+}
+
+type ContentBlockType struct {
+	ContentBinding  string `json:"content_binding,omitempty"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	Content         string `json:"content,omitempty"`
+	TimestampLabel  string `json:"timestamp_label,omitempty"`
+	Message         string `json:"message,omitempty"`
+}
+
+// ----------------------------------------------------------------------
+// Public Create Functions
+// ----------------------------------------------------------------------
+
+func New() InboxMessageType {
+	var obj InboxMessageType
+	obj.MessageType = "Inbox_Message"
+	obj.Id = common.CreateMessageId()
+	return obj
+}
+
+// ----------------------------------------------------------------------
+// Public Methods
+// ----------------------------------------------------------------------
+
+func (this *InboxMessageType) AddResultId(s string) {
+	this.ResultId = s
+}
+
+func (this *InboxMessageType) AddDestinationCollectionName(s string) {
+	this.DestinationCollectionNames = append(this.DestinationCollectionNames, s)
+}
+
+func (this *InboxMessageType) AddMessage(s string) {
+	this.Message = s
+}
+
+func (this *InboxMessageType) AddSourceSubscription(collectionName, subscriptionId, begin, end string) {
+	this.SourceSubscription = &SourceSubscriptionType{
+		CollectionName:               collectionName,
+		SubscriptionId:               subscriptionId,
+		ExclusiveBeginTimestampLabel: begin,
+		InclusiveEndTimestampLabel:   end,
+	}
+}
+
+func (this *InboxMessageType) AddRecordCount(i int) {
+	this.RecordCount = i
+}
+
+// NewContentBlock adds an empty content block to the message and returns a
+// pointer to it so that the caller can populate it
+func (this *InboxMessageType) NewContentBlock() *ContentBlockType {
+	var obj ContentBlockType
+	positionThatAppendWillUse := len(this.ContentBlocks)
+	this.ContentBlocks = append(this.ContentBlocks, obj)
+	return &this.ContentBlocks[positionThatAppendWillUse]
+}
+
+// ----------------------------------------------------------------------
+// Content Block Methods
+// ----------------------------------------------------------------------
+
+func (this *ContentBlockType) AddContentBinding(s string) {
+	this.ContentBinding = s
+}
+
+func (this *ContentBlockType) SetContentEncodingToJson() {
+	this.ContentEncoding = "json"
+}
+
+func (this *ContentBlockType) AddContentEncoding(s string) {
+	this.ContentEncoding = s
+}
+
+func (this *ContentBlockType) AddContent(s string) {
+	this.Content = s
+}
+
+func (this *ContentBlockType) AddTimestampLabel(s string) {
+	this.TimestampLabel = s
+}
+
+func (this *ContentBlockType) AddMessage(s string) {
+	this.Message = s
+}
@@ -0,0 +1,271 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package pollMessage carries the Poll Request, Poll Response and Poll
+// Fulfillment messages. It follows the libtaxii API, but the content blocks
+// also carry the content binding and timestamp label of content that was
+// pushed in to the server, and the messages can be sent in either the JSON or
+// the XML binding.
+package pollMessage
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"io"
+)
+
+// Message types that can be sent to the Poll service
+const (
+	MSG_POLL_REQUEST     = "Poll_Request"
+	MSG_POLL_FULFILLMENT = "Poll_Fulfillment"
+)
+
+// ----------------------------------------------------------------------
+// Define Message Types
+// ----------------------------------------------------------------------
+
+type PollRequestMessageType struct {
+	MessageType             string              `json:"message_type,omitempty"`
+	Id                      string              `json:"id,omitempty"`
+	CollectionName          string              `json:"collection_name,omitempty"`
+	ExclusiveBeginTimestamp string              `json:"exclusive_begin_timestamp,omitempty"`
+	InclusiveEndTimestamp   string              `json:"inclusive_end_timestamp,omitempty"`
+	SubscriptionId          string              `json:"subscription_id,omitempty"`
+	PollParameters          *PollParametersType `json:"poll_parameters,omitempty"`
+}
+
+type PollParametersType struct {
+	AllowAsynch     bool     `json:"allow_asynch,omitempty"`
+	ContentBindings []string `json:"content_bindings,omitempty"`
+}
+
+type PollResponseMessageType struct {
+	MessageType             string             `json:"message_type,omitempty"`
+	Id                      string             `json:"id,omitempty"`
+	InResponseTo            string             `json:"in_response_to,omitempty"`
+	CollectionName          string             `json:"collection_name,omitempty"`
+	ResultId                string             `json:"result_id,omitempty"`
+	ExclusiveBeginTimestamp string             `json:"exclusive_begin_timestamp,omitempty"`
+	InclusiveEndTimestamp   string             `json:"inclusive_end_timestamp,omitempty"`
+	More                    bool               `json:"more"`
+	ResultPartNumber        int                `json:"result_part_number,omitempty"`
+	Message                 string             `json:"message,omitempty"`
+	ContentBlocks           []ContentBlockType `json:"content_blocks,omitempty"`
+}
+
+type PollFulfillmentMessageType struct {
+	MessageType      string `json:"message_type,omitempty"`
+	Id               string `json:"id,omitempty"`
+	CollectionName   string `json:"collection_name,omitempty"`
+	ResultId         string `json:"result_id,omitempty"`
+	ResultPartNumber int    `json:"result_part_number,omitempty"`
+}
+
+type ContentBlockType struct {
+	ContentBinding  string `json:"content_binding,omitempty"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	Content         string `json:"content,omitempty"`
+	TimestampLabel  string `json:"timestamp_label,omitempty"`
+}
+
+// ----------------------------------------------------------------------
+// Public Create Functions
+// ----------------------------------------------------------------------
+
+func NewRequest() PollRequestMessageType {
+	var obj PollRequestMessageType
+	obj.MessageType = MSG_POLL_REQUEST
+	obj.Id = common.CreateMessageId()
+	return obj
+}
+
+func NewResponse() PollResponseMessageType {
+	var obj PollResponseMessageType
+	obj.MessageType = "Poll_Response"
+	obj.Id = common.CreateMessageId()
+	obj.ResultPartNumber = 1
+	return obj
+}
+
+func NewFulfillment() PollFulfillmentMessageType {
+	var obj PollFulfillmentMessageType
+	obj.MessageType = MSG_POLL_FULFILLMENT
+	obj.Id = common.CreateMessageId()
+	return obj
+}
+
+// ----------------------------------------------------------------------
+// Public Decode Functions
+// ----------------------------------------------------------------------
+
+// GetMessageType returns the type of a message sent to the Poll service
+// without decoding all of it, so the caller can tell a Poll Request from a
+// Poll Fulfillment
+func GetMessageType(binding string, data []byte) string {
+	if binding == common.TAXII_MESSAGE_XML {
+		return common.XmlRootElement(data)
+	}
+
+	var obj struct {
+		MessageType string `json:"message_type"`
+	}
+	json.Unmarshal(data, &obj)
+	return obj.MessageType
+}
+
+func DecodeRequest(binding string, r io.Reader) (PollRequestMessageType, error) {
+	var obj PollRequestMessageType
+
+	if binding == common.TAXII_MESSAGE_XML {
+		var x pollRequestXmlType
+		err := xml.NewDecoder(r).Decode(&x)
+		return x.fromXml(), err
+	}
+
+	err := json.NewDecoder(r).Decode(&obj)
+	return obj, err
+}
+
+func DecodeFulfillment(binding string, r io.Reader) (PollFulfillmentMessageType, error) {
+	var obj PollFulfillmentMessageType
+
+	if binding == common.TAXII_MESSAGE_XML {
+		var x pollFulfillmentXmlType
+		err := xml.NewDecoder(r).Decode(&x)
+		return x.fromXml(), err
+	}
+
+	err := json.NewDecoder(r).Decode(&obj)
+	return obj, err
+}
+
+// ----------------------------------------------------------------------
+// Poll Request Methods
+// ----------------------------------------------------------------------
+
+func (this *PollRequestMessageType) AddCollectionName(s string) {
+	this.CollectionName = s
+}
+
+func (this *PollRequestMessageType) AddExclusiveBeginTimestamp(s string) {
+	this.ExclusiveBeginTimestamp = s
+}
+
+func (this *PollRequestMessageType) AddInclusiveEndTimestamp(s string) {
+	this.InclusiveEndTimestamp = s
+}
+
+func (this *PollRequestMessageType) AddSubscriptionId(s string) {
+	this.SubscriptionId = s
+}
+
+func (this *PollRequestMessageType) SetAllowAsynch(b bool) {
+	if this.PollParameters == nil {
+		this.PollParameters = &PollParametersType{}
+	}
+	this.PollParameters.AllowAsynch = b
+}
+
+func (this *PollRequestMessageType) AddContentBinding(s string) {
+	if this.PollParameters == nil {
+		this.PollParameters = &PollParametersType{}
+	}
+	this.PollParameters.ContentBindings = append(this.PollParameters.ContentBindings, s)
+}
+
+// ----------------------------------------------------------------------
+// Poll Response Methods
+// ----------------------------------------------------------------------
+
+func (this *PollResponseMessageType) AddInResponseTo(s string) {
+	this.InResponseTo = s
+}
+
+func (this *PollResponseMessageType) AddCollectionName(s string) {
+	this.CollectionName = s
+}
+
+func (this *PollResponseMessageType) AddResultId(s string) {
+	this.ResultId = s
+}
+
+func (this *PollResponseMessageType) AddExclusiveBeginTimestamp(s string) {
+	this.ExclusiveBeginTimestamp = s
+}
+
+func (this *PollResponseMessageType) AddInclusiveEndTimestamp(s string) {
+	this.InclusiveEndTimestamp = s
+}
+
+func (this *PollResponseMessageType) SetMore(b bool) {
+	this.More = b
+}
+
+func (this *PollResponseMessageType) AddResultPartNumber(i int) {
+	this.ResultPartNumber = i
+}
+
+func (this *PollResponseMessageType) AddMessage(s string) {
+	this.Message = s
+}
+
+// NewContentBlock adds an empty content block to the message and returns a
+// pointer to it so that the caller can populate it
+func (this *PollResponseMessageType) NewContentBlock() *ContentBlockType {
+	var obj ContentBlockType
+	positionThatAppendWillUse := len(this.ContentBlocks)
+	this.ContentBlocks = append(this.ContentBlocks, obj)
+	return &this.ContentBlocks[positionThatAppendWillUse]
+}
+
+// Encode returns the message in the requested TAXII message binding
+func (this *PollResponseMessageType) Encode(binding string) ([]byte, error) {
+	if binding == common.TAXII_MESSAGE_XML {
+		return common.EncodeXml(this.toXml())
+	}
+	return json.Marshal(this)
+}
+
+// ----------------------------------------------------------------------
+// Poll Fulfillment Methods
+// ----------------------------------------------------------------------
+
+func (this *PollFulfillmentMessageType) AddCollectionName(s string) {
+	this.CollectionName = s
+}
+
+func (this *PollFulfillmentMessageType) AddResultId(s string) {
+	this.ResultId = s
+}
+
+func (this *PollFulfillmentMessageType) AddResultPartNumber(i int) {
+	this.ResultPartNumber = i
+}
+
+// ----------------------------------------------------------------------
+// Content Block Methods
+// ----------------------------------------------------------------------
+
+func (this *ContentBlockType) AddContentBinding(s string) {
+	this.ContentBinding = s
+}
+
+func (this *ContentBlockType) SetContentEncodingToJson() {
+	this.ContentEncoding = "json"
+}
+
+func (this *ContentBlockType) AddContentEncoding(s string) {
+	this.ContentEncoding = s
+}
+
+func (this *ContentBlockType) AddContent(s string) {
+	this.Content = s
+}
+
+func (this *ContentBlockType) AddTimestampLabel(s string) {
+	this.TimestampLabel = s
+}
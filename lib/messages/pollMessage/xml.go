@@ -0,0 +1,126 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pollMessage
+
+import (
+	"encoding/xml"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+)
+
+// ----------------------------------------------------------------------
+// Define TAXII XML Binding Types
+// ----------------------------------------------------------------------
+
+type pollRequestXmlType struct {
+	XMLName                 xml.Name               `xml:"http://taxii.mitre.org/messages/taxii_xml_binding-1.1 Poll_Request"`
+	MessageId               string                 `xml:"message_id,attr"`
+	CollectionName          string                 `xml:"collection_name,attr"`
+	ExclusiveBeginTimestamp string                 `xml:"Exclusive_Begin_Timestamp,omitempty"`
+	InclusiveEndTimestamp   string                 `xml:"Inclusive_End_Timestamp,omitempty"`
+	SubscriptionId          string                 `xml:"Subscription_ID,omitempty"`
+	PollParameters          *pollParametersXmlType `xml:"Poll_Parameters,omitempty"`
+}
+
+type pollParametersXmlType struct {
+	AllowAsynch     bool                    `xml:"allow_asynch,attr,omitempty"`
+	ContentBindings []contentBindingXmlType `xml:"Content_Binding"`
+}
+
+type pollResponseXmlType struct {
+	XMLName                 xml.Name              `xml:"http://taxii.mitre.org/messages/taxii_xml_binding-1.1 Poll_Response"`
+	MessageId               string                `xml:"message_id,attr"`
+	InResponseTo            string                `xml:"in_response_to,attr"`
+	CollectionName          string                `xml:"collection_name,attr"`
+	More                    bool                  `xml:"more,attr"`
+	ResultId                string                `xml:"result_id,attr,omitempty"`
+	ResultPartNumber        int                   `xml:"result_part_number,attr,omitempty"`
+	ExclusiveBeginTimestamp string                `xml:"Exclusive_Begin_Timestamp,omitempty"`
+	InclusiveEndTimestamp   string                `xml:"Inclusive_End_Timestamp,omitempty"`
+	RecordCount             int                   `xml:"Record_Count"`
+	Message                 string                `xml:"Message,omitempty"`
+	ContentBlocks           []contentBlockXmlType `xml:"Content_Block"`
+}
+
+type pollFulfillmentXmlType struct {
+	XMLName          xml.Name `xml:"http://taxii.mitre.org/messages/taxii_xml_binding-1.1 Poll_Fulfillment"`
+	MessageId        string   `xml:"message_id,attr"`
+	CollectionName   string   `xml:"collection_name,attr"`
+	ResultId         string   `xml:"result_id,attr"`
+	ResultPartNumber int      `xml:"result_part_number,attr"`
+}
+
+type contentBlockXmlType struct {
+	ContentBinding contentBindingXmlType `xml:"Content_Binding"`
+	Content        contentXmlType        `xml:"Content"`
+	TimestampLabel string                `xml:"Timestamp_Label,omitempty"`
+}
+
+type contentBindingXmlType struct {
+	BindingId string `xml:"binding_id,attr"`
+}
+
+type contentXmlType struct {
+	Inner string `xml:",innerxml"`
+}
+
+// ----------------------------------------------------------------------
+// Convert from the TAXII XML Binding
+// ----------------------------------------------------------------------
+
+func (this *pollRequestXmlType) fromXml() PollRequestMessageType {
+	var obj PollRequestMessageType
+	obj.MessageType = this.XMLName.Local
+	obj.Id = this.MessageId
+	obj.CollectionName = this.CollectionName
+	obj.ExclusiveBeginTimestamp = this.ExclusiveBeginTimestamp
+	obj.InclusiveEndTimestamp = this.InclusiveEndTimestamp
+	obj.SubscriptionId = this.SubscriptionId
+	if this.PollParameters != nil {
+		obj.SetAllowAsynch(this.PollParameters.AllowAsynch)
+		for _, value := range this.PollParameters.ContentBindings {
+			obj.AddContentBinding(value.BindingId)
+		}
+	}
+	return obj
+}
+
+func (this *pollFulfillmentXmlType) fromXml() PollFulfillmentMessageType {
+	var obj PollFulfillmentMessageType
+	obj.MessageType = this.XMLName.Local
+	obj.Id = this.MessageId
+	obj.CollectionName = this.CollectionName
+	obj.ResultId = this.ResultId
+	obj.ResultPartNumber = this.ResultPartNumber
+	return obj
+}
+
+// ----------------------------------------------------------------------
+// Convert to the TAXII XML Binding
+// ----------------------------------------------------------------------
+
+func (this *PollResponseMessageType) toXml() pollResponseXmlType {
+	var x pollResponseXmlType
+	x.MessageId = this.Id
+	x.InResponseTo = this.InResponseTo
+	x.CollectionName = this.CollectionName
+	x.More = this.More
+	x.ResultId = this.ResultId
+	x.ResultPartNumber = this.ResultPartNumber
+	x.ExclusiveBeginTimestamp = this.ExclusiveBeginTimestamp
+	x.InclusiveEndTimestamp = this.InclusiveEndTimestamp
+	x.RecordCount = len(this.ContentBlocks)
+	x.Message = this.Message
+
+	for _, value := range this.ContentBlocks {
+		x.ContentBlocks = append(x.ContentBlocks, contentBlockXmlType{
+			ContentBinding: contentBindingXmlType{value.ContentBinding},
+			Content:        contentXmlType{common.XmlContent(value.Content)},
+			TimestampLabel: value.TimestampLabel,
+		})
+	}
+	return x
+}
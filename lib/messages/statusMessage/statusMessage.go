@@ -0,0 +1,164 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package statusMessage carries the TAXII Status Message. It follows the
+// libtaxii API, but also carries the status details that some status types,
+// like PENDING, need to send back to the client, and it can be sent in either
+// the JSON or the XML binding. Status details that can have more than one
+// value are kept as a list.
+package statusMessage
+
+import (
+	"encoding/json"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+)
+
+// Status types from the TAXII 1.1 Services specification
+const (
+	ASYNCHRONOUS_POLL_ERROR      = "ASYNCHRONOUS_POLL_ERROR"
+	BAD_MESSAGE                  = "BAD_MESSAGE"
+	DENIED                       = "DENIED"
+	DESTINATION_COLLECTION_ERROR = "DESTINATION_COLLECTION_ERROR"
+	FAILURE                      = "FAILURE"
+	INVALID_RESPONSE_PART        = "INVALID_RESPONSE_PART"
+	NETWORK_ERROR                = "NETWORK_ERROR"
+	NOT_FOUND                    = "NOT_FOUND"
+	PENDING                      = "PENDING"
+	POLLING_UNSUPPORTED          = "POLLING_UNSUPPORTED"
+	RETRY                        = "RETRY"
+	SUCCESS                      = "SUCCESS"
+	UNAUTHORIZED                 = "UNAUTHORIZED"
+	UNSUPPORTED_MESSAGE          = "UNSUPPORTED_MESSAGE"
+	UNSUPPORTED_CONTENT          = "UNSUPPORTED_CONTENT"
+	UNSUPPORTED_PROTOCOL         = "UNSUPPORTED_PROTOCOL"
+	UNSUPPORTED_QUERY            = "UNSUPPORTED_QUERY"
+)
+
+// Names of the status details that go with the status types
+const (
+	DETAIL_ACCEPTABLE_DESTINATION = "ACCEPTABLE_DESTINATION"
+	DETAIL_ESTIMATED_WAIT         = "ESTIMATED_WAIT"
+	DETAIL_ITEM                   = "ITEM"
+	DETAIL_MAX_PART_NUMBER        = "MAX_PART_NUMBER"
+	DETAIL_RESULT_ID              = "RESULT_ID"
+	DETAIL_SUPPORTED_BINDING      = "SUPPORTED_BINDING"
+	DETAIL_SUPPORTED_CONTENT      = "SUPPORTED_CONTENT"
+	DETAIL_SUPPORTED_PROTOCOL     = "SUPPORTED_PROTOCOL"
+	DETAIL_SUPPORTED_QUERY        = "SUPPORTED_QUERY"
+	DETAIL_WILL_PUSH              = "WILL_PUSH"
+)
+
+// ----------------------------------------------------------------------
+// Define Message Type
+// ----------------------------------------------------------------------
+
+type StatusMessageType struct {
+	MessageType  string                 `json:"message_type,omitempty"`
+	Id           string                 `json:"id,omitempty"`
+	InResponseTo string                 `json:"in_response_to,omitempty"`
+	StatusType   string                 `json:"status_type,omitempty"`
+	StatusDetail map[string]interface{} `json:"status_detail,omitempty"`
+	Message      string                 `json:"message,omitempty"`
+}
+
+// ----------------------------------------------------------------------
+// Public Create Functions
+// ----------------------------------------------------------------------
+
+func New() StatusMessageType {
+	var obj StatusMessageType
+	obj.MessageType = "Status_Message"
+	obj.Id = common.CreateMessageId()
+	return obj
+}
+
+// ----------------------------------------------------------------------
+// Public Methods
+// ----------------------------------------------------------------------
+
+func (this *StatusMessageType) AddType(s string) {
+	this.StatusType = s
+}
+
+func (this *StatusMessageType) AddResponseId(s string) {
+	this.InResponseTo = s
+}
+
+func (this *StatusMessageType) AddStatusDetail(name string, value interface{}) {
+	if this.StatusDetail == nil {
+		this.StatusDetail = make(map[string]interface{})
+	}
+	this.StatusDetail[name] = value
+}
+
+func (this *StatusMessageType) AddMessage(s string) {
+	this.Message = s
+}
+
+// addStatusDetailValues appends to a status detail that can have more than
+// one value
+func (this *StatusMessageType) addStatusDetailValues(name string, values []string) {
+	var list []string
+	if current, ok := this.StatusDetail[name].([]string); ok {
+		list = current
+	}
+	this.AddStatusDetail(name, append(list, values...))
+}
+
+// ----------------------------------------------------------------------
+// Status Detail Methods
+// ----------------------------------------------------------------------
+// Each method sets a status detail that is defined for one or more of the
+// status types, ACCEPTABLE_DESTINATION for DESTINATION_COLLECTION_ERROR,
+// SUPPORTED_BINDING for UNSUPPORTED_MESSAGE and so on.
+
+func (this *StatusMessageType) AddAcceptableDestination(s ...string) {
+	this.addStatusDetailValues(DETAIL_ACCEPTABLE_DESTINATION, s)
+}
+
+func (this *StatusMessageType) AddEstimatedWait(i int) {
+	this.AddStatusDetail(DETAIL_ESTIMATED_WAIT, i)
+}
+
+func (this *StatusMessageType) AddItem(s string) {
+	this.AddStatusDetail(DETAIL_ITEM, s)
+}
+
+func (this *StatusMessageType) AddMaxPartNumber(i int) {
+	this.AddStatusDetail(DETAIL_MAX_PART_NUMBER, i)
+}
+
+func (this *StatusMessageType) AddResultId(s string) {
+	this.AddStatusDetail(DETAIL_RESULT_ID, s)
+}
+
+func (this *StatusMessageType) AddSupportedBinding(s ...string) {
+	this.addStatusDetailValues(DETAIL_SUPPORTED_BINDING, s)
+}
+
+func (this *StatusMessageType) AddSupportedContent(s ...string) {
+	this.addStatusDetailValues(DETAIL_SUPPORTED_CONTENT, s)
+}
+
+func (this *StatusMessageType) AddSupportedProtocol(s ...string) {
+	this.addStatusDetailValues(DETAIL_SUPPORTED_PROTOCOL, s)
+}
+
+func (this *StatusMessageType) AddSupportedQuery(s ...string) {
+	this.addStatusDetailValues(DETAIL_SUPPORTED_QUERY, s)
+}
+
+func (this *StatusMessageType) SetWillPush(b bool) {
+	this.AddStatusDetail(DETAIL_WILL_PUSH, b)
+}
+
+// Encode returns the message in the requested TAXII message binding
+func (this *StatusMessageType) Encode(binding string) ([]byte, error) {
+	if binding == common.TAXII_MESSAGE_XML {
+		return common.EncodeXml(this.toXml())
+	}
+	return json.Marshal(this)
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package statusMessage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+)
+
+// ----------------------------------------------------------------------
+// Define TAXII XML Binding Types
+// ----------------------------------------------------------------------
+
+type statusMessageXmlType struct {
+	XMLName      xml.Name             `xml:"http://taxii.mitre.org/messages/taxii_xml_binding-1.1 Status_Message"`
+	MessageId    string               `xml:"message_id,attr"`
+	InResponseTo string               `xml:"in_response_to,attr"`
+	StatusType   string               `xml:"status_type,attr"`
+	StatusDetail *statusDetailXmlType `xml:"Status_Detail,omitempty"`
+	Message      string               `xml:"Message,omitempty"`
+}
+
+type statusDetailXmlType struct {
+	Details []detailXmlType `xml:"Detail"`
+}
+
+type detailXmlType struct {
+	Name   string   `xml:"name,attr"`
+	Values []string `xml:"Value"`
+}
+
+// ----------------------------------------------------------------------
+// Convert to the TAXII XML Binding
+// ----------------------------------------------------------------------
+
+func (this *StatusMessageType) toXml() statusMessageXmlType {
+	var x statusMessageXmlType
+	x.MessageId = this.Id
+	x.InResponseTo = this.InResponseTo
+	x.StatusType = this.StatusType
+	x.Message = this.Message
+
+	if len(this.StatusDetail) > 0 {
+		// Sort the names so the same message always encodes the same way
+		var names []string
+		for k := range this.StatusDetail {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		x.StatusDetail = &statusDetailXmlType{}
+		for _, name := range names {
+			detail := detailXmlType{Name: name}
+			if values, ok := this.StatusDetail[name].([]string); ok {
+				detail.Values = values
+			} else {
+				detail.Values = []string{fmt.Sprint(this.StatusDetail[name])}
+			}
+			x.StatusDetail.Details = append(x.StatusDetail.Details, detail)
+		}
+	}
+	return x
+}
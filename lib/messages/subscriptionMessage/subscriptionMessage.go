@@ -0,0 +1,166 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package subscriptionMessage carries the Manage Collection Subscription
+// Request and Response messages.
+package subscriptionMessage
+
+import (
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+)
+
+// Subscription actions that a client can request
+const (
+	ACTION_SUBSCRIBE   = "SUBSCRIBE"
+	ACTION_UNSUBSCRIBE = "UNSUBSCRIBE"
+	ACTION_PAUSE       = "PAUSE"
+	ACTION_RESUME      = "RESUME"
+	ACTION_STATUS      = "STATUS"
+)
+
+// Status values for a subscription
+const (
+	STATUS_ACTIVE       = "ACTIVE"
+	STATUS_PAUSED       = "PAUSED"
+	STATUS_UNSUBSCRIBED = "UNSUBSCRIBED"
+)
+
+// Response types for a subscription
+const (
+	RESPONSE_TYPE_FULL       = "FULL"
+	RESPONSE_TYPE_COUNT_ONLY = "COUNT_ONLY"
+)
+
+// ----------------------------------------------------------------------
+// Define Message Types
+// ----------------------------------------------------------------------
+
+type SubscriptionRequestMessageType struct {
+	MessageType            string                      `json:"message_type,omitempty"`
+	Id                     string                      `json:"id,omitempty"`
+	CollectionName         string                      `json:"collection_name,omitempty"`
+	Action                 string                      `json:"action,omitempty"`
+	SubscriptionId         string                      `json:"subscription_id,omitempty"`
+	SubscriptionParameters *SubscriptionParametersType `json:"subscription_parameters,omitempty"`
+	PushParameters         *PushParametersType         `json:"push_parameters,omitempty"`
+}
+
+type SubscriptionResponseMessageType struct {
+	MessageType           string                     `json:"message_type,omitempty"`
+	Id                    string                     `json:"id,omitempty"`
+	InResponseTo          string                     `json:"in_response_to,omitempty"`
+	CollectionName        string                     `json:"collection_name,omitempty"`
+	Message               string                     `json:"message,omitempty"`
+	SubscriptionInstances []SubscriptionInstanceType `json:"subscription_instances,omitempty"`
+}
+
+type SubscriptionParametersType struct {
+	ResponseType    string   `json:"response_type,omitempty"`
+	ContentBindings []string `json:"content_bindings,omitempty"`
+}
+
+type PushParametersType struct {
+	ProtocolBinding string `json:"protocol_binding,omitempty"`
+	Address         string `json:"address,omitempty"`
+	MessageBinding  string `json:"message_binding,omitempty"`
+}
+
+type PollInstanceType struct {
+	ProtocolBinding string `json:"protocol_binding,omitempty"`
+	Address         string `json:"address,omitempty"`
+	MessageBinding  string `json:"message_binding,omitempty"`
+}
+
+type SubscriptionInstanceType struct {
+	SubscriptionId         string                      `json:"subscription_id,omitempty"`
+	Status                 string                      `json:"status,omitempty"`
+	SubscriptionParameters *SubscriptionParametersType `json:"subscription_parameters,omitempty"`
+	PushParameters         *PushParametersType         `json:"push_parameters,omitempty"`
+	PollInstances          []PollInstanceType          `json:"poll_instances,omitempty"`
+}
+
+// ----------------------------------------------------------------------
+// Public Create Functions
+// ----------------------------------------------------------------------
+
+func NewRequest() SubscriptionRequestMessageType {
+	var obj SubscriptionRequestMessageType
+	obj.MessageType = "Subscription_Management_Request"
+	obj.Id = common.CreateMessageId()
+	return obj
+}
+
+func NewResponse() SubscriptionResponseMessageType {
+	var obj SubscriptionResponseMessageType
+	obj.MessageType = "Subscription_Management_Response"
+	obj.Id = common.CreateMessageId()
+	return obj
+}
+
+// ----------------------------------------------------------------------
+// Subscription Request Methods
+// ----------------------------------------------------------------------
+
+func (this *SubscriptionRequestMessageType) AddCollectionName(s string) {
+	this.CollectionName = s
+}
+
+func (this *SubscriptionRequestMessageType) AddAction(s string) {
+	this.Action = s
+}
+
+func (this *SubscriptionRequestMessageType) AddSubscriptionId(s string) {
+	this.SubscriptionId = s
+}
+
+// ----------------------------------------------------------------------
+// Subscription Response Methods
+// ----------------------------------------------------------------------
+
+func (this *SubscriptionResponseMessageType) AddInResponseTo(s string) {
+	this.InResponseTo = s
+}
+
+func (this *SubscriptionResponseMessageType) AddCollectionName(s string) {
+	this.CollectionName = s
+}
+
+func (this *SubscriptionResponseMessageType) AddMessage(s string) {
+	this.Message = s
+}
+
+// NewSubscriptionInstance adds an empty subscription instance to the message
+// and returns a pointer to it so that the caller can populate it
+func (this *SubscriptionResponseMessageType) NewSubscriptionInstance() *SubscriptionInstanceType {
+	var obj SubscriptionInstanceType
+	positionThatAppendWillUse := len(this.SubscriptionInstances)
+	this.SubscriptionInstances = append(this.SubscriptionInstances, obj)
+	return &this.SubscriptionInstances[positionThatAppendWillUse]
+}
+
+// ----------------------------------------------------------------------
+// Subscription Instance Methods
+// ----------------------------------------------------------------------
+
+func (this *SubscriptionInstanceType) AddSubscriptionId(s string) {
+	this.SubscriptionId = s
+}
+
+func (this *SubscriptionInstanceType) AddStatus(s string) {
+	this.Status = s
+}
+
+func (this *SubscriptionInstanceType) AddSubscriptionParameters(responseType string, contentBindings []string) {
+	this.SubscriptionParameters = &SubscriptionParametersType{ResponseType: responseType, ContentBindings: contentBindings}
+}
+
+func (this *SubscriptionInstanceType) AddPushParameters(protocolBinding, address, messageBinding string) {
+	this.PushParameters = &PushParametersType{ProtocolBinding: protocolBinding, Address: address, MessageBinding: messageBinding}
+}
+
+func (this *SubscriptionInstanceType) AddPollInstance(protocolBinding, address, messageBinding string) {
+	this.PollInstances = append(this.PollInstances, PollInstanceType{ProtocolBinding: protocolBinding, Address: address, MessageBinding: messageBinding})
+}
@@ -0,0 +1,292 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package taxii2Message carries the resources of the TAXII 2.1 REST API. They
+// are built with the same New / Add / Set style as the TAXII 1.x messages.
+package taxii2Message
+
+import (
+	"encoding/json"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+)
+
+// TAXII 2.1 and STIX 2.1 media types
+const (
+	MEDIA_TYPE_TAXII = "application/taxii+json;version=2.1"
+	MEDIA_TYPE_STIX  = common.CONTENT_BINDING_STIX2_JSON
+)
+
+// Values of the status property of a Status resource
+const (
+	STATUS_COMPLETE = "complete"
+	STATUS_PENDING  = "pending"
+)
+
+// ----------------------------------------------------------------------
+// Define Resource Types
+// ----------------------------------------------------------------------
+
+type DiscoveryType struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Contact     string   `json:"contact,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	ApiRoots    []string `json:"api_roots,omitempty"`
+}
+
+type ApiRootType struct {
+	Title            string   `json:"title"`
+	Description      string   `json:"description,omitempty"`
+	Versions         []string `json:"versions"`
+	MaxContentLength int      `json:"max_content_length"`
+}
+
+type CollectionsType struct {
+	Collections []CollectionType `json:"collections,omitempty"`
+}
+
+type CollectionType struct {
+	Id          string   `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Alias       string   `json:"alias,omitempty"`
+	CanRead     bool     `json:"can_read"`
+	CanWrite    bool     `json:"can_write"`
+	MediaTypes  []string `json:"media_types,omitempty"`
+}
+
+// Objects are kept as raw JSON so that any STIX object can be passed through
+// without this package needing to know about it
+type EnvelopeType struct {
+	More    bool              `json:"more,omitempty"`
+	Next    string            `json:"next,omitempty"`
+	Objects []json.RawMessage `json:"objects,omitempty"`
+}
+
+type ManifestType struct {
+	More    bool                 `json:"more,omitempty"`
+	Objects []ManifestRecordType `json:"objects,omitempty"`
+}
+
+type ManifestRecordType struct {
+	Id        string `json:"id"`
+	DateAdded string `json:"date_added"`
+	Version   string `json:"version"`
+	MediaType string `json:"media_type,omitempty"`
+}
+
+type StatusType struct {
+	Id               string              `json:"id"`
+	Status           string              `json:"status"`
+	RequestTimestamp string              `json:"request_timestamp,omitempty"`
+	TotalCount       int                 `json:"total_count"`
+	SuccessCount     int                 `json:"success_count"`
+	Successes        []StatusDetailsType `json:"successes,omitempty"`
+	FailureCount     int                 `json:"failure_count"`
+	Failures         []StatusDetailsType `json:"failures,omitempty"`
+	PendingCount     int                 `json:"pending_count"`
+	Pendings         []StatusDetailsType `json:"pendings,omitempty"`
+}
+
+type StatusDetailsType struct {
+	Id      string `json:"id"`
+	Version string `json:"version"`
+	Message string `json:"message,omitempty"`
+}
+
+type ErrorType struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	HttpStatus  string `json:"http_status,omitempty"`
+}
+
+// ----------------------------------------------------------------------
+// Public Create Functions
+// ----------------------------------------------------------------------
+
+func NewDiscovery() DiscoveryType {
+	var obj DiscoveryType
+	return obj
+}
+
+func NewApiRoot() ApiRootType {
+	var obj ApiRootType
+	obj.Versions = []string{MEDIA_TYPE_TAXII}
+	return obj
+}
+
+func NewCollections() CollectionsType {
+	var obj CollectionsType
+	return obj
+}
+
+func NewEnvelope() EnvelopeType {
+	var obj EnvelopeType
+	return obj
+}
+
+func NewManifest() ManifestType {
+	var obj ManifestType
+	return obj
+}
+
+func NewStatus(id string) StatusType {
+	var obj StatusType
+	obj.Id = id
+	obj.Status = STATUS_PENDING
+	return obj
+}
+
+func NewError(title string) ErrorType {
+	var obj ErrorType
+	obj.Title = title
+	return obj
+}
+
+// ----------------------------------------------------------------------
+// Discovery Methods
+// ----------------------------------------------------------------------
+
+func (this *DiscoveryType) AddTitle(s string) {
+	this.Title = s
+}
+
+func (this *DiscoveryType) AddDescription(s string) {
+	this.Description = s
+}
+
+func (this *DiscoveryType) AddContact(s string) {
+	this.Contact = s
+}
+
+func (this *DiscoveryType) AddDefault(s string) {
+	this.Default = s
+}
+
+func (this *DiscoveryType) AddApiRoot(s string) {
+	this.ApiRoots = append(this.ApiRoots, s)
+}
+
+// ----------------------------------------------------------------------
+// API Root Methods
+// ----------------------------------------------------------------------
+
+func (this *ApiRootType) AddTitle(s string) {
+	this.Title = s
+}
+
+func (this *ApiRootType) AddDescription(s string) {
+	this.Description = s
+}
+
+func (this *ApiRootType) AddMaxContentLength(i int) {
+	this.MaxContentLength = i
+}
+
+// ----------------------------------------------------------------------
+// Collection Methods
+// ----------------------------------------------------------------------
+
+// NewCollection adds an empty collection to the resource and returns a
+// pointer to it so that the caller can populate it
+func (this *CollectionsType) NewCollection() *CollectionType {
+	var obj CollectionType
+	positionThatAppendWillUse := len(this.Collections)
+	this.Collections = append(this.Collections, obj)
+	return &this.Collections[positionThatAppendWillUse]
+}
+
+func (this *CollectionType) AddId(s string) {
+	this.Id = s
+}
+
+func (this *CollectionType) AddTitle(s string) {
+	this.Title = s
+}
+
+func (this *CollectionType) AddDescription(s string) {
+	this.Description = s
+}
+
+func (this *CollectionType) AddAlias(s string) {
+	this.Alias = s
+}
+
+func (this *CollectionType) SetCanRead(b bool) {
+	this.CanRead = b
+}
+
+func (this *CollectionType) SetCanWrite(b bool) {
+	this.CanWrite = b
+}
+
+func (this *CollectionType) AddMediaType(s string) {
+	this.MediaTypes = append(this.MediaTypes, s)
+}
+
+// ----------------------------------------------------------------------
+// Envelope Methods
+// ----------------------------------------------------------------------
+
+func (this *EnvelopeType) AddObject(o json.RawMessage) {
+	this.Objects = append(this.Objects, o)
+}
+
+func (this *EnvelopeType) SetMore(b bool) {
+	this.More = b
+}
+
+func (this *EnvelopeType) AddNext(s string) {
+	this.Next = s
+}
+
+// ----------------------------------------------------------------------
+// Manifest Methods
+// ----------------------------------------------------------------------
+
+func (this *ManifestType) AddRecord(id, dateAdded, version, mediaType string) {
+	this.Objects = append(this.Objects, ManifestRecordType{id, dateAdded, version, mediaType})
+}
+
+func (this *ManifestType) SetMore(b bool) {
+	this.More = b
+}
+
+// ----------------------------------------------------------------------
+// Status Methods
+// ----------------------------------------------------------------------
+
+func (this *StatusType) AddRequestTimestamp(s string) {
+	this.RequestTimestamp = s
+}
+
+func (this *StatusType) AddSuccess(id, version string) {
+	this.Successes = append(this.Successes, StatusDetailsType{Id: id, Version: version})
+	this.SuccessCount++
+	this.TotalCount++
+}
+
+func (this *StatusType) AddFailure(id, version, message string) {
+	this.Failures = append(this.Failures, StatusDetailsType{id, version, message})
+	this.FailureCount++
+	this.TotalCount++
+}
+
+func (this *StatusType) SetComplete() {
+	this.Status = STATUS_COMPLETE
+}
+
+// ----------------------------------------------------------------------
+// Error Methods
+// ----------------------------------------------------------------------
+
+func (this *ErrorType) AddDescription(s string) {
+	this.Description = s
+}
+
+func (this *ErrorType) AddHttpStatus(s string) {
+	this.HttpStatus = s
+}
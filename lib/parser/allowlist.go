@@ -0,0 +1,224 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package parser
+
+import (
+	"errors"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	ALLOWLIST_TYPE_CIDR   = "cidr"
+	ALLOWLIST_TYPE_DOMAIN = "domain"
+	ALLOWLIST_TYPE_REGEX  = "regex"
+)
+
+// ----------------------------------------------------------------------
+// Define Allowlist Type
+// ----------------------------------------------------------------------
+// An allowlist holds values that are never published. A cidr entry matches
+// addresses and networks inside it and URLs with an address in it. A domain
+// entry matches the domain and every name under it, in domain names, URLs and
+// email addresses. A regex entry matches the normal form of any value.
+
+type AllowlistType struct {
+	entries []allowlistEntryType
+}
+
+type allowlistEntryType struct {
+	entry   storage.AllowlistEntryType
+	network *net.IPNet
+	domain  string
+	regex   *regexp.Regexp
+}
+
+// --------------------------------------------------
+// Create an Allowlist
+// --------------------------------------------------
+
+func NewAllowlist(entries []storage.AllowlistEntryType) (*AllowlistType, error) {
+	var obj AllowlistType
+
+	for _, entry := range entries {
+		entry, err := NormalizeAllowlistEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		e := allowlistEntryType{entry: entry}
+		switch entry.Type {
+		case ALLOWLIST_TYPE_CIDR:
+			_, e.network, _ = net.ParseCIDR(entry.Value)
+		case ALLOWLIST_TYPE_DOMAIN:
+			e.domain = entry.Value
+		case ALLOWLIST_TYPE_REGEX:
+			e.regex = regexp.MustCompile(entry.Value)
+		}
+		obj.entries = append(obj.entries, e)
+	}
+	return &obj, nil
+}
+
+// --------------------------------------------------
+// Check and Normalize an Allowlist Entry
+// --------------------------------------------------
+// A single address is turned in to a network of one address and domain names
+// are put in the same form as the values they are matched against.
+
+func NormalizeAllowlistEntry(entry storage.AllowlistEntryType) (storage.AllowlistEntryType, error) {
+	entry.Type = strings.ToLower(strings.TrimSpace(entry.Type))
+	entry.Value = strings.TrimSpace(entry.Value)
+
+	switch entry.Type {
+	case ALLOWLIST_TYPE_CIDR:
+		value := entry.Value
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return entry, errors.New("the address " + value + " is not valid")
+			}
+			if ip.To4() != nil {
+				value = value + "/32"
+			} else {
+				value = value + "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return entry, errors.New("the network " + entry.Value + " is not valid")
+		}
+		entry.Value = network.String()
+
+	case ALLOWLIST_TYPE_DOMAIN:
+		domain, err := normalizeDomain(strings.TrimPrefix(entry.Value, "*."))
+		if err != nil {
+			return entry, err
+		}
+		entry.Value = domain
+
+	case ALLOWLIST_TYPE_REGEX:
+		_, err := regexp.Compile(entry.Value)
+		if err != nil {
+			return entry, errors.New("the regex " + entry.Value + " is not valid, " + err.Error())
+		}
+
+	default:
+		return entry, errors.New("the allowlist type must be cidr, domain or regex")
+	}
+	return entry, nil
+}
+
+// --------------------------------------------------
+// Remove the Values that are on the Allowlist
+// --------------------------------------------------
+// The values are expected to have been through Normalize. The ones that are
+// removed are returned with the entry that matched them as the reason.
+
+func (this *AllowlistType) Filter(observables []storage.ObservableType) ([]storage.ObservableType, []RejectedType) {
+	if this == nil || len(this.entries) == 0 {
+		return observables, nil
+	}
+
+	var kept []storage.ObservableType
+	var suppressed []RejectedType
+	for _, value := range observables {
+		entry, ok := this.Match(value)
+		if ok {
+			reason := "on the allowlist as " + entry.Type + " " + entry.Value
+			suppressed = append(suppressed, RejectedType{Value: value.Value, Reason: reason})
+			continue
+		}
+		kept = append(kept, value)
+	}
+	return kept, suppressed
+}
+
+// --------------------------------------------------
+// Find the Allowlist Entry for a Value
+// --------------------------------------------------
+
+func (this *AllowlistType) Match(observable storage.ObservableType) (storage.AllowlistEntryType, bool) {
+	if this == nil {
+		return storage.AllowlistEntryType{}, false
+	}
+
+	ip, ones, host := allowlistKeys(observable)
+	for _, e := range this.entries {
+		switch {
+		case e.network != nil && ip != nil:
+			size, _ := e.network.Mask.Size()
+			if e.network.Contains(ip) && size <= ones {
+				return e.entry, true
+			}
+		case e.domain != "" && host != "":
+			if host == e.domain || strings.HasSuffix(host, "."+e.domain) {
+				return e.entry, true
+			}
+		case e.regex != nil:
+			if e.regex.MatchString(observable.Value) {
+				return e.entry, true
+			}
+		}
+	}
+	return storage.AllowlistEntryType{}, false
+}
+
+// --------------------------------------------------
+// Helpers
+// --------------------------------------------------
+
+// Returns the address and prefix length, or the host name, that the cidr and
+// domain entries are matched against. A single address has the full prefix
+// length.
+func allowlistKeys(observable storage.ObservableType) (net.IP, int, string) {
+	switch observable.Type {
+	case OBSERVABLE_TYPE_IPV4_ADDRESS, OBSERVABLE_TYPE_IPV6_ADDRESS:
+		return allowlistAddress(observable.Value)
+	case OBSERVABLE_TYPE_IPV4_NETWORK, OBSERVABLE_TYPE_IPV6_NETWORK:
+		ip, network, err := net.ParseCIDR(observable.Value)
+		if err != nil {
+			return nil, 0, ""
+		}
+		ones, _ := network.Mask.Size()
+		return ip, ones, ""
+	case OBSERVABLE_TYPE_DOMAIN_NAME:
+		return nil, 0, observable.Value
+	case OBSERVABLE_TYPE_EMAIL_ADDRESS:
+		return nil, 0, observable.Value[strings.LastIndex(observable.Value, "@")+1:]
+	case OBSERVABLE_TYPE_URL:
+		u, err := url.Parse(observable.Value)
+		if err != nil {
+			return nil, 0, ""
+		}
+		if ip, ones, _ := allowlistAddress(u.Hostname()); ip != nil {
+			return ip, ones, ""
+		}
+		return nil, 0, u.Hostname()
+	}
+
+	// Values stored before they were classified have an older type
+	observableType, value, err := Classify(observable.Value)
+	if err != nil || observableType == observable.Type {
+		return nil, 0, ""
+	}
+	return allowlistKeys(storage.ObservableType{Type: observableType, Value: value})
+}
+
+func allowlistAddress(value string) (net.IP, int, string) {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, 0, ""
+	}
+	if ip.To4() != nil {
+		return ip, 32, ""
+	}
+	return ip, 128, ""
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package parser
+
+import (
+	"testing"
+
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+)
+
+func TestNormalizeAllowlistEntry(t *testing.T) {
+	tests := []struct {
+		entryType string
+		value     string
+		want      string
+	}{
+		{"cidr", "192.0.2.1", "192.0.2.1/32"},
+		{"cidr", "2001:DB8::1", "2001:db8::1/128"},
+		{"cidr", "192.0.2.5/24", "192.0.2.0/24"},
+		{" CIDR ", " 2001:db8::/32 ", "2001:db8::/32"},
+		{"domain", "*.Example.COM", "example.com"},
+		{"domain", "www.example.com.", "www.example.com"},
+		{"regex", `/safe$`, `/safe$`},
+	}
+
+	for _, test := range tests {
+		entry, err := NormalizeAllowlistEntry(storage.AllowlistEntryType{Type: test.entryType, Value: test.value})
+		if err != nil {
+			t.Errorf("%s %q returned an error, %v", test.entryType, test.value, err)
+			continue
+		}
+		if entry.Value != test.want {
+			t.Errorf("%s %q is %q, want %q", test.entryType, test.value, entry.Value, test.want)
+		}
+	}
+
+	for _, entry := range []storage.AllowlistEntryType{
+		{Type: "cidr", Value: "not an address"},
+		{Type: "cidr", Value: "192.0.2.0/33"},
+		{Type: "domain", Value: "localhost"},
+		{Type: "regex", Value: "("},
+		{Type: "other", Value: "192.0.2.1"},
+	} {
+		if _, err := NormalizeAllowlistEntry(entry); err == nil {
+			t.Errorf("%s %q was accepted", entry.Type, entry.Value)
+		}
+	}
+}
+
+func TestAllowlistMatch(t *testing.T) {
+	allowlist, err := NewAllowlist([]storage.AllowlistEntryType{
+		{Type: "cidr", Value: "192.0.2.0/24"},
+		{Type: "cidr", Value: "2001:db8::/32"},
+		{Type: "domain", Value: "*.example.com"},
+		{Type: "regex", Value: `/safe$`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		observableType string
+		value          string
+		want           string
+	}{
+		{OBSERVABLE_TYPE_IPV4_ADDRESS, "192.0.2.1", "192.0.2.0/24"},
+		{OBSERVABLE_TYPE_IPV4_ADDRESS, "198.51.100.1", ""},
+		{OBSERVABLE_TYPE_IPV4_NETWORK, "192.0.2.0/25", "192.0.2.0/24"},
+		{OBSERVABLE_TYPE_IPV4_NETWORK, "192.0.0.0/16", ""},
+		{OBSERVABLE_TYPE_IPV6_ADDRESS, "2001:db8::1", "2001:db8::/32"},
+		{OBSERVABLE_TYPE_IPV6_NETWORK, "2001:db8:1::/48", "2001:db8::/32"},
+		{OBSERVABLE_TYPE_IPV6_NETWORK, "2001:db8::/16", ""},
+		{OBSERVABLE_TYPE_DOMAIN_NAME, "example.com", "example.com"},
+		{OBSERVABLE_TYPE_DOMAIN_NAME, "www.example.com", "example.com"},
+		{OBSERVABLE_TYPE_DOMAIN_NAME, "badexample.com", ""},
+		{OBSERVABLE_TYPE_EMAIL_ADDRESS, "user@mail.example.com", "example.com"},
+		{OBSERVABLE_TYPE_EMAIL_ADDRESS, "user@example.net", ""},
+		{OBSERVABLE_TYPE_URL, "http://www.example.com/a", "example.com"},
+		{OBSERVABLE_TYPE_URL, "http://192.0.2.1/a", "192.0.2.0/24"},
+		{OBSERVABLE_TYPE_URL, "http://[2001:db8::1]:8080/a", "2001:db8::/32"},
+		{OBSERVABLE_TYPE_URL, "http://example.net/a", ""},
+		{OBSERVABLE_TYPE_URL, "http://example.net/safe", `/safe$`},
+
+		// Values saved before they were classified
+		{OBSERVABLE_TYPE_IP_ADDRESS, "192.0.2.9", "192.0.2.0/24"},
+		{OBSERVABLE_TYPE_IP_ADDRESS, "www.example.com", "example.com"},
+	}
+
+	for _, test := range tests {
+		entry, ok := allowlist.Match(storage.ObservableType{Type: test.observableType, Value: test.value})
+		if ok != (test.want != "") || entry.Value != test.want {
+			t.Errorf("%s %q matched %v %q, want %q", test.observableType, test.value, ok, entry.Value, test.want)
+		}
+	}
+}
+
+func TestAllowlistFilter(t *testing.T) {
+	observables := []storage.ObservableType{
+		{Type: OBSERVABLE_TYPE_IPV4_ADDRESS, Value: "192.0.2.1"},
+		{Type: OBSERVABLE_TYPE_IPV4_ADDRESS, Value: "198.51.100.1"},
+	}
+
+	var empty *AllowlistType
+	if kept, suppressed := empty.Filter(observables); len(kept) != 2 || len(suppressed) != 0 {
+		t.Errorf("an empty allowlist removed values, %+v", suppressed)
+	}
+
+	allowlist, err := NewAllowlist([]storage.AllowlistEntryType{{Type: "cidr", Value: "192.0.2.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, suppressed := allowlist.Filter(observables)
+	if len(kept) != 1 || kept[0].Value != "198.51.100.1" {
+		t.Errorf("unexpected values kept %+v", kept)
+	}
+	if len(suppressed) != 1 || suppressed[0].Value != "192.0.2.1" || suppressed[0].Reason != "on the allowlist as cidr 192.0.2.1/32" {
+		t.Errorf("unexpected values suppressed %+v", suppressed)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package parser
+
+import (
+	"errors"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"golang.org/x/net/idna"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Observable types that a value is classified as. The hash types are named
+// after the hash algorithm.
+const (
+	OBSERVABLE_TYPE_IPV4_ADDRESS = "IPv4 Address"
+	OBSERVABLE_TYPE_IPV6_ADDRESS = "IPv6 Address"
+	OBSERVABLE_TYPE_IPV4_NETWORK = "IPv4 Network"
+	OBSERVABLE_TYPE_IPV6_NETWORK = "IPv6 Network"
+	OBSERVABLE_TYPE_MD5          = "MD5"
+	OBSERVABLE_TYPE_SHA1         = "SHA-1"
+	OBSERVABLE_TYPE_SHA256       = "SHA-256"
+	OBSERVABLE_TYPE_SHA512       = "SHA-512"
+)
+
+// This type holds a value that could not be classified, and why
+type RejectedType struct {
+	Value  string
+	Reason string
+}
+
+// Defanged values are common in feeds that are meant to be read by people
+var refang = strings.NewReplacer("[.]", ".", "(.)", ".", "{.}", ".", "[dot]", ".", "[@]", "@", "[at]", "@", "[:]", ":")
+
+// --------------------------------------------------
+// Classify and Normalize a Value
+// --------------------------------------------------
+// Returns the observable type of the value and the value in its normal form:
+// addresses and networks in the form Go prints them, domain names in lower
+// case ASCII, URLs with a lower case scheme and host and hashes in lower
+// case. An error is returned if the value is not valid as any type.
+
+func Classify(value string) (string, string, error) {
+	value = strings.Trim(strings.TrimSpace(value), `"'`)
+	value = refang.Replace(value)
+	if value == "" {
+		return "", "", errors.New("the value is blank")
+	}
+	if strings.ContainsAny(value, " \t\r\n") {
+		return "", "", errors.New("the value has white space in it")
+	}
+
+	lower := strings.ToLower(value)
+	if strings.HasPrefix(lower, "hxxp") {
+		value = "http" + value[4:]
+		lower = strings.ToLower(value)
+	}
+
+	switch {
+	case strings.Contains(value, "://"):
+		return classifyUrl(value)
+	case strings.Contains(value, "@") && !strings.Contains(value, "/"):
+		return classifyEmail(value)
+	}
+
+	if _, network, err := net.ParseCIDR(value); err == nil {
+		ones, bits := network.Mask.Size()
+		if ones == bits {
+			return classifyIp(network.IP)
+		}
+		if bits == 32 {
+			return OBSERVABLE_TYPE_IPV4_NETWORK, network.String(), nil
+		}
+		return OBSERVABLE_TYPE_IPV6_NETWORK, network.String(), nil
+	}
+	if ip := net.ParseIP(value); ip != nil {
+		return classifyIp(ip)
+	}
+
+	if hashType := hashTypeOf(lower); hashType != "" {
+		return hashType, lower, nil
+	}
+
+	// A host with a path but no scheme, like example.com/bad.exe
+	if strings.Contains(value, "/") {
+		return classifyUrl("http://" + value)
+	}
+
+	domain, err := normalizeDomain(value)
+	if err != nil {
+		return "", "", err
+	}
+	return OBSERVABLE_TYPE_DOMAIN_NAME, domain, nil
+}
+
+// --------------------------------------------------
+// Classify and Normalize a List of Observables
+// --------------------------------------------------
+// The observables that are valid are returned with their type and value
+// replaced, without duplicates. The ones that are not valid are returned
+// separately so they can be looked at.
+
+func Normalize(observables []storage.ObservableType) ([]storage.ObservableType, []RejectedType) {
+	var accepted []storage.ObservableType
+	var rejected []RejectedType
+
+	seen := make(map[string]bool)
+	for _, value := range observables {
+		observableType, normalized, err := Classify(value.Value)
+		if err != nil {
+			rejected = append(rejected, RejectedType{Value: value.Value, Reason: err.Error()})
+			continue
+		}
+
+		key := observableType + " " + normalized
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		value.Type = observableType
+		value.Value = normalized
+		accepted = append(accepted, value)
+	}
+	return accepted, rejected
+}
+
+// --------------------------------------------------
+// Helpers
+// --------------------------------------------------
+
+func classifyIp(ip net.IP) (string, string, error) {
+	if ip.To4() != nil {
+		return OBSERVABLE_TYPE_IPV4_ADDRESS, ip.String(), nil
+	}
+	return OBSERVABLE_TYPE_IPV6_ADDRESS, ip.String(), nil
+}
+
+func classifyUrl(value string) (string, string, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", "", errors.New("the URL can not be read")
+	}
+	if u.Host == "" {
+		return "", "", errors.New("the URL does not have a host")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := u.Hostname()
+	if net.ParseIP(host) == nil {
+		host, err = normalizeDomain(host)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + strings.ToLower(host) + "]"
+	}
+	if port := u.Port(); port != "" {
+		host = host + ":" + port
+	}
+	u.Host = host
+	return OBSERVABLE_TYPE_URL, u.String(), nil
+}
+
+func classifyEmail(value string) (string, string, error) {
+	i := strings.LastIndex(value, "@")
+	local := value[:i]
+	if local == "" || strings.ContainsAny(local, "@<>()[],;:\"") {
+		return "", "", errors.New("the email address is not valid")
+	}
+
+	domain, err := normalizeDomain(value[i+1:])
+	if err != nil {
+		return "", "", err
+	}
+	return OBSERVABLE_TYPE_EMAIL_ADDRESS, local + "@" + domain, nil
+}
+
+// Domain names are turned in to lower case ASCII, so an internationalized
+// name is stored in its xn-- form
+func normalizeDomain(value string) (string, error) {
+	domain, err := idna.Lookup.ToASCII(strings.TrimSuffix(value, "."))
+	if err != nil {
+		return "", errors.New("the domain name " + value + " is not valid")
+	}
+	if len(domain) > 253 {
+		return "", errors.New("the domain name is longer than 253 characters")
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return "", errors.New("the value " + value + " is not a domain name, address, URL or hash")
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 {
+			return "", errors.New("the domain name " + value + " is not valid")
+		}
+	}
+
+	// A name made of numbers is a bad address rather than a domain
+	tld := labels[len(labels)-1]
+	if strings.Trim(tld, "0123456789") == "" {
+		return "", errors.New("the address " + value + " is not valid")
+	}
+	return domain, nil
+}
+
+func hashTypeOf(value string) string {
+	if strings.Trim(value, "0123456789abcdef") != "" {
+		return ""
+	}
+	switch len(value) {
+	case 32:
+		return OBSERVABLE_TYPE_MD5
+	case 40:
+		return OBSERVABLE_TYPE_SHA1
+	case 64:
+		return OBSERVABLE_TYPE_SHA256
+	case 128:
+		return OBSERVABLE_TYPE_SHA512
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package parser
+
+import (
+	"testing"
+
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		value          string
+		observableType string
+		normalized     string
+	}{
+		{"192.0.2.1", OBSERVABLE_TYPE_IPV4_ADDRESS, "192.0.2.1"},
+		{" '192.0.2.1' ", OBSERVABLE_TYPE_IPV4_ADDRESS, "192.0.2.1"},
+		{"192[.]0[.]2[.]1", OBSERVABLE_TYPE_IPV4_ADDRESS, "192.0.2.1"},
+		{"192.0.2.1/32", OBSERVABLE_TYPE_IPV4_ADDRESS, "192.0.2.1"},
+		{"192.0.2.0/24", OBSERVABLE_TYPE_IPV4_NETWORK, "192.0.2.0/24"},
+		{"192.0.2.5/24", OBSERVABLE_TYPE_IPV4_NETWORK, "192.0.2.0/24"},
+		{"2001:DB8::1", OBSERVABLE_TYPE_IPV6_ADDRESS, "2001:db8::1"},
+		{"2001:db8::1/128", OBSERVABLE_TYPE_IPV6_ADDRESS, "2001:db8::1"},
+		{"2001:db8::/32", OBSERVABLE_TYPE_IPV6_NETWORK, "2001:db8::/32"},
+		{"Example.COM.", OBSERVABLE_TYPE_DOMAIN_NAME, "example.com"},
+		{"www[.]example[.]com", OBSERVABLE_TYPE_DOMAIN_NAME, "www.example.com"},
+		{"bücher.example", OBSERVABLE_TYPE_DOMAIN_NAME, "xn--bcher-kva.example"},
+		{"HTTP://EXAMPLE.com:8080/Path", OBSERVABLE_TYPE_URL, "http://example.com:8080/Path"},
+		{"hxxp://example[.]com/a", OBSERVABLE_TYPE_URL, "http://example.com/a"},
+		{"http://[2001:DB8::1]:8080/a", OBSERVABLE_TYPE_URL, "http://[2001:db8::1]:8080/a"},
+		{"http://192.0.2.1/a", OBSERVABLE_TYPE_URL, "http://192.0.2.1/a"},
+		{"example.com/bad.exe", OBSERVABLE_TYPE_URL, "http://example.com/bad.exe"},
+		{"User@Example.COM", OBSERVABLE_TYPE_EMAIL_ADDRESS, "User@example.com"},
+		{"user[@]example[.]com", OBSERVABLE_TYPE_EMAIL_ADDRESS, "user@example.com"},
+		{"D41D8CD98F00B204E9800998ECF8427E", OBSERVABLE_TYPE_MD5, "d41d8cd98f00b204e9800998ecf8427e"},
+		{"da39a3ee5e6b4b0d3255bfef95601890afd80709", OBSERVABLE_TYPE_SHA1, "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+		{"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", OBSERVABLE_TYPE_SHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	}
+
+	for _, test := range tests {
+		observableType, normalized, err := Classify(test.value)
+		if err != nil {
+			t.Errorf("%q returned an error, %v", test.value, err)
+			continue
+		}
+		if observableType != test.observableType || normalized != test.normalized {
+			t.Errorf("%q is %s %q, want %s %q", test.value, observableType, normalized, test.observableType, test.normalized)
+		}
+	}
+}
+
+func TestClassifyRejects(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"192.0.2.1 extra",
+		"localhost",
+		"999.1.1.1",
+		"http:///path",
+		"@example.com",
+		"bad label.example.com",
+	} {
+		if observableType, normalized, err := Classify(value); err == nil {
+			t.Errorf("%q was classified as %s %q", value, observableType, normalized)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	observables := []storage.ObservableType{
+		{Type: OBSERVABLE_TYPE_IP_ADDRESS, Value: "192.0.2.1"},
+		{Type: OBSERVABLE_TYPE_IP_ADDRESS, Value: "192[.]0[.]2[.]1"},
+		{Type: OBSERVABLE_TYPE_IP_ADDRESS, Value: "Example.com"},
+		{Type: OBSERVABLE_TYPE_IP_ADDRESS, Value: "localhost"},
+	}
+
+	accepted, rejected := Normalize(observables)
+	if len(accepted) != 2 {
+		t.Fatalf("expected 2 values without the duplicate, got %+v", accepted)
+	}
+	if accepted[0].Type != OBSERVABLE_TYPE_IPV4_ADDRESS || accepted[1].Type != OBSERVABLE_TYPE_DOMAIN_NAME || accepted[1].Value != "example.com" {
+		t.Errorf("unexpected values %+v", accepted)
+	}
+	if len(rejected) != 1 || rejected[0].Value != "localhost" || rejected[0].Reason == "" {
+		t.Errorf("unexpected rejected values %+v", rejected)
+	}
+}
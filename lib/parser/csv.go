@@ -0,0 +1,161 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package parser
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ----------------------------------------------------------------------
+// Define CSV Parser Type
+// ----------------------------------------------------------------------
+// Columns are named by number, starting at 1, or by the name in the header
+// row if there is one.
+//
+// Options:
+//   valuecolumn - Column that holds the value, the default is 1
+//   typecolumn  - Column that holds the observable type of the value
+//   type        - Observable type of values that do not have a type column
+//   delimiter   - A single character, or tab, comma, semicolon or pipe
+//   header      - true if the first row names the columns
+//   comment     - Character that starts a comment line, the default is #
+
+type CsvParserType struct {
+	Type        string
+	ValueColumn string
+	TypeColumn  string
+	Delimiter   rune
+	Header      bool
+	Comment     rune
+}
+
+func init() {
+	Register("csv", NewCsvParser)
+}
+
+func NewCsvParser(options OptionsType) (ParserType, error) {
+	var obj CsvParserType
+	obj.Type = options.defaultType()
+	obj.ValueColumn = options["valuecolumn"]
+	if obj.ValueColumn == "" {
+		obj.ValueColumn = "1"
+	}
+	obj.TypeColumn = options["typecolumn"]
+	obj.Header = options["header"] == "true"
+
+	obj.Delimiter = ','
+	switch d := options["delimiter"]; d {
+	case "", "comma":
+	case "tab":
+		obj.Delimiter = '\t'
+	case "semicolon":
+		obj.Delimiter = ';'
+	case "pipe":
+		obj.Delimiter = '|'
+	default:
+		if utf8.RuneCountInString(d) != 1 {
+			return nil, errors.New("csv delimiter " + d + " is not a single character")
+		}
+		obj.Delimiter, _ = utf8.DecodeRuneInString(d)
+	}
+
+	obj.Comment = '#'
+	if c := options["comment"]; c != "" {
+		obj.Comment, _ = utf8.DecodeRuneInString(c)
+	}
+	return &obj, nil
+}
+
+func (this *CsvParserType) Parse(data []byte) ([]storage.ObservableType, error) {
+	var observables []storage.ObservableType
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = this.Delimiter
+	r.Comment = this.Comment
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.TrimLeadingSpace = true
+
+	valueIndex, typeIndex := -1, -1
+	var err error
+	if !this.Header {
+		valueIndex, err = columnIndex(this.ValueColumn, nil)
+		if err != nil {
+			return nil, err
+		}
+		typeIndex, err = columnIndex(this.TypeColumn, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	first := true
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if first && this.Header {
+			first = false
+			valueIndex, err = columnIndex(this.ValueColumn, record)
+			if err != nil {
+				return nil, err
+			}
+			typeIndex, err = columnIndex(this.TypeColumn, record)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if valueIndex >= len(record) {
+			continue
+		}
+		value := strings.TrimSpace(record[valueIndex])
+		if value == "" {
+			continue
+		}
+
+		observableType := this.Type
+		if typeIndex >= 0 && typeIndex < len(record) && strings.TrimSpace(record[typeIndex]) != "" {
+			observableType = strings.TrimSpace(record[typeIndex])
+		}
+		observables = append(observables, storage.ObservableType{Type: observableType, Value: value})
+	}
+	return observables, nil
+}
+
+// Columns are numbered from 1, a blank column is -1. A name is looked up in
+// the header row.
+func columnIndex(column string, header []string) (int, error) {
+	if column == "" {
+		return -1, nil
+	}
+	if n, err := strconv.Atoi(column); err == nil {
+		if n < 1 {
+			return -1, errors.New("csv column " + column + " must be 1 or more")
+		}
+		return n - 1, nil
+	}
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), column) {
+			return i, nil
+		}
+	}
+	return -1, errors.New("csv column " + column + " was not found")
+}
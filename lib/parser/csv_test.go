@@ -0,0 +1,75 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package parser
+
+import (
+	"testing"
+
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+)
+
+func TestCsvParserColumns(t *testing.T) {
+	data := "first,192.0.2.1\n" +
+		"# comment,192.0.2.9\n" +
+		"second\n" +
+		"third, 192.0.2.2 \n" +
+		"fourth,\n"
+
+	checkObservables(t, parse(t, "csv", "valuecolumn=2", data),
+		storage.ObservableType{Type: OBSERVABLE_TYPE_IP_ADDRESS, Value: "192.0.2.1"},
+		storage.ObservableType{Type: OBSERVABLE_TYPE_IP_ADDRESS, Value: "192.0.2.2"},
+	)
+}
+
+func TestCsvParserHeader(t *testing.T) {
+	data := "Kind|Indicator\n" +
+		"URL|http://example.com/a\n" +
+		"|example.com\n"
+
+	checkObservables(t, parse(t, "csv", "header=true delimiter=pipe valuecolumn=indicator typecolumn=kind type=Domain", data),
+		storage.ObservableType{Type: OBSERVABLE_TYPE_URL, Value: "http://example.com/a"},
+		storage.ObservableType{Type: "Domain", Value: "example.com"},
+	)
+}
+
+func TestCsvParserDelimiters(t *testing.T) {
+	for _, test := range []struct {
+		options string
+		data    string
+	}{
+		{"", "192.0.2.1,a\n"},
+		{"delimiter=tab", "192.0.2.1\ta\n"},
+		{"delimiter=semicolon", "192.0.2.1;a\n"},
+		{"delimiter=:", "192.0.2.1:a\n"},
+	} {
+		checkObservables(t, parse(t, "csv", test.options, test.data),
+			storage.ObservableType{Type: OBSERVABLE_TYPE_IP_ADDRESS, Value: "192.0.2.1"})
+	}
+}
+
+func TestCsvParserErrors(t *testing.T) {
+	if _, err := New("csv", "delimiter=ab"); err == nil {
+		t.Errorf("a delimiter of more than one character was accepted")
+	}
+
+	for _, test := range []struct {
+		options string
+		data    string
+	}{
+		{"valuecolumn=0", "192.0.2.1\n"},
+		{"header=true valuecolumn=missing", "ip\n192.0.2.1\n"},
+		{"typecolumn=missing", "192.0.2.1\n"},
+	} {
+		p, err := New("csv", test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = p.Parse([]byte(test.data)); err == nil {
+			t.Errorf("options %q were accepted", test.options)
+		}
+	}
+}
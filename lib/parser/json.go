@@ -0,0 +1,165 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ----------------------------------------------------------------------
+// Define JSON Parser Type
+// ----------------------------------------------------------------------
+// Paths are keys separated by dots, with [*] for every element of an array
+// and [n] for one of them, for example $.data.results[*]. A key used on an
+// array is looked up in every element, * on an object is every value in the
+// order of the keys.
+//
+// Options:
+//   items     - Path to the items, the default is the whole document
+//   value     - Path to the value inside of an item, the default is the item
+//   typefield - Path to the observable type inside of an item
+//   type      - Observable type of values that do not have a type field
+
+type JsonParserType struct {
+	Type      string
+	Items     string
+	Value     string
+	TypeField string
+}
+
+func init() {
+	Register("json", NewJsonParser)
+}
+
+func NewJsonParser(options OptionsType) (ParserType, error) {
+	var obj JsonParserType
+	obj.Type = options.defaultType()
+	obj.Items = options["items"]
+	obj.Value = options["value"]
+	obj.TypeField = options["typefield"]
+	return &obj, nil
+}
+
+func (this *JsonParserType) Parse(data []byte) ([]storage.ObservableType, error) {
+	var observables []storage.ObservableType
+
+	var document interface{}
+	err := json.Unmarshal(data, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range selectPath(document, this.Items) {
+		observableType := this.Type
+		if this.TypeField != "" {
+			if types := selectPath(item, this.TypeField); len(types) > 0 {
+				if s := jsonString(types[0]); s != "" {
+					observableType = s
+				}
+			}
+		}
+
+		for _, value := range selectPath(item, this.Value) {
+			s := strings.TrimSpace(jsonString(value))
+			if s == "" {
+				continue
+			}
+			observables = append(observables, storage.ObservableType{Type: observableType, Value: s})
+		}
+	}
+	return observables, nil
+}
+
+// --------------------------------------------------
+// Select the Values at a Path
+// --------------------------------------------------
+// Arrays at the end of the path are returned one element at a time
+
+func selectPath(document interface{}, path string) []interface{} {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	path = strings.Replace(path, "[", ".", -1)
+	path = strings.Replace(path, "]", "", -1)
+
+	current := []interface{}{document}
+	for _, key := range strings.Split(path, ".") {
+		if key == "" {
+			continue
+		}
+
+		var next []interface{}
+		for _, value := range current {
+			next = append(next, selectKey(value, key)...)
+		}
+		current = next
+	}
+
+	var values []interface{}
+	for _, value := range current {
+		if list, ok := value.([]interface{}); ok {
+			values = append(values, list...)
+			continue
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func selectKey(value interface{}, key string) []interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if key == "*" {
+			var keys []string
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			var values []interface{}
+			for _, k := range keys {
+				values = append(values, v[k])
+			}
+			return values
+		}
+		if child, ok := v[key]; ok {
+			return []interface{}{child}
+		}
+	case []interface{}:
+		if key == "*" {
+			return v
+		}
+		if n, err := strconv.Atoi(key); err == nil {
+			if n >= 0 && n < len(v) {
+				return []interface{}{v[n]}
+			}
+			return nil
+		}
+		var values []interface{}
+		for _, child := range v {
+			values = append(values, selectKey(child, key)...)
+		}
+		return values
+	}
+	return nil
+}
+
+// Numbers are turned back in to text, objects and arrays are not values
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return fmt.Sprint(v)
+	}
+	return ""
+}
@@ -0,0 +1,149 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package parser turns the content of a feed or an imported file in to typed
+// observables. Every parser registers itself by name, so the fetcher and
+// freetaxii-mgmt can use any of them from the name saved with a feed.
+package parser
+
+import (
+	"errors"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Observable types that the parsers find
+const (
+	OBSERVABLE_TYPE_IP_ADDRESS    = "IP Address"
+	OBSERVABLE_TYPE_DOMAIN_NAME   = "Domain Name"
+	OBSERVABLE_TYPE_URL           = "URL"
+	OBSERVABLE_TYPE_EMAIL_ADDRESS = "Email Address"
+	OBSERVABLE_TYPE_FILE_HASH     = "File Hash"
+)
+
+// Parser that is used when a feed does not name one
+const (
+	DEFAULT_PARSER = "plaintext"
+)
+
+// ----------------------------------------------------------------------
+// Define Parser Interface
+// ----------------------------------------------------------------------
+// A parser returns the observables it found in the order it found them, the
+// Created time is left for the caller to fill in. Values that do not say what
+// type they are get the type option, or IP Address if it is not set. The
+// values are not checked, Normalize does that and sets their real type.
+
+type ParserType interface {
+	Parse(data []byte) ([]storage.ObservableType, error)
+}
+
+// Options are given as key=value pairs separated by spaces, for example
+// "valuecolumn=2 delimiter=tab header=true"
+type OptionsType map[string]string
+
+type NewParserFunc func(options OptionsType) (ParserType, error)
+
+var registry = struct {
+	sync.RWMutex
+	parsers map[string]NewParserFunc
+}{parsers: make(map[string]NewParserFunc)}
+
+// --------------------------------------------------
+// Register a Parser
+// --------------------------------------------------
+
+func Register(name string, f NewParserFunc) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.parsers[name] = f
+}
+
+// --------------------------------------------------
+// Create a Parser by Name
+// --------------------------------------------------
+// A blank name is the default parser
+
+func New(name, options string) (ParserType, error) {
+	if name == "" {
+		name = DEFAULT_PARSER
+	}
+
+	registry.RLock()
+	f, ok := registry.parsers[name]
+	registry.RUnlock()
+	if !ok {
+		return nil, errors.New("unknown parser " + name)
+	}
+
+	o, err := ParseOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return f(o)
+}
+
+// --------------------------------------------------
+// Get the Names of every Parser
+// --------------------------------------------------
+
+func Names() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	var names []string
+	for name := range registry.parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// --------------------------------------------------
+// Read the Options of a Parser
+// --------------------------------------------------
+
+func ParseOptions(s string) (OptionsType, error) {
+	o := make(OptionsType)
+	for _, field := range strings.Fields(s) {
+		i := strings.Index(field, "=")
+		if i <= 0 {
+			return nil, errors.New("parser option " + field + " is not in the form key=value")
+		}
+		o[strings.ToLower(field[:i])] = field[i+1:]
+	}
+	return o, nil
+}
+
+// The type option, or IP Address since that is what the feeds have always
+// held
+func (this OptionsType) defaultType() string {
+	if this["type"] != "" {
+		return this["type"]
+	}
+	return OBSERVABLE_TYPE_IP_ADDRESS
+}
+
+// --------------------------------------------------
+// Remove a Comment from a Line
+// --------------------------------------------------
+// A comment starts with one of the comment characters at the start of the
+// line or after white space, so a # inside of a URL is kept.
+
+func stripComment(line, comment string) string {
+	for i, c := range line {
+		if !strings.ContainsRune(comment, c) {
+			continue
+		}
+		if i == 0 || line[i-1] == ' ' || line[i-1] == '\t' {
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package parser
+
+import (
+	"testing"
+
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+)
+
+// parse creates the named parser with the options and parses the data
+func parse(t *testing.T, name, options, data string) []storage.ObservableType {
+	t.Helper()
+	p, err := New(name, options)
+	if err != nil {
+		t.Fatalf("unable to create parser %s, %v", name, err)
+	}
+	observables, err := p.Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("parser %s returned an error, %v", name, err)
+	}
+	return observables
+}
+
+func checkObservables(t *testing.T, got []storage.ObservableType, want ...storage.ObservableType) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("observable %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("unknown", ""); err == nil {
+		t.Errorf("an unknown parser was created")
+	}
+	if _, err := New("csv", "valuecolumn"); err == nil {
+		t.Errorf("an option without a value was accepted")
+	}
+
+	// A blank name is the default parser
+	p, err := New("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(*PlainTextParserType); !ok {
+		t.Errorf("the default parser is %T", p)
+	}
+
+	for _, name := range []string{"csv", "plaintext"} {
+		found := false
+		for _, value := range Names() {
+			found = found || value == name
+		}
+		if !found {
+			t.Errorf("parser %s is not registered", name)
+		}
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	o, err := ParseOptions("ValueColumn=2  delimiter=tab header=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o) != 3 || o["valuecolumn"] != "2" || o["delimiter"] != "tab" || o["header"] != "true" {
+		t.Errorf("unexpected options %v", o)
+	}
+
+	for _, s := range []string{"header", "=true"} {
+		if _, err := ParseOptions(s); err == nil {
+			t.Errorf("options %q were accepted", s)
+		}
+	}
+}
+
+func TestStripComment(t *testing.T) {
+	tests := []struct {
+		line    string
+		comment string
+		want    string
+	}{
+		{"# comment", "#", ""},
+		{"192.0.2.1 # comment", "#", "192.0.2.1 "},
+		{"192.0.2.1\t# comment", "#", "192.0.2.1\t"},
+		{"http://example.com/#anchor", "#", "http://example.com/#anchor"},
+		{"192.0.2.1 ; comment", ";#", "192.0.2.1 "},
+		{"192.0.2.1;not a comment", ";", "192.0.2.1;not a comment"},
+	}
+
+	for _, test := range tests {
+		if got := stripComment(test.line, test.comment); got != test.want {
+			t.Errorf("%q with %q is %q, want %q", test.line, test.comment, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package parser
+
+import (
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"strings"
+)
+
+// ----------------------------------------------------------------------
+// Define Plain Text Parser Type
+// ----------------------------------------------------------------------
+// One value per line. Anything after a comment character is dropped, as is
+// anything after the first space, so lists like "1.2.3.4 ; SBL123" work.
+//
+// Options:
+//   type     - Observable type of every value
+//   comment  - Characters that start a comment, the default is #
+
+type PlainTextParserType struct {
+	Type    string
+	Comment string
+}
+
+func init() {
+	Register("plaintext", NewPlainTextParser)
+}
+
+func NewPlainTextParser(options OptionsType) (ParserType, error) {
+	var obj PlainTextParserType
+	obj.Type = options.defaultType()
+	obj.Comment = options["comment"]
+	if obj.Comment == "" {
+		obj.Comment = "#"
+	}
+	return &obj, nil
+}
+
+func (this *PlainTextParserType) Parse(data []byte) ([]storage.ObservableType, error) {
+	var observables []storage.ObservableType
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(stripComment(line, this.Comment))
+		if len(fields) == 0 {
+			continue
+		}
+		observables = append(observables, storage.ObservableType{Type: this.Type, Value: fields[0]})
+	}
+	return observables, nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package parser
+
+import (
+	"testing"
+
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+)
+
+func TestPlainTextParser(t *testing.T) {
+	data := "# Example list\n" +
+		"192.0.2.1\n" +
+		"\n" +
+		"192.0.2.2 ; SBL123\n" +
+		"  192.0.2.3\t# note\n" +
+		"http://example.com/#anchor other fields\n"
+
+	checkObservables(t, parse(t, "plaintext", "", data),
+		storage.ObservableType{Type: OBSERVABLE_TYPE_IP_ADDRESS, Value: "192.0.2.1"},
+		storage.ObservableType{Type: OBSERVABLE_TYPE_IP_ADDRESS, Value: "192.0.2.2"},
+		storage.ObservableType{Type: OBSERVABLE_TYPE_IP_ADDRESS, Value: "192.0.2.3"},
+		storage.ObservableType{Type: OBSERVABLE_TYPE_IP_ADDRESS, Value: "http://example.com/#anchor"},
+	)
+}
+
+func TestPlainTextParserOptions(t *testing.T) {
+	data := "; Example list\n" +
+		"http://example.com/a ; note\n" +
+		"#http://example.com/b\n"
+
+	checkObservables(t, parse(t, "plaintext", "type=URL comment=;", data),
+		storage.ObservableType{Type: OBSERVABLE_TYPE_URL, Value: "http://example.com/a"},
+		storage.ObservableType{Type: OBSERVABLE_TYPE_URL, Value: "#http://example.com/b"},
+	)
+}
@@ -0,0 +1,192 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ----------------------------------------------------------------------
+// Define STIX 1.x Parser Type
+// ----------------------------------------------------------------------
+// Reads the CybOX object properties in a STIX 1.x XML document, wherever
+// they are. Address, domain name, hostname, URI and file hash objects are
+// kept. A value with a condition can hold a list separated by ##comma##.
+
+type Stix1ParserType struct{}
+
+func init() {
+	Register("stix1", NewStix1Parser)
+}
+
+func NewStix1Parser(options OptionsType) (ParserType, error) {
+	return &Stix1ParserType{}, nil
+}
+
+const (
+	XSI_NAMESPACE        = "http://www.w3.org/2001/XMLSchema-instance"
+	CYBOX_LIST_DELIMITER = "##comma##"
+)
+
+// Elements that hold a value for each of the object types we keep
+var stix1ValueElements = map[string][]string{
+	"AddressObjectType":    {"Address_Value"},
+	"DomainNameObjectType": {"Value"},
+	"HostnameObjectType":   {"Hostname_Value"},
+	"URIObjectType":        {"Value"},
+	"FileObjectType":       {"Simple_Hash_Value", "Fuzzy_Hash_Value"},
+}
+
+func (this *Stix1ParserType) Parse(data []byte) ([]storage.ObservableType, error) {
+	var observables []storage.ObservableType
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	// The object type and observable type of the properties we are in, and
+	// the text of the value element we are in
+	objectType := ""
+	observableType := ""
+	depth := 0
+	inValue := false
+	var value bytes.Buffer
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if objectType != "" {
+				depth++
+				if stringInList(t.Name.Local, stix1ValueElements[objectType]) {
+					inValue = true
+					value.Reset()
+				}
+				continue
+			}
+			if t.Name.Local != "Properties" {
+				continue
+			}
+
+			xsiType := ""
+			category := ""
+			uriType := ""
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Local == "type" && attr.Name.Space == XSI_NAMESPACE:
+					xsiType = attr.Value
+				case attr.Name.Local == "category":
+					category = attr.Value
+				case attr.Name.Local == "type":
+					uriType = attr.Value
+				}
+			}
+			if i := strings.Index(xsiType, ":"); i >= 0 {
+				xsiType = xsiType[i+1:]
+			}
+			if _, ok := stix1ValueElements[xsiType]; !ok {
+				continue
+			}
+
+			objectType = xsiType
+			observableType = stix1ObservableType(objectType, category, uriType)
+			depth = 0
+
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+
+		case xml.EndElement:
+			if objectType == "" {
+				continue
+			}
+			if depth == 0 {
+				objectType = ""
+				continue
+			}
+			depth--
+
+			if inValue {
+				inValue = false
+				for _, s := range strings.Split(value.String(), CYBOX_LIST_DELIMITER) {
+					s = strings.TrimSpace(s)
+					if s != "" && observableType != "" {
+						observables = append(observables, storage.ObservableType{Type: observableType, Value: s})
+					}
+				}
+			}
+		}
+	}
+	return observables, nil
+}
+
+// --------------------------------------------------
+// Map a CybOX Object to an Observable Type
+// --------------------------------------------------
+
+func stix1ObservableType(objectType, category, uriType string) string {
+	switch objectType {
+	case "AddressObjectType":
+		if category == "e-mail" {
+			return OBSERVABLE_TYPE_EMAIL_ADDRESS
+		}
+		if category == "" || strings.HasPrefix(category, "ipv4") || strings.HasPrefix(category, "ipv6") {
+			return OBSERVABLE_TYPE_IP_ADDRESS
+		}
+	case "DomainNameObjectType", "HostnameObjectType":
+		return OBSERVABLE_TYPE_DOMAIN_NAME
+	case "URIObjectType":
+		if uriType == "Domain Name" {
+			return OBSERVABLE_TYPE_DOMAIN_NAME
+		}
+		return OBSERVABLE_TYPE_URL
+	case "FileObjectType":
+		return OBSERVABLE_TYPE_FILE_HASH
+	}
+	return ""
+}
+
+// --------------------------------------------------
+// Helpers
+// --------------------------------------------------
+
+func stringInList(s string, list []string) bool {
+	for _, value := range list {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// The values of a map in the order of their keys
+func sortedValues(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var values []string
+	for _, key := range keys {
+		if m[key] != "" {
+			values = append(values, m[key])
+		}
+	}
+	return values
+}
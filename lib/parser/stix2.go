@@ -0,0 +1,124 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package parser
+
+import (
+	"encoding/json"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"regexp"
+	"strings"
+)
+
+// ----------------------------------------------------------------------
+// Define STIX 2.x Parser Type
+// ----------------------------------------------------------------------
+// Reads a bundle, a list of objects or a single object. Values come from the
+// equality comparisons in the patterns of indicators and from cyber
+// observable objects. Comparisons that are not equality, and object types
+// that are not known, are skipped.
+
+type Stix2ParserType struct{}
+
+func init() {
+	Register("stix2", NewStix2Parser)
+}
+
+func NewStix2Parser(options OptionsType) (ParserType, error) {
+	return &Stix2ParserType{}, nil
+}
+
+// Matches [ipv4-addr:value = '1.2.3.4'] and file:hashes.'SHA-256' = '...'
+var stix2Comparison = regexp.MustCompile(`([a-z0-9-]+):([A-Za-z0-9_.'\-]+)\s*=\s*'((?:[^'\\]|\\.)*)'`)
+
+type stix2ObjectType struct {
+	Type        string            `json:"type"`
+	Pattern     string            `json:"pattern"`
+	PatternType string            `json:"pattern_type"`
+	Value       string            `json:"value"`
+	Hashes      map[string]string `json:"hashes"`
+	Objects     []json.RawMessage `json:"objects"`
+}
+
+func (this *Stix2ParserType) Parse(data []byte) ([]storage.ObservableType, error) {
+	var observables []storage.ObservableType
+
+	var objects []json.RawMessage
+	data = []byte(strings.TrimSpace(string(data)))
+	if len(data) > 0 && data[0] == '[' {
+		err := json.Unmarshal(data, &objects)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		objects = []json.RawMessage{data}
+	}
+
+	for len(objects) > 0 {
+		var o stix2ObjectType
+		err := json.Unmarshal(objects[0], &o)
+		objects = objects[1:]
+		if err != nil {
+			return nil, err
+		}
+
+		switch o.Type {
+		case "bundle":
+			objects = append(objects, o.Objects...)
+		case "indicator":
+			if o.PatternType != "" && o.PatternType != "stix" {
+				continue
+			}
+			for _, match := range stix2Comparison.FindAllStringSubmatch(o.Pattern, -1) {
+				observableType := stix2ObservableType(match[1], match[2])
+				if observableType == "" {
+					continue
+				}
+				value := strings.Replace(strings.Replace(match[3], `\'`, `'`, -1), `\\`, `\`, -1)
+				observables = append(observables, storage.ObservableType{Type: observableType, Value: value})
+			}
+		case "file":
+			for _, value := range sortedValues(o.Hashes) {
+				observables = append(observables, storage.ObservableType{Type: OBSERVABLE_TYPE_FILE_HASH, Value: value})
+			}
+		default:
+			observableType := stix2ObservableType(o.Type, "value")
+			if observableType != "" && o.Value != "" {
+				observables = append(observables, storage.ObservableType{Type: observableType, Value: o.Value})
+			}
+		}
+	}
+	return observables, nil
+}
+
+// --------------------------------------------------
+// Map a STIX 2 Object Path to an Observable Type
+// --------------------------------------------------
+// Returns a blank type if the path does not hold a value we keep
+
+func stix2ObservableType(objectType, path string) string {
+	if objectType == "file" {
+		if strings.HasPrefix(path, "hashes.") {
+			return OBSERVABLE_TYPE_FILE_HASH
+		}
+		return ""
+	}
+	if path != "value" {
+		return ""
+	}
+
+	switch objectType {
+	case "ipv4-addr", "ipv6-addr":
+		return OBSERVABLE_TYPE_IP_ADDRESS
+	case "domain-name":
+		return OBSERVABLE_TYPE_DOMAIN_NAME
+	case "url":
+		return OBSERVABLE_TYPE_URL
+	case "email-addr":
+		return OBSERVABLE_TYPE_EMAIL_ADDRESS
+	}
+	return ""
+}
@@ -0,0 +1,245 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package stix1 builds the small set of STIX 1.2 objects that the server
+// publishes in JSON: packages, indicators, the sources that produced them and
+// the CybOX objects of their observables.
+package stix1
+
+import (
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"strings"
+	"time"
+)
+
+const (
+	STIX_VERSION = "1.2"
+	ID_PREFIX    = "freetaxii"
+)
+
+// STIX 1.2 timestamps are always UTC with microsecond precision
+const (
+	TIMESTAMP_FORMAT = "2006-01-02T15:04:05.000000Z"
+)
+
+// CybOX object types, and the values of their category and type attributes
+// that the server uses
+const (
+	OBJECT_TYPE_ADDRESS     = "AddressObjectType"
+	OBJECT_TYPE_DOMAIN_NAME = "DomainNameObjectType"
+	OBJECT_TYPE_URI         = "URIObjectType"
+	OBJECT_TYPE_FILE        = "FileObjectType"
+
+	ADDRESS_CATEGORY_IPV4_ADDRESS = "ipv4-addr"
+	ADDRESS_CATEGORY_IPV6_ADDRESS = "ipv6-addr"
+	ADDRESS_CATEGORY_IPV4_NETWORK = "ipv4-net"
+	ADDRESS_CATEGORY_IPV6_NETWORK = "ipv6-net"
+	ADDRESS_CATEGORY_EMAIL        = "e-mail"
+	DOMAIN_NAME_TYPE_FQDN         = "FQDN"
+	URI_TYPE_URL                  = "URL"
+)
+
+// A property that holds a list of values is matched if any of them match,
+// the values are separated by ##comma##
+const (
+	CONDITION_EQUALS    = "Equals"
+	APPLY_CONDITION_ANY = "ANY"
+	LIST_DELIMITER      = "##comma##"
+)
+
+// ----------------------------------------------------------------------
+// Define Object Types
+// ----------------------------------------------------------------------
+
+type PackageType struct {
+	Id         string          `json:"id"`
+	Version    string          `json:"version"`
+	Timestamp  string          `json:"timestamp"`
+	Indicators []IndicatorType `json:"indicators,omitempty"`
+}
+
+type IndicatorType struct {
+	Id          string                 `json:"id"`
+	Timestamp   string                 `json:"timestamp"`
+	Title       string                 `json:"title,omitempty"`
+	Types       []string               `json:"types,omitempty"`
+	Producer    *InformationSourceType `json:"producer,omitempty"`
+	Observables []ObservableType       `json:"observables,omitempty"`
+}
+
+type InformationSourceType struct {
+	Description         string                  `json:"description,omitempty"`
+	Identity            *IdentityType           `json:"identity,omitempty"`
+	ContributingSources []InformationSourceType `json:"contributing_sources,omitempty"`
+	ProducedTime        string                  `json:"produced_time,omitempty"`
+	References          []string                `json:"references,omitempty"`
+}
+
+type IdentityType struct {
+	Name string `json:"name"`
+}
+
+type ObservableType struct {
+	Id     string     `json:"id"`
+	Object ObjectType `json:"object"`
+}
+
+type ObjectType struct {
+	Id         string               `json:"id"`
+	Properties ObjectPropertiesType `json:"properties"`
+}
+
+// The properties of every CybOX object type the server uses, only the ones of
+// the object named by XsiType are set
+type ObjectPropertiesType struct {
+	XsiType      string              `json:"xsi:type"`
+	Category     string              `json:"category,omitempty"`
+	Type         string              `json:"type,omitempty"`
+	AddressValue *StringPropertyType `json:"address_value,omitempty"`
+	Value        *StringPropertyType `json:"value,omitempty"`
+	Hashes       []HashType          `json:"hashes,omitempty"`
+}
+
+type HashType struct {
+	Type            StringPropertyType `json:"type"`
+	SimpleHashValue StringPropertyType `json:"simple_hash_value"`
+}
+
+type StringPropertyType struct {
+	Condition      string `json:"condition,omitempty"`
+	ApplyCondition string `json:"apply_condition,omitempty"`
+	Value          string `json:"value"`
+}
+
+// ----------------------------------------------------------------------
+// Public Create Functions
+// ----------------------------------------------------------------------
+
+func NewPackage() PackageType {
+	var obj PackageType
+	obj.Id = CreateId("Package")
+	obj.Version = STIX_VERSION
+	obj.Timestamp = Timestamp(time.Now())
+	return obj
+}
+
+func NewIndicator() IndicatorType {
+	var obj IndicatorType
+	obj.Id = CreateId("indicator")
+	obj.Timestamp = Timestamp(time.Now())
+	return obj
+}
+
+func NewInformationSource() InformationSourceType {
+	var obj InformationSourceType
+	return obj
+}
+
+// CreateId returns a STIX 1 identifier for an object of the given type
+func CreateId(objectType string) string {
+	return ID_PREFIX + ":" + objectType + "-" + common.CreateMessageId()
+}
+
+// Timestamp returns a time in the STIX 1.2 timestamp format
+func Timestamp(t time.Time) string {
+	return t.UTC().Format(TIMESTAMP_FORMAT)
+}
+
+// ----------------------------------------------------------------------
+// Package Methods
+// ----------------------------------------------------------------------
+
+func (this *PackageType) AddIndicator(i IndicatorType) {
+	this.Indicators = append(this.Indicators, i)
+}
+
+// ----------------------------------------------------------------------
+// Indicator Methods
+// ----------------------------------------------------------------------
+
+func (this *IndicatorType) AddTitle(s string) {
+	this.Title = s
+}
+
+func (this *IndicatorType) AddType(s string) {
+	if s != "" {
+		this.Types = append(this.Types, s)
+	}
+}
+
+func (this *IndicatorType) AddProducer(s InformationSourceType) {
+	this.Producer = &s
+}
+
+// AddObservable adds an observable that holds the CybOX object with the given
+// properties
+func (this *IndicatorType) AddObservable(properties ObjectPropertiesType) {
+	var o ObservableType
+	o.Id = CreateId("Observable")
+	o.Object.Id = CreateId("Object")
+	o.Object.Properties = properties
+	this.Observables = append(this.Observables, o)
+}
+
+// ----------------------------------------------------------------------
+// Information Source Methods
+// ----------------------------------------------------------------------
+
+func (this *InformationSourceType) AddDescription(s string) {
+	this.Description = s
+}
+
+func (this *InformationSourceType) AddIdentityName(s string) {
+	this.Identity = &IdentityType{Name: s}
+}
+
+func (this *InformationSourceType) AddContributingSource(s InformationSourceType) {
+	this.ContributingSources = append(this.ContributingSources, s)
+}
+
+func (this *InformationSourceType) SetProducedTime(t time.Time) {
+	this.ProducedTime = Timestamp(t)
+}
+
+func (this *InformationSourceType) AddReference(s string) {
+	if s != "" {
+		this.References = append(this.References, s)
+	}
+}
+
+// ----------------------------------------------------------------------
+// CybOX Object Properties
+// ----------------------------------------------------------------------
+// Each function returns the properties of one CybOX object that matches any
+// of the values.
+
+func NewAddressProperties(category string, values []string) ObjectPropertiesType {
+	return ObjectPropertiesType{XsiType: OBJECT_TYPE_ADDRESS, Category: category, AddressValue: equalsAny(values)}
+}
+
+func NewDomainNameProperties(values []string) ObjectPropertiesType {
+	return ObjectPropertiesType{XsiType: OBJECT_TYPE_DOMAIN_NAME, Type: DOMAIN_NAME_TYPE_FQDN, Value: equalsAny(values)}
+}
+
+func NewUrlProperties(values []string) ObjectPropertiesType {
+	return ObjectPropertiesType{XsiType: OBJECT_TYPE_URI, Type: URI_TYPE_URL, Value: equalsAny(values)}
+}
+
+// The hash type is a name from the CybOX hash name vocabulary, like MD5 or
+// SHA256
+func NewFileHashProperties(hashType string, values []string) ObjectPropertiesType {
+	obj := ObjectPropertiesType{XsiType: OBJECT_TYPE_FILE}
+	obj.Hashes = []HashType{{Type: StringPropertyType{Value: hashType}, SimpleHashValue: *equalsAny(values)}}
+	return obj
+}
+
+func equalsAny(values []string) *StringPropertyType {
+	obj := StringPropertyType{Condition: CONDITION_EQUALS, Value: strings.Join(values, LIST_DELIMITER)}
+	if len(values) > 1 {
+		obj.ApplyCondition = APPLY_CONDITION_ANY
+	}
+	return &obj
+}
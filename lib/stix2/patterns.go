@@ -0,0 +1,56 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package stix2
+
+import (
+	"strings"
+)
+
+// --------------------------------------------------
+// Create STIX Patterns
+// --------------------------------------------------
+// Each function returns a pattern that matches a single value, like
+// [ipv4-addr:value = '1.2.3.4']
+
+func CreateEqualsPattern(objectPath, value string) string {
+	return "[" + objectPath + " = '" + EscapePatternString(value) + "']"
+}
+
+func CreateIpv4Pattern(value string) string {
+	return CreateEqualsPattern("ipv4-addr:value", value)
+}
+
+func CreateIpv6Pattern(value string) string {
+	return CreateEqualsPattern("ipv6-addr:value", value)
+}
+
+func CreateDomainPattern(value string) string {
+	return CreateEqualsPattern("domain-name:value", value)
+}
+
+func CreateUrlPattern(value string) string {
+	return CreateEqualsPattern("url:value", value)
+}
+
+func CreateEmailPattern(value string) string {
+	return CreateEqualsPattern("email-addr:value", value)
+}
+
+// The algorithm is a name from the hash algorithm vocabulary, like SHA-256
+func CreateFileHashPattern(algorithm, value string) string {
+	return CreateEqualsPattern("file:hashes.'"+EscapePatternString(algorithm)+"'", value)
+}
+
+// --------------------------------------------------
+// Escape a String for use in a STIX Pattern
+// --------------------------------------------------
+// String constants are quoted with ' so both \ and ' need to be escaped
+
+func EscapePatternString(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return strings.Replace(s, `'`, `\'`, -1)
+}
@@ -0,0 +1,209 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package stix2 builds the small set of STIX 2.1 objects that the server
+// publishes: bundles, indicators and the identities that produced them.
+package stix2
+
+import (
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"time"
+)
+
+const (
+	SPEC_VERSION = "2.1"
+)
+
+// Namespace of the name based IDs made by CreateNameBasedId
+const (
+	ID_NAMESPACE = "3c6d7f4a-2b1e-5c8d-9f0a-4e5b6c7d8e9f"
+)
+
+// STIX 2.1 timestamps are always UTC with millisecond precision
+const (
+	TIMESTAMP_FORMAT = "2006-01-02T15:04:05.000Z"
+)
+
+// Values from the STIX 2.1 open vocabularies that the server uses
+const (
+	INDICATOR_TYPE_MALICIOUS_ACTIVITY = "malicious-activity"
+	INDICATOR_TYPE_COMPROMISED        = "compromised"
+	IDENTITY_CLASS_ORGANIZATION       = "organization"
+	PATTERN_TYPE_STIX                 = "stix"
+)
+
+// ----------------------------------------------------------------------
+// Define Object Types
+// ----------------------------------------------------------------------
+
+type BundleType struct {
+	Type    string        `json:"type"`
+	Id      string        `json:"id"`
+	Objects []interface{} `json:"objects,omitempty"`
+}
+
+// Common properties of every STIX Domain Object
+type CommonPropertiesType struct {
+	Type               string                  `json:"type"`
+	SpecVersion        string                  `json:"spec_version"`
+	Id                 string                  `json:"id"`
+	CreatedByRef       string                  `json:"created_by_ref,omitempty"`
+	Created            string                  `json:"created"`
+	Modified           string                  `json:"modified"`
+	ExternalReferences []ExternalReferenceType `json:"external_references,omitempty"`
+}
+
+type ExternalReferenceType struct {
+	SourceName  string `json:"source_name"`
+	Description string `json:"description,omitempty"`
+	Url         string `json:"url,omitempty"`
+}
+
+type IndicatorType struct {
+	CommonPropertiesType
+	Name           string   `json:"name,omitempty"`
+	Description    string   `json:"description,omitempty"`
+	IndicatorTypes []string `json:"indicator_types,omitempty"`
+	Pattern        string   `json:"pattern"`
+	PatternType    string   `json:"pattern_type"`
+	ValidFrom      string   `json:"valid_from"`
+}
+
+type IdentityType struct {
+	CommonPropertiesType
+	Name               string `json:"name"`
+	Description        string `json:"description,omitempty"`
+	IdentityClass      string `json:"identity_class,omitempty"`
+	ContactInformation string `json:"contact_information,omitempty"`
+}
+
+// ----------------------------------------------------------------------
+// Public Create Functions
+// ----------------------------------------------------------------------
+
+func NewBundle() BundleType {
+	var obj BundleType
+	obj.Type = "bundle"
+	obj.Id = CreateId("bundle")
+	return obj
+}
+
+func NewIndicator() IndicatorType {
+	var obj IndicatorType
+	obj.initCommonProperties("indicator")
+	obj.PatternType = PATTERN_TYPE_STIX
+	obj.ValidFrom = obj.Created
+	return obj
+}
+
+func NewIdentity() IdentityType {
+	var obj IdentityType
+	obj.initCommonProperties("identity")
+	return obj
+}
+
+// CreateId returns a STIX identifier for an object of the given type
+func CreateId(objectType string) string {
+	return objectType + "--" + common.CreateMessageId()
+}
+
+// CreateNameBasedId returns a STIX identifier that is always the same for the
+// same type and name, so an object that is built again keeps its ID
+func CreateNameBasedId(objectType, name string) string {
+	return objectType + "--" + common.CreateNameBasedId(ID_NAMESPACE, objectType+"/"+name)
+}
+
+// Timestamp returns a time in the STIX 2.1 timestamp format
+func Timestamp(t time.Time) string {
+	return t.UTC().Format(TIMESTAMP_FORMAT)
+}
+
+// ----------------------------------------------------------------------
+// Bundle Methods
+// ----------------------------------------------------------------------
+
+func (this *BundleType) AddObject(o interface{}) {
+	this.Objects = append(this.Objects, o)
+}
+
+// ----------------------------------------------------------------------
+// Common Property Methods
+// ----------------------------------------------------------------------
+
+func (this *CommonPropertiesType) initCommonProperties(objectType string) {
+	this.Type = objectType
+	this.SpecVersion = SPEC_VERSION
+	this.Id = CreateId(objectType)
+	this.Created = Timestamp(time.Now())
+	this.Modified = this.Created
+}
+
+func (this *CommonPropertiesType) AddId(s string) {
+	this.Id = s
+}
+
+func (this *CommonPropertiesType) AddCreatedByRef(s string) {
+	this.CreatedByRef = s
+}
+
+// SetCreated sets both the created and modified timestamps, for an object
+// that has never been changed
+func (this *CommonPropertiesType) SetCreated(t time.Time) {
+	this.Created = Timestamp(t)
+	this.Modified = this.Created
+}
+
+func (this *CommonPropertiesType) SetModified(t time.Time) {
+	this.Modified = Timestamp(t)
+}
+
+func (this *CommonPropertiesType) AddExternalReference(sourceName, description, url string) {
+	this.ExternalReferences = append(this.ExternalReferences, ExternalReferenceType{sourceName, description, url})
+}
+
+// ----------------------------------------------------------------------
+// Indicator Methods
+// ----------------------------------------------------------------------
+
+func (this *IndicatorType) AddName(s string) {
+	this.Name = s
+}
+
+func (this *IndicatorType) AddDescription(s string) {
+	this.Description = s
+}
+
+func (this *IndicatorType) AddIndicatorType(s string) {
+	this.IndicatorTypes = append(this.IndicatorTypes, s)
+}
+
+func (this *IndicatorType) AddPattern(s string) {
+	this.Pattern = s
+}
+
+func (this *IndicatorType) SetValidFrom(t time.Time) {
+	this.ValidFrom = Timestamp(t)
+}
+
+// ----------------------------------------------------------------------
+// Identity Methods
+// ----------------------------------------------------------------------
+
+func (this *IdentityType) AddName(s string) {
+	this.Name = s
+}
+
+func (this *IdentityType) AddDescription(s string) {
+	this.Description = s
+}
+
+func (this *IdentityType) SetClassOrganization() {
+	this.IdentityClass = IDENTITY_CLASS_ORGANIZATION
+}
+
+func (this *IdentityType) AddContactInformation(s string) {
+	this.ContactInformation = s
+}
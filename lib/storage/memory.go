@@ -0,0 +1,1289 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package storage
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ----------------------------------------------------------------------
+// Define Memory Store Type
+// ----------------------------------------------------------------------
+// The memory store keeps everything in maps and is lost when the server
+// stops. It is meant for unit tests and for trying the server out.
+
+type MemoryStoreType struct {
+	mutex          sync.RWMutex
+	generation     int64
+	collections    []CollectionType
+	services       []ServiceType
+	lastService    int64
+	content        map[string][]ContentBlockType
+	lastContentId  int64
+	subscriptions  []SubscriptionType
+	deliveryLog    []DeliveryLogType
+	resultSets     map[string]ResultSetType
+	resultSetParts map[string][][]ContentBlockType
+	indicators     map[string][]IndicatorType
+	lastIndicator  int64
+	feeds          []FeedType
+	feedIndicators map[string]int64 // Indicator that holds the values of each feed
+	quarantine     []QuarantineType
+	allowlist      []AllowlistEntryType
+	lastAllowlist  int64
+	identities     []IdentityType
+	lastIdentity   int64
+	users          []UserType
+	lastUser       int64
+	apiTokens      []ApiTokenType
+	lastApiToken   int64
+	groups         []GroupType
+	lastGroup      int64
+	grants         []GrantType
+	lastGrant      int64
+	taxii2Status   map[string]string
+}
+
+// --------------------------------------------------
+// Create a Memory Store
+// --------------------------------------------------
+
+func NewMemoryStore() *MemoryStoreType {
+	var obj MemoryStoreType
+	obj.content = make(map[string][]ContentBlockType)
+	obj.resultSets = make(map[string]ResultSetType)
+	obj.resultSetParts = make(map[string][][]ContentBlockType)
+	obj.indicators = make(map[string][]IndicatorType)
+	obj.feedIndicators = make(map[string]int64)
+	obj.taxii2Status = make(map[string]string)
+	return &obj
+}
+
+// ----------------------------------------------------------------------
+// Schema
+// ----------------------------------------------------------------------
+// There is no schema to migrate, so the memory store is always up to date.
+
+func (this *MemoryStoreType) GetSchemaVersion() (int, error) {
+	return LatestSchemaVersion(), nil
+}
+
+func (this *MemoryStoreType) Migrate() ([]MigrationType, error) {
+	return nil, nil
+}
+
+// ----------------------------------------------------------------------
+// Catalog Generation
+// ----------------------------------------------------------------------
+
+func (this *MemoryStoreType) GetCatalogGeneration() (int64, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	return this.generation, nil
+}
+
+// ----------------------------------------------------------------------
+// Collections
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Get all Collections
+// --------------------------------------------------
+// Collections that do not set their own service addresses get the address of
+// the service, the same as the SQL stores.
+
+func (this *MemoryStoreType) GetCollections() (map[string]CollectionType, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	c := make(map[string]CollectionType)
+	for _, value := range this.collections {
+		collection := value
+		collection.Volume = len(this.content[collection.Name])
+		collection.ContentBindings = nil
+
+		for _, block := range this.content[collection.Name] {
+			if block.ContentBinding != "" && !containsString(collection.ContentBindings, block.ContentBinding) {
+				collection.ContentBindings = append(collection.ContentBindings, block.ContentBinding)
+			}
+		}
+		sort.Strings(collection.ContentBindings)
+
+		if collection.PollAddress == "" {
+			collection.PollAddress = this.serviceAddress("Poll")
+		}
+		if collection.InboxAddress == "" {
+			collection.InboxAddress = this.serviceAddress("Inbox")
+		}
+		if collection.SubscriptionAddress == "" {
+			collection.SubscriptionAddress = this.serviceAddress("Subscription")
+		}
+		c[collection.Name] = collection
+	}
+	return c, nil
+}
+
+func (this *MemoryStoreType) serviceAddress(serviceType string) string {
+	for _, value := range this.services {
+		if value.ServiceType == serviceType {
+			return value.Address
+		}
+	}
+	return ""
+}
+
+// --------------------------------------------------
+// Add a Collection
+// --------------------------------------------------
+
+func (this *MemoryStoreType) AddCollection(collection CollectionType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.findCollection(collection.Name) >= 0 {
+		return errors.New("collection " + collection.Name + " already exists")
+	}
+	this.collections = append(this.collections, collection)
+	this.generation++
+	return nil
+}
+
+// --------------------------------------------------
+// Change a Collection
+// --------------------------------------------------
+
+func (this *MemoryStoreType) SetCollection(collection CollectionType) (bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	i := this.findCollection(collection.Name)
+	if i < 0 {
+		return false, nil
+	}
+	this.collections[i] = collection
+	this.generation++
+	return true, nil
+}
+
+// --------------------------------------------------
+// Delete a Collection
+// --------------------------------------------------
+// Everything kept under the name of the collection goes with it, so none of
+// it turns up in a new collection with the same name.
+
+func (this *MemoryStoreType) DeleteCollection(collectionName string) (bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	i := this.findCollection(collectionName)
+	if i < 0 {
+		return false, nil
+	}
+	this.collections = append(this.collections[:i], this.collections[i+1:]...)
+
+	delete(this.content, collectionName)
+	delete(this.indicators, collectionName)
+	delete(this.feedIndicators, collectionName)
+
+	subscriptions := make(map[string]bool)
+	var keptSubscriptions []SubscriptionType
+	for _, value := range this.subscriptions {
+		if value.CollectionName == collectionName {
+			subscriptions[value.SubscriptionId] = true
+			continue
+		}
+		keptSubscriptions = append(keptSubscriptions, value)
+	}
+	this.subscriptions = keptSubscriptions
+
+	var keptLog []DeliveryLogType
+	for _, value := range this.deliveryLog {
+		if !subscriptions[value.SubscriptionId] {
+			keptLog = append(keptLog, value)
+		}
+	}
+	this.deliveryLog = keptLog
+
+	for id, value := range this.resultSets {
+		if value.CollectionName == collectionName {
+			delete(this.resultSets, id)
+			delete(this.resultSetParts, id)
+		}
+	}
+
+	var keptFeeds []FeedType
+	for _, value := range this.feeds {
+		if value.CollectionName != collectionName {
+			keptFeeds = append(keptFeeds, value)
+		}
+	}
+	this.feeds = keptFeeds
+
+	var keptQuarantine []QuarantineType
+	for _, value := range this.quarantine {
+		if value.CollectionName != collectionName {
+			keptQuarantine = append(keptQuarantine, value)
+		}
+	}
+	this.quarantine = keptQuarantine
+
+	// Entries without a collection name are for every collection
+	var keptAllowlist []AllowlistEntryType
+	for _, value := range this.allowlist {
+		if value.CollectionName != collectionName {
+			keptAllowlist = append(keptAllowlist, value)
+		}
+	}
+	this.allowlist = keptAllowlist
+
+	this.grants = removeGrants(this.grants, func(grant GrantType) bool { return grant.CollectionName == collectionName })
+	this.generation++
+	return true, nil
+}
+
+func (this *MemoryStoreType) findCollection(collectionName string) int {
+	for i, value := range this.collections {
+		if value.Name == collectionName {
+			return i
+		}
+	}
+	return -1
+}
+
+// ----------------------------------------------------------------------
+// Services
+// ----------------------------------------------------------------------
+
+func (this *MemoryStoreType) GetServices() ([]ServiceType, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	services := make([]ServiceType, len(this.services))
+	copy(services, this.services)
+	return services, nil
+}
+
+func (this *MemoryStoreType) AddService(service ServiceType) (int64, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !ValidServiceType(service.ServiceType) {
+		return 0, errors.New("unknown service type " + service.ServiceType)
+	}
+
+	this.lastService++
+	service.Id = this.lastService
+	this.services = append(this.services, service)
+	this.generation++
+	return service.Id, nil
+}
+
+func (this *MemoryStoreType) SetService(service ServiceType) (bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !ValidServiceType(service.ServiceType) {
+		return false, errors.New("unknown service type " + service.ServiceType)
+	}
+
+	i := this.findService(service.Id)
+	if i < 0 {
+		return false, nil
+	}
+	this.services[i] = service
+	this.generation++
+	return true, nil
+}
+
+func (this *MemoryStoreType) DeleteService(id int64) (bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	i := this.findService(id)
+	if i < 0 {
+		return false, nil
+	}
+	this.services = append(this.services[:i], this.services[i+1:]...)
+	this.generation++
+	return true, nil
+}
+
+func (this *MemoryStoreType) findService(id int64) int {
+	for i, value := range this.services {
+		if value.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ----------------------------------------------------------------------
+// Content
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Add a Content Block to a Collection
+// --------------------------------------------------
+
+func (this *MemoryStoreType) AddContentBlock(collectionName string, block ContentBlockType) error {
+	return this.AddContentBlocks([]string{collectionName}, []ContentBlockType{block})
+}
+
+// --------------------------------------------------
+// Add Content Blocks to several Collections
+// --------------------------------------------------
+// All of the collections are checked before any content is added, so either
+// all of them get the content or none of them do.
+
+func (this *MemoryStoreType) AddContentBlocks(collectionNames []string, blocks []ContentBlockType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for _, collectionName := range collectionNames {
+		if this.findCollection(collectionName) < 0 {
+			return errors.New("collection " + collectionName + " was not found")
+		}
+	}
+
+	// Content is added under the lock, so it is committed in ID order
+	for _, collectionName := range collectionNames {
+		for _, block := range blocks {
+			this.lastContentId++
+			block.Id = this.lastContentId
+			block.Sequence = this.lastContentId
+			this.content[collectionName] = append(this.content[collectionName], block)
+		}
+	}
+	return nil
+}
+
+// --------------------------------------------------
+// Get the Content Blocks committed after a given Sequence
+// --------------------------------------------------
+
+func (this *MemoryStoreType) GetContentBlocksAfter(collectionName string, afterSequence int64) ([]ContentBlockType, error) {
+	blocks := this.filterContentBlocks(collectionName, func(block ContentBlockType) bool {
+		return block.Sequence > afterSequence
+	})
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].Sequence < blocks[j].Sequence
+	})
+	return blocks, nil
+}
+
+// --------------------------------------------------
+// Get the Content Blocks in a Timestamp Window
+// --------------------------------------------------
+
+func (this *MemoryStoreType) GetContentBlocksInWindow(collectionName, begin, end string) ([]ContentBlockType, error) {
+	return this.filterContentBlocks(collectionName, func(block ContentBlockType) bool {
+		return (begin == "" || block.TimestampLabel > begin) && block.TimestampLabel <= end
+	}), nil
+}
+
+// --------------------------------------------------
+// Get the Content Blocks with a Content Binding
+// --------------------------------------------------
+
+func (this *MemoryStoreType) GetContentBlocksByBinding(collectionName, binding, after string) ([]ContentBlockType, error) {
+	return this.filterContentBlocks(collectionName, func(block ContentBlockType) bool {
+		return block.ContentBinding == binding && block.TimestampLabel > after
+	}), nil
+}
+
+// The blocks are returned in the same order as the SQL stores, by timestamp
+// label and then by ID
+func (this *MemoryStoreType) filterContentBlocks(collectionName string, match func(ContentBlockType) bool) []ContentBlockType {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	var blocks []ContentBlockType
+	for _, value := range this.content[collectionName] {
+		if match(value) {
+			blocks = append(blocks, value)
+		}
+	}
+
+	sort.SliceStable(blocks, func(i, j int) bool {
+		if blocks[i].TimestampLabel != blocks[j].TimestampLabel {
+			return blocks[i].TimestampLabel < blocks[j].TimestampLabel
+		}
+		return blocks[i].Id < blocks[j].Id
+	})
+	return blocks
+}
+
+// ----------------------------------------------------------------------
+// Subscriptions
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Add a Subscription
+// --------------------------------------------------
+
+func (this *MemoryStoreType) AddSubscription(sub SubscriptionType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.findCollection(sub.CollectionName) < 0 {
+		return errors.New("collection " + sub.CollectionName + " was not found")
+	}
+	if this.findSubscription(sub.SubscriptionId) >= 0 {
+		return errors.New("subscription " + sub.SubscriptionId + " already exists")
+	}
+
+	sub.DeliveryCursor = 0
+	for _, value := range this.content[sub.CollectionName] {
+		if value.Sequence > sub.DeliveryCursor {
+			sub.DeliveryCursor = value.Sequence
+		}
+	}
+	this.subscriptions = append(this.subscriptions, sub)
+	return nil
+}
+
+// --------------------------------------------------
+// Get a single Subscription by its ID
+// --------------------------------------------------
+
+func (this *MemoryStoreType) GetSubscription(subscriptionId string) (SubscriptionType, bool, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	i := this.findSubscription(subscriptionId)
+	if i < 0 {
+		return SubscriptionType{}, false, nil
+	}
+	return this.subscriptions[i], true, nil
+}
+
+// --------------------------------------------------
+// Get all Subscriptions for a Collection
+// --------------------------------------------------
+
+func (this *MemoryStoreType) GetSubscriptions(collectionName string) ([]SubscriptionType, error) {
+	return this.filterSubscriptions(func(sub SubscriptionType) bool {
+		return sub.CollectionName == collectionName
+	}), nil
+}
+
+// --------------------------------------------------
+// Get all Subscriptions that want content pushed to them
+// --------------------------------------------------
+
+func (this *MemoryStoreType) GetPushSubscriptions() ([]SubscriptionType, error) {
+	return this.filterSubscriptions(func(sub SubscriptionType) bool {
+		return sub.Status == SUBSCRIPTION_STATUS_ACTIVE && sub.InboxAddress != ""
+	}), nil
+}
+
+// --------------------------------------------------
+// Change the Status of a Subscription
+// --------------------------------------------------
+
+func (this *MemoryStoreType) SetSubscriptionStatus(subscriptionId, status string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if i := this.findSubscription(subscriptionId); i >= 0 {
+		this.subscriptions[i].Status = status
+	}
+	return nil
+}
+
+// --------------------------------------------------
+// Move the Delivery Cursor of a Subscription
+// --------------------------------------------------
+
+func (this *MemoryStoreType) SetDeliveryCursor(subscriptionId string, cursor int64) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if i := this.findSubscription(subscriptionId); i >= 0 {
+		this.subscriptions[i].DeliveryCursor = cursor
+	}
+	return nil
+}
+
+// --------------------------------------------------
+// Record a Delivery Attempt in the Delivery Log
+// --------------------------------------------------
+
+func (this *MemoryStoreType) AddDeliveryLog(entry DeliveryLogType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.deliveryLog = append(this.deliveryLog, entry)
+	return nil
+}
+
+func (this *MemoryStoreType) findSubscription(subscriptionId string) int {
+	for i, value := range this.subscriptions {
+		if value.SubscriptionId == subscriptionId {
+			return i
+		}
+	}
+	return -1
+}
+
+// Subscriptions to collections that have been deleted are left out, the same
+// as the join in the SQL stores
+func (this *MemoryStoreType) filterSubscriptions(match func(SubscriptionType) bool) []SubscriptionType {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	var subs []SubscriptionType
+	for _, value := range this.subscriptions {
+		if this.findCollection(value.CollectionName) >= 0 && match(value) {
+			subs = append(subs, value)
+		}
+	}
+	return subs
+}
+
+// ----------------------------------------------------------------------
+// Result Sets
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Add a Result Set and all of its Parts
+// --------------------------------------------------
+
+func (this *MemoryStoreType) AddResultSet(rs ResultSetType, parts [][]ContentBlockType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.findCollection(rs.CollectionName) < 0 {
+		return errors.New("collection " + rs.CollectionName + " was not found")
+	}
+	if _, ok := this.resultSets[rs.ResultId]; ok {
+		return errors.New("result set " + rs.ResultId + " already exists")
+	}
+
+	rs.Parts = len(parts)
+	this.resultSets[rs.ResultId] = rs
+	this.resultSetParts[rs.ResultId] = parts
+	return nil
+}
+
+// --------------------------------------------------
+// Finish a Pending Result Set
+// --------------------------------------------------
+
+func (this *MemoryStoreType) CompleteResultSet(resultId string, parts [][]ContentBlockType, status string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.resultSetParts[resultId] = append(this.resultSetParts[resultId], parts...)
+
+	if rs, ok := this.resultSets[resultId]; ok {
+		rs.Parts = len(parts)
+		rs.Status = status
+		this.resultSets[resultId] = rs
+	}
+	return nil
+}
+
+// --------------------------------------------------
+// Get a Result Set by its ID
+// --------------------------------------------------
+
+func (this *MemoryStoreType) GetResultSet(resultId, now string) (ResultSetType, bool, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	rs, ok := this.resultSets[resultId]
+	if !ok || rs.Expires <= now || this.findCollection(rs.CollectionName) < 0 {
+		return ResultSetType{}, false, nil
+	}
+	return rs, true, nil
+}
+
+// --------------------------------------------------
+// Get the Content Blocks for one Part of a Result Set
+// --------------------------------------------------
+
+func (this *MemoryStoreType) GetResultSetPart(resultId string, part int) ([]ContentBlockType, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	parts := this.resultSetParts[resultId]
+	if part < 1 || part > len(parts) {
+		return nil, nil
+	}
+	return parts[part-1], nil
+}
+
+// --------------------------------------------------
+// Remove Expired Result Sets
+// --------------------------------------------------
+
+func (this *MemoryStoreType) DeleteExpiredResultSets(now string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for resultId, rs := range this.resultSets {
+		if rs.Expires <= now {
+			delete(this.resultSets, resultId)
+			delete(this.resultSetParts, resultId)
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------
+// Indicators
+// ----------------------------------------------------------------------
+
+func (this *MemoryStoreType) GetIndicators(collectionName string) ([]IndicatorType, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if this.findCollection(collectionName) < 0 {
+		return nil, nil
+	}
+
+	var indicators []IndicatorType
+	for _, value := range this.indicators[collectionName] {
+		indicator := value
+		indicator.Observables = make([]ObservableType, len(value.Observables))
+		copy(indicator.Observables, value.Observables)
+		indicators = append(indicators, indicator)
+	}
+	return indicators, nil
+}
+
+func (this *MemoryStoreType) HasIndicators(collectionName string) (bool, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	return this.findCollection(collectionName) >= 0 && len(this.indicators[collectionName]) > 0, nil
+}
+
+// The ID of the indicator is assigned by the store
+func (this *MemoryStoreType) AddIndicator(collectionName string, indicator IndicatorType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.findCollection(collectionName) < 0 {
+		return errors.New("collection " + collectionName + " was not found")
+	}
+
+	this.lastIndicator++
+	indicator.Id = this.lastIndicator
+	this.indicators[collectionName] = append(this.indicators[collectionName], indicator)
+	return nil
+}
+
+// ----------------------------------------------------------------------
+// Feeds
+// ----------------------------------------------------------------------
+
+func (this *MemoryStoreType) GetFeeds() ([]FeedType, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	var feeds []FeedType
+	for _, value := range this.feeds {
+		if this.findCollection(value.CollectionName) >= 0 {
+			feeds = append(feeds, value)
+		}
+	}
+	return feeds, nil
+}
+
+func (this *MemoryStoreType) AddFeed(feed FeedType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.findCollection(feed.CollectionName) < 0 {
+		return errors.New("collection " + feed.CollectionName + " does not exist")
+	}
+	if this.findFeed(feed.CollectionName) >= 0 {
+		return errors.New("collection " + feed.CollectionName + " already has a feed")
+	}
+
+	this.feeds = append(this.feeds, FeedType{CollectionName: feed.CollectionName, Address: feed.Address, Interval: feed.Interval,
+		Parser: feed.Parser, ParserOptions: feed.ParserOptions})
+	return nil
+}
+
+func (this *MemoryStoreType) SetFeedState(feed FeedType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	i := this.findFeed(feed.CollectionName)
+	if i < 0 {
+		return nil
+	}
+	this.feeds[i].ETag = feed.ETag
+	this.feeds[i].LastModified = feed.LastModified
+	this.feeds[i].LastFetched = feed.LastFetched
+	this.feeds[i].LastSuccess = feed.LastSuccess
+	this.feeds[i].LastError = feed.LastError
+	this.feeds[i].Suppressed = feed.Suppressed
+	return nil
+}
+
+func (this *MemoryStoreType) SetFeedObservables(collectionName string, observables []ObservableType, modified string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.findCollection(collectionName) < 0 {
+		return errors.New("collection " + collectionName + " does not exist")
+	}
+	if this.findFeed(collectionName) < 0 {
+		return errors.New("collection " + collectionName + " does not have a feed")
+	}
+
+	indicators := this.indicators[collectionName]
+	i := -1
+	for j, value := range indicators {
+		if value.Id == this.feedIndicators[collectionName] {
+			i = j
+			break
+		}
+	}
+
+	if i < 0 {
+		this.lastIndicator++
+		indicators = append(indicators, IndicatorType{Id: this.lastIndicator, Title: collectionName, Created: modified})
+		this.indicators[collectionName] = indicators
+		this.feedIndicators[collectionName] = this.lastIndicator
+		i = len(indicators) - 1
+	}
+
+	indicators[i].Observables = make([]ObservableType, len(observables))
+	copy(indicators[i].Observables, observables)
+	indicators[i].Modified = modified
+	return nil
+}
+
+func (this *MemoryStoreType) findFeed(collectionName string) int {
+	for i, value := range this.feeds {
+		if value.CollectionName == collectionName {
+			return i
+		}
+	}
+	return -1
+}
+
+// ----------------------------------------------------------------------
+// Quarantine
+// ----------------------------------------------------------------------
+
+func (this *MemoryStoreType) GetQuarantine(collectionName string) ([]QuarantineType, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	var entries []QuarantineType
+	for _, value := range this.quarantine {
+		if value.CollectionName == collectionName {
+			entries = append(entries, value)
+		}
+	}
+	return entries, nil
+}
+
+func (this *MemoryStoreType) SetQuarantine(collectionName, source string, entries []QuarantineType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.findCollection(collectionName) < 0 {
+		return errors.New("collection " + collectionName + " does not exist")
+	}
+
+	var kept []QuarantineType
+	for _, value := range this.quarantine {
+		if value.CollectionName != collectionName || value.Source != source {
+			kept = append(kept, value)
+		}
+	}
+	for _, value := range entries {
+		value.CollectionName = collectionName
+		value.Source = source
+		kept = append(kept, value)
+	}
+	this.quarantine = kept
+	return nil
+}
+
+// ----------------------------------------------------------------------
+// Allowlist
+// ----------------------------------------------------------------------
+
+func (this *MemoryStoreType) GetAllowlist(collectionName string) ([]AllowlistEntryType, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	var entries []AllowlistEntryType
+	for _, value := range this.allowlist {
+		if value.CollectionName == "" || value.CollectionName == collectionName {
+			entries = append(entries, value)
+		}
+	}
+	return entries, nil
+}
+
+func (this *MemoryStoreType) AddAllowlistEntry(entry AllowlistEntryType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if entry.CollectionName != "" && this.findCollection(entry.CollectionName) < 0 {
+		return errors.New("collection " + entry.CollectionName + " does not exist")
+	}
+
+	this.lastAllowlist++
+	entry.Id = this.lastAllowlist
+	this.allowlist = append(this.allowlist, entry)
+	this.generation++
+	return nil
+}
+
+func (this *MemoryStoreType) DeleteAllowlistEntry(id int64) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for i, value := range this.allowlist {
+		if value.Id == id {
+			this.allowlist = append(this.allowlist[:i], this.allowlist[i+1:]...)
+			this.generation++
+			return nil
+		}
+	}
+	return errors.New("allowlist entry does not exist")
+}
+
+// ----------------------------------------------------------------------
+// Identities
+// ----------------------------------------------------------------------
+
+func (this *MemoryStoreType) GetIdentities() ([]IdentityType, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	identities := append([]IdentityType(nil), this.identities...)
+	sort.Slice(identities, func(i, j int) bool { return identities[i].Name < identities[j].Name })
+	return identities, nil
+}
+
+func (this *MemoryStoreType) GetIdentityByCertificate(subject string, names []string) (IdentityType, bool, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	for _, value := range this.identities {
+		if value.Subject != "" && value.Subject == subject {
+			return value, true, nil
+		}
+	}
+	for _, value := range this.identities {
+		if value.San == "" {
+			continue
+		}
+		for _, name := range names {
+			if value.San == name {
+				return value, true, nil
+			}
+		}
+	}
+	return IdentityType{}, false, nil
+}
+
+func (this *MemoryStoreType) AddIdentity(identity IdentityType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for _, value := range this.identities {
+		if value.Name == identity.Name {
+			return errors.New("identity " + identity.Name + " already exists")
+		}
+	}
+
+	this.lastIdentity++
+	identity.Id = this.lastIdentity
+	this.identities = append(this.identities, identity)
+	return nil
+}
+
+func (this *MemoryStoreType) DeleteIdentity(name string) (bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for i, value := range this.identities {
+		if value.Name == name {
+			this.identities = append(this.identities[:i], this.identities[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ----------------------------------------------------------------------
+// Users and API Tokens
+// ----------------------------------------------------------------------
+
+func (this *MemoryStoreType) GetUsers() ([]UserType, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	users := append([]UserType(nil), this.users...)
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func (this *MemoryStoreType) GetUser(username string) (UserType, bool, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	i := this.findUser(username)
+	if i < 0 {
+		return UserType{}, false, nil
+	}
+	return this.users[i], true, nil
+}
+
+func (this *MemoryStoreType) GetUserByToken(tokenHash string) (UserType, bool, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	for _, token := range this.apiTokens {
+		if token.TokenHash == tokenHash && !token.Revoked {
+			i := this.findUser(token.Username)
+			if i < 0 {
+				break
+			}
+			return this.users[i], true, nil
+		}
+	}
+	return UserType{}, false, nil
+}
+
+func (this *MemoryStoreType) AddUser(user UserType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.findUser(user.Username) >= 0 {
+		return errors.New("user " + user.Username + " already exists")
+	}
+
+	this.lastUser++
+	user.Id = this.lastUser
+	this.users = append(this.users, user)
+	return nil
+}
+
+func (this *MemoryStoreType) SetUserDisabled(username string, disabled bool) (bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	i := this.findUser(username)
+	if i < 0 {
+		return false, nil
+	}
+	this.users[i].Disabled = disabled
+	return true, nil
+}
+
+func (this *MemoryStoreType) SetUserAdmin(username string, admin bool) (bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	i := this.findUser(username)
+	if i < 0 {
+		return false, nil
+	}
+	this.users[i].Admin = admin
+	return true, nil
+}
+
+func (this *MemoryStoreType) GetApiTokens(username string) ([]ApiTokenType, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	var tokens []ApiTokenType
+	for _, value := range this.apiTokens {
+		if username == "" || value.Username == username {
+			tokens = append(tokens, value)
+		}
+	}
+	return tokens, nil
+}
+
+func (this *MemoryStoreType) AddApiToken(token ApiTokenType) (int64, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.findUser(token.Username) < 0 {
+		return 0, errors.New("user " + token.Username + " does not exist")
+	}
+	for _, value := range this.apiTokens {
+		if value.TokenHash == token.TokenHash {
+			return 0, errors.New("the token already exists")
+		}
+	}
+
+	this.lastApiToken++
+	token.Id = this.lastApiToken
+	token.Revoked = false
+	this.apiTokens = append(this.apiTokens, token)
+	return token.Id, nil
+}
+
+func (this *MemoryStoreType) RevokeApiToken(id int64) (bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for i, value := range this.apiTokens {
+		if value.Id == id {
+			this.apiTokens[i].Revoked = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (this *MemoryStoreType) findUser(username string) int {
+	for i, value := range this.users {
+		if value.Username == username {
+			return i
+		}
+	}
+	return -1
+}
+
+// ----------------------------------------------------------------------
+// Groups and Grants
+// ----------------------------------------------------------------------
+
+func (this *MemoryStoreType) GetGroups() ([]GroupType, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	groups := make([]GroupType, 0, len(this.groups))
+	for _, value := range this.groups {
+		group := value
+		group.Members = append([]PrincipalType(nil), value.Members...)
+		sort.Slice(group.Members, func(i, j int) bool {
+			if group.Members[i].Type != group.Members[j].Type {
+				return group.Members[i].Type < group.Members[j].Type
+			}
+			return group.Members[i].Name < group.Members[j].Name
+		})
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+func (this *MemoryStoreType) AddGroup(group GroupType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.findGroup(group.Name) >= 0 {
+		return errors.New("group " + group.Name + " already exists")
+	}
+
+	this.lastGroup++
+	group.Id = this.lastGroup
+	group.Members = append([]PrincipalType(nil), group.Members...)
+	this.groups = append(this.groups, group)
+	return nil
+}
+
+func (this *MemoryStoreType) DeleteGroup(name string) (bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	i := this.findGroup(name)
+	if i < 0 {
+		return false, nil
+	}
+	this.groups = append(this.groups[:i], this.groups[i+1:]...)
+	this.grants = removeGrants(this.grants, func(grant GrantType) bool {
+		return grant.GranteeType == PRINCIPAL_TYPE_GROUP && grant.Grantee == name
+	})
+	return true, nil
+}
+
+func (this *MemoryStoreType) AddGroupMember(groupName string, member PrincipalType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	i := this.findGroup(groupName)
+	if i < 0 {
+		return errors.New("group " + groupName + " does not exist")
+	}
+	for _, value := range this.groups[i].Members {
+		if value == member {
+			return errors.New(member.Type + " " + member.Name + " is already in group " + groupName)
+		}
+	}
+	this.groups[i].Members = append(this.groups[i].Members, member)
+	return nil
+}
+
+func (this *MemoryStoreType) DeleteGroupMember(groupName string, member PrincipalType) (bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	i := this.findGroup(groupName)
+	if i < 0 {
+		return false, nil
+	}
+	for j, value := range this.groups[i].Members {
+		if value == member {
+			this.groups[i].Members = append(this.groups[i].Members[:j], this.groups[i].Members[j+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (this *MemoryStoreType) GetGrants(collectionName string) ([]GrantType, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	var grants []GrantType
+	for _, value := range this.grants {
+		if collectionName == "" || value.CollectionName == collectionName {
+			grants = append(grants, value)
+		}
+	}
+	sortGrants(grants)
+	return grants, nil
+}
+
+func (this *MemoryStoreType) GetPrincipalGrants(principal PrincipalType) ([]GrantType, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	inGroup := make(map[string]bool)
+	for _, group := range this.groups {
+		for _, member := range group.Members {
+			if member == principal {
+				inGroup[group.Name] = true
+			}
+		}
+	}
+
+	var grants []GrantType
+	for _, value := range this.grants {
+		if (value.GranteeType == principal.Type && value.Grantee == principal.Name) ||
+			(value.GranteeType == PRINCIPAL_TYPE_GROUP && inGroup[value.Grantee]) {
+			grants = append(grants, value)
+		}
+	}
+	sortGrants(grants)
+	return grants, nil
+}
+
+func (this *MemoryStoreType) AddGrant(grant GrantType) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.findCollection(grant.CollectionName) < 0 {
+		return errors.New("collection " + grant.CollectionName + " does not exist")
+	}
+	for _, value := range this.grants {
+		if value.CollectionName == grant.CollectionName && value.GranteeType == grant.GranteeType &&
+			value.Grantee == grant.Grantee && value.Permission == grant.Permission {
+			return errors.New("the grant already exists")
+		}
+	}
+
+	this.lastGrant++
+	grant.Id = this.lastGrant
+	this.grants = append(this.grants, grant)
+	return nil
+}
+
+func (this *MemoryStoreType) DeleteGrant(id int64) (bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	before := len(this.grants)
+	this.grants = removeGrants(this.grants, func(grant GrantType) bool { return grant.Id == id })
+	return len(this.grants) < before, nil
+}
+
+func (this *MemoryStoreType) findGroup(name string) int {
+	for i, value := range this.groups {
+		if value.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// The grants that do not match, in a new slice
+func removeGrants(grants []GrantType, match func(GrantType) bool) []GrantType {
+	var kept []GrantType
+	for _, value := range grants {
+		if !match(value) {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+// Grants are listed by collection and then in the order they were added, the
+// same as the SQL stores
+func sortGrants(grants []GrantType) {
+	sort.SliceStable(grants, func(i, j int) bool {
+		if grants[i].CollectionName != grants[j].CollectionName {
+			return grants[i].CollectionName < grants[j].CollectionName
+		}
+		return grants[i].Id < grants[j].Id
+	})
+}
+
+// ----------------------------------------------------------------------
+// TAXII 2.1 Status Resources
+// ----------------------------------------------------------------------
+
+func (this *MemoryStoreType) AddTaxii2Status(statusId, created, resource string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if _, ok := this.taxii2Status[statusId]; ok {
+		return errors.New("status " + statusId + " already exists")
+	}
+	this.taxii2Status[statusId] = resource
+	return nil
+}
+
+func (this *MemoryStoreType) GetTaxii2Status(statusId string) (string, bool, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	resource, ok := this.taxii2Status[statusId]
+	return resource, ok, nil
+}
+
+// --------------------------------------------------
+// Close the Store
+// --------------------------------------------------
+
+func (this *MemoryStoreType) Close() error {
+	return nil
+}
+
+// --------------------------------------------------
+// Check if a String is in a List
+// --------------------------------------------------
+
+func containsString(list []string, s string) bool {
+	for _, value := range list {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
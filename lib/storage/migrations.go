@@ -0,0 +1,407 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package storage
+
+// ----------------------------------------------------------------------
+// Define Migration Type
+// ----------------------------------------------------------------------
+// Migrations are numbered from 1 and are applied in order, each one in its
+// own transaction. The version of a database is the highest migration that
+// has been applied to it, it is kept in the SchemaVersion table. Once a
+// migration has been released it must never be changed, add a new one
+// instead.
+//
+// The statements are written once for every SQL backend. %ID% and %BIGID%
+// are replaced with the primary key column type of the backend.
+//
+// Tables that may already exist in a database that was built by hand are
+// created with IF NOT EXISTS, which leaves an older table as it is. The
+// columns of a migration are added to their table only if it does not
+// have them yet, before the statements are run.
+
+type MigrationType struct {
+	Version     int
+	Description string
+	Columns     []MigrationColumnType
+	Statements  []string
+}
+
+type MigrationColumnType struct {
+	Table      string
+	Column     string
+	Definition string
+}
+
+// Applied times are stored in the same format as every other timestamp
+const (
+	TIMESTAMP_FORMAT = "2006-01-02T15:04:05.000000Z"
+)
+
+var migrations = []MigrationType{
+	{
+		Version:     1,
+		Description: "Create the initial schema",
+		Statements: []string{
+			// Databases that were built by hand before there were
+			// migrations already have these tables, so they are only
+			// created if they are missing
+			`CREATE TABLE IF NOT EXISTS Catalog (
+				id integer PRIMARY KEY,
+				generation bigint NOT NULL DEFAULT 0
+			)`,
+			`INSERT INTO Catalog (id, generation) SELECT 1, 0 WHERE NOT EXISTS (SELECT 1 FROM Catalog WHERE id = 1)`,
+
+			`CREATE TABLE IF NOT EXISTS ServiceType (
+				id integer PRIMARY KEY,
+				type text NOT NULL
+			)`,
+			`INSERT INTO ServiceType (id, type) SELECT 1, 'Discovery' WHERE NOT EXISTS (SELECT 1 FROM ServiceType WHERE id = 1)`,
+			`INSERT INTO ServiceType (id, type) SELECT 2, 'Collection' WHERE NOT EXISTS (SELECT 1 FROM ServiceType WHERE id = 2)`,
+			`INSERT INTO ServiceType (id, type) SELECT 3, 'Subscription' WHERE NOT EXISTS (SELECT 1 FROM ServiceType WHERE id = 3)`,
+			`INSERT INTO ServiceType (id, type) SELECT 4, 'Inbox' WHERE NOT EXISTS (SELECT 1 FROM ServiceType WHERE id = 4)`,
+			`INSERT INTO ServiceType (id, type) SELECT 5, 'Poll' WHERE NOT EXISTS (SELECT 1 FROM ServiceType WHERE id = 5)`,
+
+			`CREATE TABLE IF NOT EXISTS Services (
+				id %ID%,
+				typeid integer NOT NULL,
+				available integer NOT NULL,
+				address text NOT NULL
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS Collections (
+				id %ID%,
+				collection text,
+				description text,
+				type text,
+				location text,
+				address text,
+				contentbinding text NOT NULL DEFAULT '',
+				polladdress text NOT NULL DEFAULT '',
+				inboxaddress text NOT NULL DEFAULT '',
+				subscriptionaddress text NOT NULL DEFAULT ''
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS Content (
+				id %BIGID%,
+				collectionid integer NOT NULL,
+				binding text NOT NULL DEFAULT '',
+				encoding text NOT NULL DEFAULT '',
+				content text NOT NULL DEFAULT '',
+				timestamplabel text NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS Content_collection_timestamp ON Content (collectionid, timestamplabel)`,
+
+			`CREATE TABLE IF NOT EXISTS Subscriptions (
+				id %ID%,
+				subscriptionid text NOT NULL UNIQUE,
+				collectionid integer NOT NULL,
+				status text NOT NULL,
+				responsetype text NOT NULL DEFAULT 'FULL',
+				bindings text NOT NULL DEFAULT '',
+				inboxprotocol text NOT NULL DEFAULT '',
+				inboxaddress text NOT NULL DEFAULT '',
+				inboxbinding text NOT NULL DEFAULT '',
+				created text NOT NULL,
+				deliverycursor bigint NOT NULL DEFAULT 0
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS DeliveryLog (
+				id %BIGID%,
+				subscriptionid text NOT NULL,
+				attempted text NOT NULL,
+				firstcontentid bigint NOT NULL,
+				lastcontentid bigint NOT NULL,
+				blocks integer NOT NULL,
+				result text NOT NULL,
+				message text NOT NULL DEFAULT ''
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS ResultSets (
+				id %ID%,
+				resultid text NOT NULL UNIQUE,
+				collectionid integer NOT NULL,
+				begintimestamp text NOT NULL DEFAULT '',
+				endtimestamp text NOT NULL DEFAULT '',
+				parts integer NOT NULL,
+				status text NOT NULL,
+				created text NOT NULL,
+				expires text NOT NULL
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS ResultSetBlocks (
+				id %BIGID%,
+				resultid text NOT NULL,
+				partnumber integer NOT NULL,
+				binding text NOT NULL DEFAULT '',
+				encoding text NOT NULL DEFAULT '',
+				content text NOT NULL DEFAULT '',
+				timestamplabel text NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX IF NOT EXISTS ResultSetBlocks_part ON ResultSetBlocks (resultid, partnumber)`,
+
+			`CREATE TABLE IF NOT EXISTS Taxii2Status (
+				id %ID%,
+				statusid text NOT NULL UNIQUE,
+				created text NOT NULL,
+				resource text NOT NULL
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS Producers (
+				id %ID%,
+				name text NOT NULL,
+				description text NOT NULL DEFAULT '',
+				reference text NOT NULL DEFAULT ''
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS Indicators (
+				id %ID%,
+				collectionid integer NOT NULL,
+				producerid integer,
+				title text NOT NULL DEFAULT '',
+				type text NOT NULL DEFAULT '',
+				indicatortype text NOT NULL DEFAULT '',
+				created text NOT NULL,
+				modified text NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS Indicators_collection ON Indicators (collectionid)`,
+
+			`CREATE TABLE IF NOT EXISTS Observables (
+				id %BIGID%,
+				indicatorid integer NOT NULL,
+				type text NOT NULL,
+				value text NOT NULL,
+				created text NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS Observables_indicator ON Observables (indicatorid)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "Remove the _Collections_old_20150615 table left from a manual migration",
+		Statements: []string{
+			`DROP TABLE IF EXISTS _Collections_old_20150615`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "Add the Feeds table and a feed for every remote collection",
+		Statements: []string{
+			`CREATE TABLE Feeds (
+				id %ID%,
+				collectionid integer NOT NULL UNIQUE,
+				address text NOT NULL,
+				fetchinterval integer NOT NULL DEFAULT 3600,
+				etag text NOT NULL DEFAULT '',
+				lastmodified text NOT NULL DEFAULT '',
+				lastfetched text NOT NULL DEFAULT '',
+				lastsuccess text NOT NULL DEFAULT '',
+				lasterror text NOT NULL DEFAULT ''
+			)`,
+			`INSERT INTO Feeds (collectionid, address)
+				SELECT id, address FROM Collections
+				WHERE location = 'Remote' AND address IS NOT NULL AND address <> ''`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "Add the parser and parser options of a feed",
+		Statements: []string{
+			`ALTER TABLE Feeds ADD COLUMN parser text NOT NULL DEFAULT 'plaintext'`,
+			`ALTER TABLE Feeds ADD COLUMN parseroptions text NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "Add the Quarantine table for values that could not be classified",
+		Statements: []string{
+			`CREATE TABLE Quarantine (
+				id %BIGID%,
+				collectionid integer NOT NULL,
+				source text NOT NULL,
+				value text NOT NULL,
+				reason text NOT NULL,
+				created text NOT NULL
+			)`,
+			`CREATE INDEX Quarantine_collection_source ON Quarantine (collectionid, source)`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "Add the Allowlist table and count suppressed values for each feed",
+		Statements: []string{
+			`CREATE TABLE Allowlist (
+				id %ID%,
+				collectionid integer,
+				type text NOT NULL,
+				value text NOT NULL,
+				comment text NOT NULL DEFAULT '',
+				created text NOT NULL DEFAULT ''
+			)`,
+			`ALTER TABLE Feeds ADD COLUMN suppressed integer NOT NULL DEFAULT 0`,
+			`INSERT INTO Allowlist (type, value, comment) VALUES ('cidr', '10.0.0.0/8', 'RFC 1918 private network')`,
+			`INSERT INTO Allowlist (type, value, comment) VALUES ('cidr', '172.16.0.0/12', 'RFC 1918 private network')`,
+			`INSERT INTO Allowlist (type, value, comment) VALUES ('cidr', '192.168.0.0/16', 'RFC 1918 private network')`,
+			`INSERT INTO Allowlist (type, value, comment) VALUES ('cidr', '127.0.0.0/8', 'Loopback')`,
+			`INSERT INTO Allowlist (type, value, comment) VALUES ('cidr', '169.254.0.0/16', 'Link local')`,
+			`INSERT INTO Allowlist (type, value, comment) VALUES ('cidr', '::1/128', 'Loopback')`,
+			`INSERT INTO Allowlist (type, value, comment) VALUES ('cidr', 'fc00::/7', 'Unique local')`,
+			`INSERT INTO Allowlist (type, value, comment) VALUES ('cidr', 'fe80::/10', 'Link local')`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "Add the Identities table that client certificates are mapped to",
+		Statements: []string{
+			`CREATE TABLE Identities (
+				id %ID%,
+				name text NOT NULL UNIQUE,
+				subject text NOT NULL DEFAULT '',
+				san text NOT NULL DEFAULT '',
+				created text NOT NULL DEFAULT ''
+			)`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "Add the Users and ApiTokens tables for authentication",
+		Statements: []string{
+			`CREATE TABLE Users (
+				id %ID%,
+				username text NOT NULL UNIQUE,
+				passwordhash text NOT NULL DEFAULT '',
+				disabled integer NOT NULL DEFAULT 0,
+				created text NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE ApiTokens (
+				id %ID%,
+				userid integer NOT NULL,
+				tokenhash text NOT NULL UNIQUE,
+				description text NOT NULL DEFAULT '',
+				created text NOT NULL DEFAULT '',
+				revoked integer NOT NULL DEFAULT 0
+			)`,
+		},
+	},
+	{
+		Version:     9,
+		Description: "Add groups and the read and write grants of collections",
+		Statements: []string{
+			`CREATE TABLE UserGroups (
+				id %ID%,
+				name text NOT NULL UNIQUE,
+				created text NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE GroupMembers (
+				groupid integer NOT NULL,
+				membertype text NOT NULL,
+				membername text NOT NULL,
+				UNIQUE (groupid, membertype, membername)
+			)`,
+			`CREATE TABLE CollectionGrants (
+				id %ID%,
+				collectionid integer NOT NULL,
+				granteetype text NOT NULL,
+				grantee text NOT NULL,
+				permission text NOT NULL,
+				created text NOT NULL DEFAULT '',
+				UNIQUE (collectionid, granteetype, grantee, permission)
+			)`,
+		},
+	},
+	{
+		Version:     10,
+		Description: "Add the admin role to users",
+		Statements: []string{
+			`ALTER TABLE Users ADD COLUMN admin integer NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		Version:     11,
+		Description: "Give each feed its own indicator",
+		Statements: []string{
+			`ALTER TABLE Feeds ADD COLUMN indicatorid integer`,
+			// Every indicator of the collection used to get the values of
+			// the feed, the first one keeps them
+			`UPDATE Feeds SET indicatorid = (SELECT MIN(i.id) FROM Indicators AS i WHERE i.collectionid = Feeds.collectionid)`,
+		},
+	},
+	{
+		Version:     12,
+		Description: "Add the owner to subscriptions",
+		Statements: []string{
+			`ALTER TABLE Subscriptions ADD COLUMN ownertype text NOT NULL DEFAULT ''`,
+			`ALTER TABLE Subscriptions ADD COLUMN owner text NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Version:     13,
+		Description: "Remove what deleted collections left behind and stop reusing collection IDs",
+		Statements: []string{
+			// Deleting a collection used to leave its rows behind, where a
+			// new collection that was given the same ID would find them
+			`DELETE FROM Observables WHERE indicatorid IN (SELECT id FROM Indicators WHERE collectionid NOT IN (SELECT id FROM Collections))`,
+			`DELETE FROM Indicators WHERE collectionid NOT IN (SELECT id FROM Collections)`,
+			`DELETE FROM DeliveryLog WHERE subscriptionid IN (SELECT subscriptionid FROM Subscriptions WHERE collectionid NOT IN (SELECT id FROM Collections))`,
+			`DELETE FROM Subscriptions WHERE collectionid NOT IN (SELECT id FROM Collections)`,
+			`DELETE FROM ResultSetBlocks WHERE resultid IN (SELECT resultid FROM ResultSets WHERE collectionid NOT IN (SELECT id FROM Collections))`,
+			`DELETE FROM ResultSets WHERE collectionid NOT IN (SELECT id FROM Collections)`,
+			`DELETE FROM Content WHERE collectionid NOT IN (SELECT id FROM Collections)`,
+			`DELETE FROM Feeds WHERE collectionid NOT IN (SELECT id FROM Collections)`,
+			`DELETE FROM Quarantine WHERE collectionid NOT IN (SELECT id FROM Collections)`,
+			`DELETE FROM Allowlist WHERE collectionid IS NOT NULL AND collectionid NOT IN (SELECT id FROM Collections)`,
+			`DELETE FROM CollectionGrants WHERE collectionid NOT IN (SELECT id FROM Collections)`,
+
+			// The last ID that was handed out, collections are added with
+			// the next one instead of the one after the highest in use
+			`ALTER TABLE Catalog ADD COLUMN lastcollectionid bigint NOT NULL DEFAULT 0`,
+			`UPDATE Catalog SET lastcollectionid = (SELECT COALESCE(MAX(id), 0) FROM Collections)`,
+		},
+	},
+	{
+		Version:     14,
+		Description: "Add the columns that older Collections tables are missing",
+		// The Collections table of a database from before the first
+		// migration was kept by migration 1 without these columns
+		Columns: []MigrationColumnType{
+			{"Collections", "contentbinding", "text NOT NULL DEFAULT ''"},
+			{"Collections", "polladdress", "text NOT NULL DEFAULT ''"},
+			{"Collections", "inboxaddress", "text NOT NULL DEFAULT ''"},
+			{"Collections", "subscriptionaddress", "text NOT NULL DEFAULT ''"},
+		},
+	},
+	{
+		Version:     15,
+		Description: "Number content in the order it is committed",
+		Statements: []string{
+			// Content IDs are handed out when a row is inserted, so a
+			// transaction that commits after another can still have the
+			// lower ID. The sequence is taken from the Catalog row, whose
+			// lock is held until the transaction commits.
+			`ALTER TABLE Content ADD COLUMN sequence bigint NOT NULL DEFAULT 0`,
+			`UPDATE Content SET sequence = id`,
+			`CREATE INDEX Content_collection_sequence ON Content (collectionid, sequence)`,
+			`ALTER TABLE Catalog ADD COLUMN lastcontentsequence bigint NOT NULL DEFAULT 0`,
+			`UPDATE Catalog SET lastcontentsequence = (SELECT COALESCE(MAX(id), 0) FROM Content)`,
+		},
+	},
+}
+
+// --------------------------------------------------
+// Get the Migrations
+// --------------------------------------------------
+
+func Migrations() []MigrationType {
+	return migrations
+}
+
+// --------------------------------------------------
+// Get the Schema Version this Code Needs
+// --------------------------------------------------
+
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
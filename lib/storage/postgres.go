@@ -0,0 +1,33 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package storage
+
+import (
+	_ "github.com/lib/pq"
+)
+
+// --------------------------------------------------
+// Create a PostgreSQL Store
+// --------------------------------------------------
+// The data source is a lib/pq connection string, for example
+// "host=localhost dbname=freetaxii user=freetaxii sslmode=disable". The
+// tables are created with freetaxii-mgmt --db-init.
+
+func NewPostgresStore(connection string) (*SqlStoreType, error) {
+	var obj SqlStoreType
+	obj.Driver = "postgres"
+	obj.DataSource = connection
+	obj.Placeholders = "$"
+	obj.IdColumn = "serial PRIMARY KEY"
+	obj.BigIdColumn = "bigserial PRIMARY KEY"
+
+	err := obj.open()
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
@@ -0,0 +1,2029 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package storage
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------------
+// Define SQL Store Type
+// ----------------------------------------------------------------------
+// The SQL store is shared by the SQLite and PostgreSQL backends. The queries
+// are written with ? placeholders and only use SQL that both databases
+// understand, they are rewritten to $1, $2 ... for drivers that need it. The
+// connection pool is opened once and shared by every request.
+
+type SqlStoreType struct {
+	Driver       string
+	DataSource   string
+	Placeholders string // Either "?" or "$"
+	IdColumn     string // Column type of a primary key, used by the migrations
+	BigIdColumn  string // Column type of a primary key that may pass 2^31
+	db           *sql.DB
+}
+
+// --------------------------------------------------
+// Open the Connection Pool
+// --------------------------------------------------
+// The database is pinged so that a bad file name or connection string is
+// found at startup rather than on the first request.
+
+func (this *SqlStoreType) open() error {
+	db, err := sql.Open(this.Driver, this.DataSource)
+	if err != nil {
+		return fmt.Errorf("unable to open %s database %s, %v", this.Driver, this.DataSource, err)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("unable to connect to %s database %s, %v", this.Driver, this.DataSource, err)
+	}
+
+	this.db = db
+	return nil
+}
+
+// --------------------------------------------------
+// Rewrite the Placeholders in a Query
+// --------------------------------------------------
+// None of the queries have a ? inside of a string literal, so every ? is a
+// placeholder.
+
+func (this *SqlStoreType) rebind(sqlstmt string) string {
+	if this.Placeholders != "$" {
+		return sqlstmt
+	}
+
+	var s bytes.Buffer
+	n := 0
+	for _, c := range sqlstmt {
+		if c == '?' {
+			n++
+			s.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		s.WriteRune(c)
+	}
+	return s.String()
+}
+
+// --------------------------------------------------
+// Look up the ID of a Collection
+// --------------------------------------------------
+// The boolean return value is false if the collection does not exist
+
+func (this *SqlStoreType) collectionId(q querier, collectionName string) (int64, bool, error) {
+	var id int64
+	err := q.QueryRow(this.rebind("SELECT id FROM Collections WHERE collection = ?"), collectionName).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// Both *sql.DB and *sql.Tx can be used to look up a collection
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Both can also be used to insert a row
+type execer interface {
+	querier
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// --------------------------------------------------
+// Insert a Row and return its ID
+// --------------------------------------------------
+// PostgreSQL does not support LastInsertId, it returns the ID of the new row
+// from the insert instead. SQLite gives it back with the result.
+
+func (this *SqlStoreType) insertId(e execer, sqlstmt string, args ...interface{}) (int64, error) {
+	var id int64
+	if this.Driver == BACKEND_POSTGRES {
+		err := e.QueryRow(this.rebind(sqlstmt+" RETURNING id"), args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := e.Exec(this.rebind(sqlstmt), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// ----------------------------------------------------------------------
+// Schema
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Get the Schema Version
+// --------------------------------------------------
+// A database that has never been migrated does not have the SchemaVersion
+// table, it is at version 0.
+
+func (this *SqlStoreType) GetSchemaVersion() (int, error) {
+	var sqlstmt string
+	switch this.Driver {
+	case BACKEND_POSTGRES:
+		sqlstmt = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schemaversion'"
+	default:
+		sqlstmt = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND lower(name) = 'schemaversion'"
+	}
+
+	var tables int
+	err := this.db.QueryRow(sqlstmt).Scan(&tables)
+	if err != nil {
+		return 0, err
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+
+	var version int
+	err = this.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM SchemaVersion").Scan(&version)
+	return version, err
+}
+
+// --------------------------------------------------
+// Apply the Missing Migrations
+// --------------------------------------------------
+// Each migration and the row that records it are written in one transaction,
+// so a migration that fails leaves the database at the version before it.
+
+func (this *SqlStoreType) Migrate() ([]MigrationType, error) {
+	var applied []MigrationType
+
+	sqlstmt := `CREATE TABLE IF NOT EXISTS SchemaVersion (
+				version integer PRIMARY KEY,
+				description text NOT NULL,
+				applied text NOT NULL
+			)`
+	_, err := this.db.Exec(sqlstmt)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := this.GetSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+
+		err = this.applyMigration(migration)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d failed, %v", migration.Version, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+func (this *SqlStoreType) applyMigration(migration MigrationType) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, column := range migration.Columns {
+		exists, err := this.columnExists(tx, column.Table, column.Column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		_, err = tx.Exec("ALTER TABLE " + column.Table + " ADD COLUMN " + column.Column + " " + column.Definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	r := strings.NewReplacer("%BIGID%", this.BigIdColumn, "%ID%", this.IdColumn)
+	for _, sqlstmt := range migration.Statements {
+		_, err = tx.Exec(r.Replace(sqlstmt))
+		if err != nil {
+			return err
+		}
+	}
+
+	applied := time.Now().UTC().Format(TIMESTAMP_FORMAT)
+	_, err = tx.Exec(this.rebind("INSERT INTO SchemaVersion (version, description, applied) VALUES (?, ?, ?)"), migration.Version, migration.Description, applied)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Table and column names are not case sensitive in either backend
+func (this *SqlStoreType) columnExists(q querier, table, column string) (bool, error) {
+	var sqlstmt string
+	switch this.Driver {
+	case BACKEND_POSTGRES:
+		sqlstmt = `SELECT COUNT(*) FROM information_schema.columns
+					WHERE table_schema = current_schema() AND table_name = lower($1) AND column_name = lower($2)`
+	default:
+		sqlstmt = "SELECT COUNT(*) FROM pragma_table_info(?) WHERE lower(name) = lower(?)"
+	}
+
+	var count int
+	err := q.QueryRow(sqlstmt, table, column).Scan(&count)
+	return count > 0, err
+}
+
+// ----------------------------------------------------------------------
+// Catalog Generation
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Get the Catalog Generation
+// --------------------------------------------------
+
+func (this *SqlStoreType) GetCatalogGeneration() (int64, error) {
+	var generation int64
+	err := this.db.QueryRow("SELECT generation FROM Catalog WHERE id = 1").Scan(&generation)
+	return generation, err
+}
+
+// Every change to the collections, the services or the allowlist moves the
+// generation on, inside the same transaction as the change
+func (this *SqlStoreType) bumpCatalogGeneration(tx *sql.Tx) error {
+	_, err := tx.Exec("UPDATE Catalog SET generation = generation + 1 WHERE id = 1")
+	return err
+}
+
+// ----------------------------------------------------------------------
+// Collections
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Get all Collections
+// --------------------------------------------------
+
+func (this *SqlStoreType) GetCollections() (map[string]CollectionType, error) {
+	c := make(map[string]CollectionType)
+
+	// A collection without its own service addresses uses the first
+	// service of each type
+	sqlstmt := `SELECT l.collection, COALESCE(l.description, ''), COALESCE(l.type, ''),
+					COALESCE(l.location, ''), COALESCE(l.address, ''), l.contentbinding,
+					COUNT(c.id),
+					CASE WHEN l.polladdress != '' THEN l.polladdress ELSE
+						COALESCE((SELECT address FROM Services AS s INNER JOIN ServiceType AS t ON s.typeid = t.id WHERE t.type = 'Poll'
+							ORDER BY s.id LIMIT 1), '') END,
+					CASE WHEN l.inboxaddress != '' THEN l.inboxaddress ELSE
+						COALESCE((SELECT address FROM Services AS s INNER JOIN ServiceType AS t ON s.typeid = t.id WHERE t.type = 'Inbox'
+							ORDER BY s.id LIMIT 1), '') END,
+					CASE WHEN l.subscriptionaddress != '' THEN l.subscriptionaddress ELSE
+						COALESCE((SELECT address FROM Services AS s INNER JOIN ServiceType AS t ON s.typeid = t.id WHERE t.type = 'Subscription'
+							ORDER BY s.id LIMIT 1), '') END
+				FROM Collections AS l
+				LEFT JOIN Content AS c
+				ON c.collectionid = l.id
+				GROUP BY l.id`
+	rows, err := this.db.Query(sqlstmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var collection CollectionType
+		err = rows.Scan(&collection.Name, &collection.Description, &collection.Type,
+			&collection.Location, &collection.Address, &collection.ContentBinding,
+			&collection.Volume, &collection.PollAddress, &collection.InboxAddress, &collection.SubscriptionAddress)
+		if err != nil {
+			return nil, err
+		}
+		c[collection.Name] = collection
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Add the content bindings of the content that is stored in each
+	// collection
+	sqlstmt = `SELECT DISTINCT l.collection, c.binding
+				FROM Content AS c
+				INNER JOIN Collections AS l
+				ON c.collectionid = l.id
+				WHERE c.binding != ''
+				ORDER BY l.collection, c.binding`
+	rows, err = this.db.Query(sqlstmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, binding string
+		err = rows.Scan(&name, &binding)
+		if err != nil {
+			return nil, err
+		}
+
+		if collection, ok := c[name]; ok {
+			collection.ContentBindings = append(collection.ContentBindings, binding)
+			c[name] = collection
+		}
+	}
+	return c, rows.Err()
+}
+
+// --------------------------------------------------
+// Add a Collection
+// --------------------------------------------------
+
+func (this *SqlStoreType) AddCollection(collection CollectionType) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// The collection column does not have a unique index, so check first
+	_, exists, err := this.collectionId(tx, collection.Name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if exists {
+		tx.Rollback()
+		return fmt.Errorf("collection %s already exists", collection.Name)
+	}
+
+	id, err := this.nextCollectionId(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	sqlstmt := `INSERT INTO Collections (id, collection, description, type, location, address, contentbinding, polladdress, inboxaddress, subscriptionaddress)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(this.rebind(sqlstmt), id, collection.Name, collection.Description, collection.Type, collection.Location, collection.Address,
+		collection.ContentBinding, collection.PollAddress, collection.InboxAddress, collection.SubscriptionAddress)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = this.bumpCatalogGeneration(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// The ID of a deleted collection is never handed out again. Collections that
+// were added with an ID of their own, like the demo data, are skipped over.
+// The counter is moved first so the transaction holds the write lock before
+// it reads anything.
+
+func (this *SqlStoreType) nextCollectionId(tx *sql.Tx) (int64, error) {
+	_, err := tx.Exec("UPDATE Catalog SET lastcollectionid = lastcollectionid + 1 WHERE id = 1")
+	if err != nil {
+		return 0, err
+	}
+
+	var id, highest int64
+	err = tx.QueryRow("SELECT lastcollectionid FROM Catalog WHERE id = 1").Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.QueryRow("SELECT COALESCE(MAX(id), 0) FROM Collections").Scan(&highest)
+	if err != nil {
+		return 0, err
+	}
+	if id > highest {
+		return id, nil
+	}
+
+	_, err = tx.Exec(this.rebind("UPDATE Catalog SET lastcollectionid = ? WHERE id = 1"), highest+1)
+	return highest + 1, err
+}
+
+// --------------------------------------------------
+// Change a Collection
+// --------------------------------------------------
+// Collections are found by their name, which can not be changed. The boolean
+// return value is false if the collection does not exist.
+
+func (this *SqlStoreType) SetCollection(collection CollectionType) (bool, error) {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	sqlstmt := `UPDATE Collections SET description = ?, type = ?, location = ?, address = ?, contentbinding = ?,
+					polladdress = ?, inboxaddress = ?, subscriptionaddress = ?
+				WHERE collection = ?`
+	result, err := tx.Exec(this.rebind(sqlstmt), collection.Description, collection.Type, collection.Location, collection.Address,
+		collection.ContentBinding, collection.PollAddress, collection.InboxAddress, collection.SubscriptionAddress, collection.Name)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil || rows == 0 {
+		tx.Rollback()
+		return false, err
+	}
+
+	err = this.bumpCatalogGeneration(tx)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// --------------------------------------------------
+// Delete a Collection
+// --------------------------------------------------
+// Everything that belongs to the collection is deleted in the same
+// transaction, so none of it is left behind. Allowlist entries without a
+// collection are for every collection and are kept. The boolean return value
+// is false if the collection did not exist.
+
+var deleteCollectionStatements = []string{
+	"DELETE FROM Observables WHERE indicatorid IN (SELECT id FROM Indicators WHERE collectionid = ?)",
+	"DELETE FROM Indicators WHERE collectionid = ?",
+	"DELETE FROM DeliveryLog WHERE subscriptionid IN (SELECT subscriptionid FROM Subscriptions WHERE collectionid = ?)",
+	"DELETE FROM Subscriptions WHERE collectionid = ?",
+	"DELETE FROM ResultSetBlocks WHERE resultid IN (SELECT resultid FROM ResultSets WHERE collectionid = ?)",
+	"DELETE FROM ResultSets WHERE collectionid = ?",
+	"DELETE FROM Content WHERE collectionid = ?",
+	"DELETE FROM Feeds WHERE collectionid = ?",
+	"DELETE FROM Quarantine WHERE collectionid = ?",
+	"DELETE FROM Allowlist WHERE collectionid = ?",
+	"DELETE FROM CollectionGrants WHERE collectionid = ?",
+	"DELETE FROM Collections WHERE id = ?",
+}
+
+func (this *SqlStoreType) DeleteCollection(collectionName string) (bool, error) {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	id, ok, err := this.collectionId(tx, collectionName)
+	if err != nil || !ok {
+		tx.Rollback()
+		return false, err
+	}
+
+	for _, sqlstmt := range deleteCollectionStatements {
+		_, err = tx.Exec(this.rebind(sqlstmt), id)
+		if err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+
+	err = this.bumpCatalogGeneration(tx)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ----------------------------------------------------------------------
+// Services
+// ----------------------------------------------------------------------
+
+func (this *SqlStoreType) GetServices() ([]ServiceType, error) {
+	var services []ServiceType
+
+	sqlstmt := `SELECT s.id, t.type, s.available, s.address
+				FROM Services AS s
+				INNER JOIN ServiceType AS t
+				ON s.typeid = t.id
+				ORDER BY s.id`
+	rows, err := this.db.Query(sqlstmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var service ServiceType
+		var available int
+		err = rows.Scan(&service.Id, &service.ServiceType, &available, &service.Address)
+		if err != nil {
+			return nil, err
+		}
+		service.Available = available == 1
+		services = append(services, service)
+	}
+	return services, rows.Err()
+}
+
+// --------------------------------------------------
+// Add a Service
+// --------------------------------------------------
+// The service type must be one of the names in the ServiceType table. The ID
+// of the new service is returned.
+
+func (this *SqlStoreType) AddService(service ServiceType) (int64, error) {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	typeId, err := this.serviceTypeId(tx, service.ServiceType)
+	if err != nil {
+		return 0, err
+	}
+
+	available := 0
+	if service.Available {
+		available = 1
+	}
+
+	id, err := this.insertId(tx, "INSERT INTO Services (typeid, available, address) VALUES (?, ?, ?)", typeId, available, service.Address)
+	if err != nil {
+		return 0, err
+	}
+
+	err = this.bumpCatalogGeneration(tx)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// --------------------------------------------------
+// Change a Service
+// --------------------------------------------------
+// Services are found by their ID. The boolean return value is false if the
+// service does not exist.
+
+func (this *SqlStoreType) SetService(service ServiceType) (bool, error) {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	typeId, err := this.serviceTypeId(tx, service.ServiceType)
+	if err != nil {
+		return false, err
+	}
+
+	available := 0
+	if service.Available {
+		available = 1
+	}
+
+	result, err := tx.Exec(this.rebind("UPDATE Services SET typeid = ?, available = ?, address = ? WHERE id = ?"), typeId, available, service.Address, service.Id)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil || rows == 0 {
+		return false, err
+	}
+
+	err = this.bumpCatalogGeneration(tx)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// --------------------------------------------------
+// Delete a Service
+// --------------------------------------------------
+// The boolean return value is false if the service did not exist
+
+func (this *SqlStoreType) DeleteService(id int64) (bool, error) {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(this.rebind("DELETE FROM Services WHERE id = ?"), id)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil || rows == 0 {
+		return false, err
+	}
+
+	err = this.bumpCatalogGeneration(tx)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (this *SqlStoreType) serviceTypeId(tx *sql.Tx, serviceType string) (int64, error) {
+	var typeId int64
+	err := tx.QueryRow(this.rebind("SELECT id FROM ServiceType WHERE type = ?"), serviceType).Scan(&typeId)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("unknown service type %s", serviceType)
+	}
+	return typeId, err
+}
+
+// ----------------------------------------------------------------------
+// Content
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Add a Content Block to a Collection
+// --------------------------------------------------
+// This does not move the catalog generation on, content is added far more
+// often than the collections change. The volume and content bindings in the
+// server's copy of the catalog catch up when it is next read in full.
+
+func (this *SqlStoreType) AddContentBlock(collectionName string, block ContentBlockType) error {
+	return this.AddContentBlocks([]string{collectionName}, []ContentBlockType{block})
+}
+
+// --------------------------------------------------
+// Add Content Blocks to several Collections
+// --------------------------------------------------
+// Every block is added to every collection in one transaction, so either all
+// of the collections get the content or none of them do.
+
+func (this *SqlStoreType) AddContentBlocks(collectionNames []string, blocks []ContentBlockType) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	for _, collectionName := range collectionNames {
+		id, ok, err := this.collectionId(tx, collectionName)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if !ok {
+			tx.Rollback()
+			return fmt.Errorf("collection %s was not found in the database", collectionName)
+		}
+		ids = append(ids, id)
+	}
+
+	sqlstmt := `INSERT INTO Content (collectionid, binding, encoding, content, timestamplabel, sequence)
+				VALUES (?, ?, ?, ?, ?, ?)`
+	for _, id := range ids {
+		for _, block := range blocks {
+			sequence, err := this.nextContentSequence(tx)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+
+			_, err = tx.Exec(this.rebind(sqlstmt), id, block.ContentBinding, block.Encoding, block.Content, block.TimestampLabel, sequence)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// The Catalog row stays locked until the transaction ends, so no other
+// content can be given a sequence until this content has been committed.
+
+func (this *SqlStoreType) nextContentSequence(tx *sql.Tx) (int64, error) {
+	_, err := tx.Exec("UPDATE Catalog SET lastcontentsequence = lastcontentsequence + 1 WHERE id = 1")
+	if err != nil {
+		return 0, err
+	}
+
+	var sequence int64
+	err = tx.QueryRow("SELECT lastcontentsequence FROM Catalog WHERE id = 1").Scan(&sequence)
+	return sequence, err
+}
+
+// --------------------------------------------------
+// Get the Content Blocks committed after a given Sequence
+// --------------------------------------------------
+// The blocks are returned in the order they were committed.
+
+func (this *SqlStoreType) GetContentBlocksAfter(collectionName string, afterSequence int64) ([]ContentBlockType, error) {
+	return this.queryContentBlocks("l.collection = ? AND c.sequence > ?", "c.sequence", collectionName, afterSequence)
+}
+
+// --------------------------------------------------
+// Get the Content Blocks in a Timestamp Window
+// --------------------------------------------------
+// The window is exclusive of the begin timestamp and inclusive of the end
+// timestamp. An empty begin timestamp means the start of the collection.
+
+func (this *SqlStoreType) GetContentBlocksInWindow(collectionName, begin, end string) ([]ContentBlockType, error) {
+	if begin == "" {
+		return this.queryContentBlocks("l.collection = ? AND c.timestamplabel <= ?", "c.timestamplabel, c.id", collectionName, end)
+	}
+	return this.queryContentBlocks("l.collection = ? AND c.timestamplabel > ? AND c.timestamplabel <= ?", "c.timestamplabel, c.id", collectionName, begin, end)
+}
+
+// --------------------------------------------------
+// Get the Content Blocks with a Content Binding
+// --------------------------------------------------
+// Only content added after the timestamp is returned, an empty timestamp
+// means the start of the collection.
+
+func (this *SqlStoreType) GetContentBlocksByBinding(collectionName, binding, after string) ([]ContentBlockType, error) {
+	return this.queryContentBlocks("l.collection = ? AND c.binding = ? AND c.timestamplabel > ?", "c.timestamplabel, c.id", collectionName, binding, after)
+}
+
+func (this *SqlStoreType) queryContentBlocks(where, order string, args ...interface{}) ([]ContentBlockType, error) {
+	var blocks []ContentBlockType
+
+	sqlstmt := `SELECT c.id, c.sequence, c.binding, c.encoding, c.content, c.timestamplabel
+				FROM Content AS c
+				INNER JOIN Collections AS l
+				ON c.collectionid = l.id
+				WHERE ` + where + `
+				ORDER BY ` + order
+	rows, err := this.db.Query(this.rebind(sqlstmt), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var block ContentBlockType
+		err = rows.Scan(&block.Id, &block.Sequence, &block.ContentBinding, &block.Encoding, &block.Content, &block.TimestampLabel)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, rows.Err()
+}
+
+// ----------------------------------------------------------------------
+// Subscriptions
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Add a Subscription
+// --------------------------------------------------
+// The delivery cursor starts at the newest content in the collection so that
+// a new subscriber is only pushed content that arrives after they subscribed.
+
+func (this *SqlStoreType) AddSubscription(sub SubscriptionType) error {
+	id, ok, err := this.collectionId(this.db, sub.CollectionName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("collection %s was not found in the database", sub.CollectionName)
+	}
+
+	var cursor int64
+	err = this.db.QueryRow(this.rebind("SELECT COALESCE(MAX(sequence), 0) FROM Content WHERE collectionid = ?"), id).Scan(&cursor)
+	if err != nil {
+		return err
+	}
+
+	sqlstmt := `INSERT INTO Subscriptions (subscriptionid, collectionid, status, responsetype, bindings, inboxprotocol, inboxaddress, inboxbinding, created, deliverycursor, ownertype, owner)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = this.db.Exec(this.rebind(sqlstmt), sub.SubscriptionId, id, sub.Status, sub.ResponseType, strings.Join(sub.ContentBindings, ","),
+		sub.InboxProtocol, sub.InboxAddress, sub.InboxBinding, sub.Created, cursor, sub.Owner.Type, sub.Owner.Name)
+	return err
+}
+
+// --------------------------------------------------
+// Get a single Subscription by its ID
+// --------------------------------------------------
+
+func (this *SqlStoreType) GetSubscription(subscriptionId string) (SubscriptionType, bool, error) {
+	subs, err := this.querySubscriptions("s.subscriptionid = ?", subscriptionId)
+	if err != nil || len(subs) == 0 {
+		return SubscriptionType{}, false, err
+	}
+	return subs[0], true, nil
+}
+
+// --------------------------------------------------
+// Get all Subscriptions for a Collection
+// --------------------------------------------------
+
+func (this *SqlStoreType) GetSubscriptions(collectionName string) ([]SubscriptionType, error) {
+	return this.querySubscriptions("c.collection = ?", collectionName)
+}
+
+// --------------------------------------------------
+// Get all Subscriptions that want content pushed to them
+// --------------------------------------------------
+
+func (this *SqlStoreType) GetPushSubscriptions() ([]SubscriptionType, error) {
+	return this.querySubscriptions("s.status = ? AND s.inboxaddress != ''", SUBSCRIPTION_STATUS_ACTIVE)
+}
+
+// --------------------------------------------------
+// Change the Status of a Subscription
+// --------------------------------------------------
+
+func (this *SqlStoreType) SetSubscriptionStatus(subscriptionId, status string) error {
+	_, err := this.db.Exec(this.rebind("UPDATE Subscriptions SET status = ? WHERE subscriptionid = ?"), status, subscriptionId)
+	return err
+}
+
+// --------------------------------------------------
+// Move the Delivery Cursor of a Subscription
+// --------------------------------------------------
+
+func (this *SqlStoreType) SetDeliveryCursor(subscriptionId string, cursor int64) error {
+	_, err := this.db.Exec(this.rebind("UPDATE Subscriptions SET deliverycursor = ? WHERE subscriptionid = ?"), cursor, subscriptionId)
+	return err
+}
+
+// --------------------------------------------------
+// Record a Delivery Attempt in the Delivery Log
+// --------------------------------------------------
+
+func (this *SqlStoreType) AddDeliveryLog(entry DeliveryLogType) error {
+	sqlstmt := `INSERT INTO DeliveryLog (subscriptionid, attempted, firstcontentid, lastcontentid, blocks, result, message)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := this.db.Exec(this.rebind(sqlstmt), entry.SubscriptionId, entry.Attempted, entry.FirstContentId, entry.LastContentId,
+		entry.Blocks, entry.Result, entry.Message)
+	return err
+}
+
+func (this *SqlStoreType) querySubscriptions(where string, args ...interface{}) ([]SubscriptionType, error) {
+	var subs []SubscriptionType
+
+	sqlstmt := `SELECT s.subscriptionid, c.collection, s.status, s.responsetype, s.bindings, s.inboxprotocol, s.inboxaddress, s.inboxbinding, s.created, s.deliverycursor, s.ownertype, s.owner
+				FROM Subscriptions AS s
+				INNER JOIN Collections AS c
+				ON s.collectionid = c.id
+				WHERE ` + where + `
+				ORDER BY s.id`
+	rows, err := this.db.Query(this.rebind(sqlstmt), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sub SubscriptionType
+		var bindings string
+		err = rows.Scan(&sub.SubscriptionId, &sub.CollectionName, &sub.Status, &sub.ResponseType, &bindings,
+			&sub.InboxProtocol, &sub.InboxAddress, &sub.InboxBinding, &sub.Created, &sub.DeliveryCursor, &sub.Owner.Type, &sub.Owner.Name)
+		if err != nil {
+			return nil, err
+		}
+		if bindings != "" {
+			sub.ContentBindings = strings.Split(bindings, ",")
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// ----------------------------------------------------------------------
+// Result Sets
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Add a Result Set and all of its Parts
+// --------------------------------------------------
+
+func (this *SqlStoreType) AddResultSet(rs ResultSetType, parts [][]ContentBlockType) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	id, ok, err := this.collectionId(tx, rs.CollectionName)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !ok {
+		tx.Rollback()
+		return fmt.Errorf("collection %s was not found in the database", rs.CollectionName)
+	}
+
+	sqlstmt := `INSERT INTO ResultSets (resultid, collectionid, begintimestamp, endtimestamp, parts, status, created, expires)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(this.rebind(sqlstmt), rs.ResultId, id, rs.Begin, rs.End, len(parts), rs.Status, rs.Created, rs.Expires)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = this.insertResultSetParts(tx, rs.ResultId, parts)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// --------------------------------------------------
+// Finish a Pending Result Set
+// --------------------------------------------------
+
+func (this *SqlStoreType) CompleteResultSet(resultId string, parts [][]ContentBlockType, status string) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = this.insertResultSetParts(tx, resultId, parts)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(this.rebind("UPDATE ResultSets SET parts = ?, status = ? WHERE resultid = ?"), len(parts), status, resultId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Part numbers start at 1
+func (this *SqlStoreType) insertResultSetParts(tx *sql.Tx, resultId string, parts [][]ContentBlockType) error {
+	sqlstmt := this.rebind(`INSERT INTO ResultSetBlocks (resultid, partnumber, binding, encoding, content, timestamplabel)
+				VALUES (?, ?, ?, ?, ?, ?)`)
+
+	for i, part := range parts {
+		for _, value := range part {
+			_, err := tx.Exec(sqlstmt, resultId, i+1, value.ContentBinding, value.Encoding, value.Content, value.TimestampLabel)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// --------------------------------------------------
+// Get a Result Set by its ID
+// --------------------------------------------------
+// The boolean return value is false if the result set does not exist or has
+// expired by the time given in now
+
+func (this *SqlStoreType) GetResultSet(resultId, now string) (ResultSetType, bool, error) {
+	var rs ResultSetType
+
+	sqlstmt := `SELECT r.resultid, c.collection, r.begintimestamp, r.endtimestamp, r.parts, r.status, r.created, r.expires
+				FROM ResultSets AS r
+				INNER JOIN Collections AS c
+				ON r.collectionid = c.id
+				WHERE r.resultid = ? AND r.expires > ?`
+	err := this.db.QueryRow(this.rebind(sqlstmt), resultId, now).Scan(
+		&rs.ResultId, &rs.CollectionName, &rs.Begin, &rs.End, &rs.Parts, &rs.Status, &rs.Created, &rs.Expires)
+	if err == sql.ErrNoRows {
+		return rs, false, nil
+	}
+	if err != nil {
+		return rs, false, err
+	}
+	return rs, true, nil
+}
+
+// --------------------------------------------------
+// Get the Content Blocks for one Part of a Result Set
+// --------------------------------------------------
+
+func (this *SqlStoreType) GetResultSetPart(resultId string, part int) ([]ContentBlockType, error) {
+	var blocks []ContentBlockType
+
+	sqlstmt := `SELECT id, binding, encoding, content, timestamplabel
+				FROM ResultSetBlocks
+				WHERE resultid = ? AND partnumber = ?
+				ORDER BY id`
+	rows, err := this.db.Query(this.rebind(sqlstmt), resultId, part)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var block ContentBlockType
+		err = rows.Scan(&block.Id, &block.ContentBinding, &block.Encoding, &block.Content, &block.TimestampLabel)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, rows.Err()
+}
+
+// --------------------------------------------------
+// Remove Expired Result Sets
+// --------------------------------------------------
+
+func (this *SqlStoreType) DeleteExpiredResultSets(now string) error {
+	_, err := this.db.Exec(this.rebind("DELETE FROM ResultSetBlocks WHERE resultid IN (SELECT resultid FROM ResultSets WHERE expires <= ?)"), now)
+	if err != nil {
+		return err
+	}
+	_, err = this.db.Exec(this.rebind("DELETE FROM ResultSets WHERE expires <= ?"), now)
+	return err
+}
+
+// ----------------------------------------------------------------------
+// Indicators
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Get the Indicators in a Collection
+// --------------------------------------------------
+
+func (this *SqlStoreType) GetIndicators(collectionName string) ([]IndicatorType, error) {
+	var indicators []IndicatorType
+
+	sqlstmt := `SELECT i.id, i.title, i.type, i.indicatortype, COALESCE(p.name, ''), COALESCE(p.reference, ''), i.created, i.modified
+				FROM Indicators AS i
+				INNER JOIN Collections AS l
+				ON i.collectionid = l.id
+				LEFT JOIN Producers AS p
+				ON i.producerid = p.id
+				WHERE l.collection = ?
+				ORDER BY i.id`
+	rows, err := this.db.Query(this.rebind(sqlstmt), collectionName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var indicator IndicatorType
+		err = rows.Scan(&indicator.Id, &indicator.Title, &indicator.Type, &indicator.IndicatorType,
+			&indicator.ProducerName, &indicator.ProducerReference, &indicator.Created, &indicator.Modified)
+		if err != nil {
+			return nil, err
+		}
+		indicators = append(indicators, indicator)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sqlstmt = this.rebind("SELECT type, value, created FROM Observables WHERE indicatorid = ? ORDER BY id")
+	for i := range indicators {
+		rows, err := this.db.Query(sqlstmt, indicators[i].Id)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var observable ObservableType
+			err = rows.Scan(&observable.Type, &observable.Value, &observable.Created)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			indicators[i].Observables = append(indicators[i].Observables, observable)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return indicators, nil
+}
+
+// --------------------------------------------------
+// Check if a Collection has Indicators
+// --------------------------------------------------
+
+func (this *SqlStoreType) HasIndicators(collectionName string) (bool, error) {
+	var count int
+	sqlstmt := `SELECT COUNT(*)
+				FROM Indicators AS i
+				INNER JOIN Collections AS l
+				ON i.collectionid = l.id
+				WHERE l.collection = ?`
+	err := this.db.QueryRow(this.rebind(sqlstmt), collectionName).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// --------------------------------------------------
+// Add an Indicator to a Collection
+// --------------------------------------------------
+// The indicator is saved along with its observables, the producer is not
+// saved.
+
+func (this *SqlStoreType) AddIndicator(collectionName string, indicator IndicatorType) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = this.addIndicator(tx, collectionName, indicator)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// The ID of the new indicator is returned
+func (this *SqlStoreType) addIndicator(tx *sql.Tx, collectionName string, indicator IndicatorType) (int64, error) {
+	collectionId, ok, err := this.collectionId(tx, collectionName)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("collection %s does not exist", collectionName)
+	}
+
+	sqlstmt := `INSERT INTO Indicators (collectionid, title, type, indicatortype, created, modified)
+				VALUES (?, ?, ?, ?, ?, ?)`
+	id, err := this.insertId(tx, sqlstmt, collectionId, indicator.Title, indicator.Type, indicator.IndicatorType, indicator.Created, indicator.Modified)
+	if err != nil {
+		return 0, err
+	}
+
+	sqlstmt = this.rebind("INSERT INTO Observables (indicatorid, type, value, created) VALUES (?, ?, ?, ?)")
+	for _, value := range indicator.Observables {
+		_, err = tx.Exec(sqlstmt, id, value.Type, value.Value, value.Created)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// ----------------------------------------------------------------------
+// Feeds
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Get all Feeds
+// --------------------------------------------------
+
+func (this *SqlStoreType) GetFeeds() ([]FeedType, error) {
+	var feeds []FeedType
+
+	sqlstmt := `SELECT l.collection, f.address, f.fetchinterval, f.parser, f.parseroptions, f.etag, f.lastmodified, f.lastfetched, f.lastsuccess, f.lasterror, f.suppressed
+				FROM Feeds AS f
+				INNER JOIN Collections AS l
+				ON f.collectionid = l.id
+				ORDER BY f.id`
+	rows, err := this.db.Query(sqlstmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var feed FeedType
+		err = rows.Scan(&feed.CollectionName, &feed.Address, &feed.Interval, &feed.Parser, &feed.ParserOptions, &feed.ETag, &feed.LastModified,
+			&feed.LastFetched, &feed.LastSuccess, &feed.LastError, &feed.Suppressed)
+		if err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+	}
+	return feeds, rows.Err()
+}
+
+// --------------------------------------------------
+// Add a Feed to a Collection
+// --------------------------------------------------
+// A collection only has one feed
+
+func (this *SqlStoreType) AddFeed(feed FeedType) error {
+	id, ok, err := this.collectionId(this.db, feed.CollectionName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("collection %s does not exist", feed.CollectionName)
+	}
+
+	sqlstmt := "INSERT INTO Feeds (collectionid, address, fetchinterval, parser, parseroptions) VALUES (?, ?, ?, ?, ?)"
+	_, err = this.db.Exec(this.rebind(sqlstmt), id, feed.Address, feed.Interval, feed.Parser, feed.ParserOptions)
+	return err
+}
+
+// --------------------------------------------------
+// Save the Result of a Fetch
+// --------------------------------------------------
+
+func (this *SqlStoreType) SetFeedState(feed FeedType) error {
+	id, ok, err := this.collectionId(this.db, feed.CollectionName)
+	if err != nil || !ok {
+		return err
+	}
+
+	sqlstmt := `UPDATE Feeds
+				SET etag = ?, lastmodified = ?, lastfetched = ?, lastsuccess = ?, lasterror = ?, suppressed = ?
+				WHERE collectionid = ?`
+	_, err = this.db.Exec(this.rebind(sqlstmt), feed.ETag, feed.LastModified, feed.LastFetched, feed.LastSuccess, feed.LastError, feed.Suppressed, id)
+	return err
+}
+
+// --------------------------------------------------
+// Replace the Observables that came from a Feed
+// --------------------------------------------------
+// Only the indicator that belongs to the feed has its observables replaced,
+// indicators added to the collection in any other way are left alone. The
+// first time the feed is read its indicator is created, named after the
+// collection.
+
+func (this *SqlStoreType) SetFeedObservables(collectionName string, observables []ObservableType, modified string) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = this.setFeedObservables(tx, collectionName, observables, modified)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (this *SqlStoreType) setFeedObservables(tx *sql.Tx, collectionName string, observables []ObservableType, modified string) error {
+	collectionId, ok, err := this.collectionId(tx, collectionName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("collection %s does not exist", collectionName)
+	}
+
+	var indicatorId sql.NullInt64
+	err = tx.QueryRow(this.rebind("SELECT indicatorid FROM Feeds WHERE collectionid = ?"), collectionId).Scan(&indicatorId)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("collection %s does not have a feed", collectionName)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The indicator is gone if it was never created or the collection was
+	// cleared out since
+	if indicatorId.Valid {
+		var count int
+		err = tx.QueryRow(this.rebind("SELECT COUNT(*) FROM Indicators WHERE id = ? AND collectionid = ?"), indicatorId.Int64, collectionId).Scan(&count)
+		if err != nil {
+			return err
+		}
+		indicatorId.Valid = count > 0
+	}
+
+	if !indicatorId.Valid {
+		indicatorId.Int64, err = this.addIndicator(tx, collectionName, IndicatorType{Title: collectionName, Created: modified, Modified: modified})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(this.rebind("UPDATE Feeds SET indicatorid = ? WHERE collectionid = ?"), indicatorId.Int64, collectionId)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(this.rebind("DELETE FROM Observables WHERE indicatorid = ?"), indicatorId.Int64)
+	if err != nil {
+		return err
+	}
+
+	insert := this.rebind("INSERT INTO Observables (indicatorid, type, value, created) VALUES (?, ?, ?, ?)")
+	for _, value := range observables {
+		_, err = tx.Exec(insert, indicatorId.Int64, value.Type, value.Value, value.Created)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(this.rebind("UPDATE Indicators SET modified = ? WHERE id = ?"), modified, indicatorId.Int64)
+	return err
+}
+
+// ----------------------------------------------------------------------
+// Quarantine
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Get the Quarantined Values of a Collection
+// --------------------------------------------------
+
+func (this *SqlStoreType) GetQuarantine(collectionName string) ([]QuarantineType, error) {
+	var entries []QuarantineType
+
+	sqlstmt := `SELECT q.source, q.value, q.reason, q.created
+				FROM Quarantine AS q
+				INNER JOIN Collections AS l
+				ON q.collectionid = l.id
+				WHERE l.collection = ?
+				ORDER BY q.id`
+	rows, err := this.db.Query(this.rebind(sqlstmt), collectionName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry QuarantineType
+		entry.CollectionName = collectionName
+		err = rows.Scan(&entry.Source, &entry.Value, &entry.Reason, &entry.Created)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// --------------------------------------------------
+// Replace the Quarantined Values from a Source
+// --------------------------------------------------
+// Only the last fetch or import of a source is kept
+
+func (this *SqlStoreType) SetQuarantine(collectionName, source string, entries []QuarantineType) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = this.setQuarantine(tx, collectionName, source, entries)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (this *SqlStoreType) setQuarantine(tx *sql.Tx, collectionName, source string, entries []QuarantineType) error {
+	collectionId, ok, err := this.collectionId(tx, collectionName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("collection %s does not exist", collectionName)
+	}
+
+	_, err = tx.Exec(this.rebind("DELETE FROM Quarantine WHERE collectionid = ? AND source = ?"), collectionId, source)
+	if err != nil {
+		return err
+	}
+
+	sqlstmt := this.rebind("INSERT INTO Quarantine (collectionid, source, value, reason, created) VALUES (?, ?, ?, ?, ?)")
+	for _, entry := range entries {
+		_, err = tx.Exec(sqlstmt, collectionId, source, entry.Value, entry.Reason, entry.Created)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------
+// Allowlist
+// ----------------------------------------------------------------------
+
+// --------------------------------------------------
+// Get the Allowlist of a Collection
+// --------------------------------------------------
+// The global entries are always included. A blank collection name only gets
+// the global entries.
+
+func (this *SqlStoreType) GetAllowlist(collectionName string) ([]AllowlistEntryType, error) {
+	var entries []AllowlistEntryType
+
+	sqlstmt := `SELECT a.id, COALESCE(l.collection, ''), a.type, a.value, a.comment, a.created
+				FROM Allowlist AS a
+				LEFT JOIN Collections AS l
+				ON a.collectionid = l.id
+				WHERE a.collectionid IS NULL OR l.collection = ?
+				ORDER BY a.id`
+	rows, err := this.db.Query(this.rebind(sqlstmt), collectionName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AllowlistEntryType
+		err = rows.Scan(&entry.Id, &entry.CollectionName, &entry.Type, &entry.Value, &entry.Comment, &entry.Created)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// --------------------------------------------------
+// Add an Allowlist Entry
+// --------------------------------------------------
+
+func (this *SqlStoreType) AddAllowlistEntry(entry AllowlistEntryType) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var collectionId interface{}
+	if entry.CollectionName != "" {
+		id, ok, err := this.collectionId(tx, entry.CollectionName)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if !ok {
+			tx.Rollback()
+			return fmt.Errorf("collection %s does not exist", entry.CollectionName)
+		}
+		collectionId = id
+	}
+
+	sqlstmt := "INSERT INTO Allowlist (collectionid, type, value, comment, created) VALUES (?, ?, ?, ?, ?)"
+	_, err = tx.Exec(this.rebind(sqlstmt), collectionId, entry.Type, entry.Value, entry.Comment, entry.Created)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = this.bumpCatalogGeneration(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// --------------------------------------------------
+// Delete an Allowlist Entry
+// --------------------------------------------------
+
+func (this *SqlStoreType) DeleteAllowlistEntry(id int64) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(this.rebind("DELETE FROM Allowlist WHERE id = ?"), id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count == 0 {
+		tx.Rollback()
+		return fmt.Errorf("allowlist entry %d does not exist", id)
+	}
+
+	err = this.bumpCatalogGeneration(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ----------------------------------------------------------------------
+// Identities
+// ----------------------------------------------------------------------
+
+func (this *SqlStoreType) GetIdentities() ([]IdentityType, error) {
+	var identities []IdentityType
+
+	rows, err := this.db.Query("SELECT id, name, subject, san, created FROM Identities ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var identity IdentityType
+		err = rows.Scan(&identity.Id, &identity.Name, &identity.Subject, &identity.San, &identity.Created)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// --------------------------------------------------
+// Get the Identity of a Client Certificate
+// --------------------------------------------------
+// A match on the subject wins over a match on one of the names, and if more
+// than one identity matches the oldest one is used.
+
+func (this *SqlStoreType) GetIdentityByCertificate(subject string, names []string) (IdentityType, bool, error) {
+	var identity IdentityType
+
+	args := []interface{}{subject}
+	placeholders := make([]string, 0, len(names))
+	for _, name := range names {
+		args = append(args, name)
+		placeholders = append(placeholders, "?")
+	}
+
+	sqlstmt := `SELECT id, name, subject, san, created FROM Identities
+				WHERE (subject != '' AND subject = ?)`
+	if len(names) > 0 {
+		sqlstmt += ` OR (san != '' AND san IN (` + strings.Join(placeholders, ", ") + `))`
+	}
+	sqlstmt += ` ORDER BY CASE WHEN subject = ? THEN 0 ELSE 1 END, id`
+	args = append(args, subject)
+
+	err := this.db.QueryRow(this.rebind(sqlstmt), args...).Scan(&identity.Id, &identity.Name, &identity.Subject, &identity.San, &identity.Created)
+	if err == sql.ErrNoRows {
+		return identity, false, nil
+	}
+	if err != nil {
+		return identity, false, err
+	}
+	return identity, true, nil
+}
+
+func (this *SqlStoreType) AddIdentity(identity IdentityType) error {
+	sqlstmt := "INSERT INTO Identities (name, subject, san, created) VALUES (?, ?, ?, ?)"
+	_, err := this.db.Exec(this.rebind(sqlstmt), identity.Name, identity.Subject, identity.San, identity.Created)
+	return err
+}
+
+func (this *SqlStoreType) DeleteIdentity(name string) (bool, error) {
+	result, err := this.db.Exec(this.rebind("DELETE FROM Identities WHERE name = ?"), name)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// ----------------------------------------------------------------------
+// Users and API Tokens
+// ----------------------------------------------------------------------
+
+func (this *SqlStoreType) GetUsers() ([]UserType, error) {
+	var users []UserType
+
+	rows, err := this.db.Query("SELECT id, username, passwordhash, disabled, admin, created FROM Users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user UserType
+		var disabled, admin int
+		err = rows.Scan(&user.Id, &user.Username, &user.PasswordHash, &disabled, &admin, &user.Created)
+		if err != nil {
+			return nil, err
+		}
+		user.Disabled = disabled == 1
+		user.Admin = admin == 1
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (this *SqlStoreType) GetUser(username string) (UserType, bool, error) {
+	sqlstmt := "SELECT id, username, passwordhash, disabled, admin, created FROM Users WHERE username = ?"
+	return this.getUser(sqlstmt, username)
+}
+
+// --------------------------------------------------
+// Get the User of an API Token
+// --------------------------------------------------
+// Revoked tokens do not have a user. A disabled user is still returned, it is
+// up to the caller to turn them away.
+
+func (this *SqlStoreType) GetUserByToken(tokenHash string) (UserType, bool, error) {
+	sqlstmt := `SELECT u.id, u.username, u.passwordhash, u.disabled, u.admin, u.created
+				FROM Users AS u
+				JOIN ApiTokens AS t
+				ON t.userid = u.id
+				WHERE t.tokenhash = ? AND t.revoked = 0`
+	return this.getUser(sqlstmt, tokenHash)
+}
+
+func (this *SqlStoreType) getUser(sqlstmt string, value string) (UserType, bool, error) {
+	var user UserType
+	var disabled, admin int
+
+	err := this.db.QueryRow(this.rebind(sqlstmt), value).Scan(&user.Id, &user.Username, &user.PasswordHash, &disabled, &admin, &user.Created)
+	if err == sql.ErrNoRows {
+		return user, false, nil
+	}
+	if err != nil {
+		return user, false, err
+	}
+	user.Disabled = disabled == 1
+	user.Admin = admin == 1
+	return user, true, nil
+}
+
+func (this *SqlStoreType) AddUser(user UserType) error {
+	disabled := 0
+	if user.Disabled {
+		disabled = 1
+	}
+	admin := 0
+	if user.Admin {
+		admin = 1
+	}
+
+	sqlstmt := "INSERT INTO Users (username, passwordhash, disabled, admin, created) VALUES (?, ?, ?, ?, ?)"
+	_, err := this.db.Exec(this.rebind(sqlstmt), user.Username, user.PasswordHash, disabled, admin, user.Created)
+	return err
+}
+
+func (this *SqlStoreType) SetUserDisabled(username string, disabled bool) (bool, error) {
+	value := 0
+	if disabled {
+		value = 1
+	}
+
+	result, err := this.db.Exec(this.rebind("UPDATE Users SET disabled = ? WHERE username = ?"), value, username)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (this *SqlStoreType) SetUserAdmin(username string, admin bool) (bool, error) {
+	value := 0
+	if admin {
+		value = 1
+	}
+
+	result, err := this.db.Exec(this.rebind("UPDATE Users SET admin = ? WHERE username = ?"), value, username)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// --------------------------------------------------
+// Get the API Tokens of a User
+// --------------------------------------------------
+// A blank username gets the tokens of every user
+
+func (this *SqlStoreType) GetApiTokens(username string) ([]ApiTokenType, error) {
+	var tokens []ApiTokenType
+
+	sqlstmt := `SELECT t.id, u.username, t.tokenhash, t.description, t.created, t.revoked
+				FROM ApiTokens AS t
+				JOIN Users AS u
+				ON t.userid = u.id
+				WHERE ? = '' OR u.username = ?
+				ORDER BY t.id`
+	rows, err := this.db.Query(this.rebind(sqlstmt), username, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token ApiTokenType
+		var revoked int
+		err = rows.Scan(&token.Id, &token.Username, &token.TokenHash, &token.Description, &token.Created, &revoked)
+		if err != nil {
+			return nil, err
+		}
+		token.Revoked = revoked == 1
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// --------------------------------------------------
+// Add an API Token
+// --------------------------------------------------
+// Returns the ID of the token so it can be revoked later
+
+func (this *SqlStoreType) AddApiToken(token ApiTokenType) (int64, error) {
+	user, ok, err := this.GetUser(token.Username)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("user %s does not exist", token.Username)
+	}
+
+	sqlstmt := "INSERT INTO ApiTokens (userid, tokenhash, description, created) VALUES (?, ?, ?, ?)"
+	return this.insertId(this.db, sqlstmt, user.Id, token.TokenHash, token.Description, token.Created)
+}
+
+func (this *SqlStoreType) RevokeApiToken(id int64) (bool, error) {
+	result, err := this.db.Exec(this.rebind("UPDATE ApiTokens SET revoked = 1 WHERE id = ?"), id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// ----------------------------------------------------------------------
+// Groups and Grants
+// ----------------------------------------------------------------------
+
+func (this *SqlStoreType) GetGroups() ([]GroupType, error) {
+	var groups []GroupType
+
+	rows, err := this.db.Query("SELECT id, name, created FROM UserGroups ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var group GroupType
+		err = rows.Scan(&group.Id, &group.Name, &group.Created)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sqlstmt := this.rebind("SELECT membertype, membername FROM GroupMembers WHERE groupid = ? ORDER BY membertype, membername")
+	for i := range groups {
+		members, err := this.db.Query(sqlstmt, groups[i].Id)
+		if err != nil {
+			return nil, err
+		}
+		for members.Next() {
+			var member PrincipalType
+			err = members.Scan(&member.Type, &member.Name)
+			if err != nil {
+				members.Close()
+				return nil, err
+			}
+			groups[i].Members = append(groups[i].Members, member)
+		}
+		err = members.Err()
+		members.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+func (this *SqlStoreType) AddGroup(group GroupType) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(this.rebind("INSERT INTO UserGroups (name, created) VALUES (?, ?)"), group.Name, group.Created)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, member := range group.Members {
+		err = this.addGroupMember(tx, group.Name, member)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// --------------------------------------------------
+// Delete a Group
+// --------------------------------------------------
+// The members and the grants of the group go with it
+
+func (this *SqlStoreType) DeleteGroup(name string) (bool, error) {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(this.rebind("DELETE FROM GroupMembers WHERE groupid IN (SELECT id FROM UserGroups WHERE name = ?)"), name)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	_, err = tx.Exec(this.rebind("DELETE FROM CollectionGrants WHERE granteetype = ? AND grantee = ?"), PRINCIPAL_TYPE_GROUP, name)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	result, err := tx.Exec(this.rebind("DELETE FROM UserGroups WHERE name = ?"), name)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil || rows == 0 {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (this *SqlStoreType) AddGroupMember(groupName string, member PrincipalType) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = this.addGroupMember(tx, groupName, member)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (this *SqlStoreType) addGroupMember(tx *sql.Tx, groupName string, member PrincipalType) error {
+	var groupId int64
+	err := tx.QueryRow(this.rebind("SELECT id FROM UserGroups WHERE name = ?"), groupName).Scan(&groupId)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("group %s does not exist", groupName)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(this.rebind("INSERT INTO GroupMembers (groupid, membertype, membername) VALUES (?, ?, ?)"), groupId, member.Type, member.Name)
+	return err
+}
+
+func (this *SqlStoreType) DeleteGroupMember(groupName string, member PrincipalType) (bool, error) {
+	sqlstmt := `DELETE FROM GroupMembers
+				WHERE groupid IN (SELECT id FROM UserGroups WHERE name = ?) AND membertype = ? AND membername = ?`
+	result, err := this.db.Exec(this.rebind(sqlstmt), groupName, member.Type, member.Name)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// --------------------------------------------------
+// Get the Grants of a Collection
+// --------------------------------------------------
+// A blank collection name gets the grants of every collection
+
+func (this *SqlStoreType) GetGrants(collectionName string) ([]GrantType, error) {
+	sqlstmt := `SELECT g.id, c.collection, g.granteetype, g.grantee, g.permission, g.created
+				FROM CollectionGrants AS g
+				JOIN Collections AS c
+				ON g.collectionid = c.id
+				WHERE ? = '' OR c.collection = ?
+				ORDER BY c.collection, g.id`
+	return this.getGrants(sqlstmt, collectionName, collectionName)
+}
+
+// --------------------------------------------------
+// Get the Grants of a User or Identity
+// --------------------------------------------------
+// Both the grants made to them and the grants made to the groups they are in
+
+func (this *SqlStoreType) GetPrincipalGrants(principal PrincipalType) ([]GrantType, error) {
+	sqlstmt := `SELECT g.id, c.collection, g.granteetype, g.grantee, g.permission, g.created
+				FROM CollectionGrants AS g
+				JOIN Collections AS c
+				ON g.collectionid = c.id
+				WHERE (g.granteetype = ? AND g.grantee = ?)
+				OR (g.granteetype = ? AND g.grantee IN (
+					SELECT u.name
+					FROM UserGroups AS u
+					JOIN GroupMembers AS m
+					ON m.groupid = u.id
+					WHERE m.membertype = ? AND m.membername = ?))
+				ORDER BY c.collection, g.id`
+	return this.getGrants(sqlstmt, principal.Type, principal.Name, PRINCIPAL_TYPE_GROUP, principal.Type, principal.Name)
+}
+
+func (this *SqlStoreType) getGrants(sqlstmt string, args ...interface{}) ([]GrantType, error) {
+	var grants []GrantType
+
+	rows, err := this.db.Query(this.rebind(sqlstmt), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var grant GrantType
+		err = rows.Scan(&grant.Id, &grant.CollectionName, &grant.GranteeType, &grant.Grantee, &grant.Permission, &grant.Created)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
+
+func (this *SqlStoreType) AddGrant(grant GrantType) error {
+	collectionId, ok, err := this.collectionId(this.db, grant.CollectionName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("collection %s does not exist", grant.CollectionName)
+	}
+
+	sqlstmt := "INSERT INTO CollectionGrants (collectionid, granteetype, grantee, permission, created) VALUES (?, ?, ?, ?, ?)"
+	_, err = this.db.Exec(this.rebind(sqlstmt), collectionId, grant.GranteeType, grant.Grantee, grant.Permission, grant.Created)
+	return err
+}
+
+func (this *SqlStoreType) DeleteGrant(id int64) (bool, error) {
+	result, err := this.db.Exec(this.rebind("DELETE FROM CollectionGrants WHERE id = ?"), id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// ----------------------------------------------------------------------
+// TAXII 2.1 Status Resources
+// ----------------------------------------------------------------------
+
+func (this *SqlStoreType) AddTaxii2Status(statusId, created, resource string) error {
+	_, err := this.db.Exec(this.rebind("INSERT INTO Taxii2Status (statusid, created, resource) VALUES (?, ?, ?)"), statusId, created, resource)
+	return err
+}
+
+func (this *SqlStoreType) GetTaxii2Status(statusId string) (string, bool, error) {
+	var resource string
+	err := this.db.QueryRow(this.rebind("SELECT resource FROM Taxii2Status WHERE statusid = ?"), statusId).Scan(&resource)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return resource, true, nil
+}
+
+// --------------------------------------------------
+// Close the Store
+// --------------------------------------------------
+
+func (this *SqlStoreType) Close() error {
+	return this.db.Close()
+}
@@ -0,0 +1,34 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package storage
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// --------------------------------------------------
+// Create a SQLite Store
+// --------------------------------------------------
+// The data source is the full path to the database file. SQLite only allows
+// one writer at a time, so the pool is kept to a single connection rather
+// than have requests fail with a locked database.
+
+func NewSqliteStore(filename string) (*SqlStoreType, error) {
+	var obj SqlStoreType
+	obj.Driver = "sqlite3"
+	obj.DataSource = filename
+	obj.Placeholders = "?"
+	obj.IdColumn = "integer PRIMARY KEY"
+	obj.BigIdColumn = "integer PRIMARY KEY"
+
+	err := obj.open()
+	if err != nil {
+		return nil, err
+	}
+	obj.db.SetMaxOpenConns(1)
+	return &obj, nil
+}
@@ -0,0 +1,393 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package storage holds everything the server keeps between requests:
+// collections, services, content, subscriptions, result sets, indicators and
+// TAXII 2.1 status resources. The server only talks to the StoreType
+// interface, so the backend can be SQLite, PostgreSQL or memory.
+package storage
+
+import (
+	"errors"
+)
+
+// Backends that can be named in the dbtype directive of the system section
+// of the configuration file
+const (
+	BACKEND_SQLITE   = "sqlite3"
+	BACKEND_POSTGRES = "postgres"
+	BACKEND_MEMORY   = "memory"
+)
+
+// Subscriptions with this status have content pushed to them, it is the same
+// value as the TAXII status
+const (
+	SUBSCRIPTION_STATUS_ACTIVE = "ACTIVE"
+)
+
+// Who a request is from, and who a collection can be granted to. A grant to a
+// group applies to every user and identity in it.
+const (
+	PRINCIPAL_TYPE_USER     = "user"
+	PRINCIPAL_TYPE_IDENTITY = "identity"
+	PRINCIPAL_TYPE_GROUP    = "group"
+)
+
+// What a grant allows. Read covers polling, subscribing and exporting, write
+// covers adding content.
+const (
+	PERMISSION_READ  = "read"
+	PERMISSION_WRITE = "write"
+)
+
+// The services the Discovery service can offer, the same names as the
+// ServiceType table
+const (
+	SERVICE_TYPE_DISCOVERY    = "Discovery"
+	SERVICE_TYPE_COLLECTION   = "Collection"
+	SERVICE_TYPE_SUBSCRIPTION = "Subscription"
+	SERVICE_TYPE_INBOX        = "Inbox"
+	SERVICE_TYPE_POLL         = "Poll"
+)
+
+func ValidServiceType(serviceType string) bool {
+	switch serviceType {
+	case SERVICE_TYPE_DISCOVERY, SERVICE_TYPE_COLLECTION, SERVICE_TYPE_SUBSCRIPTION, SERVICE_TYPE_INBOX, SERVICE_TYPE_POLL:
+		return true
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------
+// Define Storage Interface
+// ----------------------------------------------------------------------
+// Methods that look up a single record return false if it does not exist
+// rather than an error. The catalog generation changes every time the
+// collections, the services or the allowlist change, so that a copy of them
+// can be kept until it is out of date, even when the change was made by
+// another process. Adding content does not change it.
+//
+// The schema version is the number of the last migration applied to the
+// store. Migrate applies every migration that is missing and returns the ones
+// it applied.
+
+type StoreType interface {
+	// Schema
+	GetSchemaVersion() (int, error)
+	Migrate() ([]MigrationType, error)
+
+	// Catalog
+	GetCatalogGeneration() (int64, error)
+
+	// Collections
+	GetCollections() (map[string]CollectionType, error)
+	AddCollection(collection CollectionType) error
+	SetCollection(collection CollectionType) (bool, error)
+	DeleteCollection(collectionName string) (bool, error)
+
+	// Services
+	GetServices() ([]ServiceType, error)
+	AddService(service ServiceType) (int64, error)
+	SetService(service ServiceType) (bool, error)
+	DeleteService(id int64) (bool, error)
+
+	// Content
+	AddContentBlock(collectionName string, block ContentBlockType) error
+	AddContentBlocks(collectionNames []string, blocks []ContentBlockType) error
+	GetContentBlocksAfter(collectionName string, afterSequence int64) ([]ContentBlockType, error)
+	GetContentBlocksInWindow(collectionName, begin, end string) ([]ContentBlockType, error)
+	GetContentBlocksByBinding(collectionName, binding, after string) ([]ContentBlockType, error)
+
+	// Subscriptions
+	AddSubscription(sub SubscriptionType) error
+	GetSubscription(subscriptionId string) (SubscriptionType, bool, error)
+	GetSubscriptions(collectionName string) ([]SubscriptionType, error)
+	GetPushSubscriptions() ([]SubscriptionType, error)
+	SetSubscriptionStatus(subscriptionId, status string) error
+	SetDeliveryCursor(subscriptionId string, cursor int64) error
+	AddDeliveryLog(entry DeliveryLogType) error
+
+	// Result Sets
+	AddResultSet(rs ResultSetType, parts [][]ContentBlockType) error
+	CompleteResultSet(resultId string, parts [][]ContentBlockType, status string) error
+	GetResultSet(resultId, now string) (ResultSetType, bool, error)
+	GetResultSetPart(resultId string, part int) ([]ContentBlockType, error)
+	DeleteExpiredResultSets(now string) error
+
+	// Indicators
+	GetIndicators(collectionName string) ([]IndicatorType, error)
+	HasIndicators(collectionName string) (bool, error)
+	AddIndicator(collectionName string, indicator IndicatorType) error
+
+	// Feeds
+	GetFeeds() ([]FeedType, error)
+	AddFeed(feed FeedType) error
+	SetFeedState(feed FeedType) error
+	SetFeedObservables(collectionName string, observables []ObservableType, modified string) error
+
+	// Quarantine
+	GetQuarantine(collectionName string) ([]QuarantineType, error)
+	SetQuarantine(collectionName, source string, entries []QuarantineType) error
+
+	// Allowlist
+	GetAllowlist(collectionName string) ([]AllowlistEntryType, error)
+	AddAllowlistEntry(entry AllowlistEntryType) error
+	DeleteAllowlistEntry(id int64) error
+
+	// Identities
+	GetIdentities() ([]IdentityType, error)
+	GetIdentityByCertificate(subject string, names []string) (IdentityType, bool, error)
+	AddIdentity(identity IdentityType) error
+	DeleteIdentity(name string) (bool, error)
+
+	// Users and API Tokens
+	GetUsers() ([]UserType, error)
+	GetUser(username string) (UserType, bool, error)
+	GetUserByToken(tokenHash string) (UserType, bool, error)
+	AddUser(user UserType) error
+	SetUserDisabled(username string, disabled bool) (bool, error)
+	SetUserAdmin(username string, admin bool) (bool, error)
+	GetApiTokens(username string) ([]ApiTokenType, error)
+	AddApiToken(token ApiTokenType) (int64, error)
+	RevokeApiToken(id int64) (bool, error)
+
+	// Groups and Grants
+	GetGroups() ([]GroupType, error)
+	AddGroup(group GroupType) error
+	DeleteGroup(name string) (bool, error)
+	AddGroupMember(groupName string, member PrincipalType) error
+	DeleteGroupMember(groupName string, member PrincipalType) (bool, error)
+	GetGrants(collectionName string) ([]GrantType, error)
+	GetPrincipalGrants(principal PrincipalType) ([]GrantType, error)
+	AddGrant(grant GrantType) error
+	DeleteGrant(id int64) (bool, error)
+
+	// TAXII 2.1 Status Resources
+	AddTaxii2Status(statusId, created, resource string) error
+	GetTaxii2Status(statusId string) (string, bool, error)
+
+	Close() error
+}
+
+// ----------------------------------------------------------------------
+// Define Record Types
+// ----------------------------------------------------------------------
+
+// This type holds a collection. The service addresses are the ones set for
+// the collection, or the address of the service if the collection does not
+// set its own.
+type CollectionType struct {
+	Name                string
+	Description         string
+	Type                string   // DATA_FEED or DATA_SET
+	Location            string   // Remote if the content comes from a slow remote source
+	Address             string   // Address of the remote source
+	ContentBinding      string   // Content binding the indicators are published in
+	ContentBindings     []string // Content bindings of the content stored in the collection
+	Volume              int      // Number of content blocks stored in the collection
+	PollAddress         string
+	InboxAddress        string
+	SubscriptionAddress string
+}
+
+// This type holds a TAXII service that is offered by the Discovery service.
+// The ID is assigned by the store.
+type ServiceType struct {
+	Id          int64
+	ServiceType string
+	Available   bool
+	Address     string
+}
+
+// This type holds a content block. The ID and sequence are assigned by the
+// store and only ever increase. Content is numbered in sequence in the order
+// it was committed, which the ID does not have to follow.
+type ContentBlockType struct {
+	Id             int64
+	Sequence       int64
+	ContentBinding string
+	Encoding       string
+	Content        string
+	TimestampLabel string
+}
+
+// This type holds a collection subscription
+type SubscriptionType struct {
+	SubscriptionId  string
+	CollectionName  string
+	Status          string
+	ResponseType    string
+	ContentBindings []string
+	InboxProtocol   string
+	InboxAddress    string
+	InboxBinding    string
+	Created         string
+	DeliveryCursor  int64         // The sequence of the last content delivered
+	Owner           PrincipalType // Who subscribed, blank for an anonymous request
+}
+
+// This type holds a single attempt to push content to a subscriber
+type DeliveryLogType struct {
+	SubscriptionId string
+	Attempted      string
+	FirstContentId int64
+	LastContentId  int64
+	Blocks         int
+	Result         string
+	Message        string
+}
+
+// This type holds the details of a poll result set. The content blocks of
+// each part are stored separately.
+type ResultSetType struct {
+	ResultId       string
+	CollectionName string
+	Begin          string
+	End            string
+	Parts          int
+	Status         string
+	Created        string
+	Expires        string
+}
+
+// This type holds an indicator along with the observables that belong to it.
+// The producer is only set for indicators that come from somewhere other than
+// this server.
+type IndicatorType struct {
+	Id                int64
+	Title             string
+	Type              string
+	IndicatorType     string
+	ProducerName      string
+	ProducerReference string
+	Created           string
+	Modified          string
+	Observables       []ObservableType
+}
+
+type ObservableType struct {
+	Type    string
+	Value   string
+	Created string
+}
+
+// This type holds a remote source that is fetched in to a collection. The
+// ETag and Last-Modified values are the ones the source sent with the content
+// that is stored, they are sent back so it can answer 304 Not Modified.
+type FeedType struct {
+	CollectionName string
+	Address        string
+	Interval       int    // Seconds between fetches
+	Parser         string // Name of the parser for the content
+	ParserOptions  string
+	ETag           string
+	LastModified   string
+	LastFetched    string // When the source was last asked for the content
+	LastSuccess    string // When the source last answered without an error
+	LastError      string // Empty if the last fetch worked
+	Suppressed     int    // Values the last fetch left out because of the allowlist
+}
+
+// This type holds a value that was left out of a collection because it could
+// not be classified or is on the allowlist. The source is the feed address or
+// file it came from.
+type QuarantineType struct {
+	CollectionName string
+	Source         string
+	Value          string
+	Reason         string
+	Created        string
+}
+
+// This type holds a value that is never published. An entry without a
+// collection name applies to every collection. The type is cidr, domain or
+// regex.
+type AllowlistEntryType struct {
+	Id             int64
+	CollectionName string
+	Type           string
+	Value          string
+	Comment        string
+	Created        string
+}
+
+// This type holds a partner that connects with a client certificate. The
+// certificate matches if its subject is the same as the subject, or if one of
+// its subject alternative names is the same as the SAN. A blank subject or SAN
+// never matches.
+type IdentityType struct {
+	Id      int64
+	Name    string
+	Subject string // Distinguished name in the form CN=partner,O=Example
+	San     string // DNS name, email address, URI or IP address
+	Created string
+}
+
+// This type holds a user that can sign in with a password or an API token.
+// The password hash is a bcrypt hash, a user without one can only use tokens.
+type UserType struct {
+	Id           int64
+	Username     string
+	PasswordHash string
+	Disabled     bool
+	Admin        bool // May use the admin API
+	Created      string
+}
+
+// This type holds an API token of a user. Only the SHA-256 hash of the token
+// is stored, the token itself is only shown when it is issued.
+type ApiTokenType struct {
+	Id          int64
+	Username    string
+	TokenHash   string
+	Description string
+	Created     string
+	Revoked     bool
+}
+
+// This type holds a user or an identity, the type is one of the PRINCIPAL_TYPE
+// constants
+type PrincipalType struct {
+	Type string
+	Name string
+}
+
+// This type holds a group of users and identities
+type GroupType struct {
+	Id      int64
+	Name    string
+	Members []PrincipalType
+	Created string
+}
+
+// This type holds a permission on a collection for a user, an identity or a
+// group
+type GrantType struct {
+	Id             int64
+	CollectionName string
+	GranteeType    string // user, identity or group
+	Grantee        string
+	Permission     string // read or write
+	Created        string
+}
+
+// --------------------------------------------------
+// Open a Store
+// --------------------------------------------------
+// The data source is the file name for SQLite, the connection string for
+// PostgreSQL and is not used for memory.
+
+func Open(backend, dataSource string) (StoreType, error) {
+	switch backend {
+	case BACKEND_SQLITE, "":
+		return NewSqliteStore(dataSource)
+	case BACKEND_POSTGRES:
+		return NewPostgresStore(dataSource)
+	case BACKEND_MEMORY:
+		return NewMemoryStore(), nil
+	}
+	return nil, errors.New("unknown storage backend " + backend)
+}
@@ -0,0 +1,761 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ----------------------------------------------------------------------
+// Every test is run against the memory store and an SQLite database made
+// from the migrations, so both backends behave the same way. When
+// FREETAXII_TEST_POSTGRES holds a lib/pq connection string in the key=value
+// form, every test is also run against PostgreSQL in a schema of its own
+// that is dropped afterwards.
+// ----------------------------------------------------------------------
+
+const POSTGRES_TEST_ENV = "FREETAXII_TEST_POSTGRES"
+
+func forEachStore(t *testing.T, test func(t *testing.T, store StoreType)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+
+	t.Run("sqlite3", func(t *testing.T) {
+		store, err := NewSqliteStore(filepath.Join(t.TempDir(), "freetaxii.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		_, err = store.Migrate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, store)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		connection := os.Getenv(POSTGRES_TEST_ENV)
+		if connection == "" {
+			t.Skip(POSTGRES_TEST_ENV + " is not set")
+		}
+		store := newPostgresTestStore(t, connection)
+
+		_, err := store.Migrate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, store)
+	})
+}
+
+// newPostgresTestStore returns a store that only sees a new, empty schema
+func newPostgresTestStore(t *testing.T, connection string) *SqlStoreType {
+	admin, err := NewPostgresStore(connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("freetaxii_test_%d", time.Now().UnixNano())
+	_, err = admin.db.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.db.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	store, err := NewPostgresStore(connection + " search_path=" + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func addTestCollections(t *testing.T, store StoreType, names ...string) {
+	for _, name := range names {
+		err := store.AddCollection(CollectionType{Name: name, Description: "Test collection " + name, Type: "DATA_SET"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStoreCollections(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store StoreType) {
+		addTestCollections(t, store, "first", "second")
+
+		if err := store.AddCollection(CollectionType{Name: "first"}); err == nil {
+			t.Errorf("a collection was added twice")
+		}
+
+		collections, err := store.GetCollections()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(collections) != 2 || collections["first"].Description != "Test collection first" {
+			t.Errorf("unexpected collections %+v", collections)
+		}
+
+		ok, err := store.SetCollection(CollectionType{Name: "first", Description: "Changed", Type: "DATA_FEED"})
+		if err != nil || !ok {
+			t.Fatalf("unable to change collection, %v", err)
+		}
+		collections, _ = store.GetCollections()
+		if collections["first"].Description != "Changed" || collections["first"].Type != "DATA_FEED" {
+			t.Errorf("the collection was not changed, %+v", collections["first"])
+		}
+
+		ok, err = store.SetCollection(CollectionType{Name: "missing"})
+		if err != nil || ok {
+			t.Errorf("changing a missing collection returned %v, %v", ok, err)
+		}
+
+		ok, err = store.DeleteCollection("first")
+		if err != nil || !ok {
+			t.Fatalf("unable to delete collection, %v", err)
+		}
+		ok, err = store.DeleteCollection("first")
+		if err != nil || ok {
+			t.Errorf("deleting a missing collection returned %v, %v", ok, err)
+		}
+		collections, _ = store.GetCollections()
+		if _, found := collections["first"]; found || len(collections) != 1 {
+			t.Errorf("unexpected collections after delete %+v", collections)
+		}
+	})
+}
+
+// A database from before the migrations has its own Collections, Services
+// and ServiceType tables, which the migrations must bring up to date.
+func TestMigrateBaselineDatabase(t *testing.T) {
+	store, err := NewSqliteStore(filepath.Join(t.TempDir(), "freetaxii.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, sqlstmt := range []string{
+		`CREATE TABLE "ServiceType" ("id" integer, "type" text NOT NULL, PRIMARY KEY("id"))`,
+		`CREATE TABLE "Services" ("id" integer, "typeid" integer NOT NULL, "available" integer NOT NULL,
+			"address" text NOT NULL, PRIMARY KEY("id"))`,
+		`CREATE TABLE "Collections" ("id" integer, "collection" text, "description" text, "type" text,
+			"location" text, "address" text, PRIMARY KEY("id"))`,
+		`INSERT INTO ServiceType (id, type) VALUES (1, 'POLL')`,
+		`INSERT INTO Services (id, typeid, available, address) VALUES (1, 1, 1, 'http://localhost/services/poll/')`,
+		`INSERT INTO Collections (id, collection, description, type, location, address)
+			VALUES (1, 'baseline', 'From before the migrations', 'DATA_SET', '', '')`,
+	} {
+		_, err = store.db.Exec(sqlstmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = store.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collections, err := store.GetCollections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collections["baseline"].Description != "From before the migrations" {
+		t.Errorf("unexpected collections %+v", collections)
+	}
+
+	// Running them again changes nothing
+	_, err = store.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreDeleteCollectionRemovesEverything(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store StoreType) {
+		// The collection that is deleted has the highest ID, the one a
+		// store that reuses IDs would hand out next
+		addTestCollections(t, store, "kept", "doomed")
+		created := "2015-06-01T00:00:00.000000Z"
+
+		for _, name := range []string{"doomed", "kept"} {
+			block := ContentBlockType{ContentBinding: "urn:a", Content: name, TimestampLabel: created}
+			if err := store.AddContentBlock(name, block); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.AddSubscription(SubscriptionType{SubscriptionId: "sub-" + name, CollectionName: name, Status: SUBSCRIPTION_STATUS_ACTIVE,
+				ResponseType: "FULL", InboxAddress: "https://inbox.example.com/", Created: created}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.AddGrant(GrantType{CollectionName: name, GranteeType: PRINCIPAL_TYPE_USER, Grantee: "alice", Permission: PERMISSION_READ}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := store.AddDeliveryLog(DeliveryLogType{SubscriptionId: "sub-doomed", Attempted: created, Result: "SUCCESS"}); err != nil {
+			t.Fatal(err)
+		}
+		rs := ResultSetType{ResultId: "rs-1", CollectionName: "doomed", Parts: 1, Status: "COMPLETE", Created: created, Expires: "2099-01-01T00:00:00.000000Z"}
+		if err := store.AddResultSet(rs, [][]ContentBlockType{{{ContentBinding: "urn:a", Content: "part"}}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AddIndicator("doomed", IndicatorType{Title: "doomed", Created: created, Modified: created,
+			Observables: []ObservableType{{"IP Address", "198.51.100.1", created}}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AddFeed(FeedType{CollectionName: "doomed", Address: "https://feed.example.com/"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetFeedObservables("doomed", []ObservableType{{"IP Address", "192.0.2.1", created}}, created); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetQuarantine("doomed", "https://feed.example.com/", []QuarantineType{{Value: "bad", Reason: "not valid", Created: created}}); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"doomed", ""} {
+			if err := store.AddAllowlistEntry(AllowlistEntryType{CollectionName: name, Type: "IP Address", Value: "192.0.2.99", Created: created}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var oldId int64
+		sqlStore, isSql := store.(*SqlStoreType)
+		if isSql {
+			oldId, _, _ = sqlStore.collectionId(sqlStore.db, "doomed")
+		}
+
+		ok, err := store.DeleteCollection("doomed")
+		if err != nil || !ok {
+			t.Fatalf("unable to delete collection, %v", err)
+		}
+
+		// A new collection with the same name starts out empty
+		addTestCollections(t, store, "doomed")
+
+		if isSql {
+			newId, _, _ := sqlStore.collectionId(sqlStore.db, "doomed")
+			if newId == oldId {
+				t.Errorf("the ID %d of the deleted collection was used again", oldId)
+			}
+		}
+
+		if blocks, _ := store.GetContentBlocksAfter("doomed", 0); len(blocks) != 0 {
+			t.Errorf("content was left behind, %+v", blocks)
+		}
+		if subs, _ := store.GetSubscriptions("doomed"); len(subs) != 0 {
+			t.Errorf("subscriptions were left behind, %+v", subs)
+		}
+		if _, ok, _ := store.GetSubscription("sub-doomed"); ok {
+			t.Errorf("the subscription can still be found")
+		}
+		if _, ok, _ := store.GetResultSet("rs-1", created); ok {
+			t.Errorf("the result set can still be found")
+		}
+		if indicators, _ := store.GetIndicators("doomed"); len(indicators) != 0 {
+			t.Errorf("indicators were left behind, %+v", indicators)
+		}
+		if feeds, _ := store.GetFeeds(); len(feeds) != 0 {
+			t.Errorf("the feed was left behind, %+v", feeds)
+		}
+		if entries, _ := store.GetQuarantine("doomed"); len(entries) != 0 {
+			t.Errorf("the quarantine was left behind, %+v", entries)
+		}
+		// The SQL stores come with entries for every collection of their own
+		entries, _ := store.GetAllowlist("doomed")
+		global := 0
+		for _, value := range entries {
+			if value.CollectionName != "" {
+				t.Errorf("an allowlist entry was left behind, %+v", value)
+			}
+			if value.Value == "192.0.2.99" {
+				global++
+			}
+		}
+		if global != 1 {
+			t.Errorf("expected the allowlist entry for every collection to be kept")
+		}
+		if grants, _ := store.GetGrants("doomed"); len(grants) != 0 {
+			t.Errorf("grants were left behind, %+v", grants)
+		}
+
+		// The other collection is not touched
+		if blocks, _ := store.GetContentBlocksAfter("kept", 0); len(blocks) != 1 {
+			t.Errorf("expected the content of the other collection to be kept, got %d blocks", len(blocks))
+		}
+		if subs, _ := store.GetSubscriptions("kept"); len(subs) != 1 {
+			t.Errorf("expected the subscription of the other collection to be kept, got %d", len(subs))
+		}
+		if grants, _ := store.GetGrants("kept"); len(grants) != 1 {
+			t.Errorf("expected the grant of the other collection to be kept, got %d", len(grants))
+		}
+	})
+}
+
+func TestStoreServices(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store StoreType) {
+		existing, err := store.GetServices()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pollId, err := store.AddService(ServiceType{ServiceType: SERVICE_TYPE_POLL, Available: true, Address: "http://localhost/poll"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		inboxId, err := store.AddService(ServiceType{ServiceType: SERVICE_TYPE_INBOX, Address: "http://localhost/inbox"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pollId <= 0 || inboxId <= 0 || pollId == inboxId {
+			t.Fatalf("services were given the IDs %d and %d", pollId, inboxId)
+		}
+
+		if _, err := store.AddService(ServiceType{ServiceType: "Unknown"}); err == nil {
+			t.Errorf("a service with an unknown type was added")
+		}
+
+		services, _ := store.GetServices()
+		if len(services) != len(existing)+2 {
+			t.Fatalf("expected %d services, got %d", len(existing)+2, len(services))
+		}
+		for _, value := range services {
+			if value.Id == pollId && (value.ServiceType != SERVICE_TYPE_POLL || !value.Available || value.Address != "http://localhost/poll") {
+				t.Errorf("unexpected poll service %+v", value)
+			}
+			if value.Id == inboxId && (value.ServiceType != SERVICE_TYPE_INBOX || value.Available) {
+				t.Errorf("unexpected inbox service %+v", value)
+			}
+		}
+
+		ok, err := store.SetService(ServiceType{Id: inboxId, ServiceType: SERVICE_TYPE_INBOX, Available: true, Address: "http://localhost/inbox2"})
+		if err != nil || !ok {
+			t.Fatalf("unable to change service, %v", err)
+		}
+
+		ok, err = store.DeleteService(pollId)
+		if err != nil || !ok {
+			t.Fatalf("unable to delete service, %v", err)
+		}
+		ok, err = store.DeleteService(pollId)
+		if err != nil || ok {
+			t.Errorf("deleting a missing service returned %v, %v", ok, err)
+		}
+
+		services, _ = store.GetServices()
+		for _, value := range services {
+			if value.Id == pollId {
+				t.Errorf("the deleted service is still there")
+			}
+			if value.Id == inboxId && value.Address != "http://localhost/inbox2" {
+				t.Errorf("the service was not changed, %+v", value)
+			}
+		}
+	})
+}
+
+// A collection without its own poll address uses the first poll service,
+// even when there are several
+func TestStoreCollectionDefaultAddress(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store StoreType) {
+		addTestCollections(t, store, "first")
+		for _, address := range []string{"http://localhost/poll1", "http://localhost/poll2"} {
+			_, err := store.AddService(ServiceType{ServiceType: SERVICE_TYPE_POLL, Available: true, Address: address})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		services, err := store.GetServices()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want ServiceType
+		for _, value := range services {
+			if value.ServiceType == SERVICE_TYPE_POLL && (want.Id == 0 || value.Id < want.Id) {
+				want = value
+			}
+		}
+
+		collections, err := store.GetCollections()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if collections["first"].PollAddress != want.Address {
+			t.Errorf("poll address %q, want %q", collections["first"].PollAddress, want.Address)
+		}
+	})
+}
+
+func TestStoreContentBlocks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store StoreType) {
+		addTestCollections(t, store, "first", "second")
+
+		labels := []string{"2015-06-01T00:00:00.000000Z", "2015-06-02T00:00:00.000000Z", "2015-06-03T00:00:00.000000Z"}
+		for _, label := range labels {
+			for _, name := range []string{"first", "second"} {
+				err := store.AddContentBlock(name, ContentBlockType{ContentBinding: "urn:test", Content: name + " " + label, TimestampLabel: label})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := store.AddContentBlock("missing", ContentBlockType{}); err == nil {
+			t.Errorf("content was added to a missing collection")
+		}
+
+		blocks, err := store.GetContentBlocksAfter("first", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocks) != 3 {
+			t.Fatalf("expected 3 content blocks, got %d", len(blocks))
+		}
+		for i := 1; i < len(blocks); i++ {
+			if blocks[i].Id <= blocks[i-1].Id {
+				t.Errorf("content block IDs do not increase, %d after %d", blocks[i].Id, blocks[i-1].Id)
+			}
+			if blocks[i].Sequence <= blocks[i-1].Sequence {
+				t.Errorf("content block sequences do not increase, %d after %d", blocks[i].Sequence, blocks[i-1].Sequence)
+			}
+		}
+		if blocks[0].Content != "first "+labels[0] {
+			t.Errorf("unexpected content %q", blocks[0].Content)
+		}
+
+		after, _ := store.GetContentBlocksAfter("first", blocks[0].Sequence)
+		if len(after) != 2 || after[0].Id != blocks[1].Id {
+			t.Errorf("expected the last 2 content blocks, got %+v", after)
+		}
+
+		window, _ := store.GetContentBlocksInWindow("first", labels[0], labels[1])
+		if len(window) != 1 || window[0].TimestampLabel != labels[1] {
+			t.Errorf("expected only the content block at %s, got %+v", labels[1], window)
+		}
+
+		binding, _ := store.GetContentBlocksByBinding("second", "urn:test", labels[1])
+		if len(binding) != 1 || binding[0].Content != "second "+labels[2] {
+			t.Errorf("expected only the last content block, got %+v", binding)
+		}
+
+		collections, _ := store.GetCollections()
+		if collections["first"].Volume != 3 {
+			t.Errorf("expected a volume of 3, got %d", collections["first"].Volume)
+		}
+	})
+}
+
+// Content that was given a lower ID can be committed after content with a
+// higher one. It is still found after a cursor past the higher ID, as it is
+// numbered when it commits.
+func TestStoreContentBlocksInSeveralCollections(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store StoreType) {
+		addTestCollections(t, store, "first", "second")
+
+		blocks := []ContentBlockType{
+			{ContentBinding: "urn:test", Content: "one", TimestampLabel: "2015-06-01T00:00:00.000000Z"},
+			{ContentBinding: "urn:test", Content: "two", TimestampLabel: "2015-06-01T00:00:00.000000Z"},
+		}
+
+		// A missing collection stops the content being added to any of them
+		if err := store.AddContentBlocks([]string{"first", "missing", "second"}, blocks); err == nil {
+			t.Errorf("content was added with a missing collection")
+		}
+		for _, name := range []string{"first", "second"} {
+			if saved, _ := store.GetContentBlocksAfter(name, 0); len(saved) != 0 {
+				t.Errorf("collection %s has content from the failed add, %+v", name, saved)
+			}
+		}
+
+		if err := store.AddContentBlocks([]string{"first", "second"}, blocks); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"first", "second"} {
+			saved, err := store.GetContentBlocksAfter(name, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != 2 || saved[0].Content != "one" || saved[1].Content != "two" {
+				t.Errorf("collection %s has %+v", name, saved)
+			}
+		}
+	})
+}
+
+func TestStoreContentAfterLateCommit(t *testing.T) {
+	store, err := NewSqliteStore(filepath.Join(t.TempDir(), "freetaxii.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err = store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	addTestCollections(t, store, "first")
+	for _, content := range []string{"one", "two", "three"} {
+		err = store.AddContentBlock("first", ContentBlockType{Content: content, TimestampLabel: "2015-01-01T00:00:00Z"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocks, _ := store.GetContentBlocksAfter("first", 0)
+	cursor := blocks[2].Sequence
+
+	// "two" is committed last
+	_, err = store.db.Exec("UPDATE Catalog SET lastcontentsequence = lastcontentsequence + 1")
+	if err == nil {
+		_, err = store.db.Exec("UPDATE Content SET sequence = (SELECT lastcontentsequence FROM Catalog) WHERE content = 'two'")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := store.GetContentBlocksAfter("first", cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 1 || after[0].Content != "two" {
+		t.Errorf("expected the late content, got %+v", after)
+	}
+
+	after, _ = store.GetContentBlocksAfter("first", blocks[0].Sequence)
+	if len(after) != 2 || after[0].Content != "three" || after[1].Content != "two" {
+		t.Errorf("content is not in commit order, %+v", after)
+	}
+
+	// A new subscriber starts after the content committed last
+	err = store.AddSubscription(SubscriptionType{SubscriptionId: "sub", CollectionName: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, _, _ := store.GetSubscription("sub")
+	if sub.DeliveryCursor != after[1].Sequence {
+		t.Errorf("delivery cursor %d, want %d", sub.DeliveryCursor, after[1].Sequence)
+	}
+}
+
+func TestStoreSubscriptions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store StoreType) {
+		addTestCollections(t, store, "first")
+
+		var sub SubscriptionType
+		sub.SubscriptionId = "sub-1"
+		sub.CollectionName = "first"
+		sub.Status = SUBSCRIPTION_STATUS_ACTIVE
+		sub.ResponseType = "FULL"
+		sub.ContentBindings = []string{"urn:a", "urn:b"}
+		sub.InboxAddress = "https://inbox.example.com/"
+		sub.Created = "2015-06-01T00:00:00.000000Z"
+		sub.Owner = PrincipalType{Type: PRINCIPAL_TYPE_USER, Name: "alice"}
+		if err := store.AddSubscription(sub); err != nil {
+			t.Fatal(err)
+		}
+
+		pull := sub
+		pull.SubscriptionId = "sub-2"
+		pull.InboxAddress = ""
+		if err := store.AddSubscription(pull); err != nil {
+			t.Fatal(err)
+		}
+
+		found, ok, err := store.GetSubscription("sub-1")
+		if err != nil || !ok {
+			t.Fatalf("unable to read subscription, %v", err)
+		}
+		if found.CollectionName != "first" || len(found.ContentBindings) != 2 || found.InboxAddress != sub.InboxAddress || found.Owner != sub.Owner {
+			t.Errorf("unexpected subscription %+v", found)
+		}
+		if _, ok, _ := store.GetSubscription("missing"); ok {
+			t.Errorf("a missing subscription was found")
+		}
+
+		subs, _ := store.GetSubscriptions("first")
+		if len(subs) != 2 {
+			t.Errorf("expected 2 subscriptions, got %d", len(subs))
+		}
+
+		push, _ := store.GetPushSubscriptions()
+		if len(push) != 1 || push[0].SubscriptionId != "sub-1" {
+			t.Errorf("expected only sub-1 to be pushed to, got %+v", push)
+		}
+
+		if err := store.SetDeliveryCursor("sub-1", 42); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetSubscriptionStatus("sub-1", "PAUSED"); err != nil {
+			t.Fatal(err)
+		}
+		found, _, _ = store.GetSubscription("sub-1")
+		if found.DeliveryCursor != 42 || found.Status != "PAUSED" {
+			t.Errorf("the subscription was not changed, %+v", found)
+		}
+
+		push, _ = store.GetPushSubscriptions()
+		if len(push) != 0 {
+			t.Errorf("a paused subscription is pushed to")
+		}
+
+		err = store.AddDeliveryLog(DeliveryLogType{SubscriptionId: "sub-1", Attempted: sub.Created, FirstContentId: 1, LastContentId: 2, Blocks: 2, Result: "SUCCESS"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestStoreIndicators(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store StoreType) {
+		addTestCollections(t, store, "first", "second")
+
+		// Add the indicators of both collections in turn, so the observables
+		// have to be saved with the ID of the right indicator
+		for i, value := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"} {
+			for _, name := range []string{"first", "second"} {
+				var indicator IndicatorType
+				indicator.Title = name
+				indicator.Created = "2015-06-01T00:00:00.000000Z"
+				indicator.Modified = indicator.Created
+				indicator.Observables = []ObservableType{{"IP Address", value, indicator.Created}}
+				if i == 0 {
+					indicator.Observables = append(indicator.Observables, ObservableType{"Domain Name", name + ".example.com", indicator.Created})
+				}
+				if err := store.AddIndicator(name, indicator); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		has, err := store.HasIndicators("first")
+		if err != nil || !has {
+			t.Errorf("the collection does not have indicators, %v", err)
+		}
+
+		indicators, err := store.GetIndicators("second")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(indicators) != 3 {
+			t.Fatalf("expected 3 indicators, got %d", len(indicators))
+		}
+		if len(indicators[0].Observables) != 2 || indicators[0].Observables[1].Value != "second.example.com" {
+			t.Errorf("the first indicator has the wrong observables, %+v", indicators[0].Observables)
+		}
+		for i, value := range indicators {
+			if value.Title != "second" {
+				t.Errorf("indicator %d is from the wrong collection, %q", i, value.Title)
+			}
+			if value.Observables[0].Value != []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"}[i] {
+				t.Errorf("indicator %d has the wrong observables, %+v", i, value.Observables)
+			}
+		}
+
+		if err := store.AddIndicator("missing", IndicatorType{}); err == nil {
+			t.Errorf("an indicator was added to a missing collection")
+		}
+	})
+}
+
+func TestStoreFeedObservables(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store StoreType) {
+		addTestCollections(t, store, "feed")
+
+		created := "2015-06-01T00:00:00.000000Z"
+		analyst := IndicatorType{Title: "analyst", Created: created, Modified: created,
+			Observables: []ObservableType{{"IP Address", "198.51.100.1", created}}}
+		if err := store.AddIndicator("feed", analyst); err != nil {
+			t.Fatal(err)
+		}
+
+		observables := []ObservableType{{"IP Address", "192.0.2.1", created}}
+		if err := store.SetFeedObservables("feed", observables, created); err == nil {
+			t.Errorf("observables were saved for a collection without a feed")
+		}
+
+		if err := store.AddFeed(FeedType{CollectionName: "feed", Address: "https://feed.example.com/"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The second fetch has to replace the values of the first
+		for _, value := range []string{"192.0.2.1", "192.0.2.2"} {
+			observables := []ObservableType{{"IP Address", value, created}}
+			if err := store.SetFeedObservables("feed", observables, "2015-06-02T00:00:00.000000Z"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		indicators, err := store.GetIndicators("feed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(indicators) != 2 {
+			t.Fatalf("expected 2 indicators, got %d", len(indicators))
+		}
+		if indicators[0].Title != "analyst" || len(indicators[0].Observables) != 1 || indicators[0].Observables[0].Value != "198.51.100.1" ||
+			indicators[0].Modified != created {
+			t.Errorf("the feed changed an indicator it does not own, %+v", indicators[0])
+		}
+		if indicators[1].Title != "feed" || len(indicators[1].Observables) != 1 || indicators[1].Observables[0].Value != "192.0.2.2" {
+			t.Errorf("the indicator of the feed is wrong, %+v", indicators[1])
+		}
+	})
+}
+
+func TestStoreUsersAndTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store StoreType) {
+		if err := store.AddUser(UserType{Username: "alice", PasswordHash: "x", Created: "2015-06-01T00:00:00.000000Z"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AddUser(UserType{Username: "alice"}); err == nil {
+			t.Errorf("a user was added twice")
+		}
+
+		first, err := store.AddApiToken(ApiTokenType{Username: "alice", TokenHash: "hash-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := store.AddApiToken(ApiTokenType{Username: "alice", TokenHash: "hash-2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first <= 0 || first == second {
+			t.Fatalf("tokens were given the IDs %d and %d", first, second)
+		}
+		if _, err := store.AddApiToken(ApiTokenType{Username: "bob", TokenHash: "hash-3"}); err == nil {
+			t.Errorf("a token was added for a missing user")
+		}
+
+		user, ok, err := store.GetUserByToken("hash-2")
+		if err != nil || !ok || user.Username != "alice" {
+			t.Errorf("the token did not find the user, %+v %v", user, err)
+		}
+
+		ok, err = store.RevokeApiToken(first)
+		if err != nil || !ok {
+			t.Fatalf("unable to revoke token, %v", err)
+		}
+		if _, ok, _ := store.GetUserByToken("hash-1"); ok {
+			t.Errorf("a revoked token still finds the user")
+		}
+		if _, ok, _ := store.GetUserByToken("hash-2"); !ok {
+			t.Errorf("the wrong token was revoked")
+		}
+
+		ok, err = store.SetUserAdmin("alice", true)
+		if err != nil || !ok {
+			t.Fatalf("unable to give the admin role, %v", err)
+		}
+		user, _, _ = store.GetUser("alice")
+		if !user.Admin {
+			t.Errorf("the user does not have the admin role")
+		}
+	})
+}
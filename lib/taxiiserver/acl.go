@@ -0,0 +1,122 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"log"
+	"net/http"
+)
+
+// ----------------------------------------------------------------------
+// Define Permissions Type
+// ----------------------------------------------------------------------
+// The permissions a request has on each collection. When access control is
+// turned off every request can read and write every collection.
+
+type permissionsType struct {
+	unrestricted bool
+	collections  map[string]map[string]bool
+}
+
+// --------------------------------------------------
+// Check a Permission
+// --------------------------------------------------
+// A blank permission is allowed if the request has any permission at all on
+// the collection.
+
+func (this permissionsType) allows(collectionName, permission string) bool {
+	if this.unrestricted {
+		return true
+	}
+	if permission == "" {
+		return len(this.collections[collectionName]) > 0
+	}
+	return this.collections[collectionName][permission]
+}
+
+// --------------------------------------------------
+// Get the User or Identity of a Request
+// --------------------------------------------------
+// A client certificate identity comes first, as a request that has one does
+// not need to sign in as a user.
+
+func requestPrincipal(r *http.Request) (storage.PrincipalType, bool) {
+	if identity, ok := IdentityFromRequest(r); ok {
+		return storage.PrincipalType{Type: storage.PRINCIPAL_TYPE_IDENTITY, Name: identity.Name}, true
+	}
+	if user, ok := UserFromRequest(r); ok {
+		return storage.PrincipalType{Type: storage.PRINCIPAL_TYPE_USER, Name: user.Username}, true
+	}
+	return storage.PrincipalType{}, false
+}
+
+// --------------------------------------------------
+// Get the Permissions of a Request
+// --------------------------------------------------
+// Anonymous requests have no permissions.
+
+func (this *ServerType) getPermissions(r *http.Request) permissionsType {
+	principal, _ := requestPrincipal(r)
+	return this.getPrincipalPermissions(principal)
+}
+
+// --------------------------------------------------
+// Get the Permissions of a User or Identity
+// --------------------------------------------------
+// A blank principal has no permissions. If the grants can not be read the
+// principal is treated as having none, rather than as having all of them.
+
+func (this *ServerType) getPrincipalPermissions(principal storage.PrincipalType) permissionsType {
+	var p permissionsType
+	p.collections = make(map[string]map[string]bool)
+
+	if !this.SysConfig.Auth.Acl {
+		p.unrestricted = true
+		return p
+	}
+
+	if principal.Name == "" {
+		return p
+	}
+
+	grants, err := this.Store.GetPrincipalGrants(principal)
+	if err != nil {
+		log.Printf("error reading the grants of %s %s, %v", principal.Type, principal.Name, err)
+		return p
+	}
+
+	for _, grant := range grants {
+		if p.collections[grant.CollectionName] == nil {
+			p.collections[grant.CollectionName] = make(map[string]bool)
+		}
+		p.collections[grant.CollectionName][grant.Permission] = true
+	}
+	return p
+}
+
+// --------------------------------------------------
+// Get the Collections a Request may Use
+// --------------------------------------------------
+// Only the collections the request has the permission on, a blank permission
+// gets every collection it has any permission on. Collections that are left
+// out should be treated as if they do not exist.
+
+func (this *ServerType) getPermittedCollections(r *http.Request, permission string) map[string]storage.CollectionType {
+	collections := this.getValidCollections()
+	if !this.SysConfig.Auth.Acl {
+		return collections
+	}
+
+	p := this.getPermissions(r)
+	for name := range collections {
+		if !p.allows(name, permission) {
+			delete(collections, name)
+		}
+	}
+	return collections
+}
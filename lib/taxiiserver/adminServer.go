@@ -7,28 +7,615 @@
 package taxiiserver
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	MAX_ADMIN_REQUEST_SIZE = 1048576
+)
+
+// ----------------------------------------------------------------------
+// Define Admin API Types
+// ----------------------------------------------------------------------
+// The JSON forms of collections and services. Volume and content bindings
+// come from the content of the collection and are ignored when a collection
+// is added or changed.
+
+type AdminCollectionType struct {
+	Name                string   `json:"name"`
+	Description         string   `json:"description"`
+	Type                string   `json:"type"`
+	Location            string   `json:"location,omitempty"`
+	Address             string   `json:"address,omitempty"`
+	ContentBinding      string   `json:"content_binding,omitempty"`
+	ContentBindings     []string `json:"content_bindings,omitempty"`
+	Volume              int      `json:"volume"`
+	PollAddress         string   `json:"poll_address,omitempty"`
+	InboxAddress        string   `json:"inbox_address,omitempty"`
+	SubscriptionAddress string   `json:"subscription_address,omitempty"`
+}
+
+type AdminServiceType struct {
+	Id          int64  `json:"id"`
+	ServiceType string `json:"service_type"`
+	Available   bool   `json:"available"`
+	Address     string `json:"address"`
+}
+
+type AdminCollectionStatsType struct {
+	Name          string `json:"name"`
+	Volume        int    `json:"volume"`
+	Subscriptions int    `json:"subscriptions"`
+}
+
+type AdminStatsType struct {
+	Started           string                     `json:"started"`
+	UptimeSeconds     int64                      `json:"uptime_seconds"`
+	CatalogGeneration int64                      `json:"catalog_generation"`
+	Collections       int                        `json:"collections"`
+	ContentBlocks     int                        `json:"content_blocks"`
+	Services          int                        `json:"services"`
+	Users             int                        `json:"users"`
+	Subscriptions     int                        `json:"subscriptions"`
+	AsyncPollQueue    int                        `json:"async_poll_queue"`
+	Goroutines        int                        `json:"goroutines"`
+	HeapBytes         uint64                     `json:"heap_bytes"`
+	CollectionStats   []AdminCollectionStatsType `json:"collection_stats"`
+}
+
+type adminErrorType struct {
+	Error string `json:"error"`
+}
+
+// --------------------------------------------------
+// Admin API Handler
+// --------------------------------------------------
+// A JSON API for admins, served under the admin path:
+//
+//	GET                collections        list the collections
+//	POST               collections        add a collection
+//	GET, PUT, DELETE   collections/<name> read, change or delete a collection
+//	GET                services           list the services
+//	POST               services           add a service
+//	GET, PUT, DELETE   services/<id>      read, change or delete a service
+//	POST               reload             read the collections and services again
+//	GET                stats              server statistics
+//
+// Every request has to sign in as a user with the admin role, even when
+// authentication is turned off for the TAXII services.
+
 func (this *ServerType) AdminServerHandler(w http.ResponseWriter, r *http.Request) {
 	if this.SysConfig.Logging.LogLevel >= 3 {
-		log.Printf("DEBUG-3: Found Message on Admin Server Handler from %s", r.RemoteAddr)
+		log.Printf("DEBUG-3: Found Message on Admin Server Handler from %s for %s", r.RemoteAddr, r.URL.Path)
 	}
 
-	urlValues, _ := url.ParseQuery(r.URL.RawQuery)
+	user, ok := this.authenticateUser(r)
+	if !ok {
+		realm := this.SysConfig.Auth.Realm
+		if realm == "" {
+			realm = DEFAULT_AUTH_REALM
+		}
+		w.Header().Add("WWW-Authenticate", `Basic realm="`+realm+`"`)
+		w.Header().Add("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+		this.sendAdminError(w, http.StatusUnauthorized, "Authentication is required")
+		return
+	}
+	if !user.Admin {
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Printf("DEBUG-1: User %s from %s is not an admin", user.Username, r.RemoteAddr)
+		}
+		this.sendAdminError(w, http.StatusForbidden, "The admin role is required")
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
 
-	// TODO look in to moving this to a JSON objet instead of URL parameters
-	if val, ok := urlValues["reloadservices"]; ok {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(this.SysConfig.Services.Admin, "/")), "/")
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
 
-		if val[0] == "true" {
+	switch {
+	case len(parts) == 1 && parts[0] == "collections":
+		if this.verifyAdminMethod(w, r, "GET", "POST") {
+			if r.Method == "POST" {
+				this.adminAddCollection(w, r)
+			} else {
+				this.adminCollections(w, r)
+			}
+		}
+	case len(parts) == 2 && parts[0] == "collections":
+		if this.verifyAdminMethod(w, r, "GET", "PUT", "DELETE") {
+			switch r.Method {
+			case "PUT":
+				this.adminSetCollection(w, r, parts[1])
+			case "DELETE":
+				this.adminDeleteCollection(w, r, parts[1])
+			default:
+				this.adminCollection(w, parts[1], http.StatusOK)
+			}
+		}
+	case len(parts) == 1 && parts[0] == "services":
+		if this.verifyAdminMethod(w, r, "GET", "POST") {
+			if r.Method == "POST" {
+				this.adminAddService(w, r)
+			} else {
+				this.adminServices(w, r)
+			}
+		}
+	case len(parts) == 2 && parts[0] == "services":
+		id, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			this.sendAdminError(w, http.StatusNotFound, "The service \""+parts[1]+"\" does not exist")
+			return
+		}
+		if this.verifyAdminMethod(w, r, "GET", "PUT", "DELETE") {
+			switch r.Method {
+			case "PUT":
+				this.adminSetService(w, r, id)
+			case "DELETE":
+				this.adminDeleteService(w, r, id)
+			default:
+				this.adminService(w, r, id)
+			}
+		}
+	case len(parts) == 1 && parts[0] == "reload":
+		if this.verifyAdminMethod(w, r, "POST") {
 			if this.SysConfig.Logging.LogLevel >= 3 {
-				log.Println("DEBUG-3: Setting Reload Services to true via admin console")
+				log.Printf("DEBUG-3: Reloading collections and services for admin %s", user.Username)
 			}
+			this.ReloadCatalog()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case len(parts) == 1 && parts[0] == "stats":
+		if this.verifyAdminMethod(w, r, "GET") {
+			this.adminStats(w, r)
+		}
+	default:
+		this.sendAdminError(w, http.StatusNotFound, "The requested resource does not exist")
+	}
+}
+
+// ----------------------------------------------------------------------
+// Collections
+// ----------------------------------------------------------------------
+
+func (this *ServerType) adminCollections(w http.ResponseWriter, r *http.Request) {
+	collections, err := this.Store.GetCollections()
+	if err != nil {
+		log.Printf("error reading collections, %v", err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to read the collections")
+		return
+	}
+
+	list := make([]AdminCollectionType, 0, len(collections))
+	for _, collection := range collections {
+		list = append(list, newAdminCollection(collection))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	this.sendAdminResource(w, http.StatusOK, list)
+}
+
+func (this *ServerType) adminCollection(w http.ResponseWriter, name string, httpStatus int) {
+	collections, err := this.Store.GetCollections()
+	if err != nil {
+		log.Printf("error reading collections, %v", err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to read the collections")
+		return
+	}
+
+	collection, ok := collections[name]
+	if !ok {
+		this.sendAdminError(w, http.StatusNotFound, "The collection \""+name+"\" does not exist")
+		return
+	}
+	this.sendAdminResource(w, httpStatus, newAdminCollection(collection))
+}
+
+func (this *ServerType) adminAddCollection(w http.ResponseWriter, r *http.Request) {
+	var c AdminCollectionType
+	if !this.readAdminRequest(w, r, &c) {
+		return
+	}
+	if c.Name == "" || strings.Contains(c.Name, "/") {
+		this.sendAdminError(w, http.StatusBadRequest, "A collection name can not be blank or have a / in it")
+		return
+	}
+	if !this.verifyAdminCollection(w, &c) {
+		return
+	}
+
+	collections, err := this.Store.GetCollections()
+	if err != nil {
+		log.Printf("error reading collections, %v", err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to read the collections")
+		return
+	}
+	if _, ok := collections[c.Name]; ok {
+		this.sendAdminError(w, http.StatusConflict, "The collection \""+c.Name+"\" already exists")
+		return
+	}
+
+	err = this.Store.AddCollection(c.storageCollection())
+	if err != nil {
+		log.Printf("error adding collection %s, %v", c.Name, err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to add the collection")
+		return
+	}
+
+	// Requests see the change straight away rather than after the next
+	// catalog check
+	this.ReloadCatalog()
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Admin %s added collection %s", identityName(r), c.Name)
+	}
+	w.Header().Set("Location", strings.TrimSuffix(this.SysConfig.Services.Admin, "/")+"/collections/"+c.Name)
+	this.adminCollection(w, c.Name, http.StatusCreated)
+}
+
+func (this *ServerType) adminSetCollection(w http.ResponseWriter, r *http.Request, name string) {
+	var c AdminCollectionType
+	if !this.readAdminRequest(w, r, &c) {
+		return
+	}
+	if c.Name != "" && c.Name != name {
+		this.sendAdminError(w, http.StatusBadRequest, "A collection can not be renamed")
+		return
+	}
+	c.Name = name
+
+	// The whole collection is replaced, so a blank type is not taken to mean
+	// a data feed the way it is when a collection is added
+	if c.Type == "" {
+		this.sendAdminError(w, http.StatusBadRequest, "The collection type must be DATA_FEED or DATA_SET")
+		return
+	}
+	if !this.verifyAdminCollection(w, &c) {
+		return
+	}
+
+	ok, err := this.Store.SetCollection(c.storageCollection())
+	if err != nil {
+		log.Printf("error changing collection %s, %v", name, err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to change the collection")
+		return
+	}
+	if !ok {
+		this.sendAdminError(w, http.StatusNotFound, "The collection \""+name+"\" does not exist")
+		return
+	}
+
+	this.ReloadCatalog()
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Admin %s changed collection %s", identityName(r), name)
+	}
+	this.adminCollection(w, name, http.StatusOK)
+}
+
+func (this *ServerType) adminDeleteCollection(w http.ResponseWriter, r *http.Request, name string) {
+	ok, err := this.Store.DeleteCollection(name)
+	if err != nil {
+		log.Printf("error deleting collection %s, %v", name, err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to delete the collection")
+		return
+	}
+	if !ok {
+		this.sendAdminError(w, http.StatusNotFound, "The collection \""+name+"\" does not exist")
+		return
+	}
+
+	this.ReloadCatalog()
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Admin %s deleted collection %s", identityName(r), name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// A blank type is a data feed, the same as freetaxii-mgmt
+func (this *ServerType) verifyAdminCollection(w http.ResponseWriter, c *AdminCollectionType) bool {
+	if c.Type == "" {
+		c.Type = "DATA_FEED"
+	}
+	if c.Type != "DATA_FEED" && c.Type != "DATA_SET" {
+		this.sendAdminError(w, http.StatusBadRequest, "The collection type must be DATA_FEED or DATA_SET")
+		return false
+	}
+	return true
+}
+
+func newAdminCollection(collection storage.CollectionType) AdminCollectionType {
+	var c AdminCollectionType
+	c.Name = collection.Name
+	c.Description = collection.Description
+	c.Type = collection.Type
+	c.Location = collection.Location
+	c.Address = collection.Address
+	c.ContentBinding = collection.ContentBinding
+	c.ContentBindings = collection.ContentBindings
+	c.Volume = collection.Volume
+	c.PollAddress = collection.PollAddress
+	c.InboxAddress = collection.InboxAddress
+	c.SubscriptionAddress = collection.SubscriptionAddress
+	return c
+}
+
+func (this AdminCollectionType) storageCollection() storage.CollectionType {
+	var collection storage.CollectionType
+	collection.Name = this.Name
+	collection.Description = this.Description
+	collection.Type = this.Type
+	collection.Location = this.Location
+	collection.Address = this.Address
+	collection.ContentBinding = this.ContentBinding
+	collection.PollAddress = this.PollAddress
+	collection.InboxAddress = this.InboxAddress
+	collection.SubscriptionAddress = this.SubscriptionAddress
+	return collection
+}
+
+// ----------------------------------------------------------------------
+// Services
+// ----------------------------------------------------------------------
+
+func (this *ServerType) adminServices(w http.ResponseWriter, r *http.Request) {
+	services, err := this.Store.GetServices()
+	if err != nil {
+		log.Printf("error reading services, %v", err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to read the services")
+		return
+	}
 
-			this.ReloadServices = true
+	list := make([]AdminServiceType, 0, len(services))
+	for _, service := range services {
+		list = append(list, AdminServiceType{Id: service.Id, ServiceType: service.ServiceType, Available: service.Available, Address: service.Address})
+	}
+	this.sendAdminResource(w, http.StatusOK, list)
+}
+
+func (this *ServerType) adminService(w http.ResponseWriter, r *http.Request, id int64) {
+	services, err := this.Store.GetServices()
+	if err != nil {
+		log.Printf("error reading services, %v", err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to read the services")
+		return
+	}
+
+	for _, service := range services {
+		if service.Id == id {
+			this.sendAdminResource(w, http.StatusOK, AdminServiceType{Id: service.Id, ServiceType: service.ServiceType, Available: service.Available, Address: service.Address})
+			return
 		}
+	}
+	this.sendAdminError(w, http.StatusNotFound, "The service \""+strconv.FormatInt(id, 10)+"\" does not exist")
+}
+
+func (this *ServerType) adminAddService(w http.ResponseWriter, r *http.Request) {
+	var s AdminServiceType
+	if !this.readAdminRequest(w, r, &s) || !this.verifyAdminService(w, s) {
+		return
+	}
+
+	id, err := this.Store.AddService(storage.ServiceType{ServiceType: s.ServiceType, Available: s.Available, Address: s.Address})
+	if err != nil {
+		log.Printf("error adding the %s service, %v", s.ServiceType, err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to add the service")
+		return
+	}
+
+	this.ReloadCatalog()
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Admin %s added the %s service at %s", identityName(r), s.ServiceType, s.Address)
+	}
+	s.Id = id
+	w.Header().Set("Location", strings.TrimSuffix(this.SysConfig.Services.Admin, "/")+"/services/"+strconv.FormatInt(id, 10))
+	this.sendAdminResource(w, http.StatusCreated, s)
+}
+
+func (this *ServerType) adminSetService(w http.ResponseWriter, r *http.Request, id int64) {
+	var s AdminServiceType
+	if !this.readAdminRequest(w, r, &s) || !this.verifyAdminService(w, s) {
+		return
+	}
+	if s.Id != 0 && s.Id != id {
+		this.sendAdminError(w, http.StatusBadRequest, "The id of a service can not be changed")
+		return
+	}
+	s.Id = id
+
+	ok, err := this.Store.SetService(storage.ServiceType{Id: s.Id, ServiceType: s.ServiceType, Available: s.Available, Address: s.Address})
+	if err != nil {
+		log.Printf("error changing service %d, %v", id, err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to change the service")
+		return
+	}
+	if !ok {
+		this.sendAdminError(w, http.StatusNotFound, "The service \""+strconv.FormatInt(id, 10)+"\" does not exist")
+		return
+	}
+
+	this.ReloadCatalog()
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Admin %s changed service %d", identityName(r), id)
+	}
+	this.sendAdminResource(w, http.StatusOK, s)
+}
+
+func (this *ServerType) adminDeleteService(w http.ResponseWriter, r *http.Request, id int64) {
+	ok, err := this.Store.DeleteService(id)
+	if err != nil {
+		log.Printf("error deleting service %d, %v", id, err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to delete the service")
+		return
+	}
+	if !ok {
+		this.sendAdminError(w, http.StatusNotFound, "The service \""+strconv.FormatInt(id, 10)+"\" does not exist")
+		return
+	}
+
+	this.ReloadCatalog()
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Admin %s deleted service %d", identityName(r), id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The service types are the ones the Discovery service can offer
+func (this *ServerType) verifyAdminService(w http.ResponseWriter, s AdminServiceType) bool {
+	if !storage.ValidServiceType(s.ServiceType) {
+		this.sendAdminError(w, http.StatusBadRequest, "The service type must be Discovery, Collection, Subscription, Inbox or Poll")
+		return false
+	}
+	if s.Address == "" {
+		this.sendAdminError(w, http.StatusBadRequest, "A service address can not be blank")
+		return false
+	}
+	return true
+}
+
+// ----------------------------------------------------------------------
+// Statistics
+// ----------------------------------------------------------------------
+
+func (this *ServerType) adminStats(w http.ResponseWriter, r *http.Request) {
+	var stats AdminStatsType
+	now := time.Now().UTC()
+
+	if !this.StartTime.IsZero() {
+		stats.Started = this.StartTime.UTC().Format(time.RFC3339)
+		stats.UptimeSeconds = int64(now.Sub(this.StartTime) / time.Second)
+	}
+
+	generation, err := this.Store.GetCatalogGeneration()
+	if err != nil {
+		log.Printf("error reading catalog generation, %v", err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to read the statistics")
+		return
+	}
+	stats.CatalogGeneration = generation
+
+	collections, err := this.Store.GetCollections()
+	if err != nil {
+		log.Printf("error reading collections, %v", err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to read the statistics")
+		return
+	}
+	stats.Collections = len(collections)
+	stats.CollectionStats = make([]AdminCollectionStatsType, 0, len(collections))
+	for name, collection := range collections {
+		subscriptions, err := this.Store.GetSubscriptions(name)
+		if err != nil {
+			log.Printf("error reading subscriptions of %s, %v", name, err)
+			this.sendAdminError(w, http.StatusInternalServerError, "Unable to read the statistics")
+			return
+		}
+		stats.ContentBlocks += collection.Volume
+		stats.Subscriptions += len(subscriptions)
+		stats.CollectionStats = append(stats.CollectionStats, AdminCollectionStatsType{Name: name, Volume: collection.Volume, Subscriptions: len(subscriptions)})
+	}
+	sort.Slice(stats.CollectionStats, func(i, j int) bool { return stats.CollectionStats[i].Name < stats.CollectionStats[j].Name })
+
+	services, err := this.Store.GetServices()
+	if err != nil {
+		log.Printf("error reading services, %v", err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to read the statistics")
+		return
+	}
+	stats.Services = len(services)
+
+	users, err := this.Store.GetUsers()
+	if err != nil {
+		log.Printf("error reading users, %v", err)
+		this.sendAdminError(w, http.StatusInternalServerError, "Unable to read the statistics")
+		return
+	}
+	stats.Users = len(users)
+
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	stats.AsyncPollQueue = this.asyncPollQueueLength()
+	stats.Goroutines = runtime.NumGoroutine()
+	stats.HeapBytes = memory.HeapAlloc
+
+	this.sendAdminResource(w, http.StatusOK, stats)
+}
+
+// ----------------------------------------------------------------------
+// Requests and Responses
+// ----------------------------------------------------------------------
+
+func (this *ServerType) verifyAdminMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, value := range methods {
+		if r.Method == value {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	this.sendAdminError(w, http.StatusMethodNotAllowed, "The "+r.Method+" method is not supported on this resource")
+	return false
+}
+
+// Unknown fields are turned away so that a misspelt field is not silently
+// dropped
+func (this *ServerType) readAdminRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		this.sendAdminError(w, http.StatusUnsupportedMediaType, "The request body must be application/json")
+		return false
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MAX_ADMIN_REQUEST_SIZE+1))
+	if err != nil {
+		this.sendAdminError(w, http.StatusBadRequest, "Unable to read the request body")
+		return false
+	}
+	if len(body) > MAX_ADMIN_REQUEST_SIZE {
+		this.sendAdminError(w, http.StatusRequestEntityTooLarge, "The request body is too large")
+		return false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(v)
+	if err != nil {
+		this.sendAdminError(w, http.StatusBadRequest, "The request body is not valid, "+err.Error())
+		return false
+	}
+	return true
+}
+
+func (this *ServerType) sendAdminResource(w http.ResponseWriter, httpStatus int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("error encoding admin resource, %v", err)
+		httpStatus = http.StatusInternalServerError
+		data = []byte(`{"error":"Internal Error"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	w.Write(data)
+}
 
+func (this *ServerType) sendAdminError(w http.ResponseWriter, httpStatus int, msg string) {
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Println("DEBUG-1: Admin error", httpStatus, msg)
 	}
+	this.sendAdminResource(w, httpStatus, adminErrorType{Error: msg})
 }
@@ -0,0 +1,124 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+	"log"
+)
+
+const (
+	DEFAULT_ASYNC_POLL_WORKERS   = 2
+	DEFAULT_ASYNC_POLL_QUEUE     = 100
+	DEFAULT_ASYNC_ESTIMATED_WAIT = 30
+)
+
+// Collections with this location in the database are built from slow remote
+// sources and can be polled asynchronously.
+const (
+	COLLECTION_LOCATION_REMOTE = "Remote"
+)
+
+// --------------------------------------------------
+// Create a PENDING response for an Asynchronous Poll
+// --------------------------------------------------
+// A pending result set is saved so that Poll Fulfillment can find it, and a
+// worker builds the content in the background. The client gets a PENDING
+// status message with the result ID and how long it should wait.
+
+func (this *ServerType) createPendingPollResponse(responseid, collectionName, begin, end string, contentBindings []string) statusMessage.StatusMessageType {
+	this.asyncPollOnce.Do(this.startAsyncPollWorkers)
+
+	rs := this.newResultSet(collectionName, begin, end, RESULT_SET_PENDING)
+	rs.ContentBindings = contentBindings
+	err := this.saveResultSet(rs, nil)
+	if err != nil {
+		log.Printf("error saving pending result set for collection %s, %v", collectionName, err)
+		return this.CreateTaxiiStatusMessage(responseid, statusMessage.FAILURE, "Unable to start building the poll result")
+	}
+
+	// Never block the request goroutine waiting for a worker, if the queue is
+	// full the client is told to try again later.
+	select {
+	case this.asyncPollJobs <- rs:
+	default:
+		this.completeResultSet(rs.ResultId, nil, RESULT_SET_FAILED)
+		tm := this.CreateTaxiiStatusMessage(responseid, statusMessage.RETRY, "The server is busy, please try again later")
+		tm.AddEstimatedWait(this.estimatedWait())
+		return tm
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Queued result set %s for collection %s", rs.ResultId, collectionName)
+	}
+	return this.CreateTaxiiPendingStatusMessage(responseid, rs.ResultId, this.estimatedWait())
+}
+
+// --------------------------------------------------
+// Start the Asynchronous Poll Workers
+// --------------------------------------------------
+
+func (this *ServerType) startAsyncPollWorkers() {
+	workers := this.SysConfig.Poll.AsyncWorkers
+	if workers <= 0 {
+		workers = DEFAULT_ASYNC_POLL_WORKERS
+	}
+
+	this.asyncPollJobs = make(chan ResultSetType, DEFAULT_ASYNC_POLL_QUEUE)
+	for i := 0; i < workers; i++ {
+		go this.asyncPollWorker()
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Started %d asynchronous poll workers", workers)
+	}
+}
+
+// The queue is made when the workers are started, so they are started here
+// too rather than read the queue before it has safely been made.
+func (this *ServerType) asyncPollQueueLength() int {
+	this.asyncPollOnce.Do(this.startAsyncPollWorkers)
+	return len(this.asyncPollJobs)
+}
+
+func (this *ServerType) asyncPollWorker() {
+	for rs := range this.asyncPollJobs {
+		blocks := this.buildPollResult(rs.CollectionName, rs.Begin, rs.End, rs.ContentBindings)
+		parts := this.splitResultParts(blocks)
+
+		err := this.completeResultSet(rs.ResultId, parts, RESULT_SET_READY)
+		if err != nil {
+			log.Printf("error saving result set %s for collection %s, %v", rs.ResultId, rs.CollectionName, err)
+			this.completeResultSet(rs.ResultId, nil, RESULT_SET_FAILED)
+			continue
+		}
+
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Printf("DEBUG-1: Result set %s for collection %s is ready with %d parts", rs.ResultId, rs.CollectionName, len(parts))
+		}
+	}
+}
+
+// --------------------------------------------------
+// Get the Estimated Wait for an Asynchronous Poll
+// --------------------------------------------------
+
+func (this *ServerType) estimatedWait() int {
+	if this.SysConfig.Poll.EstimatedWait > 0 {
+		return this.SysConfig.Poll.EstimatedWait
+	}
+	return DEFAULT_ASYNC_ESTIMATED_WAIT
+}
+
+// --------------------------------------------------
+// Check if a Collection is built Asynchronously
+// --------------------------------------------------
+
+func (this *ServerType) collectionIsAsync(collectionName string) bool {
+	collection, ok := this.getValidCollections()[collectionName]
+	return ok && collection.Location == COLLECTION_LOCATION_REMOTE
+}
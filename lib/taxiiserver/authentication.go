@@ -0,0 +1,133 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"context"
+	"github.com/freetaxii/freetaxii-server/lib/auth"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	DEFAULT_AUTH_REALM = "FreeTAXII"
+)
+
+// The key used to store the user of a request in its context
+type userContextKey struct{}
+
+// --------------------------------------------------
+// Get the User of a Request
+// --------------------------------------------------
+// Only set for requests that signed in with a password or an API token
+
+func UserFromRequest(r *http.Request) (storage.UserType, bool) {
+	user, ok := r.Context().Value(userContextKey{}).(storage.UserType)
+	return user, ok
+}
+
+// --------------------------------------------------
+// Authentication Handler
+// --------------------------------------------------
+// Wraps a handler so that it is only reached by users that sign in with HTTP
+// Basic authentication or an API token sent as a Bearer token. A request that
+// already has an identity from its client certificate does not need to sign
+// in as well, so this has to be wrapped by WithIdentity. Disabled users and
+// revoked tokens get an UNAUTHORIZED answer, as does a request that does not
+// sign in at all.
+
+func (this *ServerType) WithAuthentication(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !this.SysConfig.Auth.Enabled {
+			next(w, r)
+			return
+		}
+
+		if _, ok := IdentityFromRequest(r); ok {
+			next(w, r)
+			return
+		}
+
+		user, ok := this.authenticateUser(r)
+		if !ok {
+			realm := this.SysConfig.Auth.Realm
+			if realm == "" {
+				realm = DEFAULT_AUTH_REALM
+			}
+			w.Header().Add("WWW-Authenticate", `Basic realm="`+realm+`"`)
+			w.Header().Add("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+			this.sendUnauthorized(w, r, "Authentication is required")
+			return
+		}
+
+		if this.SysConfig.Logging.LogLevel >= 3 {
+			log.Printf("DEBUG-3: Request from %s is from user %s", r.RemoteAddr, user.Username)
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	}
+}
+
+// --------------------------------------------------
+// Sign a User In
+// --------------------------------------------------
+// The reason a sign in failed is only logged, the client is not told whether
+// the user exists.
+
+func (this *ServerType) authenticateUser(r *http.Request) (storage.UserType, bool) {
+	var user storage.UserType
+	var ok bool
+	var err error
+
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		user, ok, err = this.Store.GetUserByToken(auth.HashApiToken(authorization[7:]))
+		if err != nil {
+			log.Printf("error looking up an API token, %v", err)
+			return user, false
+		}
+		if !ok {
+			if this.SysConfig.Logging.LogLevel >= 1 {
+				log.Printf("DEBUG-1: Unknown or revoked API token from %s", r.RemoteAddr)
+			}
+			return user, false
+		}
+	} else if username, password, basic := r.BasicAuth(); basic {
+		user, ok, err = this.Store.GetUser(username)
+		if err != nil {
+			log.Printf("error looking up user %s, %v", username, err)
+			return user, false
+		}
+		if !ok {
+			auth.CheckNoUser(password)
+			if this.SysConfig.Logging.LogLevel >= 1 {
+				log.Printf("DEBUG-1: Sign in from %s for user %s that does not exist", r.RemoteAddr, username)
+			}
+			return user, false
+		}
+		if !auth.CheckPassword(user.PasswordHash, password) {
+			if this.SysConfig.Logging.LogLevel >= 1 {
+				log.Printf("DEBUG-1: Wrong password from %s for user %s", r.RemoteAddr, username)
+			}
+			return user, false
+		}
+	} else {
+		if this.SysConfig.Logging.LogLevel >= 3 {
+			log.Printf("DEBUG-3: Request from %s did not sign in", r.RemoteAddr)
+		}
+		return user, false
+	}
+
+	if user.Disabled {
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Printf("DEBUG-1: Sign in from %s for user %s that is disabled", r.RemoteAddr, user.Username)
+		}
+		return user, false
+	}
+	return user, true
+}
@@ -0,0 +1,126 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freetaxii/freetaxii-server/lib/auth"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+)
+
+// newAuthTestServer returns a server that needs users to sign in, with the
+// user alice, the disabled user bob, and a good and a revoked token of alice
+func newAuthTestServer(t *testing.T) (*ServerType, string, string) {
+	s := newTestServer(t)
+	s.SysConfig.Auth.Enabled = true
+
+	for _, user := range []storage.UserType{{Username: "alice"}, {Username: "bob", Disabled: true}} {
+		hash, err := auth.HashPassword("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.PasswordHash = hash
+		if err = s.Store.AddUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		token, hash, err := auth.NewApiToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := s.Store.AddApiToken(storage.ApiTokenType{Username: "alice", TokenHash: hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			if _, err = s.Store.RevokeApiToken(id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tokens = append(tokens, token)
+	}
+	return s, tokens[0], tokens[1]
+}
+
+func TestWithAuthentication(t *testing.T) {
+	s, token, revokedToken := newAuthTestServer(t)
+	identity := storage.IdentityType{Name: "sensor"}
+
+	tests := []struct {
+		name          string
+		username      string
+		password      string
+		authorization string
+		identity      bool
+		want          string // The user or identity that reaches the handler, blank if none does
+	}{
+		{name: "password", username: "alice", password: "correct horse", want: "alice"},
+		{name: "wrong password", username: "alice", password: "wrong"},
+		{name: "unknown user", username: "carol", password: "correct horse"},
+		{name: "disabled user", username: "bob", password: "correct horse"},
+		{name: "token", authorization: "Bearer " + token, want: "alice"},
+		{name: "lower case bearer", authorization: "bearer " + token, want: "alice"},
+		{name: "unknown token", authorization: "Bearer ftx_unknown"},
+		{name: "revoked token", authorization: "Bearer " + revokedToken},
+		{name: "other scheme", authorization: "Digest username=\"alice\""},
+		{name: "missing header"},
+		{name: "identity", identity: true, want: "sensor"},
+		{name: "identity with a wrong password", identity: true, username: "alice", password: "wrong", want: "sensor"},
+		{name: "identity with a token", identity: true, authorization: "Bearer " + token, want: "sensor"},
+	}
+
+	for _, test := range tests {
+		var reached string
+		handler := s.WithAuthentication(func(w http.ResponseWriter, r *http.Request) {
+			reached = identityName(r)
+			if _, ok := UserFromRequest(r); ok && test.identity {
+				t.Errorf("%s: a request with an identity was also signed in as a user", test.name)
+			}
+		})
+
+		r := httptest.NewRequest("POST", "/services/poll/", nil)
+		if test.username != "" {
+			r.SetBasicAuth(test.username, test.password)
+		}
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		if test.identity {
+			r = r.WithContext(context.WithValue(r.Context(), identityContextKey{}, identity))
+		}
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if reached != test.want {
+			t.Errorf("%s: reached the handler as %q, want %q", test.name, reached, test.want)
+		}
+		if test.want == "" && len(w.Header()["Www-Authenticate"]) != 2 {
+			t.Errorf("%s: the answer did not ask to sign in, %v", test.name, w.Header())
+		}
+	}
+}
+
+func TestWithAuthenticationDisabled(t *testing.T) {
+	s, _, _ := newAuthTestServer(t)
+	s.SysConfig.Auth.Enabled = false
+
+	reached := false
+	handler := s.WithAuthentication(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/services/poll/", nil))
+	if !reached {
+		t.Errorf("a request did not reach the handler with authentication turned off")
+	}
+}
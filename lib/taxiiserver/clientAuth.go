@@ -0,0 +1,360 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/freetaxii/freetaxii-server/lib/headers"
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How often the revocation list file is checked for a new list
+	CRL_CHECK_INTERVAL = 10 * time.Second
+)
+
+// ----------------------------------------------------------------------
+// Define Client Verifier Type
+// ----------------------------------------------------------------------
+// Checks client certificates against the CA bundle and the revocation lists.
+// The revocation list file is checked once every check interval and read again
+// when it has changed, so a new list can be put in place without a restart. If
+// the new file can not be read the last good list is kept. Requests read the
+// current lists without taking a lock, the mutex only keeps two requests from
+// reading the file at the same time.
+
+type ClientVerifierType struct {
+	roots       *x509.CertPool
+	caCerts     []*x509.Certificate
+	crlFile     string
+	mutex       sync.Mutex
+	revocations atomic.Value // *revocationListsType
+}
+
+// The lists are never changed once they have been stored
+type revocationListsType struct {
+	modTime time.Time       // Of the file the lists were read from
+	checked time.Time       // When the file was last checked for changes
+	revoked map[string]bool // RawIssuer + serial number of each revoked certificate
+}
+
+// The key used to store the identity of a request in its context
+type identityContextKey struct{}
+
+// --------------------------------------------------
+// Create a Client Verifier
+// --------------------------------------------------
+// The revocation list file is optional.
+
+func NewClientVerifier(caFile, crlFile string) (*ClientVerifierType, error) {
+	var obj ClientVerifierType
+	obj.roots = x509.NewCertPool()
+	obj.crlFile = crlFile
+
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read a certificate in %s, %v", caFile, err)
+		}
+		obj.roots.AddCert(cert)
+		obj.caCerts = append(obj.caCerts, cert)
+	}
+	if len(obj.caCerts) == 0 {
+		return nil, errors.New("there are no certificates in " + caFile)
+	}
+
+	if crlFile != "" {
+		lists, err := obj.loadRevocationLists(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		obj.revocations.Store(lists)
+	}
+	return &obj, nil
+}
+
+// --------------------------------------------------
+// Verify a Client Certificate
+// --------------------------------------------------
+// The certificates are the ones the client sent, the first one is its own.
+// The error says why the certificate is not accepted.
+
+func (this *ClientVerifierType) Verify(certs []*x509.Certificate) (*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no client certificate was sent")
+	}
+	leaf := certs[0]
+
+	now := time.Now()
+	if now.After(leaf.NotAfter) {
+		return nil, errors.New("the client certificate expired at " + leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if now.Before(leaf.NotBefore) {
+		return nil, errors.New("the client certificate is not valid until " + leaf.NotBefore.UTC().Format(time.RFC3339))
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         this.roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	chains, err := leaf.Verify(opts)
+	if err != nil {
+		return nil, errors.New("the client certificate is not trusted, " + err.Error())
+	}
+
+	if this.crlFile == "" {
+		return leaf, nil
+	}
+
+	// Every certificate in the chain other than the CA itself is checked
+	revoked := this.getRevocationLists(now).revoked
+	for _, chain := range chains {
+		for _, cert := range chain[:len(chain)-1] {
+			if revoked[string(cert.RawIssuer)+cert.SerialNumber.String()] {
+				return nil, errors.New("the certificate " + cert.Subject.String() + " has been revoked")
+			}
+		}
+	}
+	return leaf, nil
+}
+
+// --------------------------------------------------
+// Read the Revocation Lists
+// --------------------------------------------------
+// The file can hold one DER list or any number of PEM lists. A list is only
+// used if it was signed by one of the CAs in the bundle.
+
+func (this *ClientVerifierType) loadRevocationLists(now time.Time) (*revocationListsType, error) {
+	info, err := os.Stat(this.crlFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(this.crlFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var lists [][]byte
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "X509 CRL" {
+			lists = append(lists, block.Bytes)
+		}
+	}
+	if len(lists) == 0 {
+		lists = append(lists, data)
+	}
+
+	revoked := make(map[string]bool)
+	for _, der := range lists {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, err
+		}
+
+		signed := false
+		for _, ca := range this.caCerts {
+			if string(ca.RawSubject) == string(crl.RawIssuer) && crl.CheckSignatureFrom(ca) == nil {
+				signed = true
+				break
+			}
+		}
+		if !signed {
+			return nil, errors.New("the revocation list of " + crl.Issuer.String() + " is not signed by a CA in the client CA file")
+		}
+
+		for _, entry := range crl.RevokedCertificateEntries {
+			revoked[string(crl.RawIssuer)+entry.SerialNumber.String()] = true
+		}
+	}
+
+	return &revocationListsType{modTime: info.ModTime(), checked: now, revoked: revoked}, nil
+}
+
+// --------------------------------------------------
+// Get the current Revocation Lists
+// --------------------------------------------------
+// The file is only looked at once every check interval, and only read again
+// if it has changed since the lists were read.
+
+func (this *ClientVerifierType) getRevocationLists(now time.Time) *revocationListsType {
+	current := this.revocations.Load().(*revocationListsType)
+	if now.Sub(current.checked) < CRL_CHECK_INTERVAL {
+		return current
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	// Another request may have checked it while this one was waiting
+	current = this.revocations.Load().(*revocationListsType)
+	if now.Sub(current.checked) < CRL_CHECK_INTERVAL {
+		return current
+	}
+
+	lists := *current
+	lists.checked = now
+	info, err := os.Stat(this.crlFile)
+	if err == nil && !info.ModTime().Equal(current.modTime) {
+		var changed *revocationListsType
+		changed, err = this.loadRevocationLists(now)
+		if err == nil {
+			lists = *changed
+		}
+	}
+	if err != nil {
+		log.Printf("error reading the revocation lists in %s, the last ones read are still used, %v", this.crlFile, err)
+	}
+
+	this.revocations.Store(&lists)
+	return &lists
+}
+
+// --------------------------------------------------
+// Get the Identity of a Request
+// --------------------------------------------------
+// Only set for requests that came with a client certificate that was mapped to
+// an identity. Requests without a certificate are anonymous.
+
+func IdentityFromRequest(r *http.Request) (storage.IdentityType, bool) {
+	identity, ok := r.Context().Value(identityContextKey{}).(storage.IdentityType)
+	return identity, ok
+}
+
+// The name of the identity or the user of a request for the logs
+func identityName(r *http.Request) string {
+	if identity, ok := IdentityFromRequest(r); ok {
+		return identity.Name
+	}
+	if user, ok := UserFromRequest(r); ok {
+		return user.Username
+	}
+	return "anonymous"
+}
+
+// --------------------------------------------------
+// Identity Handler
+// --------------------------------------------------
+// Wraps a handler so that the client certificate is checked and mapped to an
+// identity before the request is handled. A certificate that is not trusted,
+// has expired or has been revoked, or that does not map to an identity, gets
+// an UNAUTHORIZED answer. So does a request without a certificate if client
+// certificates are required.
+
+func (this *ServerType) WithIdentity(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if this.ClientVerifier == nil {
+			next(w, r)
+			return
+		}
+
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			if this.SysConfig.Tls.RequireClientCert {
+				this.sendUnauthorized(w, r, "A client certificate is required")
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		leaf, err := this.ClientVerifier.Verify(r.TLS.PeerCertificates)
+		if err != nil {
+			if this.SysConfig.Logging.LogLevel >= 1 {
+				log.Printf("DEBUG-1: Client certificate from %s not accepted, %v", r.RemoteAddr, err)
+			}
+			this.sendUnauthorized(w, r, "The client certificate is not accepted")
+			return
+		}
+
+		identity, ok, err := this.Store.GetIdentityByCertificate(leaf.Subject.String(), certificateNames(leaf))
+		if err != nil {
+			log.Printf("error looking up the identity of %s, %v", leaf.Subject.String(), err)
+			this.sendUnauthorized(w, r, "The client certificate is not accepted")
+			return
+		}
+		if !ok {
+			if this.SysConfig.Logging.LogLevel >= 1 {
+				log.Printf("DEBUG-1: Client certificate %s from %s does not map to an identity", leaf.Subject.String(), r.RemoteAddr)
+			}
+			this.sendUnauthorized(w, r, "The client certificate is not accepted")
+			return
+		}
+
+		if this.SysConfig.Logging.LogLevel >= 3 {
+			log.Printf("DEBUG-3: Request from %s is from identity %s", r.RemoteAddr, identity.Name)
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), identityContextKey{}, identity)))
+	}
+}
+
+// --------------------------------------------------
+// Send an UNAUTHORIZED Answer
+// --------------------------------------------------
+// In the form the handler of the path would answer in. The reason the
+// certificate was turned away is only logged.
+
+func (this *ServerType) sendUnauthorized(w http.ResponseWriter, r *http.Request, msg string) {
+	if this.SysConfig.Taxii2.Enabled && (strings.HasPrefix(r.URL.Path, this.SysConfig.Taxii2.Discovery) || strings.HasPrefix(r.URL.Path, this.SysConfig.Taxii2.ApiRoot)) {
+		this.sendTaxii2Error(w, http.StatusUnauthorized, "Unauthorized", msg)
+		return
+	}
+
+	if this.SysConfig.Services.Export != "" && strings.HasPrefix(r.URL.Path, this.SysConfig.Services.Export) {
+		http.Error(w, msg, http.StatusUnauthorized)
+		return
+	}
+
+	// The headers are only read to find the binding to answer in
+	var taxiiHeader headers.HttpHeaderType
+	taxiiHeader.VerifyHttpTaxiiHeaderValues(r)
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Println("DEBUG-1: UNAUTHORIZED", msg)
+	}
+	tm := this.CreateTaxiiStatusMessage("", statusMessage.UNAUTHORIZED, msg)
+	this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+}
+
+// The subject alternative names of a certificate, as they are written in the
+// SAN of an identity
+func certificateNames(cert *x509.Certificate) []string {
+	var names []string
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, value := range cert.URIs {
+		names = append(names, value.String())
+	}
+	for _, value := range cert.IPAddresses {
+		names = append(names, value.String())
+	}
+	return names
+}
@@ -0,0 +1,162 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCaType is a CA that issues client certificates and revocation lists
+type testCaType struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newTestCa(t *testing.T, name string) *testCaType {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCaType{cert: cert, key: key}
+}
+
+func (this *testCaType) issue(t *testing.T, serial int64) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "client " + big.NewInt(serial).String()},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, this.cert, key.Public(), this.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeRevocationList writes a PEM revocation list of the serial numbers and
+// gives the file a new modification time
+func (this *testCaType) writeRevocationList(t *testing.T, filename string, number int64, serials ...int64) {
+	template := &x509.RevocationList{
+		Number:     big.NewInt(number),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, serial := range serials {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries,
+			x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now().Add(-time.Minute)})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, this.cert, this.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(time.Duration(number) * time.Second)
+	if err = os.Chtimes(filename, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expireRevocationCheck makes the next request check the revocation list file
+func expireRevocationCheck(v *ClientVerifierType) {
+	lists := *v.revocations.Load().(*revocationListsType)
+	lists.checked = time.Now().Add(-CRL_CHECK_INTERVAL)
+	v.revocations.Store(&lists)
+}
+
+func TestClientVerifierRevocation(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCa(t, "Test CA")
+	caFile := filepath.Join(dir, "ca.pem")
+	err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlFile := filepath.Join(dir, "crl.pem")
+	ca.writeRevocationList(t, crlFile, 1, 3)
+
+	v, err := NewClientVerifier(caFile, crlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	good := ca.issue(t, 2)
+	revoked := ca.issue(t, 3)
+	if _, err = v.Verify([]*x509.Certificate{good}); err != nil {
+		t.Errorf("a good certificate was not accepted, %v", err)
+	}
+	if _, err = v.Verify([]*x509.Certificate{revoked}); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Errorf("a revoked certificate was accepted, %v", err)
+	}
+
+	// A new list is only read once the file is checked again
+	ca.writeRevocationList(t, crlFile, 2, 2, 3)
+	if _, err = v.Verify([]*x509.Certificate{good}); err != nil {
+		t.Errorf("the file was read before the check interval, %v", err)
+	}
+	expireRevocationCheck(v)
+	if _, err = v.Verify([]*x509.Certificate{good}); err == nil {
+		t.Errorf("the certificate revoked in the new list was accepted")
+	}
+
+	// A list that is not signed by the CA is not used, the last good one is
+	// kept
+	newTestCa(t, "Test CA").writeRevocationList(t, crlFile, 3)
+	expireRevocationCheck(v)
+	if _, err = v.Verify([]*x509.Certificate{good}); err == nil {
+		t.Errorf("the list from another CA replaced the last good one")
+	}
+
+	// A certificate from another CA is not trusted at all
+	if _, err = v.Verify([]*x509.Certificate{newTestCa(t, "Other CA").issue(t, 2)}); err == nil {
+		t.Errorf("a certificate from another CA was accepted")
+	}
+}
@@ -7,11 +7,14 @@
 package taxiiserver
 
 import (
-	"encoding/json"
 	"github.com/freetaxii/freetaxii-server/lib/headers"
-	"github.com/freetaxii/libtaxii/messages/collectionMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/collectionMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
 	"log"
 	"net/http"
+	"sort"
 )
 
 func (this *ServerType) CollectionServerHandler(w http.ResponseWriter, r *http.Request) {
@@ -42,12 +45,11 @@ func (this *ServerType) CollectionServerHandler(w http.ResponseWriter, r *http.R
 		// If the headers are not right we will not attempt to read the message.
 		// This also means that we will not have an InReponseTo ID for the
 		// createTaxiiStatusMessage function
-		statusMessageData := this.CreateTaxiiStatusMessage("", "BAD_MESSAGE", err.Error())
+		tm := this.CreateTaxiiStatusMessage("", statusMessage.BAD_MESSAGE, err.Error())
 		if this.SysConfig.Logging.LogLevel >= 1 {
 			log.Println("DEBUG-1: BAD_MESSAGE", err.Error())
 		}
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Write(statusMessageData)
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
 		return
 	}
 
@@ -55,71 +57,105 @@ func (this *ServerType) CollectionServerHandler(w http.ResponseWriter, r *http.R
 	// Decode incoming request message
 	// --------------------------------------------------
 	// Use decoder instead of unmarshal so we can handle stream data
-	decoder := json.NewDecoder(r.Body)
-	var incomingMessageData collectionMessage.CollectionRequestMessageType
-	err = decoder.Decode(&incomingMessageData)
+	this.limitRequestBody(w, r)
+	incomingMessageData, err := collectionMessage.DecodeRequest(taxiiHeader.RequestBinding, r.Body)
 
 	if err != nil {
-		statusMessageData := this.CreateTaxiiStatusMessage("", "BAD_MESSAGE", "Can not decode Collection Request")
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.BAD_MESSAGE, "Can not decode Collection Request")
 		if this.SysConfig.Logging.LogLevel >= 1 {
 			log.Println("DEBUG-1: BAD_MESSAGE, can not decode Collection Request")
 		}
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Write(statusMessageData)
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
 		return
 	}
 
 	// Check to make sure their is a message ID in the request message
 	if incomingMessageData.Id == "" {
-		statusMessageData := this.CreateTaxiiStatusMessage("", "BAD_MESSAGE", "Collection Request message did not include an ID")
+		tm := this.CreateTaxiiStatusMessage("", statusMessage.BAD_MESSAGE, "Collection Request message did not include an ID")
 		if this.SysConfig.Logging.LogLevel >= 1 {
 			log.Println("DEBUG-1: BAD_MESSAGE, Collection Request message did not include an ID")
 		}
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Write(statusMessageData)
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
 		return
 	}
 
 	if this.SysConfig.Logging.LogLevel >= 1 {
-		log.Printf("DEBUG-1: Collection Request from %s with ID: %s", r.RemoteAddr, incomingMessageData.Id)
+		log.Printf("DEBUG-1: Collection Request from %s (%s) with ID: %s", r.RemoteAddr, identityName(r), incomingMessageData.Id)
 	}
 
-	// Get a list of valid collections for this collection request
-	validCollections := this.SysConfig.GetValidCollections()
+	// Only the collections the client has a permission on are listed
+	validCollections := this.getPermittedCollections(r, "")
+
+	data, err := this.createCollectionResponse(taxiiHeader.ResponseBinding, incomingMessageData.Id, validCollections)
+	if err != nil {
+		log.Printf("error creating Collection Response, %v", err)
+		this.sendTaxiiServerError(w, taxiiHeader, incomingMessageData.Id, "Unable to create Collection Response")
+		return
+	}
 
-	data := this.createCollectionResponse(incomingMessageData.Id, validCollections)
 	if this.SysConfig.Logging.LogLevel >= 1 {
 		log.Println("DEBUG-1: Sending Collection Response to", r.RemoteAddr)
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	taxiiHeader.SetHttpTaxiiResponseHeaders(w)
 	w.Write(data)
 }
 
 // --------------------------------------------------
 // Create a TAXII Collection Response Message
 // --------------------------------------------------
+// The collections are sorted by name so that clients always see them in the
+// same order. The volume counts the indicators that are built for the
+// collection as one content block.
 
-func (this *ServerType) createCollectionResponse(inResponseToID string, validCollections map[string]string) []byte {
+func (this *ServerType) createCollectionResponse(binding, inResponseToID string, validCollections map[string]storage.CollectionType) ([]byte, error) {
 	tm := collectionMessage.NewResponse()
 	tm.AddInResponseTo(inResponseToID)
 
-	for k, v := range validCollections {
+	var names []string
+	for name := range validCollections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		collection := validCollections[name]
+		volume := collection.Volume
+		contentBindings := collection.ContentBindings
+		if this.hasIndicators(name) {
+			volume++
+			contentBindings = appendMissing(contentBindings, common.CONTENT_BINDING_STIX1_JSON, common.CONTENT_BINDING_STIX2_JSON)
+		}
+
 		c := tm.NewCollection()
-		c.AddName(k)
+		c.AddName(name)
+		if collection.Type == collectionMessage.COLLECTION_TYPE_DATA_SET {
+			c.SetTypeDataSet()
+		} else {
+			c.SetTypeDataFeed()
+		}
 		c.SetAvailable()
-		c.AddDescription(v)
-		c.AddVolume(1)
+		c.AddDescription(collection.Description)
+		for _, value := range contentBindings {
+			c.AddContentBinding(value)
+		}
+		c.AddVolume(volume)
 		//c.SetPushMethodToHttpJson()
-		c.SetPollServiceToHttpJson("http://test.freetaxii.com:8000/services/poll/")
-		//c.SetSubscriptionServiceToHttpJson("http://taxiitest.freetaxii.com/services/collection-management/")
-		//c.SetInboxServiceToHttpJson("http://taxiitest.freetaxii.com/services/inbox/")
+		if collection.PollAddress != "" {
+			for _, instance := range this.serviceInstances(collection.PollAddress) {
+				c.AddPollService(instance.Protocol, instance.Address, common.TAXII_MESSAGE_JSON, common.TAXII_MESSAGE_XML)
+			}
+		}
+		if this.SysConfig.Services.Subscription != "" && collection.SubscriptionAddress != "" {
+			for _, instance := range this.serviceInstances(collection.SubscriptionAddress) {
+				c.AddSubscriptionService(instance.Protocol, instance.Address, common.TAXII_MESSAGE_JSON)
+			}
+		}
+		if this.SysConfig.Services.Inbox != "" && collection.InboxAddress != "" {
+			for _, instance := range this.serviceInstances(collection.InboxAddress) {
+				c.AddInboxService(instance.Protocol, instance.Address, common.TAXII_MESSAGE_JSON)
+			}
+		}
 	}
 
-	data, err := json.Marshal(tm)
-	if err != nil {
-		// If we can not create a status message then there is something
-		// wrong with the APIs and nothing is going to work.
-		log.Fatal("Unable to create Collection Response Message")
-	}
-	return data
+	return tm.Encode(binding)
 }
@@ -0,0 +1,175 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"fmt"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How often the catalog generation in the store is checked, so changes
+	// made by another process are seen
+	CATALOG_CHECK_INTERVAL = 2 * time.Second
+
+	// How often the catalog is read in full even if the generation has not
+	// changed, this keeps the volume and content bindings of the collections
+	// up to date
+	CATALOG_RELOAD_INTERVAL = 60 * time.Second
+)
+
+// This type holds a copy of the collections and services from the store.
+// Requests read the current snapshot without taking a lock. The snapshot is
+// replaced when the catalog generation in the store changes, when it is older
+// than the reload interval, or when the admin service asks for it. The mutex
+// only keeps two requests from reading the store at the same time.
+type CatalogType struct {
+	mutex    sync.Mutex
+	snapshot atomic.Value // *catalogSnapshotType
+}
+
+// A snapshot is never changed once it has been stored
+type catalogSnapshotType struct {
+	generation  int64
+	checked     time.Time // When the generation was last read from the store
+	loaded      time.Time // When the collections and services were read
+	collections map[string]storage.CollectionType
+	services    []TaxiiServiceType
+}
+
+// --------------------------------------------------
+// Get list of valid collections
+// --------------------------------------------------
+// Every caller gets its own copy, so it is free to change it. An empty map is
+// returned if the collections have never been read, so the collections look
+// like they do not exist rather than the request failing. These are all of the
+// collections, handlers use getPermittedCollections to get the ones the
+// client may use.
+
+func (this *ServerType) getValidCollections() map[string]storage.CollectionType {
+	snapshot := this.getCatalog()
+
+	c := make(map[string]storage.CollectionType, len(snapshot.collections))
+	for name, value := range snapshot.collections {
+		collection := value
+		collection.ContentBindings = append([]string(nil), value.ContentBindings...)
+		c[name] = collection
+	}
+	return c
+}
+
+// --------------------------------------------------
+// Get the Services for the Discovery Service
+// --------------------------------------------------
+
+func (this *ServerType) getServices() []TaxiiServiceType {
+	return append([]TaxiiServiceType(nil), this.getCatalog().services...)
+}
+
+// --------------------------------------------------
+// Reload the Catalog
+// --------------------------------------------------
+// The catalog and the allowlists are read again on the next request
+
+func (this *ServerType) ReloadCatalog() {
+	this.catalog.mutex.Lock()
+	defer this.catalog.mutex.Unlock()
+
+	this.catalog.snapshot.Store((*catalogSnapshotType)(nil))
+	this.reloadAllowlists()
+}
+
+// --------------------------------------------------
+// Get the current Catalog
+// --------------------------------------------------
+// The store is only asked for the generation once every check interval. If
+// the store can not be read the old copy is kept, and an empty one is used if
+// there is no old copy.
+
+func (this *ServerType) getCatalog() *catalogSnapshotType {
+	now := time.Now()
+	current, _ := this.catalog.snapshot.Load().(*catalogSnapshotType)
+	if current != nil && now.Sub(current.checked) < CATALOG_CHECK_INTERVAL {
+		return current
+	}
+
+	this.catalog.mutex.Lock()
+	defer this.catalog.mutex.Unlock()
+
+	// Another request may have refreshed it while this one was waiting
+	current, _ = this.catalog.snapshot.Load().(*catalogSnapshotType)
+	if current != nil && now.Sub(current.checked) < CATALOG_CHECK_INTERVAL {
+		return current
+	}
+
+	snapshot, err := this.refreshCatalog(current, now)
+	if err != nil {
+		log.Print(err)
+		if current == nil {
+			return &catalogSnapshotType{}
+		}
+		return current
+	}
+
+	this.catalog.snapshot.Store(snapshot)
+	return snapshot
+}
+
+// --------------------------------------------------
+// Refresh the Catalog if it is out of date
+// --------------------------------------------------
+// The caller must hold the catalog mutex. The current snapshot is nil if the
+// catalog has not been read or has been reloaded.
+
+func (this *ServerType) refreshCatalog(current *catalogSnapshotType, now time.Time) (*catalogSnapshotType, error) {
+	generation, err := this.Store.GetCatalogGeneration()
+	if err != nil {
+		return nil, fmt.Errorf("error reading catalog generation, %v", err)
+	}
+
+	if current != nil && generation == current.generation && now.Sub(current.loaded) < CATALOG_RELOAD_INTERVAL {
+		snapshot := *current
+		snapshot.checked = now
+		return &snapshot, nil
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Println("DEBUG-3: Reloading Collections and Discovery Services")
+	}
+
+	collections, err := this.Store.GetCollections()
+	if err != nil {
+		return nil, fmt.Errorf("error reading collections, %v", err)
+	}
+
+	storedServices, err := this.Store.GetServices()
+	if err != nil {
+		return nil, fmt.Errorf("error reading services, %v", err)
+	}
+
+	var services []TaxiiServiceType
+	for _, value := range storedServices {
+		var service TaxiiServiceType
+		service.ServiceType = value.ServiceType
+		service.Available = value.Available
+		service.Address = value.Address
+		services = append(services, service)
+	}
+
+	snapshot := &catalogSnapshotType{
+		generation:  generation,
+		checked:     now,
+		loaded:      now,
+		collections: collections,
+		services:    services,
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+)
+
+// expireCatalogCheck makes the next request check the catalog generation
+func expireCatalogCheck(s *ServerType) {
+	current := s.getCatalog()
+	snapshot := *current
+	snapshot.checked = time.Now().Add(-CATALOG_CHECK_INTERVAL)
+	s.catalog.snapshot.Store(&snapshot)
+}
+
+func TestCatalogFollowsGeneration(t *testing.T) {
+	s := newTestServer(t)
+
+	if _, ok := s.getValidCollections()["test-collection"]; !ok {
+		t.Fatalf("the collection is not in the catalog")
+	}
+
+	// Changes made by another process are seen once the generation is
+	// checked again
+	err := s.Store.AddCollection(storage.CollectionType{Name: "second", Type: "DATA_SET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.getValidCollections()["second"]; ok {
+		t.Errorf("the catalog was read before the check interval")
+	}
+	expireCatalogCheck(s)
+	if _, ok := s.getValidCollections()["second"]; !ok {
+		t.Errorf("the new collection is not in the catalog")
+	}
+
+	// Content does not move the generation on
+	before, _ := s.Store.GetCatalogGeneration()
+	addTestContent(t, s, 1)
+	after, _ := s.Store.GetCatalogGeneration()
+	if before != after {
+		t.Errorf("adding content moved the catalog generation from %d to %d", before, after)
+	}
+
+	// A reload is seen straight away
+	s.Store.DeleteCollection("second")
+	s.ReloadCatalog()
+	if _, ok := s.getValidCollections()["second"]; ok {
+		t.Errorf("the deleted collection is still in the catalog after a reload")
+	}
+	if volume := s.getValidCollections()["test-collection"].Volume; volume != 1 {
+		t.Errorf("expected a volume of 1 after a reload, got %d", volume)
+	}
+}
+
+// ----------------------------------------------------------------------
+// Benchmarks
+// ----------------------------------------------------------------------
+
+func newCatalogBenchmarkServer(b *testing.B) *ServerType {
+	s := newTestServer(b)
+	for i := 0; i < 50; i++ {
+		err := s.Store.AddCollection(storage.CollectionType{Name: "collection-" + strconv.Itoa(i), Type: "DATA_FEED"})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	return s
+}
+
+func BenchmarkGetValidCollections(b *testing.B) {
+	s := newCatalogBenchmarkServer(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.getValidCollections()
+	}
+}
+
+func BenchmarkGetValidCollectionsParallel(b *testing.B) {
+	s := newCatalogBenchmarkServer(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.getValidCollections()
+		}
+	})
+}
+
+func BenchmarkGetServicesParallel(b *testing.B) {
+	s := newCatalogBenchmarkServer(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.getServices()
+		}
+	})
+}
+
+// Reading the catalog from the store on every request, which is what every
+// request did before the snapshot was cached
+func BenchmarkGetValidCollectionsReload(b *testing.B) {
+	s := newCatalogBenchmarkServer(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.ReloadCatalog()
+		s.getValidCollections()
+	}
+}
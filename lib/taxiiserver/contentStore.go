@@ -0,0 +1,98 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"github.com/freetaxii/freetaxii-server/lib/messages/inboxMessage"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"log"
+	"time"
+)
+
+// Timestamp labels are stored as fixed width UTC strings so that they sort
+// correctly when compared as text in the store.
+const (
+	TIMESTAMP_LABEL_FORMAT = "2006-01-02T15:04:05.000000Z"
+)
+
+// --------------------------------------------------
+// Save a Content Block to a Collection
+// --------------------------------------------------
+
+func (this *ServerType) saveContentBlock(collectionName string, block inboxMessage.ContentBlockType) error {
+	return this.saveContentBlocks([]string{collectionName}, []inboxMessage.ContentBlockType{block})
+}
+
+// --------------------------------------------------
+// Save Content Blocks to Collections
+// --------------------------------------------------
+// Every block is saved in to every collection in one call to the store, so a
+// failure part of the way through does not leave the content in only some of
+// the collections.
+
+func (this *ServerType) saveContentBlocks(collectionNames []string, blocks []inboxMessage.ContentBlockType) error {
+
+	// The timestamp label is always assigned by the server, it records when
+	// the content was added to the collections.
+	timestampLabel := time.Now().UTC().Format(TIMESTAMP_LABEL_FORMAT)
+
+	var stored []storage.ContentBlockType
+	for _, block := range blocks {
+		stored = append(stored, storage.ContentBlockType{
+			ContentBinding: block.ContentBinding,
+			Encoding:       block.ContentEncoding,
+			Content:        block.Content,
+			TimestampLabel: timestampLabel,
+		})
+	}
+
+	err := this.Store.AddContentBlocks(collectionNames, stored)
+	if err != nil {
+		return err
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Saved %d content blocks in to collections %v with timestamp label %s", len(stored), collectionNames, timestampLabel)
+	}
+	return nil
+}
+
+// --------------------------------------------------
+// Get the Content Blocks committed after a given Sequence
+// --------------------------------------------------
+// Content is numbered in the order it was committed, so the sequence is used
+// as a delivery cursor for subscriptions. A content ID is not, as content
+// with a lower ID can be committed after it.
+
+func (this *ServerType) getContentBlocksAfter(collectionName string, afterSequence int64) ([]storage.ContentBlockType, error) {
+	return this.Store.GetContentBlocksAfter(collectionName, afterSequence)
+}
+
+// --------------------------------------------------
+// Get the Content Blocks in a Timestamp Window
+// --------------------------------------------------
+// The window is exclusive of the begin timestamp and inclusive of the end
+// timestamp, both are in TIMESTAMP_LABEL_FORMAT. An empty begin timestamp
+// means the start of the collection.
+
+func (this *ServerType) getContentBlocksInWindow(collectionName, begin, end string) ([]storage.ContentBlockType, error) {
+	return this.Store.GetContentBlocksInWindow(collectionName, begin, end)
+}
+
+// --------------------------------------------------
+// Convert a TAXII Timestamp to a Timestamp Label
+// --------------------------------------------------
+// Clients send RFC 3339 timestamps in any time zone, they are converted to
+// the format used in the database so they can be compared.
+
+func parseTimestampLabel(s string) (string, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return "", err
+	}
+	return t.UTC().Format(TIMESTAMP_LABEL_FORMAT), nil
+}
@@ -7,28 +7,106 @@
 package taxiiserver
 
 import (
-	"encoding/json"
-	"github.com/freetaxii/libtaxii/messages/statusMessage"
+	"github.com/freetaxii/freetaxii-server/lib/headers"
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
 	"log"
+	"net/http"
+	"sort"
 )
 
 // --------------------------------------------------
 // Create a TAXII Status Message
 // --------------------------------------------------
+// The status type should be one of the statusMessage constants. The response
+// ID is the ID of the request message, and should always be passed in if the
+// request could be decoded far enough to find it. Status details can be added
+// to the message that is returned before it is sent.
 
-func (this *ServerType) CreateTaxiiStatusMessage(responseid, msgType, msg string) []byte {
+func (this *ServerType) CreateTaxiiStatusMessage(responseid, statusType, msg string) statusMessage.StatusMessageType {
 	tm := statusMessage.New()
-	tm.AddType(msgType)
+	tm.AddType(statusType)
 	if responseid != "" {
 		tm.AddResponseId(responseid)
 	}
 	tm.AddMessage(msg)
+	return tm
+}
+
+// --------------------------------------------------
+// Create a TAXII PENDING Status Message
+// --------------------------------------------------
+// This tells the client that its result is being built and that it can come
+// back for it with a Poll Fulfillment message after the estimated wait.
+
+func (this *ServerType) CreateTaxiiPendingStatusMessage(responseid, resultId string, estimatedWait int) statusMessage.StatusMessageType {
+	tm := this.CreateTaxiiStatusMessage(responseid, statusMessage.PENDING, "The result is being built, use a Poll Fulfillment message to collect it")
+	tm.AddEstimatedWait(estimatedWait)
+	tm.AddResultId(resultId)
+	tm.SetWillPush(false)
+	return tm
+}
+
+// --------------------------------------------------
+// Create a TAXII DESTINATION_COLLECTION_ERROR Status Message
+// --------------------------------------------------
+// The collections are sent back as acceptable destinations, they should only
+// be the ones the client is permitted to use
+
+func (this *ServerType) CreateTaxiiDestinationErrorStatusMessage(responseid, msg string, collections map[string]storage.CollectionType) statusMessage.StatusMessageType {
+	tm := this.CreateTaxiiStatusMessage(responseid, statusMessage.DESTINATION_COLLECTION_ERROR, msg)
+
+	var names []string
+	for name := range collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	data, err := json.Marshal(tm)
+	if len(names) > 0 {
+		tm.AddAcceptableDestination(names...)
+	}
+	return tm
+}
+
+// --------------------------------------------------
+// Encode a TAXII Status Message
+// --------------------------------------------------
+// The status message is encoded in the message binding the client asked for
+
+func (this *ServerType) EncodeTaxiiStatusMessage(binding string, tm statusMessage.StatusMessageType) ([]byte, error) {
+	return tm.Encode(binding)
+}
+
+// --------------------------------------------------
+// Send a TAXII Status Message
+// --------------------------------------------------
+// If the status message can not be encoded the client gets an HTTP error
+// instead, as there is no other way to tell it what went wrong.
+
+func (this *ServerType) sendTaxiiStatusMessage(w http.ResponseWriter, taxiiHeader headers.HttpHeaderType, tm statusMessage.StatusMessageType) {
+	this.writeTaxiiStatusMessage(w, taxiiHeader, tm, http.StatusOK)
+}
+
+// --------------------------------------------------
+// Send a TAXII FAILURE Status Message for a Server Error
+// --------------------------------------------------
+// This is used when the server can not create the response message it should
+// send, the client gets a FAILURE status message with an HTTP 500 status.
+
+func (this *ServerType) sendTaxiiServerError(w http.ResponseWriter, taxiiHeader headers.HttpHeaderType, responseid, msg string) {
+	tm := this.CreateTaxiiStatusMessage(responseid, statusMessage.FAILURE, msg)
+	this.writeTaxiiStatusMessage(w, taxiiHeader, tm, http.StatusInternalServerError)
+}
+
+func (this *ServerType) writeTaxiiStatusMessage(w http.ResponseWriter, taxiiHeader headers.HttpHeaderType, tm statusMessage.StatusMessageType, code int) {
+	data, err := this.EncodeTaxiiStatusMessage(taxiiHeader.ResponseBinding, tm)
 	if err != nil {
-		// If we can not create a status message response then there is something
-		// wrong with the APIs and nothing is going to work.
-		log.Fatal("Unable to create Discovery Response Message")
+		log.Printf("error encoding %s Status Message, %v", tm.StatusType, err)
+		http.Error(w, "Unable to create Status Message", http.StatusInternalServerError)
+		return
 	}
-	return data
+
+	taxiiHeader.SetHttpTaxiiResponseHeaders(w)
+	w.WriteHeader(code)
+	w.Write(data)
 }
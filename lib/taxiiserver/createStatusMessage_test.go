@@ -0,0 +1,47 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freetaxii/freetaxii-server/lib/headers"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+)
+
+func TestSendTaxiiServerError(t *testing.T) {
+	s := newTestServer(t)
+
+	var taxiiHeader headers.HttpHeaderType
+	taxiiHeader.ResponseBinding = common.TAXII_MESSAGE_JSON
+
+	w := httptest.NewRecorder()
+	s.sendTaxiiServerError(w, taxiiHeader, "1234", "Unable to create Poll Response")
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected HTTP status 500, got %d", w.Code)
+	}
+
+	var status struct {
+		StatusType   string `json:"status_type"`
+		InResponseTo string `json:"in_response_to"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &status)
+	if err != nil {
+		t.Fatalf("the response is not a JSON status message, %v", err)
+	}
+	if status.StatusType != statusMessage.FAILURE {
+		t.Errorf("expected a FAILURE status message, got %q", status.StatusType)
+	}
+	if status.InResponseTo != "1234" {
+		t.Errorf("expected the request ID in the status message, got %q", status.InResponseTo)
+	}
+}
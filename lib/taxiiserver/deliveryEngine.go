@@ -0,0 +1,480 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/freetaxii/freetaxii-server/lib/messages/inboxMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/subscriptionMessage"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"github.com/freetaxii/libtaxii/defs"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DEFAULT_DELIVERY_INTERVAL        = 60
+	DEFAULT_DELIVERY_MAX_BLOCKS      = 100
+	DEFAULT_DELIVERY_RETRY_DELAY     = 30
+	DEFAULT_DELIVERY_MAX_RETRY_DELAY = 3600
+	DEFAULT_DELIVERY_WORKERS         = 4
+)
+
+// ----------------------------------------------------------------------
+// Define Delivery Engine Type
+// ----------------------------------------------------------------------
+// The delivery engine pushes new content in a collection to the inbox of
+// every active subscriber that registered one. Each subscription has a
+// delivery cursor in the database, the sequence of the last content block
+// that was delivered, so nothing is lost or sent twice across restarts. A
+// subscriber that fails is retried on later runs with an exponential backoff.
+//
+// Only the content blocks stored in the collection are pushed. The indicators
+// that feeds and imports add are only served to polls, which build them for
+// the time window that is asked for.
+//
+// A run hands the subscriptions to a fixed number of workers, so one slow
+// subscriber does not hold up the rest and a large number of subscribers does
+// not open an unbounded number of connections.
+//
+// With access control turned on the owner of a subscription has to still be
+// able to read the collection each time content is pushed. If the grant was
+// taken away the subscription is paused, the owner can resume it once they
+// can read the collection again.
+
+type DeliveryEngineType struct {
+	Server     *ServerType
+	HttpClient *http.Client
+	retries    map[string]*deliveryRetryType
+	retryLock  sync.Mutex
+	quit       chan bool
+	wg         sync.WaitGroup
+}
+
+type deliveryRetryType struct {
+	Failures    int
+	NextAttempt time.Time
+}
+
+// --------------------------------------------------
+// Start and Stop the Delivery Engine
+// --------------------------------------------------
+
+func (this *DeliveryEngineType) Start() {
+	if this.HttpClient == nil {
+		this.HttpClient = this.newHttpClient()
+	}
+	this.retries = make(map[string]*deliveryRetryType)
+	this.quit = make(chan bool)
+
+	interval := this.Server.SysConfig.Delivery.Interval
+	if interval <= 0 {
+		interval = DEFAULT_DELIVERY_INTERVAL
+	}
+
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			this.RunOnce()
+			select {
+			case <-ticker.C:
+			case <-this.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (this *DeliveryEngineType) Stop() {
+	close(this.quit)
+	this.wg.Wait()
+}
+
+// --------------------------------------------------
+// Deliver New Content to every Subscriber
+// --------------------------------------------------
+
+func (this *DeliveryEngineType) RunOnce() {
+	if this.HttpClient == nil {
+		this.HttpClient = this.newHttpClient()
+	}
+	this.retryLock.Lock()
+	if this.retries == nil {
+		this.retries = make(map[string]*deliveryRetryType)
+	}
+	this.retryLock.Unlock()
+
+	subs, err := this.Server.getPushSubscriptions()
+	if err != nil {
+		log.Printf("error reading subscriptions for delivery, %v", err)
+		return
+	}
+
+	workers := this.Server.SysConfig.Delivery.Workers
+	if workers <= 0 {
+		workers = DEFAULT_DELIVERY_WORKERS
+	}
+	if workers > len(subs) {
+		workers = len(subs)
+	}
+
+	queue := make(chan storage.SubscriptionType)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sub := range queue {
+				this.deliverWithRetry(sub)
+			}
+		}()
+	}
+
+	for _, sub := range subs {
+		queue <- sub
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// --------------------------------------------------
+// Deliver to a Subscriber unless it is waiting to be Retried
+// --------------------------------------------------
+
+func (this *DeliveryEngineType) deliverWithRetry(sub storage.SubscriptionType) {
+	this.retryLock.Lock()
+	retry, ok := this.retries[sub.SubscriptionId]
+	if ok && time.Now().Before(retry.NextAttempt) {
+		this.retryLock.Unlock()
+		return
+	}
+	this.retryLock.Unlock()
+
+	if !this.Server.getPrincipalPermissions(sub.Owner).allows(sub.CollectionName, storage.PERMISSION_READ) {
+		log.Printf("pausing subscription %s, %s %s can no longer read collection %s", sub.SubscriptionId, sub.Owner.Type, sub.Owner.Name, sub.CollectionName)
+		err := this.Server.setSubscriptionStatus(sub.SubscriptionId, subscriptionMessage.STATUS_PAUSED)
+		if err != nil {
+			log.Printf("error pausing subscription %s, %v", sub.SubscriptionId, err)
+		}
+		return
+	}
+
+	err := this.deliver(sub)
+
+	this.retryLock.Lock()
+	defer this.retryLock.Unlock()
+	if err != nil {
+		if !ok {
+			retry = &deliveryRetryType{}
+			this.retries[sub.SubscriptionId] = retry
+		}
+		retry.Failures++
+		retry.NextAttempt = time.Now().Add(this.retryDelay(retry.Failures))
+		log.Printf("error delivering to subscription %s at %s, retry %d at %s, %v", sub.SubscriptionId, sub.InboxAddress, retry.Failures, retry.NextAttempt.Format(time.RFC3339), err)
+		return
+	}
+	delete(this.retries, sub.SubscriptionId)
+}
+
+// --------------------------------------------------
+// Deliver New Content to a single Subscriber
+// --------------------------------------------------
+// Content is sent in batches until the subscriber has everything, the cursor
+// is only moved once the subscriber has accepted a batch.
+
+func (this *DeliveryEngineType) deliver(sub storage.SubscriptionType) error {
+	maxBlocks := this.Server.SysConfig.Delivery.MaxBlocks
+	if maxBlocks <= 0 {
+		maxBlocks = DEFAULT_DELIVERY_MAX_BLOCKS
+	}
+
+	blocks, err := this.Server.getContentBlocksAfter(sub.CollectionName, sub.DeliveryCursor)
+	if err != nil {
+		return err
+	}
+
+	for len(blocks) > 0 {
+		batch := blocks
+		if len(batch) > maxBlocks {
+			batch = batch[:maxBlocks]
+		}
+		blocks = blocks[len(batch):]
+
+		err = this.deliverBatch(sub, batch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *DeliveryEngineType) deliverBatch(sub storage.SubscriptionType, batch []storage.ContentBlockType) error {
+	first := batch[0].Id
+	last := batch[0].Id
+	cursor := batch[0].Sequence
+	for _, value := range batch {
+		if value.Id < first {
+			first = value.Id
+		}
+		if value.Id > last {
+			last = value.Id
+		}
+		if value.Sequence > cursor {
+			cursor = value.Sequence
+		}
+	}
+
+	// The delivery cursor is a content sequence rather than a timestamp
+	// label, so only the end of the window can be given to the subscriber.
+	tm := inboxMessage.New()
+	tm.AddSourceSubscription(sub.CollectionName, sub.SubscriptionId, "", batch[len(batch)-1].TimestampLabel)
+
+	count := 0
+	for _, value := range batch {
+		if !subscriptionWantsBinding(sub, value.ContentBinding) {
+			continue
+		}
+		count++
+
+		if sub.ResponseType == subscriptionMessage.RESPONSE_TYPE_COUNT_ONLY {
+			continue
+		}
+		c := tm.NewContentBlock()
+		c.AddContentBinding(value.ContentBinding)
+		c.AddContentEncoding(value.Encoding)
+		c.AddContent(value.Content)
+		c.AddTimestampLabel(value.TimestampLabel)
+	}
+	tm.AddRecordCount(count)
+
+	// If none of the content matched the bindings the subscriber asked for,
+	// there is nothing to send, but the cursor still needs to move past it.
+	if count == 0 {
+		return this.Server.setDeliveryCursor(sub.SubscriptionId, cursor)
+	}
+
+	data, err := json.Marshal(tm)
+	if err != nil {
+		return err
+	}
+
+	err = this.post(sub, data)
+	this.Server.logDelivery(sub.SubscriptionId, first, last, count, err)
+	if err != nil {
+		return err
+	}
+
+	if this.Server.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Delivered %d content blocks from %s to subscription %s at %s", count, sub.CollectionName, sub.SubscriptionId, sub.InboxAddress)
+	}
+	return this.Server.setDeliveryCursor(sub.SubscriptionId, cursor)
+}
+
+// --------------------------------------------------
+// Send an Inbox Message to a Subscriber
+// --------------------------------------------------
+
+func (this *DeliveryEngineType) post(sub storage.SubscriptionType, data []byte) error {
+	if sub.InboxBinding != "" && sub.InboxBinding != defs.TAXII_MESSAGE_JSON {
+		return fmt.Errorf("unsupported inbox message binding %s", sub.InboxBinding)
+	}
+
+	// Subscriptions made before push addresses were checked could point
+	// anywhere, so check again before sending.
+	err := this.Server.checkPushAddress(sub.InboxAddress)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", sub.InboxAddress, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Taxii-Services", defs.TAXII_VERSION)
+	req.Header.Set("X-Taxii-Content-Type", defs.TAXII_MESSAGE_JSON)
+	req.Header.Set("X-Taxii-Accept", defs.TAXII_MESSAGE_JSON)
+	if sub.InboxProtocol != "" {
+		req.Header.Set("X-Taxii-Protocol", sub.InboxProtocol)
+	}
+
+	resp, err := this.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("inbox returned HTTP status %s", resp.Status)
+	}
+
+	// The subscriber should answer with a TAXII Status Message, anything other
+	// than SUCCESS means they did not accept the content.
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var status struct {
+		StatusType string `json:"status_type"`
+		Message    string `json:"message"`
+	}
+	if json.Unmarshal(body, &status) == nil && status.StatusType != "" && status.StatusType != statusMessage.SUCCESS {
+		return fmt.Errorf("inbox returned status %s, %s", status.StatusType, status.Message)
+	}
+	return nil
+}
+
+// --------------------------------------------------
+// Create the HTTP Client used for Deliveries
+// --------------------------------------------------
+// A push address is a host name, so the address it resolves to is checked
+// again when the connection is made. Otherwise a name could be pointed at a
+// private address after the subscription was accepted.
+
+func (this *DeliveryEngineType) newHttpClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("unable to check push address %s", address)
+			}
+			if !this.Server.SysConfig.Delivery.AllowPrivateAddresses && isPrivateAddress(ip) {
+				return fmt.Errorf("push address %s is on a private network", address)
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConnsPerHost: 2,
+	}
+	return &http.Client{Timeout: 60 * time.Second, Transport: transport}
+}
+
+// --------------------------------------------------
+// Check a Push Address
+// --------------------------------------------------
+// Subscribers give the address content is pushed to, so the server must not
+// be usable to reach hosts on its own networks. A literal address is checked
+// here, a host name is checked each time a connection is made.
+
+func (this *ServerType) checkPushAddress(address string) error {
+	u, err := url.Parse(address)
+	if err != nil {
+		return errors.New("the push address is not a valid URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("the push address must be an http or https URL")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("the push address does not have a host")
+	}
+
+	if this.SysConfig.Delivery.AllowPrivateAddresses {
+		return nil
+	}
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return errors.New("the push address is on a private network")
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && isPrivateAddress(ip) {
+		return errors.New("the push address is on a private network")
+	}
+	return nil
+}
+
+func isPrivateAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// --------------------------------------------------
+// Work out the Retry Delay
+// --------------------------------------------------
+
+func (this *DeliveryEngineType) retryDelay(failures int) time.Duration {
+	delay := this.Server.SysConfig.Delivery.RetryDelay
+	if delay <= 0 {
+		delay = DEFAULT_DELIVERY_RETRY_DELAY
+	}
+	maxDelay := this.Server.SysConfig.Delivery.MaxRetryDelay
+	if maxDelay <= 0 {
+		maxDelay = DEFAULT_DELIVERY_MAX_RETRY_DELAY
+	}
+
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay = delay * 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return time.Duration(delay) * time.Second
+}
+
+// --------------------------------------------------
+// Check the Content Bindings of a Subscription
+// --------------------------------------------------
+// A subscription with no content bindings wants everything
+
+func subscriptionWantsBinding(sub storage.SubscriptionType, binding string) bool {
+	if len(sub.ContentBindings) == 0 {
+		return true
+	}
+	for _, value := range sub.ContentBindings {
+		if strings.TrimSpace(value) == binding {
+			return true
+		}
+	}
+	return false
+}
+
+// --------------------------------------------------
+// Record a Delivery Attempt in the Delivery Log
+// --------------------------------------------------
+
+func (this *ServerType) logDelivery(subscriptionId string, firstContentId, lastContentId int64, blocks int, deliveryErr error) {
+	var entry storage.DeliveryLogType
+	entry.SubscriptionId = subscriptionId
+	entry.Attempted = time.Now().UTC().Format(TIMESTAMP_LABEL_FORMAT)
+	entry.FirstContentId = firstContentId
+	entry.LastContentId = lastContentId
+	entry.Blocks = blocks
+	entry.Result = "SUCCESS"
+	if deliveryErr != nil {
+		entry.Result = "FAILURE"
+		entry.Message = deliveryErr.Error()
+	}
+
+	err := this.Store.AddDeliveryLog(entry)
+	if err != nil {
+		log.Printf("error writing delivery log for subscription %s, %v", subscriptionId, err)
+	}
+}
@@ -0,0 +1,357 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/subscriptionMessage"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+)
+
+// testInboxType is a subscriber inbox that records what is pushed to it
+type testInboxType struct {
+	mutex    sync.Mutex
+	server   *httptest.Server
+	status   int
+	messages []map[string]interface{}
+}
+
+func newTestInbox(t *testing.T) *testInboxType {
+	inbox := &testInboxType{status: http.StatusOK}
+	inbox.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inbox.mutex.Lock()
+		defer inbox.mutex.Unlock()
+
+		if inbox.status != http.StatusOK {
+			w.WriteHeader(inbox.status)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		var msg map[string]interface{}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Errorf("inbox received a message that is not JSON, %v", err)
+		}
+		inbox.messages = append(inbox.messages, msg)
+		w.Write([]byte(`{"message_type":"Status_Message","status_type":"SUCCESS"}`))
+	}))
+	t.Cleanup(inbox.server.Close)
+	return inbox
+}
+
+func (this *testInboxType) setStatus(status int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.status = status
+}
+
+func (this *testInboxType) received() []map[string]interface{} {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return append([]map[string]interface{}{}, this.messages...)
+}
+
+// newDeliveryTestServer adds a push subscription for the inbox and returns
+// the server and the subscription
+func newDeliveryTestServer(t *testing.T, inbox *testInboxType) (*ServerType, storage.SubscriptionType) {
+	s := newTestServer(t)
+	s.SysConfig.Delivery.AllowPrivateAddresses = true
+	s.SysConfig.Delivery.MaxBlocks = 2
+
+	var sub storage.SubscriptionType
+	sub.SubscriptionId = "sub-1"
+	sub.CollectionName = "test-collection"
+	sub.Status = subscriptionMessage.STATUS_ACTIVE
+	sub.ResponseType = subscriptionMessage.RESPONSE_TYPE_FULL
+	sub.InboxAddress = inbox.server.URL
+	err := s.Store.AddSubscription(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, sub
+}
+
+func addTestContent(t *testing.T, s *ServerType, count int) {
+	for i := 0; i < count; i++ {
+		var block storage.ContentBlockType
+		block.ContentBinding = "urn:stix.mitre.org:xml:1.1.1"
+		block.Content = "<stix:STIX_Package id=\"" + strconv.Itoa(i) + "\"/>"
+		block.TimestampLabel = time.Now().UTC().Format(TIMESTAMP_LABEL_FORMAT)
+		err := s.Store.AddContentBlock("test-collection", block)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func deliveryCursor(t *testing.T, s *ServerType, subscriptionId string) int64 {
+	sub, ok, err := s.Store.GetSubscription(subscriptionId)
+	if err != nil || !ok {
+		t.Fatalf("unable to read subscription %s, %v", subscriptionId, err)
+	}
+	return sub.DeliveryCursor
+}
+
+func TestDeliveryPushesNewContentInBatches(t *testing.T) {
+	inbox := newTestInbox(t)
+	s, sub := newDeliveryTestServer(t, inbox)
+	addTestContent(t, s, 3)
+
+	engine := &DeliveryEngineType{Server: s}
+	engine.RunOnce()
+
+	messages := inbox.received()
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 inbox messages for 3 blocks with a batch size of 2, got %d", len(messages))
+	}
+	if messages[0]["message_type"] != "Inbox_Message" {
+		t.Errorf("expected an Inbox_Message, got %v", messages[0]["message_type"])
+	}
+	if cursor := deliveryCursor(t, s, sub.SubscriptionId); cursor != 3 {
+		t.Errorf("expected the delivery cursor to be 3, got %d", cursor)
+	}
+
+	// Nothing new, nothing is sent
+	engine.RunOnce()
+	if len(inbox.received()) != 2 {
+		t.Errorf("content was delivered twice")
+	}
+
+	// Only the new content is sent
+	addTestContent(t, s, 1)
+	engine.RunOnce()
+	if len(inbox.received()) != 3 {
+		t.Errorf("expected the new content to be delivered")
+	}
+	if cursor := deliveryCursor(t, s, sub.SubscriptionId); cursor != 4 {
+		t.Errorf("expected the delivery cursor to be 4, got %d", cursor)
+	}
+}
+
+func TestDeliveryPausesWhenOwnerLosesReadGrant(t *testing.T) {
+	inbox := newTestInbox(t)
+	s := newTestServer(t)
+	s.SysConfig.Auth.Acl = true
+	s.SysConfig.Delivery.AllowPrivateAddresses = true
+
+	owner := storage.PrincipalType{Type: storage.PRINCIPAL_TYPE_USER, Name: "alice"}
+	err := s.Store.AddGrant(storage.GrantType{CollectionName: "test-collection", GranteeType: owner.Type, Grantee: owner.Name, Permission: storage.PERMISSION_READ})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sub storage.SubscriptionType
+	sub.SubscriptionId = "sub-1"
+	sub.CollectionName = "test-collection"
+	sub.Status = subscriptionMessage.STATUS_ACTIVE
+	sub.ResponseType = subscriptionMessage.RESPONSE_TYPE_FULL
+	sub.InboxAddress = inbox.server.URL
+	sub.Owner = owner
+	if err = s.Store.AddSubscription(sub); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &DeliveryEngineType{Server: s}
+	addTestContent(t, s, 1)
+	e.RunOnce()
+	if got := len(inbox.received()); got != 1 {
+		t.Fatalf("inbox received %d messages while the owner could read, want 1", got)
+	}
+
+	grants, _ := s.Store.GetGrants("test-collection")
+	for _, grant := range grants {
+		if _, err = s.Store.DeleteGrant(grant.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addTestContent(t, s, 1)
+	e.RunOnce()
+	if got := len(inbox.received()); got != 1 {
+		t.Errorf("inbox received %d messages after the grant was taken away, want 1", got)
+	}
+	found, _, _ := s.Store.GetSubscription("sub-1")
+	if found.Status != subscriptionMessage.STATUS_PAUSED {
+		t.Errorf("subscription status is %s, want PAUSED", found.Status)
+	}
+}
+
+func TestDeliveryRetriesAfterFailure(t *testing.T) {
+	inbox := newTestInbox(t)
+	s, sub := newDeliveryTestServer(t, inbox)
+	s.SysConfig.Delivery.RetryDelay = 60
+	addTestContent(t, s, 1)
+
+	inbox.setStatus(http.StatusServiceUnavailable)
+	engine := &DeliveryEngineType{Server: s}
+	engine.RunOnce()
+
+	if cursor := deliveryCursor(t, s, sub.SubscriptionId); cursor != 0 {
+		t.Fatalf("the delivery cursor moved after a failed delivery, %d", cursor)
+	}
+	retry := engine.retries[sub.SubscriptionId]
+	if retry == nil || retry.Failures != 1 {
+		t.Fatalf("expected one failure to be recorded, got %+v", retry)
+	}
+
+	// The subscriber is back, but the retry delay has not passed yet
+	inbox.setStatus(http.StatusOK)
+	engine.RunOnce()
+	if len(inbox.received()) != 0 {
+		t.Fatalf("delivery was retried before the retry delay")
+	}
+
+	retry.NextAttempt = time.Now().Add(-time.Second)
+	engine.RunOnce()
+	if len(inbox.received()) != 1 {
+		t.Fatalf("expected the content to be delivered on retry")
+	}
+	if cursor := deliveryCursor(t, s, sub.SubscriptionId); cursor != 1 {
+		t.Errorf("expected the delivery cursor to be 1, got %d", cursor)
+	}
+	if _, ok := engine.retries[sub.SubscriptionId]; ok {
+		t.Errorf("the retry was not cleared after a successful delivery")
+	}
+}
+
+func TestDeliveryRetryDelayBacksOff(t *testing.T) {
+	s := newTestServer(t)
+	s.SysConfig.Delivery.RetryDelay = 10
+	s.SysConfig.Delivery.MaxRetryDelay = 60
+	engine := &DeliveryEngineType{Server: s}
+
+	expected := []time.Duration{10, 20, 40, 60, 60}
+	for i, value := range expected {
+		if delay := engine.retryDelay(i + 1); delay != value*time.Second {
+			t.Errorf("failure %d, expected a delay of %ds, got %s", i+1, value, delay)
+		}
+	}
+}
+
+func TestDeliveryRefusesPrivateAddresses(t *testing.T) {
+	inbox := newTestInbox(t)
+	s, sub := newDeliveryTestServer(t, inbox)
+	s.SysConfig.Delivery.AllowPrivateAddresses = false
+	addTestContent(t, s, 1)
+
+	engine := &DeliveryEngineType{Server: s}
+	engine.RunOnce()
+
+	if len(inbox.received()) != 0 {
+		t.Fatalf("content was pushed to a loopback address")
+	}
+	if cursor := deliveryCursor(t, s, sub.SubscriptionId); cursor != 0 {
+		t.Errorf("the delivery cursor moved, %d", cursor)
+	}
+}
+
+func TestCheckPushAddress(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		address string
+		valid   bool
+	}{
+		{"https://inbox.example.com/services/inbox", true},
+		{"http://203.0.113.10:8000/inbox", true},
+		{"ftp://inbox.example.com/", false},
+		{"inbox.example.com/services/inbox", false},
+		{"http:///inbox", false},
+		{"http://%zz/", false},
+		{"http://localhost:8000/inbox", false},
+		{"http://127.0.0.1/inbox", false},
+		{"http://[::1]/inbox", false},
+		{"http://10.1.2.3/inbox", false},
+		{"http://192.168.1.1/inbox", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://[fe80::1]/inbox", false},
+		{"http://0.0.0.0/inbox", false},
+	}
+
+	for _, value := range tests {
+		err := s.checkPushAddress(value.address)
+		if value.valid && err != nil {
+			t.Errorf("%s should be allowed, %v", value.address, err)
+		}
+		if !value.valid && err == nil {
+			t.Errorf("%s should be refused", value.address)
+		}
+	}
+
+	s.SysConfig.Delivery.AllowPrivateAddresses = true
+	if err := s.checkPushAddress("http://127.0.0.1/inbox"); err != nil {
+		t.Errorf("private addresses should be allowed when configured, %v", err)
+	}
+	if err := s.checkPushAddress("file:///etc/passwd"); err == nil {
+		t.Errorf("only http and https should be allowed when private addresses are")
+	}
+}
+
+func TestSubscribeRefusesPrivatePushAddress(t *testing.T) {
+	s := newTestServer(t)
+
+	var req subscriptionMessage.SubscriptionRequestMessageType
+	req.Id = "1"
+	req.CollectionName = "test-collection"
+	req.Action = subscriptionMessage.ACTION_SUBSCRIBE
+	req.PushParameters = &subscriptionMessage.PushParametersType{Address: "http://169.254.169.254/"}
+
+	_, msgType, _ := s.subscribe(req, storage.PrincipalType{})
+	if msgType != statusMessage.BAD_MESSAGE {
+		t.Errorf("expected a BAD_MESSAGE status, got %q", msgType)
+	}
+
+	subs, _ := s.Store.GetSubscriptions("test-collection")
+	if len(subs) != 0 {
+		t.Errorf("the subscription was saved")
+	}
+}
+
+// Feed indicators are only served to polls, push delivery only sends the
+// content blocks that were stored in the collection
+func TestDeliveryDoesNotPushIndicators(t *testing.T) {
+	inbox := newTestInbox(t)
+	s, sub := newDeliveryTestServer(t, inbox)
+
+	err := s.Store.AddIndicator("test-collection", storage.IndicatorType{Title: "Feed",
+		Observables: []storage.ObservableType{{Type: "ipv4-addr", Value: "192.0.2.1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := &DeliveryEngineType{Server: s}
+	engine.RunOnce()
+	if len(inbox.received()) != 0 {
+		t.Fatalf("indicators were pushed, %v", inbox.received())
+	}
+
+	addTestContent(t, s, 1)
+	engine.RunOnce()
+	messages := inbox.received()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 inbox message, got %d", len(messages))
+	}
+	blocks, _ := messages[0]["content_blocks"].([]interface{})
+	if len(blocks) != 1 || blocks[0].(map[string]interface{})["content_binding"] != "urn:stix.mitre.org:xml:1.1.1" {
+		t.Errorf("expected only the stored content block, got %v", messages[0]["content_blocks"])
+	}
+
+	// The indicators are still there for a poll of the subscription
+	result := s.buildPollResult(sub.CollectionName, "", time.Now().UTC().Format(TIMESTAMP_LABEL_FORMAT), nil)
+	if len(result) != 2 {
+		t.Errorf("expected the indicators and the content block in a poll, got %d blocks", len(result))
+	}
+}
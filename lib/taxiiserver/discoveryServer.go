@@ -7,11 +7,10 @@
 package taxiiserver
 
 import (
-	"database/sql"
-	"encoding/json"
 	"github.com/freetaxii/freetaxii-server/lib/headers"
-	"github.com/freetaxii/libtaxii/messages/discoveryMessage"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"github.com/freetaxii/freetaxii-server/lib/messages/discoveryMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
 	"log"
 	"net/http"
 )
@@ -44,12 +43,11 @@ func (this *ServerType) DiscoveryServerHandler(w http.ResponseWriter, r *http.Re
 		// If the headers are not right we will not attempt to read the message.
 		// This also means that we will not have an InReponseTo ID for the
 		// createTaxiiStatusMessage function
-		statusMessageData := this.CreateTaxiiStatusMessage("", "BAD_MESSAGE", err.Error())
+		tm := this.CreateTaxiiStatusMessage("", statusMessage.BAD_MESSAGE, err.Error())
 		if this.SysConfig.Logging.LogLevel >= 1 {
 			log.Println("DEBUG-1: BAD_MESSAGE", err.Error())
 		}
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Write(statusMessageData)
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
 		return
 	}
 
@@ -58,28 +56,25 @@ func (this *ServerType) DiscoveryServerHandler(w http.ResponseWriter, r *http.Re
 	// --------------------------------------------------
 	// Use decoder instead of unmarshal so we can handle stream data
 
-	decoder := json.NewDecoder(r.Body)
-	var incomingMessageData discoveryMessage.DiscoveryRequestMessageType
-	err = decoder.Decode(&incomingMessageData)
+	this.limitRequestBody(w, r)
+	incomingMessageData, err := discoveryMessage.DecodeRequest(taxiiHeader.RequestBinding, r.Body)
 
 	if err != nil {
-		statusMessageData := this.CreateTaxiiStatusMessage("", "BAD_MESSAGE", "Can not decode Discovery Request")
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.BAD_MESSAGE, "Can not decode Discovery Request")
 		if this.SysConfig.Logging.LogLevel >= 1 {
 			log.Println("DEBUG-1: BAD_MESSAGE, can not decode Discovery Request")
 		}
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Write(statusMessageData)
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
 		return
 	}
 
 	// Check to make sure their is a message ID in the request message
 	if incomingMessageData.Id == "" {
-		statusMessageData := this.CreateTaxiiStatusMessage("", "BAD_MESSAGE", "Discovery Request message did not include an ID")
+		tm := this.CreateTaxiiStatusMessage("", statusMessage.BAD_MESSAGE, "Discovery Request message did not include an ID")
 		if this.SysConfig.Logging.LogLevel >= 1 {
 			log.Println("DEBUG-1: BAD_MESSAGE, Discovery Request message did not include an ID")
 		}
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Write(statusMessageData)
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
 		return
 	}
 
@@ -87,119 +82,73 @@ func (this *ServerType) DiscoveryServerHandler(w http.ResponseWriter, r *http.Re
 		log.Printf("DEBUG-1: Discovery Request from %s with ID: %s", r.RemoteAddr, incomingMessageData.Id)
 	}
 
-	if this.SysConfig.Logging.LogLevel >= 3 {
-		log.Println("DEBUG-3: Reload Services is currently set to", this.ReloadServices)
-	}
-
-	if this.ReloadServices == true {
-		this.loadServices()
-		this.ReloadServices = false
-		if this.SysConfig.Logging.LogLevel >= 3 {
-			log.Println("DEBUG-3: Setting Reload Services to false")
-		}
+	data, err := this.createDiscoveryResponse(taxiiHeader.ResponseBinding, incomingMessageData.Id, this.getServices())
+	if err != nil {
+		log.Printf("error creating Discovery Response, %v", err)
+		this.sendTaxiiServerError(w, taxiiHeader, incomingMessageData.Id, "Unable to create Discovery Response")
+		return
 	}
 
-	data := this.createDiscoveryResponse(incomingMessageData.Id, this.CurrentTaxiiServices)
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	taxiiHeader.SetHttpTaxiiResponseHeaders(w)
 	w.Write(data)
 	if this.SysConfig.Logging.LogLevel >= 1 {
 		log.Println("DEBUG-1: Sending Discovery Response to", r.RemoteAddr)
 	}
 }
 
-// --------------------------------------------------
-// Load Services from Database
-// --------------------------------------------------
-
-func (this *ServerType) loadServices() {
-
-	if this.SysConfig.Logging.LogLevel >= 1 {
-		log.Println("DEBUG-1: Reloading Discovery Services")
-	}
-
-	// Clear out existing data so when we reload we do not have a contaminated array
-	this.CurrentTaxiiServices = nil
-
-	// Open connection to database
-	filename := this.SysConfig.System.DbFileFullPath
-	db, err := sql.Open("sqlite3", filename)
-	if err != nil {
-		log.Fatalf("Unable to open file %s due to error %v", filename, err)
-	}
-	defer db.Close()
-
-	// Read in services for the discovery server.
-	sqlstmt := `SELECT type, available, address 
-				FROM Services AS s 
-				INNER JOIN ServiceType AS t 
-				ON s.typeid = t.id`
-	rows, err := db.Query(sqlstmt)
-	if err != nil {
-		log.Printf("error running query, %v", err)
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var servicetype string
-		var available int
-		var address string
-		err = rows.Scan(&servicetype, &available, &address)
-
-		if err != nil {
-			log.Printf("error reading from database, %v", err)
-		}
-
-		var services TaxiiServiceType
-		services.ServiceType = servicetype
-		if available == 1 {
-			services.Available = true
-		} else {
-			services.Available = false
-		}
-		services.Address = address
-
-		// Add services to object
-		this.CurrentTaxiiServices = append(this.CurrentTaxiiServices, services)
-	}
-}
-
 // --------------------------------------------------
 // Create a TAXII Discovery Response Message
 // --------------------------------------------------
 
-func (this *ServerType) createDiscoveryResponse(responseid string, ds []TaxiiServiceType) []byte {
+func (this *ServerType) createDiscoveryResponse(binding, responseid string, ds []TaxiiServiceType) ([]byte, error) {
 	tm := discoveryMessage.NewResponse()
 	tm.AddInResponseTo(responseid)
 
+	// A service that can be reached more than one way is listed once for
+	// each protocol binding
 	for _, value := range ds {
-		s := tm.NewService()
-
-		switch value.ServiceType {
-		case "Discovery":
-			s.SetTypeDiscovery()
-		case "Collection":
-			s.SetTypeCollection()
-		case "Poll":
-			s.SetTypePoll()
-		case "Inbox":
-			s.SetTypeInbox()
-		}
-
-		switch value.Available {
-		case true:
-			s.SetAvailable()
-		case false:
-			s.SetUnavailable()
+		for _, instance := range this.serviceInstances(value.Address) {
+			s := tm.NewService()
+
+			switch value.ServiceType {
+			case "Discovery":
+				s.SetTypeDiscovery()
+			case "Collection", "Subscription":
+				// Subscriptions are managed by a TAXII Collection Management service
+				s.SetTypeCollection()
+			case "Poll":
+				s.SetTypePoll()
+			case "Inbox":
+				s.SetTypeInbox()
+			}
+
+			switch value.Available {
+			case true:
+				s.SetAvailable()
+			case false:
+				s.SetUnavailable()
+			}
+			s.AddProtocolBinding(instance.Protocol)
+			for _, messageBinding := range value.messageBindings() {
+				s.AddMessageBinding(messageBinding)
+			}
+			s.AddAddress(instance.Address)
 		}
-		s.SetStandardTaxiiHttpJson()
-		s.AddAddress(value.Address)
 	}
 
-	data, err := json.Marshal(tm)
-	if err != nil {
-		// If we can not create a status message then there is something
-		// wrong with the APIs and nothing is going to work.
-		log.Fatal("Unable to create Discovery Response Message")
+	return tm.Encode(binding)
+}
+
+// --------------------------------------------------
+// Get the Message Bindings a Service Supports
+// --------------------------------------------------
+// Discovery, Collection Information and Poll messages can be sent in either
+// the JSON or the XML binding, the other services only support JSON.
+
+func (this *TaxiiServiceType) messageBindings() []string {
+	switch this.ServiceType {
+	case "Discovery", "Collection", "Poll":
+		return []string{common.TAXII_MESSAGE_JSON, common.TAXII_MESSAGE_XML}
 	}
-	return data
+	return []string{common.TAXII_MESSAGE_JSON}
 }
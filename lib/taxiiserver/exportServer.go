@@ -0,0 +1,100 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"github.com/freetaxii/freetaxii-server/lib/export"
+	"github.com/freetaxii/freetaxii-server/lib/parser"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// --------------------------------------------------
+// Export Server Handler
+// --------------------------------------------------
+// Serves the indicators of a collection in a format that firewalls, IDS
+// sensors and DNS servers can load, at <export path>/<collection>/<format>.
+// A collection can be exported if it could be polled, and the values are the
+// same ones a poll would return.
+
+func (this *ServerType) ExportServerHandler(w http.ResponseWriter, r *http.Request) {
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Found Message on Export Server Handler from %s for %s", r.RemoteAddr, r.URL.Path)
+	}
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "The "+r.Method+" method is not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, this.SysConfig.Services.Export), "/"), "/")
+	if len(parts) != 2 {
+		http.Error(w, "Export addresses are in the form <collection>/<format>", http.StatusNotFound)
+		return
+	}
+	collectionName := parts[0]
+
+	format, err := export.Get(parts[1])
+	if err != nil {
+		http.Error(w, "The export format must be one of "+strings.Join(export.Names(), ", "), http.StatusNotFound)
+		return
+	}
+
+	// A collection the client can not read looks the same as one that does
+	// not exist
+	currentlyValidCollections := this.getPermittedCollections(r, storage.PERMISSION_READ)
+	if _, ok := currentlyValidCollections[collectionName]; !ok {
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: Export asked for a collection that does not exist")
+		}
+		http.Error(w, "The requested collection \""+collectionName+"\" does not exist", http.StatusNotFound)
+		return
+	}
+
+	observables, err := this.getExportObservables(collectionName)
+	if err != nil {
+		log.Printf("error reading indicators for collection %s, %v", collectionName, err)
+		http.Error(w, "The collection can not be read", http.StatusInternalServerError)
+		return
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Sending %d values from collection %s as %s to %s", len(observables), collectionName, format.Name, r.RemoteAddr)
+	}
+
+	info := export.InfoType{CollectionName: collectionName, Generated: time.Now().UTC()}
+	w.Header().Set("Content-Type", format.ContentType)
+	err = format.Write(w, info, observables)
+	if err != nil && this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Unable to send export to %s, %v", r.RemoteAddr, err)
+	}
+}
+
+// --------------------------------------------------
+// Get the Values of a Collection for Export
+// --------------------------------------------------
+// The values of every indicator in the collection in one list, classified
+// and without duplicates. Values that are not valid are left out, the same as
+// they are when a poll response is built.
+
+func (this *ServerType) getExportObservables(collectionName string) ([]storage.ObservableType, error) {
+	indicators, err := this.getIndicators(collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	var observables []storage.ObservableType
+	for _, indicator := range indicators {
+		observables = append(observables, indicator.Observables...)
+	}
+	observables, _ = parser.Normalize(observables)
+	return observables, nil
+}
@@ -0,0 +1,274 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"errors"
+	"fmt"
+	"github.com/freetaxii/freetaxii-server/lib/parser"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_FETCHER_INTERVAL = 60
+	DEFAULT_FETCHER_TIMEOUT  = 60
+	DEFAULT_FEED_INTERVAL    = 3600
+	DEFAULT_FEED_MAX_SIZE    = 64 * 1024 * 1024
+)
+
+// ----------------------------------------------------------------------
+// Define Feed Fetcher Type
+// ----------------------------------------------------------------------
+// The fetcher reads the remote source of every collection that has a feed,
+// each one on its own interval, and saves what it finds in the store. Polls
+// only ever read the store. The source is asked with a conditional GET, so an
+// unchanged source costs a 304 and nothing is rewritten. If the source fails
+// the last good copy is kept and the error is saved with the feed.
+
+type FetcherType struct {
+	Server     *ServerType
+	HttpClient *http.Client
+	quit       chan bool
+	wg         sync.WaitGroup
+}
+
+// --------------------------------------------------
+// Start and Stop the Fetcher
+// --------------------------------------------------
+
+func (this *FetcherType) Start() {
+	timeout := this.Server.SysConfig.Fetcher.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_FETCHER_TIMEOUT
+	}
+	if this.HttpClient == nil {
+		this.HttpClient = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	}
+	this.quit = make(chan bool)
+
+	interval := this.Server.SysConfig.Fetcher.Interval
+	if interval <= 0 {
+		interval = DEFAULT_FETCHER_INTERVAL
+	}
+
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			this.RunOnce()
+			select {
+			case <-ticker.C:
+			case <-this.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (this *FetcherType) Stop() {
+	close(this.quit)
+	this.wg.Wait()
+}
+
+// --------------------------------------------------
+// Fetch every Feed that is Due
+// --------------------------------------------------
+
+func (this *FetcherType) RunOnce() {
+	if this.HttpClient == nil {
+		this.HttpClient = &http.Client{Timeout: DEFAULT_FETCHER_TIMEOUT * time.Second}
+	}
+
+	feeds, err := this.Server.Store.GetFeeds()
+	if err != nil {
+		log.Printf("error reading feeds, %v", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	for _, feed := range feeds {
+		if !feedIsDue(feed, now) {
+			continue
+		}
+
+		feed.LastFetched = now.Format(TIMESTAMP_LABEL_FORMAT)
+		changed, err := this.fetch(&feed)
+		if err != nil {
+			feed.LastError = err.Error()
+			log.Printf("error fetching feed for collection %s from %s, keeping the last copy, %v", feed.CollectionName, feed.Address, err)
+		} else {
+			feed.LastError = ""
+			feed.LastSuccess = feed.LastFetched
+			if this.Server.SysConfig.Logging.LogLevel >= 3 {
+				log.Printf("DEBUG-3: Fetched feed for collection %s from %s, changed %t", feed.CollectionName, feed.Address, changed)
+			}
+		}
+
+		err = this.Server.Store.SetFeedState(feed)
+		if err != nil {
+			log.Printf("error saving feed state for collection %s, %v", feed.CollectionName, err)
+		}
+	}
+}
+
+// --------------------------------------------------
+// Check if a Feed is Due
+// --------------------------------------------------
+// A feed that has never been fetched, or has a last fetch time that can not
+// be read, is always due.
+
+func feedIsDue(feed storage.FeedType, now time.Time) bool {
+	if feed.LastFetched == "" {
+		return true
+	}
+	last, err := time.Parse(TIMESTAMP_LABEL_FORMAT, feed.LastFetched)
+	if err != nil {
+		return true
+	}
+
+	interval := feed.Interval
+	if interval <= 0 {
+		interval = DEFAULT_FEED_INTERVAL
+	}
+	return !now.Before(last.Add(time.Duration(interval) * time.Second))
+}
+
+// --------------------------------------------------
+// Fetch a single Feed
+// --------------------------------------------------
+// The boolean return value is false if the source said the content has not
+// changed. Content larger than the configured maximum is an error. The ETag and Last-Modified values of the feed are only moved on
+// once the new content has been saved.
+
+func (this *FetcherType) fetch(feed *storage.FeedType) (bool, error) {
+	req, err := http.NewRequest("GET", feed.Address, nil)
+	if err != nil {
+		return false, err
+	}
+	if feed.ETag != "" {
+		req.Header.Set("If-None-Match", feed.ETag)
+	}
+	if feed.LastModified != "" {
+		req.Header.Set("If-Modified-Since", feed.LastModified)
+	}
+
+	resp, err := this.HttpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("source returned HTTP status %s", resp.Status)
+	}
+
+	// One byte more than the limit is read to find out if the content is too
+	// large, a source that is cut short would drop the values past the limit
+	maxSize := int64(this.Server.SysConfig.Fetcher.MaxSize)
+	if maxSize <= 0 {
+		maxSize = DEFAULT_FEED_MAX_SIZE
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return false, err
+	}
+	if int64(len(body)) > maxSize {
+		return false, fmt.Errorf("source returned more than %d bytes", maxSize)
+	}
+
+	observables, err := this.parseFeed(feed, body)
+	if err != nil {
+		return false, err
+	}
+	if len(observables) == 0 {
+		return false, errors.New("source did not return any values")
+	}
+
+	err = this.Server.Store.SetFeedObservables(feed.CollectionName, observables, feed.LastFetched)
+	if err != nil {
+		return false, err
+	}
+
+	feed.ETag = resp.Header.Get("ETag")
+	feed.LastModified = resp.Header.Get("Last-Modified")
+	return true, nil
+}
+
+// --------------------------------------------------
+// Parse the Content of a Feed
+// --------------------------------------------------
+// The parser is the one named by the feed. Every value is then classified and
+// put in its normal form. Values that are not valid or are on the allowlist
+// are left out and saved in the quarantine of the collection in place of the
+// ones from the last fetch, and the number on the allowlist is saved with the
+// feed. A value that was already in the collection keeps the time it was
+// first seen.
+
+func (this *FetcherType) parseFeed(feed *storage.FeedType, body []byte) ([]storage.ObservableType, error) {
+	p, err := parser.New(feed.Parser, feed.ParserOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	observables, err := p.Parse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	allowlist, err := this.Server.getAllowlist(feed.CollectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	observables, rejected := parser.Normalize(observables)
+	observables, suppressed := allowlist.Filter(observables)
+	feed.Suppressed = len(suppressed)
+
+	var quarantine []storage.QuarantineType
+	for _, value := range append(rejected, suppressed...) {
+		quarantine = append(quarantine, storage.QuarantineType{Value: value.Value, Reason: value.Reason, Created: feed.LastFetched})
+	}
+	err = this.Server.Store.SetQuarantine(feed.CollectionName, feed.Address, quarantine)
+	if err != nil {
+		log.Printf("error saving quarantine for collection %s, %v", feed.CollectionName, err)
+	}
+	if len(quarantine) > 0 && this.Server.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Quarantined %d values and suppressed %d values from feed for collection %s", len(rejected), len(suppressed), feed.CollectionName)
+	}
+
+	seen := make(map[string]string)
+	indicators, err := this.Server.Store.GetIndicators(feed.CollectionName)
+	if err != nil {
+		log.Printf("error reading indicators for collection %s, %v", feed.CollectionName, err)
+	}
+	for _, indicator := range indicators {
+		for _, value := range indicator.Observables {
+			seen[value.Value] = value.Created
+		}
+	}
+
+	for i := range observables {
+		created, ok := seen[observables[i].Value]
+		if !ok {
+			created = feed.LastFetched
+		}
+		observables[i].Created = created
+	}
+	return observables, nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+)
+
+// testSource is a remote feed source. It answers with a 304 when the
+// conditional headers match the current ETag and Last-Modified values.
+type testSource struct {
+	mutex        sync.Mutex
+	body         string
+	status       int
+	etag         string
+	lastModified string
+	requests     []*http.Request
+}
+
+func (this *testSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.requests = append(this.requests, r)
+	if this.status != 0 {
+		w.WriteHeader(this.status)
+		return
+	}
+	if this.etag != "" && r.Header.Get("If-None-Match") == this.etag &&
+		this.lastModified != "" && r.Header.Get("If-Modified-Since") == this.lastModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if this.etag != "" {
+		w.Header().Set("ETag", this.etag)
+	}
+	if this.lastModified != "" {
+		w.Header().Set("Last-Modified", this.lastModified)
+	}
+	w.Write([]byte(this.body))
+}
+
+func (this *testSource) set(status int, body string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.status = status
+	this.body = body
+}
+
+func (this *testSource) lastRequest() *http.Request {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.requests[len(this.requests)-1]
+}
+
+// newFetcherTestServer returns a fetcher for test-collection, which has a feed
+// that reads from source.
+func newFetcherTestServer(t *testing.T, source *testSource) (*FetcherType, *storage.FeedType) {
+	up := httptest.NewServer(source)
+	t.Cleanup(up.Close)
+
+	s := newTestServer(t)
+	err := s.Store.AddFeed(storage.FeedType{CollectionName: "test-collection", Address: up.URL, Parser: "plaintext"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &FetcherType{Server: s, HttpClient: up.Client()}, fetcherFeed(t, s)
+}
+
+func fetcherFeed(t *testing.T, s *ServerType) *storage.FeedType {
+	feeds, err := s.Store.GetFeeds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feeds) != 1 {
+		t.Fatalf("got %d feeds, want 1", len(feeds))
+	}
+	return &feeds[0]
+}
+
+// feedValues returns the sorted values of every indicator in test-collection
+func feedValues(t *testing.T, s *ServerType) []string {
+	indicators, err := s.Store.GetIndicators("test-collection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, indicator := range indicators {
+		for _, value := range indicator.Observables {
+			values = append(values, value.Value)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// runFetcher fetches the feed as if it were due and returns its saved state
+func runFetcher(t *testing.T, f *FetcherType) *storage.FeedType {
+	feed := fetcherFeed(t, f.Server)
+	feed.LastFetched = ""
+	err := f.Server.Store.SetFeedState(*feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.RunOnce()
+	return fetcherFeed(t, f.Server)
+}
+
+func TestFetcherSavesNewContent(t *testing.T) {
+	source := &testSource{body: "192.0.2.1\n192.0.2.2 ; comment\n", etag: `"v1"`, lastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}
+	f, _ := newFetcherTestServer(t, source)
+
+	feed := runFetcher(t, f)
+	if feed.LastError != "" {
+		t.Fatalf("fetch failed, %s", feed.LastError)
+	}
+	if feed.LastSuccess == "" || feed.LastSuccess != feed.LastFetched {
+		t.Errorf("last success %q, want the fetch time %q", feed.LastSuccess, feed.LastFetched)
+	}
+	if feed.ETag != source.etag || feed.LastModified != source.lastModified {
+		t.Errorf("saved %q and %q, want %q and %q", feed.ETag, feed.LastModified, source.etag, source.lastModified)
+	}
+	if got := strings.Join(feedValues(t, f.Server), ","); got != "192.0.2.1,192.0.2.2" {
+		t.Errorf("saved values %s", got)
+	}
+}
+
+func TestFetcherSendsConditionalHeaders(t *testing.T) {
+	source := &testSource{body: "192.0.2.1\n", etag: `"v1"`, lastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}
+	f, _ := newFetcherTestServer(t, source)
+
+	runFetcher(t, f)
+	indicators, err := f.Server.Store.GetIndicators("test-collection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := indicators[0].Modified
+
+	feed := runFetcher(t, f)
+	r := source.lastRequest()
+	if r.Header.Get("If-None-Match") != source.etag || r.Header.Get("If-Modified-Since") != source.lastModified {
+		t.Errorf("request sent %q and %q", r.Header.Get("If-None-Match"), r.Header.Get("If-Modified-Since"))
+	}
+	if feed.LastError != "" || feed.LastSuccess != feed.LastFetched {
+		t.Errorf("a 304 should count as a success, last error %q", feed.LastError)
+	}
+
+	// Nothing is rewritten when the source has not changed
+	indicators, err = f.Server.Store.GetIndicators("test-collection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indicators[0].Modified != modified {
+		t.Errorf("indicator was rewritten on a 304")
+	}
+}
+
+func TestFetcherKeepsLastGoodCopy(t *testing.T) {
+	source := &testSource{body: "192.0.2.1\n"}
+	f, _ := newFetcherTestServer(t, source)
+
+	feed := runFetcher(t, f)
+	success := feed.LastSuccess
+
+	source.set(http.StatusInternalServerError, "")
+	feed = runFetcher(t, f)
+	if feed.LastError == "" {
+		t.Errorf("error from the source was not saved")
+	}
+	if feed.LastSuccess != success {
+		t.Errorf("last success moved to %q on an error", feed.LastSuccess)
+	}
+	if got := strings.Join(feedValues(t, f.Server), ","); got != "192.0.2.1" {
+		t.Errorf("last good copy was not kept, got %s", got)
+	}
+}
+
+func TestFetcherRefusesLargeContent(t *testing.T) {
+	source := &testSource{body: "192.0.2.1\n"}
+	f, _ := newFetcherTestServer(t, source)
+	f.Server.SysConfig.Fetcher.MaxSize = 32
+
+	runFetcher(t, f)
+	source.set(0, "192.0.2.2\n192.0.2.3\n192.0.2.4\n192.0.2.5\n")
+	feed := runFetcher(t, f)
+	if !strings.Contains(feed.LastError, "more than 32 bytes") {
+		t.Errorf("last error %q", feed.LastError)
+	}
+	if got := strings.Join(feedValues(t, f.Server), ","); got != "192.0.2.1" {
+		t.Errorf("content past the limit was saved, got %s", got)
+	}
+}
+
+func TestFetcherOnlyReplacesItsIndicator(t *testing.T) {
+	source := &testSource{body: "192.0.2.1\n"}
+	f, _ := newFetcherTestServer(t, source)
+
+	err := f.Server.Store.AddIndicator("test-collection", storage.IndicatorType{Title: "Analyst",
+		Observables: []storage.ObservableType{{Type: "ipv4-addr", Value: "198.51.100.1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runFetcher(t, f)
+	source.set(0, "192.0.2.2\n")
+	runFetcher(t, f)
+
+	if got := strings.Join(feedValues(t, f.Server), ","); got != "192.0.2.2,198.51.100.1" {
+		t.Errorf("saved values %s", got)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"encoding/json"
+	"github.com/freetaxii/freetaxii-server/lib/headers"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"github.com/freetaxii/freetaxii-server/lib/messages/inboxMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+func (this *ServerType) InboxServerHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var taxiiHeader headers.HttpHeaderType
+
+	// Log notice of incoming TAXII message
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Found Message on Inbox Server Handler from %s", r.RemoteAddr)
+	}
+
+	// We need to put this first so that during debugging we can see problems
+	// that will generate errors below.
+	if this.SysConfig.Logging.LogLevel >= 5 {
+		taxiiHeader.DebugHttpRequest(r)
+	}
+
+	// --------------------------------------------------
+	// Check HTTP Headers for correct TAXII values
+	// --------------------------------------------------
+	// Send a Status Message on error
+
+	err = taxiiHeader.VerifyHttpTaxiiHeaderValues(r)
+	if err != nil {
+		if this.SysConfig.Logging.LogLevel >= 3 {
+			log.Print(err)
+		}
+
+		// If the headers are not right we will not attempt to read the message.
+		// This also means that we will not have an InReponseTo ID for the
+		// createTaxiiStatusMessage function
+		tm := this.CreateTaxiiStatusMessage("", statusMessage.BAD_MESSAGE, err.Error())
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: BAD_MESSAGE", err.Error())
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	// Inbox Messages can only be decoded from the JSON binding, the status
+	// message that is sent back can be in either binding
+	if taxiiHeader.RequestBinding != common.TAXII_MESSAGE_JSON {
+		tm := this.CreateTaxiiStatusMessage("", statusMessage.UNSUPPORTED_MESSAGE, "The Inbox service only supports the JSON message binding")
+		tm.AddSupportedBinding(common.TAXII_MESSAGE_JSON)
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: UNSUPPORTED_MESSAGE, Inbox Message was not sent in the JSON binding")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	// --------------------------------------------------
+	// Decode incoming request message
+	// --------------------------------------------------
+	// Use decoder instead of unmarshal so we can handle stream data
+
+	this.limitRequestBody(w, r)
+	decoder := json.NewDecoder(r.Body)
+	var incomingMessageData inboxMessage.InboxMessageType
+	err = decoder.Decode(&incomingMessageData)
+
+	if err != nil {
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.BAD_MESSAGE, "Can not decode Inbox Message")
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: BAD_MESSAGE, can not decode Inbox Message")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	// Check to make sure there is a message ID in the request message
+	if incomingMessageData.Id == "" {
+		tm := this.CreateTaxiiStatusMessage("", statusMessage.BAD_MESSAGE, "Inbox Message did not include an ID")
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: BAD_MESSAGE, Inbox Message did not include an ID")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	// Log notice of incomming Inbox Message
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Inbox Message from %s for %v with ID: %s", r.RemoteAddr, incomingMessageData.DestinationCollectionNames, incomingMessageData.Id)
+	}
+
+	// --------------------------------------------------
+	// Check for valid destination collections
+	// --------------------------------------------------
+	// This server does not have a default collection, so the client must tell
+	// us where the content should go. With access control turned on a
+	// collection the client can not write to gets the same answer as one that
+	// does not exist.
+
+	currentlyValidCollections := this.getPermittedCollections(r, storage.PERMISSION_WRITE)
+
+	if len(incomingMessageData.DestinationCollectionNames) == 0 {
+		tm := this.CreateTaxiiDestinationErrorStatusMessage(incomingMessageData.Id, "Inbox Message did not include a destination collection", currentlyValidCollections)
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: DESTINATION_COLLECTION_ERROR, Inbox Message did not include a destination collection")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	for _, collectionName := range incomingMessageData.DestinationCollectionNames {
+		if _, ok := currentlyValidCollections[collectionName]; !ok {
+			if this.SysConfig.Auth.Acl {
+				tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.UNAUTHORIZED, "Not authorized to add content to the destination collection \""+collectionName+"\"")
+				if this.SysConfig.Logging.LogLevel >= 1 {
+					log.Printf("DEBUG-1: UNAUTHORIZED, Inbox Message from %s (%s) for %s", r.RemoteAddr, identityName(r), collectionName)
+				}
+				this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+				return
+			}
+
+			errmsg := "The destination collection \"" + collectionName + "\" does not exist"
+			tm := this.CreateTaxiiDestinationErrorStatusMessage(incomingMessageData.Id, errmsg, currentlyValidCollections)
+			if this.SysConfig.Logging.LogLevel >= 1 {
+				log.Println("DEBUG-1: DESTINATION_COLLECTION_ERROR, Inbox Message named a collection that does not exist")
+			}
+			this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+			return
+		}
+	}
+
+	// --------------------------------------------------
+	// Save the content blocks
+	// --------------------------------------------------
+	// Every content block is saved in to every destination collection so that
+	// later Poll Requests for any of those collections will return it. If the
+	// content can not be saved in to one of them it is saved in to none.
+
+	err = this.saveContentBlocks(incomingMessageData.DestinationCollectionNames, incomingMessageData.ContentBlocks)
+	if err != nil {
+		log.Printf("error saving content blocks to collections %v, %v", incomingMessageData.DestinationCollectionNames, err)
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.FAILURE, "Unable to save content to the destination collections")
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	msg := "Saved " + strconv.Itoa(len(incomingMessageData.ContentBlocks)) + " content blocks"
+	tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.SUCCESS, msg)
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Println("DEBUG-1: Sending Inbox SUCCESS Status Message to", r.RemoteAddr)
+	}
+	this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+}
@@ -0,0 +1,167 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"github.com/freetaxii/freetaxii-server/lib/parser"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"log"
+	"sync"
+)
+
+// --------------------------------------------------
+// Get the Indicators in a Collection
+// --------------------------------------------------
+// Collections that come from a remote source have their observables saved in
+// the store by the feed fetcher, so this never leaves the server. Values on
+// the allowlist are removed here as well as when they are fetched, so an
+// entry added since the last fetch or import is honored without waiting for
+// the next one.
+
+func (this *ServerType) getIndicators(collectionName string) ([]storage.IndicatorType, error) {
+	indicators, err := this.Store.GetIndicators(collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	allowlist, err := this.getAllowlist(collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range indicators {
+		var suppressed []parser.RejectedType
+		indicators[i].Observables, suppressed = allowlist.Filter(indicators[i].Observables)
+		if len(suppressed) > 0 && this.SysConfig.Logging.LogLevel >= 3 {
+			log.Printf("DEBUG-3: Suppressed %d values in collection %s that are on the allowlist", len(suppressed), collectionName)
+		}
+	}
+	return indicators, nil
+}
+
+// --------------------------------------------------
+// Get the Indicators in a Collection for a Poll Window
+// --------------------------------------------------
+// Only the observables created after the exclusive begin timestamp and up to
+// the inclusive end timestamp are kept, and only the indicators that still
+// have observables. An indicator without any observables is kept if it was
+// modified in the window. Observables without a created timestamp use the
+// timestamps of their indicator, and ones that have no timestamp at all are
+// only sent on a full poll.
+
+func (this *ServerType) getIndicatorsInWindow(collectionName, begin, end string) ([]storage.IndicatorType, error) {
+	indicators, err := this.getIndicators(collectionName)
+	if err != nil || (begin == "" && end == "") {
+		return indicators, err
+	}
+
+	var selected []storage.IndicatorType
+	for _, indicator := range indicators {
+		changed := indicatorTimestamp(indicator.Modified, indicator.Created)
+
+		if len(indicator.Observables) == 0 {
+			if inPollWindow(changed, begin, end) {
+				selected = append(selected, indicator)
+			}
+			continue
+		}
+
+		var observables []storage.ObservableType
+		for _, observable := range indicator.Observables {
+			if inPollWindow(indicatorTimestamp(observable.Created, changed), begin, end) {
+				observables = append(observables, observable)
+			}
+		}
+		if len(observables) > 0 {
+			indicator.Observables = observables
+			selected = append(selected, indicator)
+		}
+	}
+	return selected, nil
+}
+
+// indicatorTimestamp returns the first of the timestamps that can be read,
+// in the same form as a timestamp label, or a blank string if none can be
+func indicatorTimestamp(timestamps ...string) string {
+	for _, value := range timestamps {
+		if value == "" {
+			continue
+		}
+		if label, err := parseTimestampLabel(value); err == nil {
+			return label
+		}
+	}
+	return ""
+}
+
+func inPollWindow(timestamp, begin, end string) bool {
+	if timestamp == "" {
+		return begin == ""
+	}
+	return (begin == "" || timestamp > begin) && (end == "" || timestamp <= end)
+}
+
+// This type holds the allowlist of each collection that has been asked for.
+// Changing the allowlist moves the catalog generation on, so the allowlists
+// are thrown away and built again when the catalog snapshot has a new one.
+type allowlistCacheType struct {
+	mutex      sync.Mutex
+	generation int64
+	allowlists map[string]*parser.AllowlistType
+}
+
+// --------------------------------------------------
+// Get the Allowlist for a Collection
+// --------------------------------------------------
+// This holds the global entries and the ones for the collection
+
+func (this *ServerType) getAllowlist(collectionName string) (*parser.AllowlistType, error) {
+	generation := this.getCatalog().generation
+
+	this.allowlists.mutex.Lock()
+	defer this.allowlists.mutex.Unlock()
+
+	if this.allowlists.allowlists == nil || this.allowlists.generation != generation {
+		this.allowlists.generation = generation
+		this.allowlists.allowlists = make(map[string]*parser.AllowlistType)
+	}
+	if allowlist, ok := this.allowlists.allowlists[collectionName]; ok {
+		return allowlist, nil
+	}
+
+	entries, err := this.Store.GetAllowlist(collectionName)
+	if err != nil {
+		return nil, err
+	}
+	allowlist, err := parser.NewAllowlist(entries)
+	if err != nil {
+		return nil, err
+	}
+	this.allowlists.allowlists[collectionName] = allowlist
+	return allowlist, nil
+}
+
+// The allowlists are built again on the next request
+func (this *ServerType) reloadAllowlists() {
+	this.allowlists.mutex.Lock()
+	defer this.allowlists.mutex.Unlock()
+
+	this.allowlists.allowlists = nil
+}
+
+// --------------------------------------------------
+// Check if a Collection has Indicators
+// --------------------------------------------------
+
+func (this *ServerType) hasIndicators(collectionName string) bool {
+	ok, err := this.Store.HasIndicators(collectionName)
+	if err != nil {
+		log.Printf("error counting indicators in collection %s, %v", collectionName, err)
+		return false
+	}
+	return ok
+}
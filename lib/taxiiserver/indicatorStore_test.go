@@ -0,0 +1,118 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"testing"
+
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+)
+
+func TestGetIndicatorsInWindow(t *testing.T) {
+	s := newTestServer(t)
+
+	var old storage.IndicatorType
+	old.Title = "Old"
+	old.Created = "2015-06-01T00:00:00.000000Z"
+	old.Modified = "2015-06-15T00:00:00.000000Z"
+	old.Observables = []storage.ObservableType{
+		{Type: "IP Address", Value: "203.0.113.1", Created: "2015-06-01T00:00:00.000000Z"},
+		{Type: "IP Address", Value: "203.0.113.2", Created: "2015-06-15T00:00:00Z"},
+		{Type: "IP Address", Value: "203.0.113.3"},
+	}
+
+	var empty storage.IndicatorType
+	empty.Title = "Empty"
+	empty.Created = "2015-06-20T00:00:00.000000Z"
+
+	for _, value := range []storage.IndicatorType{old, empty} {
+		err := s.Store.AddIndicator("test-collection", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		begin, end  string
+		indicators  []string
+		observables int
+	}{
+		// A full poll gets everything up to the end
+		{"", "2016-01-01T00:00:00.000000Z", []string{"Old", "Empty"}, 3},
+		{"", "", []string{"Old", "Empty"}, 3},
+		// The observable without a timestamp uses the modified timestamp of
+		// its indicator
+		{"2015-06-10T00:00:00.000000Z", "2016-01-01T00:00:00.000000Z", []string{"Old", "Empty"}, 2},
+		// The begin timestamp is exclusive and the end is inclusive
+		{"2015-06-15T00:00:00.000000Z", "2015-06-20T00:00:00.000000Z", []string{"Empty"}, 0},
+		{"2015-05-01T00:00:00.000000Z", "2015-06-01T00:00:00.000000Z", []string{"Old"}, 1},
+		{"2016-01-01T00:00:00.000000Z", "2016-02-01T00:00:00.000000Z", nil, 0},
+	}
+
+	for _, test := range tests {
+		indicators, err := s.getIndicatorsInWindow("test-collection", test.begin, test.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var titles []string
+		observables := 0
+		for _, value := range indicators {
+			titles = append(titles, value.Title)
+			observables += len(value.Observables)
+		}
+		if len(titles) != len(test.indicators) {
+			t.Errorf("window %q to %q, expected indicators %v, got %v", test.begin, test.end, test.indicators, titles)
+			continue
+		}
+		for i := range titles {
+			if titles[i] != test.indicators[i] {
+				t.Errorf("window %q to %q, expected indicators %v, got %v", test.begin, test.end, test.indicators, titles)
+				break
+			}
+		}
+		if observables != test.observables {
+			t.Errorf("window %q to %q, expected %d observables, got %d", test.begin, test.end, test.observables, observables)
+		}
+	}
+}
+
+func TestAllowlistFollowsGeneration(t *testing.T) {
+	s := newTestServer(t)
+	value := storage.ObservableType{Type: "IPv4 Address", Value: "192.0.2.1"}
+
+	first, err := s.getAllowlist("test-collection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := s.getAllowlist("test-collection"); again != first {
+		t.Errorf("the allowlist was built again without a change")
+	}
+
+	err = s.Store.AddAllowlistEntry(storage.AllowlistEntryType{CollectionName: "test-collection", Type: "cidr", Value: "192.0.2.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expireCatalogCheck(s)
+
+	allowlist, err := s.getAllowlist("test-collection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowlist == first {
+		t.Fatalf("the allowlist was not built again after it changed")
+	}
+	if _, ok := allowlist.Match(value); !ok {
+		t.Errorf("the new entry is not in the allowlist")
+	}
+
+	// A reload throws the allowlists away as well
+	s.ReloadCatalog()
+	if reloaded, _ := s.getAllowlist("test-collection"); reloaded == allowlist {
+		t.Errorf("the allowlist was not built again after a reload")
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"bytes"
+	"github.com/freetaxii/freetaxii-server/lib/headers"
+	"github.com/freetaxii/freetaxii-server/lib/messages/pollMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// --------------------------------------------------
+// Process a Poll Fulfillment Message
+// --------------------------------------------------
+// This is called by the Poll Server Handler once it has found that the
+// message it received is a Poll Fulfillment rather than a Poll Request. The
+// HTTP headers have already been checked.
+
+func (this *ServerType) pollFulfillment(w http.ResponseWriter, r *http.Request, taxiiHeader headers.HttpHeaderType, body []byte) {
+	incomingMessageData, err := pollMessage.DecodeFulfillment(taxiiHeader.RequestBinding, bytes.NewReader(body))
+
+	if err != nil {
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.BAD_MESSAGE, "Can not decode Poll Fulfillment")
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: BAD_MESSAGE, can not decode Poll Fulfillment")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	// Check to make sure there is a message ID in the request message
+	if incomingMessageData.Id == "" {
+		tm := this.CreateTaxiiStatusMessage("", statusMessage.BAD_MESSAGE, "Poll Fulfillment message did not include an ID")
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: BAD_MESSAGE, Poll Fulfillment message did not include an ID")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	// Log notice of incomming Poll Fulfillment
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Poll Fulfillment from %s for result %s part %d with ID: %s", r.RemoteAddr, incomingMessageData.ResultId, incomingMessageData.ResultPartNumber, incomingMessageData.Id)
+	}
+
+	// --------------------------------------------------
+	// Find the requested result set
+	// --------------------------------------------------
+
+	err = this.deleteExpiredResultSets()
+	if err != nil {
+		log.Printf("error removing expired result sets, %v", err)
+	}
+
+	rs, ok, err := this.getResultSet(incomingMessageData.ResultId)
+	if err != nil {
+		log.Printf("error reading result set %s, %v", incomingMessageData.ResultId, err)
+	}
+
+	// A result set of a collection the client can not read is not there for
+	// them, even if they have its ID
+	if ok && !this.getPermissions(r).allows(rs.CollectionName, storage.PERMISSION_READ) {
+		ok = false
+	}
+
+	if !ok || (incomingMessageData.CollectionName != "" && incomingMessageData.CollectionName != rs.CollectionName) {
+		errmsg := "The result set \"" + incomingMessageData.ResultId + "\" does not exist or has expired"
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.NOT_FOUND, errmsg)
+		tm.AddItem(incomingMessageData.ResultId)
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: NOT_FOUND, Poll Fulfillment asked for a result set that does not exist")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	// The result set may still be being built in the background
+	if rs.Status == RESULT_SET_PENDING {
+		tm := this.CreateTaxiiPendingStatusMessage(incomingMessageData.Id, rs.ResultId, this.estimatedWait())
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: PENDING, Poll Fulfillment asked for a result set that is not ready")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	if rs.Status == RESULT_SET_FAILED {
+		errmsg := "The result set \"" + incomingMessageData.ResultId + "\" could not be built"
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.FAILURE, errmsg)
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: FAILURE, Poll Fulfillment asked for a result set that could not be built")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	part := incomingMessageData.ResultPartNumber
+	if part < 1 || part > rs.Parts {
+		errmsg := "The result set \"" + incomingMessageData.ResultId + "\" does not have a part " + strconv.Itoa(part)
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.INVALID_RESPONSE_PART, errmsg)
+		tm.AddMaxPartNumber(rs.Parts)
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: INVALID_RESPONSE_PART, Poll Fulfillment asked for a result part that does not exist")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	blocks, err := this.getResultSetPart(rs.ResultId, part)
+	if err != nil {
+		log.Printf("error reading part %d of result set %s, %v", part, rs.ResultId, err)
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.FAILURE, "Unable to read the requested result part")
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	data, err := this.createPollResponsePart(taxiiHeader.ResponseBinding, incomingMessageData.Id, rs.CollectionName, rs.ResultId, rs.Begin, rs.End, part, rs.Parts, blocks)
+	if err != nil {
+		log.Printf("error creating Poll Response for result set %s, %v", rs.ResultId, err)
+		this.sendTaxiiServerError(w, taxiiHeader, incomingMessageData.Id, "Unable to create Poll Response")
+		return
+	}
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Println("DEBUG-1: Sending Poll Response part", part, "to", r.RemoteAddr)
+	}
+	taxiiHeader.SetHttpTaxiiResponseHeaders(w)
+	w.Write(data)
+}
@@ -7,14 +7,25 @@
 package taxiiserver
 
 import (
+	"bytes"
 	"encoding/json"
-	"github.com/freestix/libstix/stix"
 	"github.com/freetaxii/freetaxii-server/lib/headers"
-	"github.com/freetaxii/libtaxii/messages/pollMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"github.com/freetaxii/freetaxii-server/lib/messages/pollMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/subscriptionMessage"
+	"github.com/freetaxii/freetaxii-server/lib/parser"
+	"github.com/freetaxii/freetaxii-server/lib/stix1"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_POLL_MAX_CONTENT_BLOCKS = 100
+	DEFAULT_RESULT_SET_TIMEOUT      = 3600
 )
 
 func (this *ServerType) PollServerHandler(w http.ResponseWriter, r *http.Request) {
@@ -46,76 +57,168 @@ func (this *ServerType) PollServerHandler(w http.ResponseWriter, r *http.Request
 		// If the headers are not right we will not attempt to read the message.
 		// This also means that we will not have an InReponseTo ID for the
 		// createTaxiiStatusMessage function
-		statusMessageData := this.CreateTaxiiStatusMessage("", "BAD_MESSAGE", err.Error())
+		tm := this.CreateTaxiiStatusMessage("", statusMessage.BAD_MESSAGE, err.Error())
 		if this.SysConfig.Logging.LogLevel >= 1 {
 			log.Println("DEBUG-1: BAD_MESSAGE", err.Error())
 		}
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Write(statusMessageData)
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
 		return
 	}
 
 	// --------------------------------------------------
 	// Decode incoming request message
 	// --------------------------------------------------
-	// Use decoder instead of unmarshal so we can handle stream data
+	// The Poll service takes both Poll Request and Poll Fulfillment messages,
+	// so the body is read in full and the message type is checked before it
+	// is decoded as a Poll Request.
 
-	decoder := json.NewDecoder(r.Body)
+	this.limitRequestBody(w, r)
 	var incomingMessageData pollMessage.PollRequestMessageType
-	err = decoder.Decode(&incomingMessageData)
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		if pollMessage.GetMessageType(taxiiHeader.RequestBinding, body) == pollMessage.MSG_POLL_FULFILLMENT {
+			this.pollFulfillment(w, r, taxiiHeader, body)
+			return
+		}
+		incomingMessageData, err = pollMessage.DecodeRequest(taxiiHeader.RequestBinding, bytes.NewReader(body))
+	}
 
 	if err != nil {
-		statusMessageData := this.CreateTaxiiStatusMessage("", "BAD_MESSAGE", "Can not decode Poll Request")
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.BAD_MESSAGE, "Can not decode Poll Request")
 		if this.SysConfig.Logging.LogLevel >= 1 {
 			log.Println("DEBUG-1: BAD_MESSAGE, can not decode Poll Request")
 		}
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Write(statusMessageData)
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
 		return
 	}
 
 	// Check to make sure there is a message ID in the request message
 	if incomingMessageData.Id == "" {
-		statusMessageData := this.CreateTaxiiStatusMessage("", "BAD_MESSAGE", "Poll Request message did not include an ID")
+		tm := this.CreateTaxiiStatusMessage("", statusMessage.BAD_MESSAGE, "Poll Request message did not include an ID")
 		if this.SysConfig.Logging.LogLevel >= 1 {
 			log.Println("DEBUG-1: BAD_MESSAGE, Poll Request message did not include an ID")
 		}
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Write(statusMessageData)
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
 		return
 	}
 
 	// Log notice of incomming Poll Request
 	if this.SysConfig.Logging.LogLevel >= 1 {
-		log.Printf("DEBUG-1: Poll Request from %s for %s with ID: %s", r.RemoteAddr, incomingMessageData.CollectionName, incomingMessageData.Id)
+		log.Printf("DEBUG-1: Poll Request from %s (%s) for %s with ID: %s", r.RemoteAddr, identityName(r), incomingMessageData.CollectionName, incomingMessageData.Id)
+	}
+
+	// --------------------------------------------------
+	// Check for a valid subscription
+	// --------------------------------------------------
+	// A Poll Request may name a subscription instead of, or as well as, a
+	// collection.
+
+	if incomingMessageData.SubscriptionId != "" {
+		owner, _ := requestPrincipal(r)
+		sub, msgType, errmsg := this.pollSubscription(incomingMessageData, owner)
+		if msgType != "" {
+			tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, msgType, errmsg)
+			if msgType == statusMessage.NOT_FOUND {
+				tm.AddItem(incomingMessageData.SubscriptionId)
+			}
+			if this.SysConfig.Logging.LogLevel >= 1 {
+				log.Println("DEBUG-1:", msgType, errmsg)
+			}
+			this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+			return
+		}
+
+		incomingMessageData.CollectionName = sub.CollectionName
+	}
+
+	// --------------------------------------------------
+	// Check the requested time window
+	// --------------------------------------------------
+	// Only content with a timestamp label after the exclusive begin timestamp
+	// and up to the inclusive end timestamp is returned. If there is no end
+	// timestamp the current time is used, and it is sent back to the client
+	// so it can be used as the begin timestamp of their next poll.
+
+	var beginTimestamp string
+	endTimestamp := time.Now().UTC().Format(TIMESTAMP_LABEL_FORMAT)
+
+	if incomingMessageData.ExclusiveBeginTimestamp != "" {
+		beginTimestamp, err = parseTimestampLabel(incomingMessageData.ExclusiveBeginTimestamp)
+	}
+	if err == nil && incomingMessageData.InclusiveEndTimestamp != "" {
+		endTimestamp, err = parseTimestampLabel(incomingMessageData.InclusiveEndTimestamp)
+	}
+
+	if err != nil || (beginTimestamp != "" && beginTimestamp >= endTimestamp) {
+		errmsg := "The requested time window is not valid"
+		if err != nil {
+			errmsg = errmsg + ", " + err.Error()
+		}
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.BAD_MESSAGE, errmsg)
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: BAD_MESSAGE, Poll Request had an invalid time window")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
 	}
 
 	// --------------------------------------------------
 	// Check for valid collection
 	// --------------------------------------------------
 
-	currentlyValidCollections := this.SysConfig.GetValidCollections()
+	// With access control turned on a collection the client can not read
+	// gets the same answer as one that does not exist, so that the answer
+	// does not tell them it is there.
 
-	// TODO First check to make sure the value the requested is something they can actually get by their username / subscription / avaliable
-	// Based on the collection they are requesting, create a response that contains just the values for that collection
+	currentlyValidCollections := this.getPermittedCollections(r, storage.PERMISSION_READ)
 
 	if _, ok := currentlyValidCollections[incomingMessageData.CollectionName]; ok {
-		data := this.createPollResponse(incomingMessageData.Id, incomingMessageData.CollectionName)
+
+		// Collections that are built from slow remote sources are built in
+		// the background if the client is willing to come back for them.
+		var allowAsynch bool
+		var contentBindings []string
+		if incomingMessageData.PollParameters != nil {
+			allowAsynch = incomingMessageData.PollParameters.AllowAsynch
+			contentBindings = incomingMessageData.PollParameters.ContentBindings
+		}
+
+		if allowAsynch && this.collectionIsAsync(incomingMessageData.CollectionName) {
+			tm := this.createPendingPollResponse(incomingMessageData.Id, incomingMessageData.CollectionName, beginTimestamp, endTimestamp, contentBindings)
+
+			if this.SysConfig.Logging.LogLevel >= 1 {
+				log.Println("DEBUG-1: Sending Poll", tm.StatusType, "Status Message to", r.RemoteAddr)
+			}
+			this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+			return
+		}
+
+		data, err := this.createPollResponse(taxiiHeader.ResponseBinding, incomingMessageData.Id, incomingMessageData.CollectionName, beginTimestamp, endTimestamp, contentBindings)
+		if err != nil {
+			log.Printf("error creating Poll Response for collection %s, %v", incomingMessageData.CollectionName, err)
+			this.sendTaxiiServerError(w, taxiiHeader, incomingMessageData.Id, "Unable to create Poll Response")
+			return
+		}
 
 		if this.SysConfig.Logging.LogLevel >= 1 {
 			log.Println("DEBUG-1: Sending Poll Response to", r.RemoteAddr)
 		}
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		taxiiHeader.SetHttpTaxiiResponseHeaders(w)
 		w.Write(data)
+	} else if this.SysConfig.Auth.Acl {
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.UNAUTHORIZED, "Not authorized to poll the requested collection")
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Printf("DEBUG-1: UNAUTHORIZED, Poll Request from %s (%s) for %s", r.RemoteAddr, identityName(r), incomingMessageData.CollectionName)
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
 	} else {
 		errmsg := "The requested collection \"" + incomingMessageData.CollectionName + "\" does not exist"
-		statusMessageData := this.CreateTaxiiStatusMessage("", "DESTINATION_COLLECTION_ERROR", errmsg)
+		tm := this.CreateTaxiiDestinationErrorStatusMessage(incomingMessageData.Id, errmsg, currentlyValidCollections)
 		if this.SysConfig.Logging.LogLevel >= 1 {
 			log.Println("DEBUG-1: DESTINATION_COLLECTION_ERROR, Poll Request asked for a collection that does not exist")
 		}
 
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Write(statusMessageData)
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
 	}
 
 }
@@ -123,111 +226,192 @@ func (this *ServerType) PollServerHandler(w http.ResponseWriter, r *http.Request
 // --------------------------------------------------
 // Create a TAXII Poll Response Message
 // --------------------------------------------------
+// If there are more content blocks than will fit in a single response, the
+// whole result is saved as a result set and only the first part is returned.
+// The client can then ask for the other parts with Poll Fulfillment messages.
+// An empty list of content bindings means the client will accept any content.
+
+func (this *ServerType) createPollResponse(binding, responseid, collectionName, begin, end string, contentBindings []string) ([]byte, error) {
+	blocks := this.buildPollResult(collectionName, begin, end, contentBindings)
+	parts := this.splitResultParts(blocks)
+
+	var resultId string
+	if len(parts) > 1 {
+		rs := this.newResultSet(collectionName, begin, end, RESULT_SET_READY)
+
+		err := this.saveResultSet(rs, parts)
+		if err != nil {
+			// If the result set can not be saved the client could never get
+			// the other parts, so send everything in one response instead.
+			log.Printf("error saving result set for collection %s, %v", collectionName, err)
+			return this.createPollResponsePart(binding, responseid, collectionName, "", begin, end, 1, 1, blocks)
+		}
+		resultId = rs.ResultId
+	}
+
+	return this.createPollResponsePart(binding, responseid, collectionName, resultId, begin, end, 1, len(parts), parts[0])
+}
+
+// --------------------------------------------------
+// Build the Content Blocks for a Poll
+// --------------------------------------------------
+// Only content in one of the requested content bindings is returned, unless
+// no content bindings were requested.
+
+func (this *ServerType) buildPollResult(collectionName, begin, end string, contentBindings []string) []storage.ContentBlockType {
+	var blocks []storage.ContentBlockType
+
+	// The watch list is built fresh on every poll from the indicators and
+	// observables that were added or changed during the window, so an
+	// incremental poll only gets what is new.
+	indicators, ok := this.createIndicators(collectionName, begin, end, contentBindings)
+	if ok {
+		blocks = append(blocks, indicators)
+	}
+
+	// Add any content that has been pushed in to this collection via the
+	// Inbox service
+	storedBlocks, err := this.getContentBlocksInWindow(collectionName, begin, end)
+	if err != nil {
+		log.Printf("error reading content blocks for collection %s, %v", collectionName, err)
+	}
+	for _, value := range storedBlocks {
+		if len(contentBindings) == 0 || stringInList(value.ContentBinding, contentBindings) {
+			blocks = append(blocks, value)
+		}
+	}
+	return blocks
+}
+
+// --------------------------------------------------
+// Create a new Result Set
+// --------------------------------------------------
+// Expired result sets are cleaned up every time a new one is made
 
-func (this *ServerType) createPollResponse(responseid, collectionName string) []byte {
+func (this *ServerType) newResultSet(collectionName, begin, end, status string) ResultSetType {
+	timeout := this.SysConfig.Poll.ResultSetTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_RESULT_SET_TIMEOUT
+	}
+
+	var rs ResultSetType
+	rs.ResultId = common.CreateMessageId()
+	rs.CollectionName = collectionName
+	rs.Begin = begin
+	rs.End = end
+	rs.Status = status
+	rs.Expires = time.Now().Add(time.Duration(timeout) * time.Second).UTC().Format(TIMESTAMP_LABEL_FORMAT)
+
+	err := this.deleteExpiredResultSets()
+	if err != nil {
+		log.Printf("error removing expired result sets, %v", err)
+	}
+	return rs
+}
+
+// --------------------------------------------------
+// Create a TAXII Poll Response Message for one Part of a Result
+// --------------------------------------------------
+
+func (this *ServerType) createPollResponsePart(binding, responseid, collectionName, resultId, begin, end string, part, parts int, blocks []storage.ContentBlockType) ([]byte, error) {
 	tm := pollMessage.NewResponse()
 	tm.AddInResponseTo(responseid)
 	tm.AddCollectionName(collectionName)
-	tm.AddResultId("freetaxii-test-service-1")
+	if resultId != "" {
+		tm.AddResultId(resultId)
+	}
+	tm.AddExclusiveBeginTimestamp(begin)
+	tm.AddInclusiveEndTimestamp(end)
+	tm.AddResultPartNumber(part)
+	tm.SetMore(part < parts)
 	tm.AddMessage("This is a test service for FreeTAXII")
-	content := tm.NewContentBlock()
-	content.SetContentEncodingToJson()
-	indicators := this.createIndicatorsJSON(collectionName)
-	content.AddContent(indicators)
 
-	data, err := json.Marshal(tm)
-	if err != nil {
-		// If we can not create a status message then there is something
-		// wrong with the APIs and nothing is going to work.
-		log.Fatal("Unable to create Poll Response Message")
+	for _, value := range blocks {
+		c := tm.NewContentBlock()
+		c.AddContentBinding(value.ContentBinding)
+		c.AddContentEncoding(value.Encoding)
+		c.AddContent(value.Content)
+		c.AddTimestampLabel(value.TimestampLabel)
 	}
-	return data
+
+	return tm.Encode(binding)
 }
 
-func (this *ServerType) createIndicatorsJSON(collectionName string) string {
-
-	// Need to pass in the collection name they have requested
-	// then go to the database and the get the fields that are needed
-	// to populate the correct STIX message.
-	// I need a table in the database add the source data and other things to the collections table
-	// Create a new table for holding the indicators / observables.
-	s := stix.New()
-	i1 := s.NewIndicator()
-	i1.SetTimestampToNow()
-
-	if collectionName == "ip-watch-list" || collectionName == "url-watch-list" {
-		list := []string{
-			"176.119.3.108",
-			"178.207.85.119",
-			"178.63.174.153",
-			"188.241.140.212",
-			"14.138.73.47",
-			"131.72.138.45",
-			"62.84.51.39",
-			"62.109.23.246",
-			"5.101.113.169",
-			"213.231.8.30",
-			"208.43.25.52",
-			"112.208.6.209",
-			"115.239.248.87",
-			"117.216.190.71",
-			"131.72.139.233",
-			"129.194.97.21",
-			"162.244.35.229",
-			"178.219.10.23",
-			"184.154.124.203",
-			"184.154.146.100",
-			"184.154.146.101",
-		}
-		i1.AddTitle("Malicious IP Addresses")
-		i1.AddType("IP Watchlist")
-		observable_i1 := i1.NewObservable()
-		properties_1 := observable_i1.GetObjectProperties()
-
-		properties_1.AddType("IP Address")
-
-		for _, value := range list {
-			properties_1.AddEqualsUriValue(value)
-		}
-
-	} else if collectionName == "et-compromised-ips" {
-
-		source1 := stix.CreateInformationSource()
-		source1.AddDescriptionText("The Test.FreeTAXII.com Server")
-		source1.SetProducedTimeToNow()
-		source1.AddReference("http://test.freetaxii.com")
-
-		identity1 := stix.CreateIdentity()
-		identity1.AddName("FreeTAXII")
-		source1.AddIdentity(identity1)
-
-		contribSource1 := stix.CreateInformationSource()
-		identity2 := stix.CreateIdentity()
-		identity2.AddName("Emerging Threats Compromised IPs")
-		contribSource1.AddIdentity(identity2)
-		contribSource1.AddReference("http://rules.emergingthreats.net/blockrules/compromised-ips.txt")
-
-		source1.AddContributingSource(contribSource1)
-		i1.AddProducer(source1)
-
-		resp, _ := http.Get("http://rules.emergingthreats.net/blockrules/compromised-ips.txt")
-		defer resp.Body.Close()
-		rawhtmlbody, _ := ioutil.ReadAll(resp.Body)
-
-		s := string(rawhtmlbody)
-		s = strings.TrimSpace(s)
-		body := strings.Split(s, "\n")
-
-		i1.AddTitle("Compromised IP Addresses")
-		i1.AddType("IP Watchlist")
-		observable_i1 := i1.NewObservable()
-		properties_1 := observable_i1.GetObjectProperties()
-
-		properties_1.AddType("IP Address")
-
-		for _, value := range body {
-			properties_1.AddEqualsUriValue(value)
+// --------------------------------------------------
+// Split Content Blocks in to Result Parts
+// --------------------------------------------------
+// There is always at least one part, even if it is empty
+
+func (this *ServerType) splitResultParts(blocks []storage.ContentBlockType) [][]storage.ContentBlockType {
+	maxBlocks := this.SysConfig.Poll.MaxContentBlocks
+	if maxBlocks <= 0 {
+		maxBlocks = DEFAULT_POLL_MAX_CONTENT_BLOCKS
+	}
+
+	parts := [][]storage.ContentBlockType{}
+	for len(blocks) > maxBlocks {
+		parts = append(parts, blocks[:maxBlocks])
+		blocks = blocks[maxBlocks:]
+	}
+	return append(parts, blocks)
+}
+
+// --------------------------------------------------
+// Create the STIX 1.x Indicators for a Collection
+// --------------------------------------------------
+// Each stored indicator gets one observable for every type of observable it
+// holds, with the CybOX object of that type matching any of the values.
+
+func (this *ServerType) createIndicatorsJSON(indicators []storage.IndicatorType) string {
+	s := stix1.NewPackage()
+
+	for _, indicator := range indicators {
+		i1 := stix1.NewIndicator()
+
+		// Indicators that come from somewhere else name where they came from
+		if indicator.ProducerName != "" {
+			source1 := stix1.NewInformationSource()
+			source1.AddDescription("The Test.FreeTAXII.com Server")
+			source1.SetProducedTime(time.Now())
+			source1.AddReference("http://test.freetaxii.com")
+			source1.AddIdentityName("FreeTAXII")
+
+			contribSource1 := stix1.NewInformationSource()
+			contribSource1.AddIdentityName(indicator.ProducerName)
+			contribSource1.AddReference(indicator.ProducerReference)
+
+			source1.AddContributingSource(contribSource1)
+			i1.AddProducer(source1)
+		}
+
+		i1.AddTitle(indicator.Title)
+		i1.AddType(indicator.Type)
+
+		// Keep the observable types in the order they were first seen. Values
+		// are classified again as they may have been stored before they were
+		// checked on the way in, and the ones that are not valid are left out.
+		var types []string
+		values := make(map[string][]string)
+		for _, observable := range indicator.Observables {
+			observableType, value, err := parser.Classify(observable.Value)
+			if err != nil {
+				continue
+			}
+			if _, ok := values[observableType]; !ok {
+				types = append(types, observableType)
+			}
+			values[observableType] = append(values[observableType], value)
+		}
+
+		for _, observableType := range types {
+			properties, ok := cyboxObjectProperties(observableType, values[observableType])
+			if !ok {
+				continue
+			}
+			i1.AddObservable(properties)
 		}
+
+		s.AddIndicator(i1)
 	}
 
 	var data []byte
@@ -239,3 +423,64 @@ func (this *ServerType) createIndicatorsJSON(collectionName string) string {
 
 	return string(data)
 }
+
+// --------------------------------------------------
+// Map an Observable Type to a CybOX Object
+// --------------------------------------------------
+// Addresses and networks are address objects with the matching category,
+// domain names are FQDN domain name objects, URLs are URL URI objects and
+// hashes are file objects named after the CybOX hash vocabulary. The boolean
+// return value is false if there is no CybOX object for the type.
+
+func cyboxObjectProperties(observableType string, values []string) (stix1.ObjectPropertiesType, bool) {
+	switch observableType {
+	case parser.OBSERVABLE_TYPE_IPV4_ADDRESS:
+		return stix1.NewAddressProperties(stix1.ADDRESS_CATEGORY_IPV4_ADDRESS, values), true
+	case parser.OBSERVABLE_TYPE_IPV6_ADDRESS:
+		return stix1.NewAddressProperties(stix1.ADDRESS_CATEGORY_IPV6_ADDRESS, values), true
+	case parser.OBSERVABLE_TYPE_IPV4_NETWORK:
+		return stix1.NewAddressProperties(stix1.ADDRESS_CATEGORY_IPV4_NETWORK, values), true
+	case parser.OBSERVABLE_TYPE_IPV6_NETWORK:
+		return stix1.NewAddressProperties(stix1.ADDRESS_CATEGORY_IPV6_NETWORK, values), true
+	case parser.OBSERVABLE_TYPE_EMAIL_ADDRESS:
+		return stix1.NewAddressProperties(stix1.ADDRESS_CATEGORY_EMAIL, values), true
+	case parser.OBSERVABLE_TYPE_DOMAIN_NAME:
+		return stix1.NewDomainNameProperties(values), true
+	case parser.OBSERVABLE_TYPE_URL:
+		return stix1.NewUrlProperties(values), true
+	case parser.OBSERVABLE_TYPE_MD5:
+		return stix1.NewFileHashProperties("MD5", values), true
+	case parser.OBSERVABLE_TYPE_SHA1:
+		return stix1.NewFileHashProperties("SHA1", values), true
+	case parser.OBSERVABLE_TYPE_SHA256:
+		return stix1.NewFileHashProperties("SHA256", values), true
+	case parser.OBSERVABLE_TYPE_SHA512:
+		return stix1.NewFileHashProperties("SHA512", values), true
+	}
+	return stix1.ObjectPropertiesType{}, false
+}
+
+// --------------------------------------------------
+// Get the Subscription of a Poll Request
+// --------------------------------------------------
+// The subscription must belong to the requester, be active and be for the
+// collection that was requested. A subscription of another owner is reported
+// as missing, so its ID does not show that it exists.
+
+func (this *ServerType) pollSubscription(req pollMessage.PollRequestMessageType, owner storage.PrincipalType) (storage.SubscriptionType, string, string) {
+	sub, ok, err := this.getSubscription(req.SubscriptionId)
+	if err != nil {
+		log.Printf("error reading subscription %s, %v", req.SubscriptionId, err)
+	}
+
+	if !ok || sub.Owner != owner {
+		return sub, statusMessage.NOT_FOUND, "The subscription \"" + req.SubscriptionId + "\" does not exist"
+	}
+	if req.CollectionName != "" && req.CollectionName != sub.CollectionName {
+		return sub, statusMessage.BAD_MESSAGE, "The subscription \"" + req.SubscriptionId + "\" is not for the requested collection"
+	}
+	if sub.Status != subscriptionMessage.STATUS_ACTIVE {
+		return sub, statusMessage.FAILURE, "The subscription \"" + req.SubscriptionId + "\" is not active"
+	}
+	return sub, "", ""
+}
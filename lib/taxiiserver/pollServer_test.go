@@ -0,0 +1,106 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/freetaxii/freetaxii-server/lib/messages/pollMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/subscriptionMessage"
+	"github.com/freetaxii/freetaxii-server/lib/stix1"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+)
+
+func TestCreateIndicatorsJSONObjects(t *testing.T) {
+	s := newTestServer(t)
+
+	var indicator storage.IndicatorType
+	indicator.Title = "Mixed"
+	for _, value := range []string{
+		"192.0.2.1", "2001:db8::1", "192.0.2.2", "Example.COM", "http://example.com/a",
+		"d41d8cd98f00b204e9800998ecf8427e", "not a value",
+	} {
+		indicator.Observables = append(indicator.Observables, storage.ObservableType{Value: value})
+	}
+
+	var p stix1.PackageType
+	err := json.Unmarshal([]byte(s.createIndicatorsJSON([]storage.IndicatorType{indicator})), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Indicators) != 1 {
+		t.Fatalf("got %d indicators, want 1", len(p.Indicators))
+	}
+
+	var got []stix1.ObjectPropertiesType
+	for _, value := range p.Indicators[0].Observables {
+		got = append(got, value.Object.Properties)
+	}
+	if len(got) != 5 {
+		t.Fatalf("got %d observables, want 5, %+v", len(got), got)
+	}
+
+	ipv4 := got[0]
+	if ipv4.XsiType != stix1.OBJECT_TYPE_ADDRESS || ipv4.Category != "ipv4-addr" || ipv4.AddressValue == nil ||
+		ipv4.AddressValue.Value != "192.0.2.1##comma##192.0.2.2" || ipv4.AddressValue.ApplyCondition != "ANY" || ipv4.Value != nil {
+		t.Errorf("IPv4 addresses %+v", ipv4)
+	}
+
+	ipv6 := got[1]
+	if ipv6.XsiType != stix1.OBJECT_TYPE_ADDRESS || ipv6.Category != "ipv6-addr" || ipv6.AddressValue == nil || ipv6.AddressValue.Value != "2001:db8::1" {
+		t.Errorf("IPv6 address %+v", ipv6)
+	}
+
+	domain := got[2]
+	if domain.XsiType != stix1.OBJECT_TYPE_DOMAIN_NAME || domain.Type != "FQDN" || domain.Value == nil || domain.Value.Value != "example.com" {
+		t.Errorf("domain name %+v", domain)
+	}
+
+	url := got[3]
+	if url.XsiType != stix1.OBJECT_TYPE_URI || url.Type != "URL" || url.Value == nil || url.Value.Value != "http://example.com/a" {
+		t.Errorf("URL %+v", url)
+	}
+
+	file := got[4]
+	if file.XsiType != stix1.OBJECT_TYPE_FILE || len(file.Hashes) != 1 || file.Hashes[0].Type.Value != "MD5" ||
+		file.Hashes[0].SimpleHashValue.Value != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Errorf("file hash %+v", file)
+	}
+}
+
+func TestPollSubscriptionBelongsToItsOwner(t *testing.T) {
+	s := newTestServer(t)
+	alice := storage.PrincipalType{Type: storage.PRINCIPAL_TYPE_USER, Name: "alice"}
+	bob := storage.PrincipalType{Type: storage.PRINCIPAL_TYPE_USER, Name: "bob"}
+
+	response, msgType, errmsg := s.subscribe(newSubscriptionRequest(subscriptionMessage.ACTION_SUBSCRIBE, ""), alice)
+	if msgType != "" {
+		t.Fatalf("subscribe failed, %s %s", msgType, errmsg)
+	}
+
+	var req pollMessage.PollRequestMessageType
+	req.Id = "1"
+	req.SubscriptionId = response.SubscriptionInstances[0].SubscriptionId
+
+	sub, msgType, _ := s.pollSubscription(req, alice)
+	if msgType != "" || sub.CollectionName != "test-collection" {
+		t.Errorf("the owner could not poll the subscription, %q", msgType)
+	}
+
+	// Another owner gets the same answer as for a subscription that does
+	// not exist
+	_, msgType, errmsg = s.pollSubscription(req, bob)
+	req.SubscriptionId = "missing"
+	_, missingType, missingErrmsg := s.pollSubscription(req, bob)
+	if msgType != statusMessage.NOT_FOUND || msgType != missingType ||
+		strings.Replace(errmsg, response.SubscriptionInstances[0].SubscriptionId, "missing", 1) != missingErrmsg {
+		t.Errorf("another owner was told %q %q, a missing subscription %q %q", msgType, errmsg, missingType, missingErrmsg)
+	}
+}
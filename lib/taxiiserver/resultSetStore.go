@@ -0,0 +1,98 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"log"
+	"time"
+)
+
+const (
+	RESULT_SET_READY   = "READY"
+	RESULT_SET_PENDING = "PENDING"
+	RESULT_SET_FAILED  = "FAILED"
+)
+
+// This type holds the details of a poll result set along with what is needed
+// to build it.
+type ResultSetType struct {
+	storage.ResultSetType
+
+	// The content bindings are only needed while the result set is being
+	// built, so they are not saved in the store
+	ContentBindings []string
+}
+
+// --------------------------------------------------
+// Save a Result Set and all of its Parts
+// --------------------------------------------------
+// The content is copied in to the result set so that every part comes from
+// the same snapshot of the collection, even if content is added later.
+
+func (this *ServerType) saveResultSet(rs ResultSetType, parts [][]storage.ContentBlockType) error {
+	rs.Created = time.Now().UTC().Format(TIMESTAMP_LABEL_FORMAT)
+
+	err := this.Store.AddResultSet(rs.ResultSetType, parts)
+	if err != nil {
+		return err
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Saved result set %s for collection %s with %d parts", rs.ResultId, rs.CollectionName, len(parts))
+	}
+	return nil
+}
+
+// --------------------------------------------------
+// Finish a Pending Result Set
+// --------------------------------------------------
+// Saves the parts that were built in the background and marks the result set
+// as ready, or as failed if no parts could be built.
+
+func (this *ServerType) completeResultSet(resultId string, parts [][]storage.ContentBlockType, status string) error {
+	err := this.Store.CompleteResultSet(resultId, parts, status)
+	if err != nil {
+		return err
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Completed result set %s with %d parts and status %s", resultId, len(parts), status)
+	}
+	return nil
+}
+
+// --------------------------------------------------
+// Get a Result Set by its ID
+// --------------------------------------------------
+// The boolean return value is false if the result set does not exist or has
+// expired
+
+func (this *ServerType) getResultSet(resultId string) (ResultSetType, bool, error) {
+	var rs ResultSetType
+	var ok bool
+	var err error
+
+	rs.ResultSetType, ok, err = this.Store.GetResultSet(resultId, time.Now().UTC().Format(TIMESTAMP_LABEL_FORMAT))
+	return rs, ok, err
+}
+
+// --------------------------------------------------
+// Get the Content Blocks for one Part of a Result Set
+// --------------------------------------------------
+
+func (this *ServerType) getResultSetPart(resultId string, part int) ([]storage.ContentBlockType, error) {
+	return this.Store.GetResultSetPart(resultId, part)
+}
+
+// --------------------------------------------------
+// Remove Expired Result Sets
+// --------------------------------------------------
+
+func (this *ServerType) deleteExpiredResultSets() error {
+	return this.Store.DeleteExpiredResultSets(time.Now().UTC().Format(TIMESTAMP_LABEL_FORMAT))
+}
@@ -8,6 +8,14 @@ package taxiiserver
 
 import (
 	"github.com/freetaxii/freetaxii-server/lib/config"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_MAX_REQUEST_SIZE = 10485760
 )
 
 // ----------------------------------------------------------------------
@@ -16,18 +24,33 @@ import (
 
 type ServerType struct {
 	SysConfig      *config.ServerConfigType
-	ReloadServices bool
-	CurrentTaxiiServicesType
-}
-
-// This type will hold the list of currently configured TAXII Services as found
-// in the database
-type CurrentTaxiiServicesType struct {
-	CurrentTaxiiServices []TaxiiServiceType
+	Store          storage.StoreType
+	ClientVerifier *ClientVerifierType // Nil unless client certificates are checked
+	StartTime      time.Time           // When the server started, for the admin statistics
+	catalog        CatalogType
+	allowlists     allowlistCacheType
+	asyncPollOnce  sync.Once
+	asyncPollJobs  chan ResultSetType
 }
 
+// This type holds a TAXII Service as found in the store
 type TaxiiServiceType struct {
 	ServiceType string
 	Available   bool
 	Address     string
 }
+
+// --------------------------------------------------
+// Limit the size of a request body
+// --------------------------------------------------
+// A TAXII 1.x request body that is larger than the configured size fails to
+// read, so the services answer it with a BAD_MESSAGE instead of reading it
+// all into memory.
+
+func (this *ServerType) limitRequestBody(w http.ResponseWriter, r *http.Request) {
+	limit := int64(DEFAULT_MAX_REQUEST_SIZE)
+	if this.SysConfig.Services.MaxRequestSize > 0 {
+		limit = int64(this.SysConfig.Services.MaxRequestSize)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+}
@@ -0,0 +1,67 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/freetaxii/freetaxii-server/lib/config"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"github.com/freetaxii/libtaxii/defs"
+)
+
+// newTestServer returns a server backed by the memory store with one
+// collection, test-collection, that can be polled and subscribed to.
+func newTestServer(t testing.TB) *ServerType {
+	var c config.ServerConfigType
+	c.Services.Subscription = "/services/collection-management/"
+	c.Services.Poll = "/services/poll/"
+	c.Services.Inbox = "/services/inbox/"
+
+	store := storage.NewMemoryStore()
+	err := store.AddCollection(storage.CollectionType{Name: "test-collection", Description: "Test collection", Type: "DATA_SET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ServerType{SysConfig: &c, Store: store}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	s := newTestServer(t)
+	s.SysConfig.Services.MaxRequestSize = 256
+
+	handlers := map[string]http.HandlerFunc{
+		"Discovery_Request":               s.DiscoveryServerHandler,
+		"Collection_Information_Request":  s.CollectionServerHandler,
+		"Subscription_Management_Request": s.SubscriptionServerHandler,
+		"Poll_Request":                    s.PollServerHandler,
+		"Inbox_Message":                   s.InboxServerHandler,
+	}
+
+	for messageType, handler := range handlers {
+		body := `{"message_type":"` + messageType + `","id":"` + strings.Repeat("1", 256) + `"}`
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("X-Taxii-Services", defs.TAXII_VERSION)
+		r.Header.Set("X-Taxii-Content-Type", common.TAXII_MESSAGE_JSON)
+		r.Header.Set("X-Taxii-Accept", common.TAXII_MESSAGE_JSON)
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		var status statusMessage.StatusMessageType
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || status.StatusType != statusMessage.BAD_MESSAGE {
+			t.Errorf("%s larger than the limit was answered with %q", messageType, w.Body.String())
+		}
+	}
+}
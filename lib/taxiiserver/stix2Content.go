@@ -0,0 +1,223 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"encoding/json"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"github.com/freetaxii/freetaxii-server/lib/parser"
+	"github.com/freetaxii/freetaxii-server/lib/stix2"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"log"
+	"strconv"
+	"time"
+)
+
+// --------------------------------------------------
+// Create the Indicators Content Block for a Collection
+// --------------------------------------------------
+// The content binding is picked from the ones the client asked for, or from
+// the collection if the client did not ask for any. The boolean return value
+// is false if the indicators can not be sent in any of the requested content
+// bindings, or if a poll with a begin timestamp has no new indicators.
+
+func (this *ServerType) createIndicators(collectionName, begin, end string, contentBindings []string) (storage.ContentBlockType, bool) {
+	var block storage.ContentBlockType
+
+	binding := this.selectIndicatorBinding(collectionName, contentBindings)
+	if binding == "" {
+		return block, false
+	}
+
+	indicators, err := this.getIndicatorsInWindow(collectionName, begin, end)
+	if err != nil {
+		log.Printf("error reading indicators for collection %s, %v", collectionName, err)
+	}
+	if len(indicators) == 0 && begin != "" {
+		return block, false
+	}
+
+	block.ContentBinding = binding
+	block.Encoding = "json"
+
+	if binding == common.CONTENT_BINDING_STIX2_JSON {
+		content, ok := this.createIndicatorsStix2(indicators)
+		if !ok {
+			return block, false
+		}
+		block.Content = content
+	} else {
+		block.Content = this.createIndicatorsJSON(indicators)
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Created indicators for collection %s with content binding %s", collectionName, binding)
+	}
+	return block, true
+}
+
+// --------------------------------------------------
+// Select the Content Binding for the Indicators
+// --------------------------------------------------
+// An empty string is returned if none of the requested content bindings can
+// be used.
+
+func (this *ServerType) selectIndicatorBinding(collectionName string, contentBindings []string) string {
+	if len(contentBindings) == 0 {
+		return this.getCollectionContentBinding(collectionName)
+	}
+
+	for _, value := range contentBindings {
+		if value == common.CONTENT_BINDING_STIX1_JSON || value == common.CONTENT_BINDING_STIX2_JSON {
+			return value
+		}
+	}
+	return ""
+}
+
+// --------------------------------------------------
+// Get the Default Content Binding of a Collection
+// --------------------------------------------------
+// Collections that do not have a content binding set are published as
+// STIX 1.x, which is what they have always been.
+
+func (this *ServerType) getCollectionContentBinding(collectionName string) string {
+	collection := this.getValidCollections()[collectionName]
+	if collection.ContentBinding == "" {
+		return common.CONTENT_BINDING_STIX1_JSON
+	}
+	return collection.ContentBinding
+}
+
+// --------------------------------------------------
+// Create a STIX 2.1 Bundle of Indicators
+// --------------------------------------------------
+// Every observable becomes an indicator created by the FreeTAXII identity,
+// which is sent in the same bundle. The IDs are based on the stored
+// indicator and the value, so an indicator keeps its ID from one poll to the
+// next. The boolean return value is false if the collection does not have any
+// indicators.
+
+func (this *ServerType) createIndicatorsStix2(indicators []storage.IndicatorType) (string, bool) {
+	if len(indicators) == 0 {
+		return "", false
+	}
+
+	bundle := stix2.NewBundle()
+
+	// The identity keeps the same ID, so it also needs to keep the same
+	// timestamps. The first indicator is as good a time as any.
+	producer := stix2.NewIdentity()
+	producer.AddId(stix2.CreateNameBasedId("identity", "FreeTAXII"))
+	producer.SetCreated(parseStoredTimestamp(indicators[0].Created))
+	producer.AddName("FreeTAXII")
+	producer.AddDescription("The Test.FreeTAXII.com Server")
+	producer.SetClassOrganization()
+	producer.AddContactInformation("http://test.freetaxii.com")
+	bundle.AddObject(producer)
+
+	for _, indicator := range indicators {
+		modified := parseStoredTimestamp(indicator.Modified)
+
+		for _, observable := range indicator.Observables {
+			// Values are classified again as they may have been stored
+			// before they were checked on the way in
+			observableType, value, err := parser.Classify(observable.Value)
+			if err != nil {
+				continue
+			}
+			pattern := createStix2Pattern(observableType, value)
+			if pattern == "" {
+				continue
+			}
+
+			created := parseStoredTimestamp(observable.Created)
+
+			i := stix2.NewIndicator()
+			i.AddId(stix2.CreateNameBasedId("indicator", strconv.FormatInt(indicator.Id, 10)+"/"+value))
+			i.AddCreatedByRef(producer.Id)
+			i.SetCreated(created)
+			if modified.After(created) {
+				i.SetModified(modified)
+			}
+			i.SetValidFrom(created)
+			i.AddName(value)
+			i.AddDescription(indicator.Title)
+			i.AddIndicatorType(indicator.IndicatorType)
+			i.AddPattern(pattern)
+			if indicator.ProducerName != "" {
+				i.AddExternalReference(indicator.ProducerName, "", indicator.ProducerReference)
+			}
+			bundle.AddObject(i)
+		}
+	}
+
+	var data []byte
+	data, _ = json.MarshalIndent(bundle, "", "    ")
+	return string(data), true
+}
+
+// --------------------------------------------------
+// Create the STIX 2 Pattern for an Observable
+// --------------------------------------------------
+// A network is matched as an address object, as the value of ipv4-addr and
+// ipv6-addr can be a CIDR block. A blank pattern is returned for a type that
+// does not have one.
+
+func createStix2Pattern(observableType, value string) string {
+	switch observableType {
+	case parser.OBSERVABLE_TYPE_IPV4_ADDRESS, parser.OBSERVABLE_TYPE_IPV4_NETWORK:
+		return stix2.CreateIpv4Pattern(value)
+	case parser.OBSERVABLE_TYPE_IPV6_ADDRESS, parser.OBSERVABLE_TYPE_IPV6_NETWORK:
+		return stix2.CreateIpv6Pattern(value)
+	case parser.OBSERVABLE_TYPE_DOMAIN_NAME:
+		return stix2.CreateDomainPattern(value)
+	case parser.OBSERVABLE_TYPE_URL:
+		return stix2.CreateUrlPattern(value)
+	case parser.OBSERVABLE_TYPE_EMAIL_ADDRESS:
+		return stix2.CreateEmailPattern(value)
+	case parser.OBSERVABLE_TYPE_MD5, parser.OBSERVABLE_TYPE_SHA1, parser.OBSERVABLE_TYPE_SHA256, parser.OBSERVABLE_TYPE_SHA512:
+		return stix2.CreateFileHashPattern(observableType, value)
+	}
+	return ""
+}
+
+// Stored timestamps are in TIMESTAMP_LABEL_FORMAT, the current time is used
+// if one can not be read
+func parseStoredTimestamp(s string) time.Time {
+	t, err := time.Parse(TIMESTAMP_LABEL_FORMAT, s)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
+
+// --------------------------------------------------
+// Check if a String is in a List
+// --------------------------------------------------
+
+func stringInList(s string, list []string) bool {
+	for _, value := range list {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// --------------------------------------------------
+// Append Strings that are not already in a List
+// --------------------------------------------------
+
+func appendMissing(list []string, values ...string) []string {
+	for _, value := range values {
+		if !stringInList(value, list) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
@@ -0,0 +1,333 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"encoding/json"
+	"github.com/freetaxii/freetaxii-server/lib/headers"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/subscriptionMessage"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"github.com/freetaxii/libtaxii/defs"
+	"log"
+	"net/http"
+)
+
+func (this *ServerType) SubscriptionServerHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var taxiiHeader headers.HttpHeaderType
+
+	// Log notice of incoming TAXII message
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Found Message on Subscription Server Handler from %s", r.RemoteAddr)
+	}
+
+	// We need to put this first so that during debugging we can see problems
+	// that will generate errors below.
+	if this.SysConfig.Logging.LogLevel >= 5 {
+		taxiiHeader.DebugHttpRequest(r)
+	}
+
+	// --------------------------------------------------
+	// Check HTTP Headers for correct TAXII values
+	// --------------------------------------------------
+	// Send a Status Message on error
+
+	err = taxiiHeader.VerifyHttpTaxiiHeaderValues(r)
+	if err != nil {
+		if this.SysConfig.Logging.LogLevel >= 3 {
+			log.Print(err)
+		}
+
+		// If the headers are not right we will not attempt to read the message.
+		// This also means that we will not have an InReponseTo ID for the
+		// createTaxiiStatusMessage function
+		tm := this.CreateTaxiiStatusMessage("", statusMessage.BAD_MESSAGE, err.Error())
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: BAD_MESSAGE", err.Error())
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	// Subscription Management messages are only supported in the JSON binding
+	if taxiiHeader.RequestBinding != common.TAXII_MESSAGE_JSON || taxiiHeader.ResponseBinding != common.TAXII_MESSAGE_JSON {
+		tm := this.CreateTaxiiStatusMessage("", statusMessage.UNSUPPORTED_MESSAGE, "The Collection Management service only supports the JSON message binding")
+		tm.AddSupportedBinding(common.TAXII_MESSAGE_JSON)
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: UNSUPPORTED_MESSAGE, Subscription Management Request was not sent in the JSON binding")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	// --------------------------------------------------
+	// Decode incoming request message
+	// --------------------------------------------------
+	// Use decoder instead of unmarshal so we can handle stream data
+
+	this.limitRequestBody(w, r)
+	decoder := json.NewDecoder(r.Body)
+	var incomingMessageData subscriptionMessage.SubscriptionRequestMessageType
+	err = decoder.Decode(&incomingMessageData)
+
+	if err != nil {
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.BAD_MESSAGE, "Can not decode Subscription Management Request")
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: BAD_MESSAGE, can not decode Subscription Management Request")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	// Check to make sure there is a message ID in the request message
+	if incomingMessageData.Id == "" {
+		tm := this.CreateTaxiiStatusMessage("", statusMessage.BAD_MESSAGE, "Subscription Management Request message did not include an ID")
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: BAD_MESSAGE, Subscription Management Request message did not include an ID")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	// Log notice of incomming Subscription Management Request
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Subscription Management Request from %s for %s with action %s and ID: %s", r.RemoteAddr, incomingMessageData.CollectionName, incomingMessageData.Action, incomingMessageData.Id)
+	}
+
+	// --------------------------------------------------
+	// Check for valid collection
+	// --------------------------------------------------
+
+	// With access control turned on a collection the client can not read
+	// gets the same answer as one that does not exist
+
+	currentlyValidCollections := this.getPermittedCollections(r, storage.PERMISSION_READ)
+
+	if _, ok := currentlyValidCollections[incomingMessageData.CollectionName]; !ok {
+		if this.SysConfig.Auth.Acl {
+			tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, statusMessage.UNAUTHORIZED, "Not authorized to subscribe to the requested collection")
+			if this.SysConfig.Logging.LogLevel >= 1 {
+				log.Printf("DEBUG-1: UNAUTHORIZED, Subscription Management Request from %s (%s) for %s", r.RemoteAddr, identityName(r), incomingMessageData.CollectionName)
+			}
+			this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+			return
+		}
+
+		errmsg := "The requested collection \"" + incomingMessageData.CollectionName + "\" does not exist"
+		tm := this.CreateTaxiiDestinationErrorStatusMessage(incomingMessageData.Id, errmsg, currentlyValidCollections)
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1: DESTINATION_COLLECTION_ERROR, Subscription Management Request asked for a collection that does not exist")
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	// --------------------------------------------------
+	// Process the requested action
+	// --------------------------------------------------
+
+	// Subscriptions belong to the user or identity that made them, an
+	// anonymous request only sees the ones made by anonymous requests
+
+	var response subscriptionMessage.SubscriptionResponseMessageType
+	var msgType, errmsg string
+
+	owner, _ := requestPrincipal(r)
+
+	switch incomingMessageData.Action {
+	case subscriptionMessage.ACTION_SUBSCRIBE:
+		response, msgType, errmsg = this.subscribe(incomingMessageData, owner)
+	case subscriptionMessage.ACTION_UNSUBSCRIBE:
+		response, msgType, errmsg = this.changeSubscriptionStatus(incomingMessageData, owner, subscriptionMessage.STATUS_UNSUBSCRIBED)
+	case subscriptionMessage.ACTION_PAUSE:
+		response, msgType, errmsg = this.changeSubscriptionStatus(incomingMessageData, owner, subscriptionMessage.STATUS_PAUSED)
+	case subscriptionMessage.ACTION_RESUME:
+		response, msgType, errmsg = this.changeSubscriptionStatus(incomingMessageData, owner, subscriptionMessage.STATUS_ACTIVE)
+	case subscriptionMessage.ACTION_STATUS:
+		response, msgType, errmsg = this.subscriptionStatus(incomingMessageData, owner)
+	default:
+		msgType = statusMessage.BAD_MESSAGE
+		errmsg = "The subscription action \"" + incomingMessageData.Action + "\" is not supported"
+	}
+
+	if msgType != "" {
+		tm := this.CreateTaxiiStatusMessage(incomingMessageData.Id, msgType, errmsg)
+		if this.SysConfig.Logging.LogLevel >= 1 {
+			log.Println("DEBUG-1:", msgType, errmsg)
+		}
+		this.sendTaxiiStatusMessage(w, taxiiHeader, tm)
+		return
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("error creating Subscription Management Response, %v", err)
+		this.sendTaxiiServerError(w, taxiiHeader, incomingMessageData.Id, "Unable to create Subscription Management Response")
+		return
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Println("DEBUG-1: Sending Subscription Management Response to", r.RemoteAddr)
+	}
+	taxiiHeader.SetHttpTaxiiResponseHeaders(w)
+	w.Write(data)
+}
+
+// --------------------------------------------------
+// Subscribe to a Collection
+// --------------------------------------------------
+// Each of the action functions return either a response message or the type
+// and text of a status message that should be sent instead.
+
+func (this *ServerType) subscribe(req subscriptionMessage.SubscriptionRequestMessageType, owner storage.PrincipalType) (subscriptionMessage.SubscriptionResponseMessageType, string, string) {
+	var sub storage.SubscriptionType
+	sub.SubscriptionId = common.CreateMessageId()
+	sub.CollectionName = req.CollectionName
+	sub.Owner = owner
+	sub.Status = subscriptionMessage.STATUS_ACTIVE
+	sub.ResponseType = subscriptionMessage.RESPONSE_TYPE_FULL
+
+	if req.SubscriptionParameters != nil {
+		if req.SubscriptionParameters.ResponseType != "" {
+			sub.ResponseType = req.SubscriptionParameters.ResponseType
+		}
+		sub.ContentBindings = req.SubscriptionParameters.ContentBindings
+	}
+
+	if sub.ResponseType != subscriptionMessage.RESPONSE_TYPE_FULL && sub.ResponseType != subscriptionMessage.RESPONSE_TYPE_COUNT_ONLY {
+		return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.BAD_MESSAGE, "The response type \"" + sub.ResponseType + "\" is not supported"
+	}
+
+	if req.PushParameters != nil {
+		sub.InboxProtocol = req.PushParameters.ProtocolBinding
+		sub.InboxAddress = req.PushParameters.Address
+		sub.InboxBinding = req.PushParameters.MessageBinding
+
+		err := this.checkPushAddress(sub.InboxAddress)
+		if err != nil {
+			return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.BAD_MESSAGE, "The push address \"" + sub.InboxAddress + "\" can not be used, " + err.Error()
+		}
+	}
+
+	err := this.createSubscription(sub)
+	if err != nil {
+		log.Printf("error saving subscription for collection %s, %v", sub.CollectionName, err)
+		return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.FAILURE, "Unable to create subscription"
+	}
+
+	return this.createSubscriptionResponse(req.Id, req.CollectionName, []storage.SubscriptionType{sub}), "", ""
+}
+
+// --------------------------------------------------
+// Unsubscribe, Pause or Resume a Subscription
+// --------------------------------------------------
+// Only the owner of a subscription may change it.
+
+func (this *ServerType) changeSubscriptionStatus(req subscriptionMessage.SubscriptionRequestMessageType, owner storage.PrincipalType, status string) (subscriptionMessage.SubscriptionResponseMessageType, string, string) {
+	if req.SubscriptionId == "" {
+		return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.BAD_MESSAGE, "A subscription ID is required for the " + req.Action + " action"
+	}
+
+	sub, ok, err := this.getSubscription(req.SubscriptionId)
+	if err != nil {
+		log.Printf("error reading subscription %s, %v", req.SubscriptionId, err)
+		return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.FAILURE, "Unable to read subscription"
+	}
+	if !ok || sub.CollectionName != req.CollectionName {
+		return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.NOT_FOUND, "The subscription \"" + req.SubscriptionId + "\" does not exist for this collection"
+	}
+	if sub.Owner != owner {
+		return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.UNAUTHORIZED, "Not authorized to change the subscription \"" + req.SubscriptionId + "\""
+	}
+
+	// An unsubscribed subscription is finished and can not be changed, and
+	// only an active subscription can be paused.
+	if sub.Status == subscriptionMessage.STATUS_UNSUBSCRIBED && status != subscriptionMessage.STATUS_UNSUBSCRIBED {
+		return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.FAILURE, "The subscription \"" + req.SubscriptionId + "\" has been unsubscribed"
+	}
+	if status == subscriptionMessage.STATUS_PAUSED && sub.Status != subscriptionMessage.STATUS_ACTIVE {
+		return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.FAILURE, "The subscription \"" + req.SubscriptionId + "\" is not active"
+	}
+
+	err = this.setSubscriptionStatus(sub.SubscriptionId, status)
+	if err != nil {
+		log.Printf("error updating subscription %s, %v", req.SubscriptionId, err)
+		return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.FAILURE, "Unable to update subscription"
+	}
+	sub.Status = status
+
+	return this.createSubscriptionResponse(req.Id, req.CollectionName, []storage.SubscriptionType{sub}), "", ""
+}
+
+// --------------------------------------------------
+// Report the Status of Subscriptions
+// --------------------------------------------------
+// If no subscription ID was given, all subscriptions for the collection that
+// belong to the owner are returned.
+
+func (this *ServerType) subscriptionStatus(req subscriptionMessage.SubscriptionRequestMessageType, owner storage.PrincipalType) (subscriptionMessage.SubscriptionResponseMessageType, string, string) {
+	var subs []storage.SubscriptionType
+
+	if req.SubscriptionId != "" {
+		sub, ok, err := this.getSubscription(req.SubscriptionId)
+		if err != nil {
+			log.Printf("error reading subscription %s, %v", req.SubscriptionId, err)
+			return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.FAILURE, "Unable to read subscription"
+		}
+		if !ok || sub.CollectionName != req.CollectionName {
+			return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.NOT_FOUND, "The subscription \"" + req.SubscriptionId + "\" does not exist for this collection"
+		}
+		if sub.Owner != owner {
+			return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.UNAUTHORIZED, "Not authorized to read the subscription \"" + req.SubscriptionId + "\""
+		}
+		subs = append(subs, sub)
+	} else {
+		all, err := this.getSubscriptions(req.CollectionName)
+		if err != nil {
+			log.Printf("error reading subscriptions for collection %s, %v", req.CollectionName, err)
+			return subscriptionMessage.SubscriptionResponseMessageType{}, statusMessage.FAILURE, "Unable to read subscriptions"
+		}
+		for _, sub := range all {
+			if sub.Owner == owner {
+				subs = append(subs, sub)
+			}
+		}
+	}
+
+	return this.createSubscriptionResponse(req.Id, req.CollectionName, subs), "", ""
+}
+
+// --------------------------------------------------
+// Create a TAXII Subscription Management Response Message
+// --------------------------------------------------
+
+func (this *ServerType) createSubscriptionResponse(responseid, collectionName string, subs []storage.SubscriptionType) subscriptionMessage.SubscriptionResponseMessageType {
+	tm := subscriptionMessage.NewResponse()
+	tm.AddInResponseTo(responseid)
+	tm.AddCollectionName(collectionName)
+
+	collection := this.getValidCollections()[collectionName]
+
+	for _, value := range subs {
+		s := tm.NewSubscriptionInstance()
+		s.AddSubscriptionId(value.SubscriptionId)
+		s.AddStatus(value.Status)
+		s.AddSubscriptionParameters(value.ResponseType, value.ContentBindings)
+		if value.InboxAddress != "" {
+			s.AddPushParameters(value.InboxProtocol, value.InboxAddress, value.InboxBinding)
+		}
+		if this.SysConfig.Services.Poll != "" && collection.PollAddress != "" {
+			for _, instance := range this.serviceInstances(collection.PollAddress) {
+				s.AddPollInstance(instance.Protocol, instance.Address, defs.TAXII_MESSAGE_JSON)
+			}
+		}
+	}
+
+	return tm
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"testing"
+
+	"github.com/freetaxii/freetaxii-server/lib/messages/statusMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/subscriptionMessage"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+)
+
+func newSubscriptionRequest(action, subscriptionId string) subscriptionMessage.SubscriptionRequestMessageType {
+	var req subscriptionMessage.SubscriptionRequestMessageType
+	req.Id = "1"
+	req.CollectionName = "test-collection"
+	req.Action = action
+	req.SubscriptionId = subscriptionId
+	return req
+}
+
+func TestSubscriptionsBelongToTheirOwner(t *testing.T) {
+	s := newTestServer(t)
+	alice := storage.PrincipalType{Type: storage.PRINCIPAL_TYPE_USER, Name: "alice"}
+	bob := storage.PrincipalType{Type: storage.PRINCIPAL_TYPE_IDENTITY, Name: "bob"}
+
+	response, msgType, errmsg := s.subscribe(newSubscriptionRequest(subscriptionMessage.ACTION_SUBSCRIBE, ""), alice)
+	if msgType != "" {
+		t.Fatalf("subscribe failed, %s %s", msgType, errmsg)
+	}
+	id := response.SubscriptionInstances[0].SubscriptionId
+
+	sub, _, _ := s.Store.GetSubscription(id)
+	if sub.Owner != alice {
+		t.Errorf("subscription is owned by %+v", sub.Owner)
+	}
+
+	if _, msgType, _ = s.subscribe(newSubscriptionRequest(subscriptionMessage.ACTION_SUBSCRIBE, ""), bob); msgType != "" {
+		t.Fatalf("subscribe failed, %s", msgType)
+	}
+
+	// Each owner only sees their own subscriptions
+	for _, owner := range []storage.PrincipalType{alice, bob} {
+		response, msgType, _ = s.subscriptionStatus(newSubscriptionRequest(subscriptionMessage.ACTION_STATUS, ""), owner)
+		if msgType != "" || len(response.SubscriptionInstances) != 1 {
+			t.Errorf("%s sees %d subscriptions, want 1", owner.Name, len(response.SubscriptionInstances))
+		}
+	}
+	if _, msgType, _ = s.subscriptionStatus(newSubscriptionRequest(subscriptionMessage.ACTION_STATUS, id), bob); msgType != statusMessage.UNAUTHORIZED {
+		t.Errorf("status of another owner's subscription returned %q", msgType)
+	}
+
+	for _, status := range []string{subscriptionMessage.STATUS_PAUSED, subscriptionMessage.STATUS_ACTIVE, subscriptionMessage.STATUS_UNSUBSCRIBED} {
+		_, msgType, _ = s.changeSubscriptionStatus(newSubscriptionRequest("", id), bob, status)
+		if msgType != statusMessage.UNAUTHORIZED {
+			t.Errorf("changing another owner's subscription to %s returned %q", status, msgType)
+		}
+	}
+	if sub, _, _ = s.Store.GetSubscription(id); sub.Status != subscriptionMessage.STATUS_ACTIVE {
+		t.Errorf("subscription was changed to %s", sub.Status)
+	}
+
+	if _, msgType, _ = s.changeSubscriptionStatus(newSubscriptionRequest("", id), alice, subscriptionMessage.STATUS_PAUSED); msgType != "" {
+		t.Errorf("the owner could not pause the subscription, %q", msgType)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"log"
+	"time"
+)
+
+// --------------------------------------------------
+// Save a new Subscription
+// --------------------------------------------------
+// The store starts the delivery cursor at the newest content in the
+// collection so that a new subscriber is only pushed content that arrives
+// after they subscribed.
+
+func (this *ServerType) createSubscription(sub storage.SubscriptionType) error {
+	sub.Created = time.Now().UTC().Format(TIMESTAMP_LABEL_FORMAT)
+
+	err := this.Store.AddSubscription(sub)
+	if err != nil {
+		return err
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Saved subscription %s for collection %s", sub.SubscriptionId, sub.CollectionName)
+	}
+	return nil
+}
+
+// --------------------------------------------------
+// Get a single Subscription by its ID
+// --------------------------------------------------
+// The boolean return value is false if the subscription does not exist
+
+func (this *ServerType) getSubscription(subscriptionId string) (storage.SubscriptionType, bool, error) {
+	return this.Store.GetSubscription(subscriptionId)
+}
+
+// --------------------------------------------------
+// Get all Subscriptions for a Collection
+// --------------------------------------------------
+
+func (this *ServerType) getSubscriptions(collectionName string) ([]storage.SubscriptionType, error) {
+	return this.Store.GetSubscriptions(collectionName)
+}
+
+// --------------------------------------------------
+// Get all Subscriptions that want content pushed to them
+// --------------------------------------------------
+
+func (this *ServerType) getPushSubscriptions() ([]storage.SubscriptionType, error) {
+	return this.Store.GetPushSubscriptions()
+}
+
+// --------------------------------------------------
+// Change the Status of a Subscription
+// --------------------------------------------------
+
+func (this *ServerType) setSubscriptionStatus(subscriptionId, status string) error {
+	err := this.Store.SetSubscriptionStatus(subscriptionId, status)
+	if err != nil {
+		return err
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Set status of subscription %s to %s", subscriptionId, status)
+	}
+	return nil
+}
+
+// --------------------------------------------------
+// Move the Delivery Cursor of a Subscription
+// --------------------------------------------------
+
+func (this *ServerType) setDeliveryCursor(subscriptionId string, cursor int64) error {
+	return this.Store.SetDeliveryCursor(subscriptionId, cursor)
+}
@@ -0,0 +1,527 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"encoding/json"
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"github.com/freetaxii/freetaxii-server/lib/messages/inboxMessage"
+	"github.com/freetaxii/freetaxii-server/lib/messages/taxii2Message"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_TAXII2_MAX_PAGE_SIZE      = 100
+	DEFAULT_TAXII2_MAX_CONTENT_LENGTH = 10485760
+)
+
+// TAXII 2.1 collection IDs are name based UUIDs in this namespace, so that a
+// collection always has the same ID without it being stored in the database.
+const (
+	TAXII2_COLLECTION_NAMESPACE = "8a4b0d4e-5d63-4e4b-9a0c-7f3b1c9e2d61"
+)
+
+// ----------------------------------------------------------------------
+// TAXII 2.1 Discovery Handler
+// ----------------------------------------------------------------------
+
+func (this *ServerType) Taxii2DiscoveryHandler(w http.ResponseWriter, r *http.Request) {
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Found Message on TAXII 2.1 Discovery Handler from %s", r.RemoteAddr)
+	}
+
+	if !this.verifyTaxii2Request(w, r, "GET") {
+		return
+	}
+
+	if r.URL.Path != this.SysConfig.Taxii2.Discovery {
+		this.sendTaxii2Error(w, http.StatusNotFound, "Not Found", "The requested resource does not exist")
+		return
+	}
+
+	apiRoot := taxii2BaseUrl(r) + this.SysConfig.Taxii2.ApiRoot
+
+	tm := taxii2Message.NewDiscovery()
+	tm.AddTitle(this.SysConfig.Taxii2.Title)
+	tm.AddDescription(this.SysConfig.Taxii2.Description)
+	tm.AddContact(this.SysConfig.Taxii2.Contact)
+	tm.AddDefault(apiRoot)
+	tm.AddApiRoot(apiRoot)
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Println("DEBUG-1: Sending TAXII 2.1 Discovery to", r.RemoteAddr)
+	}
+	this.sendTaxii2Resource(w, http.StatusOK, tm)
+}
+
+// ----------------------------------------------------------------------
+// TAXII 2.1 API Root Handler
+// ----------------------------------------------------------------------
+// Every resource under the API root is served from here, the path after the
+// API root decides which one was asked for.
+
+func (this *ServerType) Taxii2ApiRootHandler(w http.ResponseWriter, r *http.Request) {
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Found Message on TAXII 2.1 API Root Handler from %s for %s", r.RemoteAddr, r.URL.Path)
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, this.SysConfig.Taxii2.ApiRoot), "/")
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+
+	switch {
+	case len(parts) == 0:
+		if this.verifyTaxii2Request(w, r, "GET") {
+			this.taxii2ApiRoot(w, r)
+		}
+	case len(parts) == 2 && parts[0] == "status":
+		if this.verifyTaxii2Request(w, r, "GET") {
+			this.taxii2Status(w, r, parts[1])
+		}
+	case len(parts) == 1 && parts[0] == "collections":
+		if this.verifyTaxii2Request(w, r, "GET") {
+			this.taxii2Collections(w, r)
+		}
+	case len(parts) == 2 && parts[0] == "collections":
+		if this.verifyTaxii2Request(w, r, "GET") {
+			this.taxii2Collection(w, r, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "collections" && parts[2] == "objects":
+		if this.verifyTaxii2Request(w, r, "GET", "POST") {
+			if r.Method == "POST" {
+				this.taxii2AddObjects(w, r, parts[1])
+			} else {
+				this.taxii2Objects(w, r, parts[1], "")
+			}
+		}
+	case len(parts) == 4 && parts[0] == "collections" && parts[2] == "objects":
+		if this.verifyTaxii2Request(w, r, "GET") {
+			this.taxii2Objects(w, r, parts[1], parts[3])
+		}
+	case len(parts) == 3 && parts[0] == "collections" && parts[2] == "manifest":
+		if this.verifyTaxii2Request(w, r, "GET") {
+			this.taxii2Manifest(w, r, parts[1])
+		}
+	default:
+		this.sendTaxii2Error(w, http.StatusNotFound, "Not Found", "The requested resource does not exist")
+	}
+}
+
+// --------------------------------------------------
+// API Root Information
+// --------------------------------------------------
+
+func (this *ServerType) taxii2ApiRoot(w http.ResponseWriter, r *http.Request) {
+	tm := taxii2Message.NewApiRoot()
+	tm.AddTitle(this.SysConfig.Taxii2.Title)
+	tm.AddDescription(this.SysConfig.Taxii2.Description)
+	tm.AddMaxContentLength(this.taxii2MaxContentLength())
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Println("DEBUG-1: Sending TAXII 2.1 API Root to", r.RemoteAddr)
+	}
+	this.sendTaxii2Resource(w, http.StatusOK, tm)
+}
+
+// --------------------------------------------------
+// Status of an Add Objects Request
+// --------------------------------------------------
+
+func (this *ServerType) taxii2Status(w http.ResponseWriter, r *http.Request, statusId string) {
+	status, ok, err := this.getTaxii2Status(statusId)
+	if err != nil {
+		log.Printf("error reading TAXII 2.1 status %s, %v", statusId, err)
+		this.sendTaxii2Error(w, http.StatusInternalServerError, "Internal Error", "Unable to read the status resource")
+		return
+	}
+	if !ok {
+		this.sendTaxii2Error(w, http.StatusNotFound, "Not Found", "The status resource \""+statusId+"\" does not exist")
+		return
+	}
+	this.sendTaxii2Resource(w, http.StatusOK, status)
+}
+
+// --------------------------------------------------
+// List of Collections
+// --------------------------------------------------
+// The collections are the same ones that the TAXII 1.x services offer
+
+func (this *ServerType) taxii2Collections(w http.ResponseWriter, r *http.Request) {
+	tm := taxii2Message.NewCollections()
+	p := this.getPermissions(r)
+	validCollections := this.getValidCollections()
+	for name := range validCollections {
+		if !p.allows(name, "") {
+			delete(validCollections, name)
+		}
+	}
+
+	// Sort the names so that clients always see the collections in the same
+	// order
+	var names []string
+	for name := range validCollections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		c := tm.NewCollection()
+		this.populateTaxii2Collection(c, validCollections[name], p)
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Println("DEBUG-1: Sending TAXII 2.1 Collections to", r.RemoteAddr)
+	}
+	this.sendTaxii2Resource(w, http.StatusOK, tm)
+}
+
+func (this *ServerType) taxii2Collection(w http.ResponseWriter, r *http.Request, collectionId string) {
+	collection, p, ok := this.findTaxii2Collection(r, collectionId)
+	if !ok {
+		this.sendTaxii2Error(w, http.StatusNotFound, "Not Found", "The collection \""+collectionId+"\" does not exist")
+		return
+	}
+
+	var c taxii2Message.CollectionType
+	this.populateTaxii2Collection(&c, collection, p)
+	this.sendTaxii2Resource(w, http.StatusOK, c)
+}
+
+// Content can only be added to a collection when the Inbox service is turned
+// on, the same as for TAXII 1.x clients, and the client has been granted write
+func (this *ServerType) populateTaxii2Collection(c *taxii2Message.CollectionType, collection storage.CollectionType, p permissionsType) {
+	c.AddId(taxii2CollectionId(collection.Name))
+	c.AddTitle(collection.Name)
+	c.AddDescription(collection.Description)
+	c.AddAlias(collection.Name)
+	c.SetCanRead(p.allows(collection.Name, storage.PERMISSION_READ))
+	c.SetCanWrite(this.SysConfig.Services.Inbox != "" && collection.InboxAddress != "" && p.allows(collection.Name, storage.PERMISSION_WRITE))
+	c.AddMediaType(taxii2Message.MEDIA_TYPE_STIX)
+}
+
+// --------------------------------------------------
+// Get Objects from a Collection
+// --------------------------------------------------
+// If an object ID is given only the versions of that object are returned
+
+func (this *ServerType) taxii2Objects(w http.ResponseWriter, r *http.Request, collectionId, objectId string) {
+	collection, p, ok := this.findTaxii2Collection(r, collectionId)
+	if !ok {
+		this.sendTaxii2Error(w, http.StatusNotFound, "Not Found", "The collection \""+collectionId+"\" does not exist")
+		return
+	}
+
+	if !p.allows(collection.Name, storage.PERMISSION_READ) {
+		this.sendTaxii2Error(w, http.StatusForbidden, "Forbidden", "Objects can not be read from this collection")
+		return
+	}
+
+	objects, more, next, ok := this.queryTaxii2Objects(w, r, collection.Name, objectId)
+	if !ok {
+		return
+	}
+
+	if objectId != "" && len(objects) == 0 {
+		this.sendTaxii2Error(w, http.StatusNotFound, "Not Found", "The object \""+objectId+"\" does not exist")
+		return
+	}
+
+	tm := taxii2Message.NewEnvelope()
+	for _, value := range objects {
+		tm.AddObject(value.Object)
+	}
+	tm.SetMore(more)
+	if more {
+		tm.AddNext(next)
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Sending %d TAXII 2.1 objects from %s to %s", len(objects), collection.Name, r.RemoteAddr)
+	}
+	setTaxii2DateAddedHeaders(w, objects)
+	this.sendTaxii2Resource(w, http.StatusOK, tm)
+}
+
+// --------------------------------------------------
+// Get the Manifest of a Collection
+// --------------------------------------------------
+
+func (this *ServerType) taxii2Manifest(w http.ResponseWriter, r *http.Request, collectionId string) {
+	collection, p, ok := this.findTaxii2Collection(r, collectionId)
+	if !ok {
+		this.sendTaxii2Error(w, http.StatusNotFound, "Not Found", "The collection \""+collectionId+"\" does not exist")
+		return
+	}
+
+	if !p.allows(collection.Name, storage.PERMISSION_READ) {
+		this.sendTaxii2Error(w, http.StatusForbidden, "Forbidden", "Objects can not be read from this collection")
+		return
+	}
+
+	objects, more, _, ok := this.queryTaxii2Objects(w, r, collection.Name, "")
+	if !ok {
+		return
+	}
+
+	tm := taxii2Message.NewManifest()
+	for _, value := range objects {
+		tm.AddRecord(value.Id, value.DateAdded, value.Version, taxii2Message.MEDIA_TYPE_STIX)
+	}
+	tm.SetMore(more)
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Sending TAXII 2.1 manifest of %d objects from %s to %s", len(objects), collection.Name, r.RemoteAddr)
+	}
+	setTaxii2DateAddedHeaders(w, objects)
+	this.sendTaxii2Resource(w, http.StatusOK, tm)
+}
+
+// --------------------------------------------------
+// Query, Filter and Page the Objects of a Collection
+// --------------------------------------------------
+// On error a TAXII 2.1 error has already been sent and ok is false
+
+func (this *ServerType) queryTaxii2Objects(w http.ResponseWriter, r *http.Request, collectionName, objectId string) ([]Taxii2ObjectType, bool, string, bool) {
+	query := r.URL.Query()
+
+	addedAfter := ""
+	if query.Get("added_after") != "" {
+		var err error
+		addedAfter, err = parseTimestampLabel(query.Get("added_after"))
+		if err != nil {
+			this.sendTaxii2Error(w, http.StatusBadRequest, "Bad Request", "added_after is not a valid timestamp")
+			return nil, false, "", false
+		}
+	}
+
+	limit := this.taxii2MaxPageSize()
+	if query.Get("limit") != "" {
+		i, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || i < 1 {
+			this.sendTaxii2Error(w, http.StatusBadRequest, "Bad Request", "limit must be a positive number")
+			return nil, false, "", false
+		}
+		if i < limit {
+			limit = i
+		}
+	}
+
+	objects, err := this.getTaxii2Objects(collectionName, addedAfter)
+	if err != nil {
+		log.Printf("error reading TAXII 2.1 objects for collection %s, %v", collectionName, err)
+		this.sendTaxii2Error(w, http.StatusInternalServerError, "Internal Error", "Unable to read the collection")
+		return nil, false, "", false
+	}
+
+	if objectId != "" {
+		query.Set("match[id]", objectId)
+	}
+	objects = filterTaxii2Objects(objects, query)
+
+	page, more, next, err := pageTaxii2Objects(objects, query.Get("next"), limit)
+	if err != nil {
+		this.sendTaxii2Error(w, http.StatusBadRequest, "Bad Request", err.Error())
+		return nil, false, "", false
+	}
+	return page, more, next, true
+}
+
+// --------------------------------------------------
+// Add Objects to a Collection
+// --------------------------------------------------
+// Every object in the envelope is stored as its own content block, so TAXII
+// 1.x clients that poll the collection see them too.
+
+func (this *ServerType) taxii2AddObjects(w http.ResponseWriter, r *http.Request, collectionId string) {
+	collection, p, ok := this.findTaxii2Collection(r, collectionId)
+	if !ok {
+		this.sendTaxii2Error(w, http.StatusNotFound, "Not Found", "The collection \""+collectionId+"\" does not exist")
+		return
+	}
+
+	if this.SysConfig.Services.Inbox == "" || collection.InboxAddress == "" || !p.allows(collection.Name, storage.PERMISSION_WRITE) {
+		this.sendTaxii2Error(w, http.StatusForbidden, "Forbidden", "Objects can not be added to this collection")
+		return
+	}
+
+	if !strings.HasPrefix(strings.Replace(r.Header.Get("Content-Type"), " ", "", -1), "application/taxii+json") {
+		this.sendTaxii2Error(w, http.StatusUnsupportedMediaType, "Unsupported Media Type", "Objects must be sent as "+taxii2Message.MEDIA_TYPE_TAXII)
+		return
+	}
+
+	maxLength := this.taxii2MaxContentLength()
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(maxLength)+1))
+	if err != nil {
+		this.sendTaxii2Error(w, http.StatusBadRequest, "Bad Request", "Unable to read the request")
+		return
+	}
+	if len(body) > maxLength {
+		this.sendTaxii2Error(w, http.StatusRequestEntityTooLarge, "Request Entity Too Large", "The request is larger than max_content_length")
+		return
+	}
+
+	var envelope taxii2Message.EnvelopeType
+	err = json.Unmarshal(body, &envelope)
+	if err != nil {
+		this.sendTaxii2Error(w, http.StatusBadRequest, "Bad Request", "Can not decode the envelope")
+		return
+	}
+
+	status := taxii2Message.NewStatus(common.CreateMessageId())
+	status.AddRequestTimestamp(time.Now().UTC().Format(TIMESTAMP_LABEL_FORMAT))
+
+	for _, value := range envelope.Objects {
+		obj, err := parseTaxii2Object(value)
+		if err != nil {
+			status.AddFailure(obj.Id, obj.Version, err.Error())
+			continue
+		}
+
+		var block inboxMessage.ContentBlockType
+		block.AddContentBinding(taxii2Message.MEDIA_TYPE_STIX)
+		block.SetContentEncodingToJson()
+		block.AddContent(string(value))
+
+		err = this.saveContentBlock(collection.Name, block)
+		if err != nil {
+			log.Printf("error saving TAXII 2.1 object %s to collection %s, %v", obj.Id, collection.Name, err)
+			status.AddFailure(obj.Id, obj.Version, "Unable to save the object")
+			continue
+		}
+		status.AddSuccess(obj.Id, obj.Version)
+	}
+	status.SetComplete()
+
+	err = this.saveTaxii2Status(status)
+	if err != nil {
+		log.Printf("error saving TAXII 2.1 status %s, %v", status.Id, err)
+	}
+
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Printf("DEBUG-1: Added %d of %d TAXII 2.1 objects to %s from %s", status.SuccessCount, status.TotalCount, collection.Name, r.RemoteAddr)
+	}
+	this.sendTaxii2Resource(w, http.StatusAccepted, status)
+}
+
+// --------------------------------------------------
+// Check the Method and Headers of a TAXII 2.1 Request
+// --------------------------------------------------
+// On error a TAXII 2.1 error is sent and false is returned
+
+func (this *ServerType) verifyTaxii2Request(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	allowed := false
+	for _, value := range methods {
+		if r.Method == value {
+			allowed = true
+		}
+	}
+	if !allowed {
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		this.sendTaxii2Error(w, http.StatusMethodNotAllowed, "Method Not Allowed", "The "+r.Method+" method is not supported on this resource")
+		return false
+	}
+
+	accept := strings.Replace(r.Header.Get("Accept"), " ", "", -1)
+	if !strings.Contains(accept, "application/taxii+json") || (strings.Contains(accept, "version=") && !strings.Contains(accept, "version=2.1")) {
+		if this.SysConfig.Logging.LogLevel >= 3 {
+			log.Printf("DEBUG-3: TAXII 2.1 request from %s with unsupported Accept header %s", r.RemoteAddr, r.Header.Get("Accept"))
+		}
+		this.sendTaxii2Error(w, http.StatusNotAcceptable, "Not Acceptable", "The Accept header must include "+taxii2Message.MEDIA_TYPE_TAXII)
+		return false
+	}
+	return true
+}
+
+// --------------------------------------------------
+// Send a TAXII 2.1 Resource or Error
+// --------------------------------------------------
+
+func (this *ServerType) sendTaxii2Resource(w http.ResponseWriter, httpStatus int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("error encoding TAXII 2.1 resource, %v", err)
+		httpStatus = http.StatusInternalServerError
+		data = []byte(`{"title":"Internal Error"}`)
+	}
+	w.Header().Set("Content-Type", taxii2Message.MEDIA_TYPE_TAXII)
+	w.WriteHeader(httpStatus)
+	w.Write(data)
+}
+
+func (this *ServerType) sendTaxii2Error(w http.ResponseWriter, httpStatus int, title, description string) {
+	if this.SysConfig.Logging.LogLevel >= 1 {
+		log.Println("DEBUG-1: TAXII 2.1 error", httpStatus, description)
+	}
+	tm := taxii2Message.NewError(title)
+	tm.AddDescription(description)
+	tm.AddHttpStatus(strconv.Itoa(httpStatus))
+	this.sendTaxii2Resource(w, httpStatus, tm)
+}
+
+// --------------------------------------------------
+// Find a Collection by its TAXII 2.1 ID or Alias
+// --------------------------------------------------
+// A collection the client has no permission on at all is not found, so the
+// answer does not tell them it is there. The permissions of the client are
+// returned so the caller can check the one it needs.
+
+func (this *ServerType) findTaxii2Collection(r *http.Request, collectionId string) (storage.CollectionType, permissionsType, bool) {
+	p := this.getPermissions(r)
+	for name, collection := range this.getValidCollections() {
+		if (collectionId == name || collectionId == taxii2CollectionId(name)) && p.allows(name, "") {
+			return collection, p, true
+		}
+	}
+	return storage.CollectionType{}, p, false
+}
+
+func taxii2CollectionId(collectionName string) string {
+	return common.CreateNameBasedId(TAXII2_COLLECTION_NAMESPACE, collectionName)
+}
+
+// --------------------------------------------------
+// Helper Functions
+// --------------------------------------------------
+
+func (this *ServerType) taxii2MaxPageSize() int {
+	if this.SysConfig.Taxii2.MaxPageSize > 0 {
+		return this.SysConfig.Taxii2.MaxPageSize
+	}
+	return DEFAULT_TAXII2_MAX_PAGE_SIZE
+}
+
+func (this *ServerType) taxii2MaxContentLength() int {
+	if this.SysConfig.Taxii2.MaxContentLength > 0 {
+		return this.SysConfig.Taxii2.MaxContentLength
+	}
+	return DEFAULT_TAXII2_MAX_CONTENT_LENGTH
+}
+
+func taxii2BaseUrl(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+func setTaxii2DateAddedHeaders(w http.ResponseWriter, objects []Taxii2ObjectType) {
+	if len(objects) == 0 {
+		return
+	}
+	w.Header().Set("X-TAXII-Date-Added-First", objects[0].DateAdded)
+	w.Header().Set("X-TAXII-Date-Added-Last", objects[len(objects)-1].DateAdded)
+}
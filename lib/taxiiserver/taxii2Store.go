@@ -0,0 +1,283 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/freetaxii/freetaxii-server/lib/messages/taxii2Message"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// This type holds a single STIX object as it is served over TAXII 2.1. A
+// content block can hold a bundle of objects, so the index of the object in
+// the content block is kept along with the content ID.
+type Taxii2ObjectType struct {
+	ContentId   int64
+	Index       int
+	Id          string
+	Type        string
+	Version     string
+	SpecVersion string
+	DateAdded   string
+	Object      json.RawMessage
+}
+
+// --------------------------------------------------
+// Get the STIX Objects in a Collection
+// --------------------------------------------------
+// Only content blocks with the STIX 2.1 content binding are read, bundles are
+// split in to the objects they hold. An empty addedAfter means the start of
+// the collection.
+
+func (this *ServerType) getTaxii2Objects(collectionName, addedAfter string) ([]Taxii2ObjectType, error) {
+	var objects []Taxii2ObjectType
+
+	blocks, err := this.Store.GetContentBlocksByBinding(collectionName, taxii2Message.MEDIA_TYPE_STIX, addedAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, block := range blocks {
+		var bundle struct {
+			Type    string            `json:"type"`
+			Objects []json.RawMessage `json:"objects"`
+		}
+		err = json.Unmarshal([]byte(block.Content), &bundle)
+		if err != nil {
+			log.Printf("error decoding STIX content %d in collection %s, %v", block.Id, collectionName, err)
+			continue
+		}
+
+		raw := []json.RawMessage{json.RawMessage(block.Content)}
+		if bundle.Type == "bundle" {
+			raw = bundle.Objects
+		}
+
+		for i, value := range raw {
+			obj, err := parseTaxii2Object(value)
+			if err != nil {
+				log.Printf("error decoding STIX object %d of content %d in collection %s, %v", i, block.Id, collectionName, err)
+				continue
+			}
+			obj.ContentId = block.Id
+			obj.Index = i
+			obj.DateAdded = block.TimestampLabel
+			if obj.Version == "" {
+				obj.Version = block.TimestampLabel
+			}
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+// --------------------------------------------------
+// Read the Common Properties of a STIX Object
+// --------------------------------------------------
+// The version of an object is its modified timestamp, or its created
+// timestamp if it has never been modified.
+
+func parseTaxii2Object(data json.RawMessage) (Taxii2ObjectType, error) {
+	var obj Taxii2ObjectType
+	var properties struct {
+		Id          string `json:"id"`
+		Type        string `json:"type"`
+		Created     string `json:"created"`
+		Modified    string `json:"modified"`
+		SpecVersion string `json:"spec_version"`
+	}
+
+	err := json.Unmarshal(data, &properties)
+	if err != nil {
+		return obj, errors.New("the object is not valid JSON")
+	}
+
+	obj.Id = properties.Id
+	obj.Type = properties.Type
+	obj.Version = properties.Modified
+	if obj.Version == "" {
+		obj.Version = properties.Created
+	}
+	obj.SpecVersion = properties.SpecVersion
+	if obj.SpecVersion == "" {
+		obj.SpecVersion = "2.0"
+	}
+	obj.Object = data
+
+	if obj.Id == "" || obj.Type == "" {
+		return obj, errors.New("the object does not have an id and a type")
+	}
+	return obj, nil
+}
+
+// --------------------------------------------------
+// Filter STIX Objects with the TAXII 2.1 match Parameters
+// --------------------------------------------------
+// match[id], match[type] and match[spec_version] take a comma separated list
+// of values. match[version] takes first, last, all or a list of versions and
+// defaults to last.
+
+func filterTaxii2Objects(objects []Taxii2ObjectType, query url.Values) []Taxii2ObjectType {
+	var filtered []Taxii2ObjectType
+
+	ids := splitTaxii2Match(query.Get("match[id]"))
+	types := splitTaxii2Match(query.Get("match[type]"))
+	specVersions := splitTaxii2Match(query.Get("match[spec_version]"))
+
+	for _, value := range objects {
+		if taxii2Matches(ids, value.Id) && taxii2Matches(types, value.Type) && taxii2Matches(specVersions, value.SpecVersion) {
+			filtered = append(filtered, value)
+		}
+	}
+
+	versions := splitTaxii2Match(query.Get("match[version]"))
+	if len(versions) == 0 {
+		versions = []string{"last"}
+	}
+	if taxii2Matches(versions, "all") {
+		return filtered
+	}
+
+	// Find the first and last version of every object
+	first := make(map[string]time.Time)
+	last := make(map[string]time.Time)
+	for _, value := range filtered {
+		t := taxii2VersionTime(value.Version)
+		if f, ok := first[value.Id]; !ok || t.Before(f) {
+			first[value.Id] = t
+		}
+		if l, ok := last[value.Id]; !ok || t.After(l) {
+			last[value.Id] = t
+		}
+	}
+
+	var result []Taxii2ObjectType
+	for _, value := range filtered {
+		t := taxii2VersionTime(value.Version)
+		keep := false
+		for _, version := range versions {
+			switch version {
+			case "first":
+				keep = keep || t.Equal(first[value.Id])
+			case "last":
+				keep = keep || t.Equal(last[value.Id])
+			default:
+				keep = keep || t.Equal(taxii2VersionTime(version))
+			}
+		}
+		if keep {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+func splitTaxii2Match(s string) []string {
+	var values []string
+	for _, value := range strings.Split(s, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// An empty list matches everything
+func taxii2Matches(values []string, s string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// STIX timestamps can have any precision, so they are parsed before they are
+// compared
+func taxii2VersionTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// --------------------------------------------------
+// Page STIX Objects
+// --------------------------------------------------
+// The next token is the content ID and index of the last object that was
+// sent, so later pages are not affected by content with the same timestamp
+// label.
+
+func pageTaxii2Objects(objects []Taxii2ObjectType, next string, limit int) ([]Taxii2ObjectType, bool, string, error) {
+	start := 0
+	if next != "" {
+		var contentId int64
+		var index int
+		_, err := fmt.Sscanf(next, "%d-%d", &contentId, &index)
+		if err != nil {
+			return nil, false, "", errors.New("next is not valid")
+		}
+
+		start = len(objects)
+		for i, value := range objects {
+			if value.ContentId > contentId || (value.ContentId == contentId && value.Index > index) {
+				start = i
+				break
+			}
+		}
+	}
+
+	end := start + limit
+	if end >= len(objects) {
+		return objects[start:], false, "", nil
+	}
+
+	lastObject := objects[end-1]
+	return objects[start:end], true, fmt.Sprintf("%d-%d", lastObject.ContentId, lastObject.Index), nil
+}
+
+// --------------------------------------------------
+// Save a TAXII 2.1 Status Resource
+// --------------------------------------------------
+
+func (this *ServerType) saveTaxii2Status(status taxii2Message.StatusType) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	return this.Store.AddTaxii2Status(status.Id, time.Now().UTC().Format(TIMESTAMP_LABEL_FORMAT), string(data))
+}
+
+// --------------------------------------------------
+// Get a TAXII 2.1 Status Resource
+// --------------------------------------------------
+
+func (this *ServerType) getTaxii2Status(statusId string) (taxii2Message.StatusType, bool, error) {
+	var status taxii2Message.StatusType
+
+	data, ok, err := this.Store.GetTaxii2Status(statusId)
+	if err != nil || !ok {
+		return status, false, err
+	}
+
+	err = json.Unmarshal([]byte(data), &status)
+	if err != nil {
+		return status, false, err
+	}
+	return status, true, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 Bret Jordan, All rights reserved.
+//
+// Use of this source code is governed by an Apache 2.0 license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package taxiiserver
+
+import (
+	"github.com/freetaxii/freetaxii-server/lib/messages/common"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// This type holds one way to reach a service, the protocol binding and the
+// address that goes with it
+type serviceInstanceType struct {
+	Protocol string
+	Address  string
+}
+
+// --------------------------------------------------
+// HTTPS Redirect Handler
+// --------------------------------------------------
+// Used on the plain HTTP listener when the TLS redirect is turned on. A 308
+// is sent so that clients send their POST again with the same body.
+
+func (this *ServerType) HttpsRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	target := "https://" + this.httpsHost(r.Host) + r.URL.RequestURI()
+
+	if this.SysConfig.Logging.LogLevel >= 3 {
+		log.Printf("DEBUG-3: Redirecting %s to %s", r.RemoteAddr, target)
+	}
+	http.Redirect(w, r, target, http.StatusPermanentRedirect)
+}
+
+// --------------------------------------------------
+// Get the Ways a Service can be Reached
+// --------------------------------------------------
+// Service addresses are stored with the scheme they were added with. When the
+// HTTPS listener is running an http address is also offered over HTTPS, on
+// the port of the HTTPS listener, and the http address is only offered if
+// there is a plain listener that does not redirect.
+
+func (this *ServerType) serviceInstances(address string) []serviceInstanceType {
+	u, err := url.Parse(address)
+	if err != nil || !this.SysConfig.Tls.Enabled || u.Scheme != "http" {
+		return []serviceInstanceType{{Protocol: protocolBinding(address), Address: address}}
+	}
+
+	secure := *u
+	secure.Scheme = "https"
+	secure.Host = this.httpsHost(u.Host)
+	instances := []serviceInstanceType{{Protocol: common.TAXII_PROTOCOL_HTTPS, Address: secure.String()}}
+
+	if this.SysConfig.System.Listen != "" && !this.SysConfig.Tls.Redirect {
+		instances = append(instances, serviceInstanceType{Protocol: common.TAXII_PROTOCOL_HTTP, Address: address})
+	}
+	return instances
+}
+
+// The TAXII protocol binding of an address
+func protocolBinding(address string) string {
+	if strings.HasPrefix(strings.ToLower(address), "https://") {
+		return common.TAXII_PROTOCOL_HTTPS
+	}
+	return common.TAXII_PROTOCOL_HTTP
+}
+
+// A host name with the port of the HTTPS listener in place of its own. The
+// port is left off if it is 443.
+func (this *ServerType) httpsHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	_, port, err := net.SplitHostPort(this.SysConfig.Tls.Listen)
+	if err != nil || port == "" || port == "443" {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]"
+		}
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -9,17 +9,24 @@ package main
 import (
 	"bufio"
 	"code.google.com/p/getopt"
-	"database/sql"
 	"fmt"
+	"github.com/freetaxii/freetaxii-server/lib/auth"
 	"github.com/freetaxii/freetaxii-server/lib/config"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/freetaxii/freetaxii-server/lib/parser"
+	"github.com/freetaxii/freetaxii-server/lib/storage"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	DEFAULT_CONFIG_FILENAME = "../etc/freetaxii.conf"
+	TIMESTAMP_FORMAT        = "2006-01-02T15:04:05.000000Z"
 )
 
 var sVersion = "0.2.1"
@@ -29,6 +36,36 @@ var sOptConfigFilename = getopt.StringLong("config", 'c', DEFAULT_CONFIG_FILENAM
 var bOptListCollection = getopt.BoolLong("list-collections", 0, "List Collections")
 var bOptAddCollection = getopt.BoolLong("add-collection", 0, "Add Collections")
 var bOptDelCollection = getopt.BoolLong("del-collection", 0, "Delete Collections")
+var bOptListFeeds = getopt.BoolLong("list-feeds", 0, "List Feeds")
+var bOptAddFeed = getopt.BoolLong("add-feed", 0, "Add a Feed to a Collection")
+var bOptImport = getopt.BoolLong("import", 0, "Import Indicators from a File")
+var bOptListQuarantine = getopt.BoolLong("list-quarantine", 0, "List Values that were Quarantined")
+var bOptListAllowlist = getopt.BoolLong("list-allowlist", 0, "List Allowlist Entries")
+var bOptAddAllowlist = getopt.BoolLong("add-allowlist", 0, "Add an Allowlist Entry")
+var bOptDelAllowlist = getopt.BoolLong("del-allowlist", 0, "Delete an Allowlist Entry")
+var bOptListIdentities = getopt.BoolLong("list-identities", 0, "List Client Certificate Identities")
+var bOptAddIdentity = getopt.BoolLong("add-identity", 0, "Add a Client Certificate Identity")
+var bOptDelIdentity = getopt.BoolLong("del-identity", 0, "Delete a Client Certificate Identity")
+var bOptListUsers = getopt.BoolLong("list-users", 0, "List Users")
+var bOptAddUser = getopt.BoolLong("add-user", 0, "Add a User")
+var bOptDisableUser = getopt.BoolLong("disable-user", 0, "Disable a User")
+var bOptEnableUser = getopt.BoolLong("enable-user", 0, "Enable a User")
+var bOptGrantAdmin = getopt.BoolLong("grant-admin", 0, "Let a User use the Admin API")
+var bOptRevokeAdmin = getopt.BoolLong("revoke-admin", 0, "Stop a User from using the Admin API")
+var bOptListTokens = getopt.BoolLong("list-tokens", 0, "List API Tokens")
+var bOptAddToken = getopt.BoolLong("add-token", 0, "Issue an API Token to a User")
+var bOptRevokeToken = getopt.BoolLong("revoke-token", 0, "Revoke an API Token")
+var bOptListGroups = getopt.BoolLong("list-groups", 0, "List Groups")
+var bOptAddGroup = getopt.BoolLong("add-group", 0, "Add a Group")
+var bOptDelGroup = getopt.BoolLong("del-group", 0, "Delete a Group")
+var bOptAddGroupMember = getopt.BoolLong("add-group-member", 0, "Add a User or Identity to a Group")
+var bOptDelGroupMember = getopt.BoolLong("del-group-member", 0, "Remove a User or Identity from a Group")
+var bOptListGrants = getopt.BoolLong("list-grants", 0, "List Collection Grants")
+var bOptAddGrant = getopt.BoolLong("add-grant", 0, "Grant Read or Write on a Collection")
+var bOptDelGrant = getopt.BoolLong("del-grant", 0, "Delete a Collection Grant")
+var bOptDbInit = getopt.BoolLong("db-init", 0, "Create the Database Schema")
+var bOptDbMigrate = getopt.BoolLong("db-migrate", 0, "Migrate the Database Schema")
+var bOptDbStatus = getopt.BoolLong("db-status", 0, "Show the Database Schema Version")
 var bOptHelp = getopt.BoolLong("help", 0, "Help")
 var bOptVer = getopt.BoolLong("version", 0, "Version")
 
@@ -75,56 +112,264 @@ func main() {
 	log.Println("Starting FreeTAXII Management")
 
 	// --------------------------------------------------
-	// Open connection to database
+	// Open the store
 	// --------------------------------------------------
-	filename := syscfg.System.DbFileFullPath
-	db, err := sql.Open("sqlite3", filename)
+	store, err := storage.Open(syscfg.System.DbType, syscfg.DbDataSource())
 	if err != nil {
-		log.Fatalf("Unable to open file %s due to error %v", filename, err)
+		log.Fatalf("Unable to open the store due to error %v", err)
 	}
-	defer db.Close()
+	defer store.Close()
 
 	if DebugLevel >= 3 {
-		log.Println("DEBUG-3: Using the following database file", filename)
+		log.Println("DEBUG-3: Using the following storage backend", syscfg.System.DbType)
 	}
 
 	// --------------------------------------------------
 	// Check for what to do
 	// --------------------------------------------------
+	if *bOptDbInit {
+		initDatabase(store, &syscfg)
+	}
+	if *bOptDbMigrate {
+		migrateDatabase(store)
+	}
+	if *bOptDbStatus {
+		databaseStatus(store)
+	}
 	if *bOptListCollection {
-		listCollections(db)
+		listCollections(store)
 	}
 	if *bOptAddCollection {
-		addCollection(db)
+		addCollection(store)
 	}
 	if *bOptDelCollection {
-		delCollection(db)
+		delCollection(store)
+	}
+	if *bOptListFeeds {
+		listFeeds(store)
+	}
+	if *bOptAddFeed {
+		addFeed(store)
+	}
+	if *bOptImport {
+		importFile(store)
+	}
+	if *bOptListQuarantine {
+		listQuarantine(store)
+	}
+	if *bOptListAllowlist {
+		listAllowlist(store)
+	}
+	if *bOptAddAllowlist {
+		addAllowlist(store)
+	}
+	if *bOptDelAllowlist {
+		delAllowlist(store)
+	}
+	if *bOptListIdentities {
+		listIdentities(store)
+	}
+	if *bOptAddIdentity {
+		addIdentity(store)
+	}
+	if *bOptDelIdentity {
+		delIdentity(store)
+	}
+	if *bOptListUsers {
+		listUsers(store)
+	}
+	if *bOptAddUser {
+		addUser(store)
+	}
+	if *bOptDisableUser {
+		setUserDisabled(store, true)
+	}
+	if *bOptEnableUser {
+		setUserDisabled(store, false)
+	}
+	if *bOptGrantAdmin {
+		setUserAdmin(store, true)
+	}
+	if *bOptRevokeAdmin {
+		setUserAdmin(store, false)
+	}
+	if *bOptListTokens {
+		listTokens(store)
+	}
+	if *bOptAddToken {
+		addToken(store)
+	}
+	if *bOptRevokeToken {
+		revokeToken(store)
+	}
+	if *bOptListGroups {
+		listGroups(store)
+	}
+	if *bOptAddGroup {
+		addGroup(store)
+	}
+	if *bOptDelGroup {
+		delGroup(store)
+	}
+	if *bOptAddGroupMember {
+		addGroupMember(store)
+	}
+	if *bOptDelGroupMember {
+		delGroupMember(store)
+	}
+	if *bOptListGrants {
+		listGrants(store)
+	}
+	if *bOptAddGrant {
+		addGrant(store)
+	}
+	if *bOptDelGrant {
+		delGrant(store)
+	}
+
+}
+
+// --------------------------------------------------
+// Create the database schema
+// --------------------------------------------------
+// Only an empty database is set up, anything else has to be migrated. The
+// services are added from the services section of the configuration file so
+// that the discovery service has something to offer.
+
+func initDatabase(store storage.StoreType, syscfg *config.ServerConfigType) {
+	version, err := store.GetSchemaVersion()
+	if err != nil {
+		log.Printf("M: Unable to read the schema version due to error %v", err)
+		return
+	}
+	if version != 0 {
+		fmt.Printf("The database is already at schema version %d, use --db-migrate to upgrade it\n", version)
+		return
+	}
+
+	if !applyMigrations(store) {
+		return
+	}
+
+	services, err := store.GetServices()
+	if err != nil {
+		log.Printf("M: Unable to read services due to error %v", err)
+		return
+	}
+	if len(services) != 0 {
+		return
+	}
+
+	configured := []storage.ServiceType{
+		{ServiceType: "Discovery", Address: syscfg.Services.Discovery},
+		{ServiceType: "Collection", Address: syscfg.Services.Collection},
+		{ServiceType: "Poll", Address: syscfg.Services.Poll},
+		{ServiceType: "Inbox", Address: syscfg.Services.Inbox},
+		{ServiceType: "Subscription", Address: syscfg.Services.Subscription},
+	}
+	// When HTTPS is the only way in the services are added with their HTTPS
+	// address, otherwise the server also offers the HTTP address over HTTPS
+	base := "http://" + syscfg.System.Listen
+	if syscfg.Tls.Enabled && (syscfg.System.Listen == "" || syscfg.Tls.Redirect) {
+		base = "https://" + syscfg.Tls.Listen
+	}
+
+	for _, service := range configured {
+		if service.Address == "" {
+			continue
+		}
+		service.Available = true
+		service.Address = base + service.Address
+
+		_, err = store.AddService(service)
+		if err != nil {
+			log.Printf("M: Unable to add the %s service due to error %v", service.ServiceType, err)
+			return
+		}
+		fmt.Printf("Added the %s service at %s\n", service.ServiceType, service.Address)
+	}
+}
+
+// --------------------------------------------------
+// Migrate the database schema
+// --------------------------------------------------
+
+func migrateDatabase(store storage.StoreType) {
+	version, err := store.GetSchemaVersion()
+	if err != nil {
+		log.Printf("M: Unable to read the schema version due to error %v", err)
+		return
+	}
+	if version == storage.LatestSchemaVersion() {
+		fmt.Printf("The database is already at schema version %d\n", version)
+		return
+	}
+	applyMigrations(store)
+}
+
+// Returns false if a migration failed
+func applyMigrations(store storage.StoreType) bool {
+	applied, err := store.Migrate()
+	for _, migration := range applied {
+		fmt.Printf("Applied migration %d: %s\n", migration.Version, migration.Description)
+	}
+	if err != nil {
+		log.Printf("M: Unable to migrate the database due to error %v", err)
+		return false
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Applied %d migrations", len(applied))
+	}
+	return true
+}
+
+// --------------------------------------------------
+// Show the database schema version
+// --------------------------------------------------
+
+func databaseStatus(store storage.StoreType) {
+	version, err := store.GetSchemaVersion()
+	if err != nil {
+		log.Printf("M: Unable to read the schema version due to error %v", err)
+		return
 	}
 
+	fmt.Println("\nDatabase Schema")
+	fmt.Println("===============")
+	fmt.Printf("\tCurrent Version: %d\n", version)
+	fmt.Printf("\tLatest Version:  %d\n", storage.LatestSchemaVersion())
+	for _, migration := range storage.Migrations() {
+		status := "applied"
+		if migration.Version > version {
+			status = "pending"
+		}
+		fmt.Printf("\t%3d \t %-7s \t %s\n", migration.Version, status, migration.Description)
+	}
 }
 
 // --------------------------------------------------
 // List currently defined collections
 // --------------------------------------------------
 
-func listCollections(db *sql.DB) {
-	rows, err := db.Query("SELECT * FROM Collections")
+func listCollections(store storage.StoreType) {
+	collections, err := store.GetCollections()
 	if err != nil {
-		log.Printf("M: error running query, %v", err)
+		log.Printf("M: error reading collections, %v", err)
+		return
+	}
+
+	var names []string
+	for name := range collections {
+		names = append(names, name)
 	}
-	defer rows.Close()
+	sort.Strings(names)
 
 	fmt.Println("\nCurrent Collections")
 	fmt.Println("===================")
-	for rows.Next() {
-		var id int
-		var collection string
-		var description string
-		err = rows.Scan(&id, &collection, &description)
-		if err != nil {
-			log.Printf("M: error reading from database, %v", err)
-		}
-		fmt.Printf("\t%-10s \t %s\n", collection, description)
+	for _, name := range names {
+		collection := collections[name]
+		fmt.Printf("\t%-10s \t %-10s \t %s\n", collection.Name, collection.Type, collection.Description)
 	}
 }
 
@@ -132,40 +377,869 @@ func listCollections(db *sql.DB) {
 // Add collection
 // --------------------------------------------------
 
-func addCollection(db *sql.DB) {
+func addCollection(store storage.StoreType) {
+	var collection storage.CollectionType
+
 	fmt.Print("Collection Name: ")
-	collectionName, _ := getInput()
+	collection.Name, _ = getInput()
 
 	fmt.Print("Collection Description: ")
-	collectionDescription, _ := getInput()
+	collection.Description, _ = getInput()
 
-	_, err := db.Exec("INSERT INTO Collections (collection, description) values (?, ?)", collectionName, collectionDescription)
+	fmt.Print("Collection Type (DATA_FEED or DATA_SET): ")
+	collection.Type, _ = getInput()
+	if collection.Type != "DATA_SET" {
+		collection.Type = "DATA_FEED"
+	}
+
+	// An empty content binding means the collection is published as STIX 1.x
+	fmt.Print("Collection Content Binding (blank for STIX 1.x, \"application/stix+json;version=2.1\" for STIX 2.1): ")
+	collection.ContentBinding, _ = getInput()
+
+	err := store.AddCollection(collection)
 	if err != nil {
-		log.Printf("M: Unable to insert record due to error %v", err)
+		log.Printf("M: Unable to add collection due to error %v", err)
+		return
 	}
 
 	if DebugLevel >= 1 {
-		log.Printf("DEBUG-1M: Inserted %s in to table Collections", collectionName)
+		log.Printf("DEBUG-1M: Added collection %s", collection.Name)
 	}
 }
 
 // --------------------------------------------------
 // Delete collection
 // --------------------------------------------------
-func delCollection(db *sql.DB) {
+func delCollection(store storage.StoreType) {
 	fmt.Print("Collection Name: ")
 	collectionName, _ := getInput()
 
-	_, err := db.Exec("DELETE FROM Collections where (collection=?)", collectionName)
+	found, err := store.DeleteCollection(collectionName)
+	if err != nil {
+		log.Printf("M: Unable to delete collection due to error %v", err)
+		return
+	}
+	if !found {
+		fmt.Printf("Collection %s does not exist\n", collectionName)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Deleted collection %s", collectionName)
+	}
+}
+
+// --------------------------------------------------
+// List currently defined feeds
+// --------------------------------------------------
+
+func listFeeds(store storage.StoreType) {
+	feeds, err := store.GetFeeds()
+	if err != nil {
+		log.Printf("M: error reading feeds, %v", err)
+		return
+	}
+
+	fmt.Println("\nCurrent Feeds")
+	fmt.Println("=============")
+	for _, feed := range feeds {
+		fmt.Printf("\t%-10s \t %6ds \t %s\n", feed.CollectionName, feed.Interval, feed.Address)
+		fmt.Printf("\t\tLast Fetched: %s  Last Success: %s  Suppressed: %d\n", feed.LastFetched, feed.LastSuccess, feed.Suppressed)
+		if feed.LastError != "" {
+			fmt.Printf("\t\tLast Error: %s\n", feed.LastError)
+		}
+	}
+}
+
+// --------------------------------------------------
+// Add feed
+// --------------------------------------------------
+
+func addFeed(store storage.StoreType) {
+	var feed storage.FeedType
+
+	fmt.Print("Collection Name: ")
+	feed.CollectionName, _ = getInput()
+
+	fmt.Print("Feed Address: ")
+	feed.Address, _ = getInput()
+
+	fmt.Print("Seconds Between Fetches (blank for 3600): ")
+	interval, _ := getInput()
+	feed.Interval = 3600
+	if interval != "" {
+		value, err := strconv.Atoi(interval)
+		if err != nil || value <= 0 {
+			fmt.Printf("%s is not a number of seconds\n", interval)
+			return
+		}
+		feed.Interval = value
+	}
+
+	fmt.Printf("Parser (%s, blank for %s): ", strings.Join(parser.Names(), ", "), parser.DEFAULT_PARSER)
+	feed.Parser, _ = getInput()
+	if feed.Parser == "" {
+		feed.Parser = parser.DEFAULT_PARSER
+	}
+
+	fmt.Print("Parser Options (key=value separated by spaces, blank for none): ")
+	feed.ParserOptions, _ = getInput()
+
+	// Catch a bad parser name or options now rather than on every fetch
+	_, err := parser.New(feed.Parser, feed.ParserOptions)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	err = store.AddFeed(feed)
+	if err != nil {
+		log.Printf("M: Unable to add feed due to error %v", err)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Added feed %s to collection %s", feed.Address, feed.CollectionName)
+	}
+}
+
+// --------------------------------------------------
+// Import indicators from a file
+// --------------------------------------------------
+// Everything found in the file is added to the collection as one indicator
+
+func importFile(store storage.StoreType) {
+	var indicator storage.IndicatorType
+
+	fmt.Print("Collection Name: ")
+	collectionName, _ := getInput()
+
+	fmt.Print("File Name: ")
+	filename, _ := getInput()
+
+	fmt.Printf("Parser (%s, blank for %s): ", strings.Join(parser.Names(), ", "), parser.DEFAULT_PARSER)
+	parserName, _ := getInput()
+
+	fmt.Print("Parser Options (key=value separated by spaces, blank for none): ")
+	parserOptions, _ := getInput()
+
+	fmt.Print("Indicator Title (blank for the file name): ")
+	indicator.Title, _ = getInput()
+	if indicator.Title == "" {
+		indicator.Title = filepath.Base(filename)
+	}
+
+	p, err := parser.New(parserName, parserOptions)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Printf("M: Unable to read %s due to error %v", filename, err)
+		return
+	}
+
+	observables, err := p.Parse(data)
+	if err != nil {
+		log.Printf("M: Unable to parse %s due to error %v", filename, err)
+		return
+	}
+
+	indicator.Created = time.Now().UTC().Format(TIMESTAMP_FORMAT)
+	indicator.Modified = indicator.Created
+
+	entries, err := store.GetAllowlist(collectionName)
+	if err != nil {
+		log.Printf("M: Unable to read the allowlist for collection %s due to error %v", collectionName, err)
+		return
+	}
+	allowlist, err := parser.NewAllowlist(entries)
+	if err != nil {
+		log.Printf("M: Unable to use the allowlist for collection %s due to error %v", collectionName, err)
+		return
+	}
+
+	// Values that are not valid or are on the allowlist are kept out of the
+	// collection and put in its quarantine so they can be looked at
+	accepted, rejected := parser.Normalize(observables)
+	accepted, suppressed := allowlist.Filter(accepted)
+	indicator.Observables = accepted
+	var quarantine []storage.QuarantineType
+	for _, value := range append(rejected, suppressed...) {
+		quarantine = append(quarantine, storage.QuarantineType{Value: value.Value, Reason: value.Reason, Created: indicator.Created})
+	}
+	err = store.SetQuarantine(collectionName, filename, quarantine)
+	if err != nil {
+		log.Printf("M: Unable to save the quarantine for collection %s due to error %v", collectionName, err)
+		return
+	}
+	if len(rejected) > 0 {
+		fmt.Printf("Quarantined %d values from %s\n", len(rejected), filename)
+	}
+	if len(suppressed) > 0 {
+		fmt.Printf("Suppressed %d values from %s that are on the allowlist\n", len(suppressed), filename)
+	}
+
+	if len(indicator.Observables) == 0 {
+		fmt.Printf("No valid values were found in %s\n", filename)
+		return
+	}
+	for i := range indicator.Observables {
+		indicator.Observables[i].Created = indicator.Created
+	}
+
+	err = store.AddIndicator(collectionName, indicator)
+	if err != nil {
+		log.Printf("M: Unable to import in to collection %s due to error %v", collectionName, err)
+		return
+	}
+	fmt.Printf("Imported %d values in to collection %s\n", len(indicator.Observables), collectionName)
+}
+
+// --------------------------------------------------
+// List quarantined values
+// --------------------------------------------------
+
+func listQuarantine(store storage.StoreType) {
+	fmt.Print("Collection Name: ")
+	collectionName, _ := getInput()
+
+	entries, err := store.GetQuarantine(collectionName)
+	if err != nil {
+		log.Printf("M: error reading quarantine, %v", err)
+		return
+	}
+
+	fmt.Println("\nQuarantined Values")
+	fmt.Println("==================")
+	for _, entry := range entries {
+		fmt.Printf("\t%-30s \t %s\n", entry.Value, entry.Reason)
+		fmt.Printf("\t\tSource: %s  Created: %s\n", entry.Source, entry.Created)
+	}
+}
+
+// --------------------------------------------------
+// List allowlist entries
+// --------------------------------------------------
+
+func listAllowlist(store storage.StoreType) {
+	fmt.Print("Collection Name (blank for global entries only): ")
+	collectionName, _ := getInput()
+
+	entries, err := store.GetAllowlist(collectionName)
+	if err != nil {
+		log.Printf("M: error reading allowlist, %v", err)
+		return
+	}
+
+	fmt.Println("\nAllowlist Entries")
+	fmt.Println("=================")
+	for _, entry := range entries {
+		scope := entry.CollectionName
+		if scope == "" {
+			scope = "(global)"
+		}
+		fmt.Printf("\t%4d \t %-10s \t %-6s \t %s\n", entry.Id, scope, entry.Type, entry.Value)
+		if entry.Comment != "" {
+			fmt.Printf("\t\t%s\n", entry.Comment)
+		}
+	}
+}
+
+// --------------------------------------------------
+// Add allowlist entry
+// --------------------------------------------------
+
+func addAllowlist(store storage.StoreType) {
+	var entry storage.AllowlistEntryType
+
+	fmt.Print("Collection Name (blank for every collection): ")
+	entry.CollectionName, _ = getInput()
+
+	fmt.Print("Type (cidr, domain or regex): ")
+	entry.Type, _ = getInput()
+
+	fmt.Print("Value: ")
+	entry.Value, _ = getInput()
+
+	fmt.Print("Comment: ")
+	entry.Comment, _ = getInput()
+
+	entry, err := parser.NormalizeAllowlistEntry(entry)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	entry.Created = time.Now().UTC().Format(TIMESTAMP_FORMAT)
+
+	err = store.AddAllowlistEntry(entry)
+	if err != nil {
+		log.Printf("M: Unable to add allowlist entry due to error %v", err)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Added allowlist entry %s %s", entry.Type, entry.Value)
+	}
+}
+
+// --------------------------------------------------
+// Delete allowlist entry
+// --------------------------------------------------
+
+func delAllowlist(store storage.StoreType) {
+	fmt.Print("Allowlist Entry Number: ")
+	input, _ := getInput()
+
+	id, err := strconv.ParseInt(input, 10, 64)
+	if err != nil {
+		fmt.Printf("%s is not an entry number\n", input)
+		return
+	}
+
+	err = store.DeleteAllowlistEntry(id)
+	if err != nil {
+		log.Printf("M: Unable to delete allowlist entry due to error %v", err)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Deleted allowlist entry %d", id)
+	}
+}
+
+// --------------------------------------------------
+// List identities
+// --------------------------------------------------
+
+func listIdentities(store storage.StoreType) {
+	identities, err := store.GetIdentities()
+	if err != nil {
+		log.Printf("M: error reading identities, %v", err)
+		return
+	}
+
+	fmt.Println("\nIdentities")
+	fmt.Println("==========")
+	for _, identity := range identities {
+		fmt.Printf("\t%-20s \t Subject: %s\n", identity.Name, identity.Subject)
+		fmt.Printf("\t%-20s \t SAN: %s\n", "", identity.San)
+	}
+}
+
+// --------------------------------------------------
+// Add identity
+// --------------------------------------------------
+// The subject is matched the way openssl x509 -noout -subject -nameopt RFC2253
+// prints it, for example CN=partner,O=Example Corp,C=US
+
+func addIdentity(store storage.StoreType) {
+	var identity storage.IdentityType
+
+	fmt.Print("Identity Name: ")
+	identity.Name, _ = getInput()
+
+	fmt.Print("Certificate Subject (blank to match on the SAN only): ")
+	identity.Subject, _ = getInput()
+
+	fmt.Print("Certificate SAN (DNS name, email, URI or IP address, blank for none): ")
+	identity.San, _ = getInput()
+
+	if identity.Name == "" {
+		fmt.Println("An identity needs a name")
+		return
+	}
+	if identity.Subject == "" && identity.San == "" {
+		fmt.Println("An identity needs a subject or a SAN to match client certificates on")
+		return
+	}
+	identity.Created = time.Now().UTC().Format(TIMESTAMP_FORMAT)
+
+	err := store.AddIdentity(identity)
+	if err != nil {
+		log.Printf("M: Unable to add identity due to error %v", err)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Added identity %s", identity.Name)
+	}
+}
+
+// --------------------------------------------------
+// Delete identity
+// --------------------------------------------------
+
+func delIdentity(store storage.StoreType) {
+	fmt.Print("Identity Name: ")
+	name, _ := getInput()
+
+	found, err := store.DeleteIdentity(name)
+	if err != nil {
+		log.Printf("M: Unable to delete identity due to error %v", err)
+		return
+	}
+	if !found {
+		fmt.Printf("Identity %s does not exist\n", name)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Deleted identity %s", name)
+	}
+}
+
+// --------------------------------------------------
+// List users
+// --------------------------------------------------
+
+func listUsers(store storage.StoreType) {
+	users, err := store.GetUsers()
+	if err != nil {
+		log.Printf("M: error reading users, %v", err)
+		return
+	}
+
+	fmt.Println("\nUsers")
+	fmt.Println("=====")
+	for _, user := range users {
+		status := "enabled"
+		if user.Disabled {
+			status = "disabled"
+		}
+		password := "password"
+		if user.PasswordHash == "" {
+			password = "tokens only"
+		}
+		role := "user"
+		if user.Admin {
+			role = "admin"
+		}
+		fmt.Printf("\t%-20s \t %-8s \t %-11s \t %-5s \t %s\n", user.Username, status, password, role, user.Created)
+	}
+}
+
+// --------------------------------------------------
+// Add user
+// --------------------------------------------------
+// A user added without a password can only sign in with API tokens
+
+func addUser(store storage.StoreType) {
+	var user storage.UserType
+
+	fmt.Print("Username: ")
+	user.Username, _ = getInput()
+	if user.Username == "" || strings.Contains(user.Username, ":") {
+		fmt.Println("A username can not be blank or have a colon in it")
+		return
+	}
+
+	fmt.Print("Password (blank for API tokens only): ")
+	password, _ := getInput()
+	if password != "" {
+		hash, err := auth.HashPassword(password)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		user.PasswordHash = hash
+	}
+	user.Created = time.Now().UTC().Format(TIMESTAMP_FORMAT)
+
+	err := store.AddUser(user)
+	if err != nil {
+		log.Printf("M: Unable to add user due to error %v", err)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Added user %s", user.Username)
+	}
+}
+
+// --------------------------------------------------
+// Disable or enable user
+// --------------------------------------------------
+// A disabled user can not sign in with a password or any of their tokens
+
+func setUserDisabled(store storage.StoreType, disabled bool) {
+	fmt.Print("Username: ")
+	username, _ := getInput()
+
+	found, err := store.SetUserDisabled(username, disabled)
+	if err != nil {
+		log.Printf("M: Unable to change user due to error %v", err)
+		return
+	}
+	if !found {
+		fmt.Printf("User %s does not exist\n", username)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Set user %s disabled to %t", username, disabled)
+	}
+}
+
+// --------------------------------------------------
+// Grant or revoke the admin role
+// --------------------------------------------------
+// Only admins can use the admin API, with a password or any of their tokens
+
+func setUserAdmin(store storage.StoreType, admin bool) {
+	fmt.Print("Username: ")
+	username, _ := getInput()
+
+	found, err := store.SetUserAdmin(username, admin)
+	if err != nil {
+		log.Printf("M: Unable to change user due to error %v", err)
+		return
+	}
+	if !found {
+		fmt.Printf("User %s does not exist\n", username)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Set user %s admin to %t", username, admin)
+	}
+}
+
+// --------------------------------------------------
+// List API tokens
+// --------------------------------------------------
+
+func listTokens(store storage.StoreType) {
+	fmt.Print("Username (blank for every user): ")
+	username, _ := getInput()
+
+	tokens, err := store.GetApiTokens(username)
+	if err != nil {
+		log.Printf("M: error reading API tokens, %v", err)
+		return
+	}
+
+	fmt.Println("\nAPI Tokens")
+	fmt.Println("==========")
+	for _, token := range tokens {
+		status := "active"
+		if token.Revoked {
+			status = "revoked"
+		}
+		fmt.Printf("\t%4d \t %-20s \t %-7s \t %s \t %s\n", token.Id, token.Username, status, token.Created, token.Description)
+	}
+}
+
+// --------------------------------------------------
+// Issue API token
+// --------------------------------------------------
+// The token is only shown here, the store only keeps its hash
+
+func addToken(store storage.StoreType) {
+	var token storage.ApiTokenType
+
+	fmt.Print("Username: ")
+	token.Username, _ = getInput()
+
+	fmt.Print("Description: ")
+	token.Description, _ = getInput()
+
+	value, hash, err := auth.NewApiToken()
+	if err != nil {
+		log.Printf("M: Unable to create API token due to error %v", err)
+		return
+	}
+	token.TokenHash = hash
+	token.Created = time.Now().UTC().Format(TIMESTAMP_FORMAT)
+
+	id, err := store.AddApiToken(token)
+	if err != nil {
+		log.Printf("M: Unable to add API token due to error %v", err)
+		return
+	}
+
+	fmt.Printf("\nAPI token %d for %s, it will not be shown again:\n\n\t%s\n\n", id, token.Username, value)
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Issued API token %d to user %s", id, token.Username)
+	}
+}
+
+// --------------------------------------------------
+// Revoke API token
+// --------------------------------------------------
+
+func revokeToken(store storage.StoreType) {
+	fmt.Print("API Token Number: ")
+	input, _ := getInput()
+
+	id, err := strconv.ParseInt(input, 10, 64)
+	if err != nil {
+		fmt.Printf("%s is not a token number\n", input)
+		return
+	}
+
+	found, err := store.RevokeApiToken(id)
+	if err != nil {
+		log.Printf("M: Unable to revoke API token due to error %v", err)
+		return
+	}
+	if !found {
+		fmt.Printf("API token %d does not exist\n", id)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Revoked API token %d", id)
+	}
+}
+
+// --------------------------------------------------
+// List groups
+// --------------------------------------------------
+
+func listGroups(store storage.StoreType) {
+	groups, err := store.GetGroups()
+	if err != nil {
+		log.Printf("M: error reading groups, %v", err)
+		return
+	}
+
+	fmt.Println("\nGroups")
+	fmt.Println("======")
+	for _, group := range groups {
+		fmt.Printf("\t%s\n", group.Name)
+		for _, member := range group.Members {
+			fmt.Printf("\t\t%-8s \t %s\n", member.Type, member.Name)
+		}
+	}
+}
+
+// --------------------------------------------------
+// Add group
+// --------------------------------------------------
+
+func addGroup(store storage.StoreType) {
+	var group storage.GroupType
+
+	fmt.Print("Group Name: ")
+	group.Name, _ = getInput()
+	if group.Name == "" {
+		fmt.Println("A group needs a name")
+		return
+	}
+	group.Created = time.Now().UTC().Format(TIMESTAMP_FORMAT)
+
+	err := store.AddGroup(group)
+	if err != nil {
+		log.Printf("M: Unable to add group due to error %v", err)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Added group %s", group.Name)
+	}
+}
+
+// --------------------------------------------------
+// Delete group
+// --------------------------------------------------
+// The grants made to the group are deleted with it
+
+func delGroup(store storage.StoreType) {
+	fmt.Print("Group Name: ")
+	name, _ := getInput()
+
+	found, err := store.DeleteGroup(name)
+	if err != nil {
+		log.Printf("M: Unable to delete group due to error %v", err)
+		return
+	}
+	if !found {
+		fmt.Printf("Group %s does not exist\n", name)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Deleted group %s", name)
+	}
+}
+
+// --------------------------------------------------
+// Add group member
+// --------------------------------------------------
+
+func addGroupMember(store storage.StoreType) {
+	fmt.Print("Group Name: ")
+	groupName, _ := getInput()
+
+	member, ok := getPrincipal(false)
+	if !ok {
+		return
+	}
+
+	err := store.AddGroupMember(groupName, member)
+	if err != nil {
+		log.Printf("M: Unable to add group member due to error %v", err)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Added %s %s to group %s", member.Type, member.Name, groupName)
+	}
+}
+
+// --------------------------------------------------
+// Delete group member
+// --------------------------------------------------
+
+func delGroupMember(store storage.StoreType) {
+	fmt.Print("Group Name: ")
+	groupName, _ := getInput()
+
+	member, ok := getPrincipal(false)
+	if !ok {
+		return
+	}
+
+	found, err := store.DeleteGroupMember(groupName, member)
+	if err != nil {
+		log.Printf("M: Unable to remove group member due to error %v", err)
+		return
+	}
+	if !found {
+		fmt.Printf("The %s %s is not in group %s\n", member.Type, member.Name, groupName)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Removed %s %s from group %s", member.Type, member.Name, groupName)
+	}
+}
+
+// --------------------------------------------------
+// List grants
+// --------------------------------------------------
+
+func listGrants(store storage.StoreType) {
+	fmt.Print("Collection Name (blank for every collection): ")
+	collectionName, _ := getInput()
+
+	grants, err := store.GetGrants(collectionName)
+	if err != nil {
+		log.Printf("M: error reading grants, %v", err)
+		return
+	}
+
+	fmt.Println("\nGrants")
+	fmt.Println("======")
+	for _, grant := range grants {
+		fmt.Printf("\t%4d \t %-20s \t %-5s \t %-8s \t %s\n", grant.Id, grant.CollectionName, grant.Permission, grant.GranteeType, grant.Grantee)
+	}
+}
+
+// --------------------------------------------------
+// Add grant
+// --------------------------------------------------
+
+func addGrant(store storage.StoreType) {
+	var grant storage.GrantType
+
+	fmt.Print("Collection Name: ")
+	grant.CollectionName, _ = getInput()
+
+	fmt.Print("Permission (read or write): ")
+	grant.Permission, _ = getInput()
+	grant.Permission = strings.ToLower(grant.Permission)
+	if grant.Permission != storage.PERMISSION_READ && grant.Permission != storage.PERMISSION_WRITE {
+		fmt.Println("The permission must be read or write")
+		return
+	}
+
+	grantee, ok := getPrincipal(true)
+	if !ok {
+		return
+	}
+	grant.GranteeType = grantee.Type
+	grant.Grantee = grantee.Name
+	grant.Created = time.Now().UTC().Format(TIMESTAMP_FORMAT)
+
+	err := store.AddGrant(grant)
 	if err != nil {
-		log.Printf("M: Unable to delete record due to error %v", err)
+		log.Printf("M: Unable to add grant due to error %v", err)
+		return
 	}
 
-	// TODO this does not work right if the value is not in the database. It says it was deleted
-	// when it was not, need to catch that error
 	if DebugLevel >= 1 {
-		log.Printf("DEBUG-1M: Deleted %s from table Collections", collectionName)
+		log.Printf("DEBUG-1M: Granted %s on %s to %s %s", grant.Permission, grant.CollectionName, grant.GranteeType, grant.Grantee)
+	}
+}
+
+// --------------------------------------------------
+// Delete grant
+// --------------------------------------------------
+
+func delGrant(store storage.StoreType) {
+	fmt.Print("Grant Number: ")
+	input, _ := getInput()
+
+	id, err := strconv.ParseInt(input, 10, 64)
+	if err != nil {
+		fmt.Printf("%s is not a grant number\n", input)
+		return
+	}
+
+	found, err := store.DeleteGrant(id)
+	if err != nil {
+		log.Printf("M: Unable to delete grant due to error %v", err)
+		return
+	}
+	if !found {
+		fmt.Printf("Grant %d does not exist\n", id)
+		return
+	}
+
+	if DebugLevel >= 1 {
+		log.Printf("DEBUG-1M: Deleted grant %d", id)
+	}
+}
+
+// --------------------------------------------------
+// Get a User, Identity or Group
+// --------------------------------------------------
+
+func getPrincipal(allowGroup bool) (storage.PrincipalType, bool) {
+	var principal storage.PrincipalType
+
+	if allowGroup {
+		fmt.Print("Type (user, identity or group): ")
+	} else {
+		fmt.Print("Type (user or identity): ")
+	}
+	principal.Type, _ = getInput()
+	principal.Type = strings.ToLower(principal.Type)
+
+	switch principal.Type {
+	case storage.PRINCIPAL_TYPE_USER, storage.PRINCIPAL_TYPE_IDENTITY:
+	case storage.PRINCIPAL_TYPE_GROUP:
+		if !allowGroup {
+			fmt.Println("Groups can not be put in groups")
+			return principal, false
+		}
+	default:
+		fmt.Printf("%s is not a known type\n", principal.Type)
+		return principal, false
+	}
+
+	fmt.Print("Name: ")
+	principal.Name, _ = getInput()
+	if principal.Name == "" {
+		fmt.Println("A name is needed")
+		return principal, false
 	}
+	return principal, true
 }
 
 // --------------------------------------------------